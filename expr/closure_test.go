@@ -0,0 +1,95 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClosures_Errors(t *testing.T) {
+	calls := 0
+	compiler := NewCompiler(WithClosures())
+	compiler.RegisterInput("a", types.Number)
+	compiler.RegisterGoFunc("check", func(n float64) (float64, error) {
+		if n < 0 {
+			return 0, errors.New("negative")
+		}
+		return n, nil
+	})
+	compiler.RegisterGoFunc("count", func(n float64) float64 {
+		calls++
+		return n
+	})
+
+	run := func(r *runtime.Runtime, a float64) (runtime.Value, error) {
+		return r.Run(context.Background(), 0, []runtime.Value{runtime.NewNumber(a)})
+	}
+
+	prog, err := compiler.Compile(`count(1) + check(a) + count(check(a))`)
+	require.NoError(t, err)
+	r := runtime.NewRuntime(prog)
+
+	// The execution stops at the first error, like the bytecode.
+	_, err = run(r, -1)
+	require.EqualError(t, err, "negative")
+	require.Equal(t, 1, calls)
+
+	// The runtime can be reused after an error.
+	res, err := run(r, 2)
+	require.NoError(t, err)
+	require.Equal(t, 5.0, res.Number())
+	require.Equal(t, 3, calls)
+
+	// Panics in host functions are not recovered.
+	compiler = NewCompiler(WithClosures())
+	compiler.RegisterInput("a", types.Number)
+	compiler.RegisterGoFunc("crash", func(n float64) float64 {
+		panic("crash")
+	})
+	prog, err = compiler.Compile(`crash(a)`)
+	require.NoError(t, err)
+	require.PanicsWithValue(t, "crash", func() {
+		run(runtime.NewRuntime(prog), 1)
+	})
+}
+
+func TestClosures_Placeholders(t *testing.T) {
+	run := func(name, input string, expected string) {
+		t.Run(name, func(t *testing.T) {
+			compiler := NewCompiler(WithClosures())
+			compiler.RegisterInput("s", types.String)
+			prog, err := compiler.Compile(input)
+			require.NoError(t, err)
+
+			// The values of failed calls are consumed by the enclosing
+			// expressions before the error is returned.
+			_, err = runtime.NewRuntime(prog).Run(
+				context.Background(), 0, []runtime.Value{runtime.NewString("(")})
+			require.EqualError(t, err, expected)
+		})
+	}
+
+	const badRegex = "invalid regular expression \"(\": " +
+		"error parsing regexp: missing closing ): `(`"
+	run("string", `regexFind("abc", s) == "a"`, badRegex)
+	run("array", `count(regexSplit("a,b", s), x => x == "a")`, badRegex)
+	run("ip", `ip(s) in cidr("10.0.0.0/8")`, `invalid IP address "("`)
+	run("cidr", `ip("10.0.0.1") in cidr(s)`, `invalid CIDR "("`)
+}
+
+func BenchmarkClosures(b *testing.B) {
+	run := benchRunner(b,
+		benchVariant{"vm", nil},
+		benchVariant{"closures", []Option{WithClosures()}},
+	)
+
+	run("compare", `a < b`)
+	run("arith", `(a + b) * 2 - a / b >= k`)
+	run("call", `len(s) + k == b`)
+	run("loop", `count(nums, x => x > a && x < b * 10)`)
+	run("map", `[x * 2 for x in nums if x > 50]`)
+}
@@ -1,6 +1,7 @@
 package expr
 
 import (
+	"fmt"
 	"io"
 
 	"github.com/dcaiafa/go-expr/expr/internal/context"
@@ -14,20 +15,116 @@ import (
 // executed in a runtime.Runtime.
 type Compiler struct {
 	ctx *context.Context
+	err error
+
+	evalBudget int
+	closures   bool
+	registers  bool
+	noPeephole bool
+	noCSE      bool
+
+	// inputs are the registered inputs, by input index.
+	inputs []context.GoParam
+}
+
+// Option configures a Compiler created by NewCompiler.
+type Option func(c *Compiler) error
+
+// Module is a set of functions and constants that can be registered with a
+// Compiler.
+type Module interface {
+	Register(c *Compiler) error
+}
+
+// WithModule registers the functions and constants of m with the Compiler.
+func WithModule(m Module) Option {
+	return func(c *Compiler) error {
+		return m.Register(c)
+	}
+}
+
+// WithClosures makes Compile also compile the expression to a tree of Go
+// closures, which the runtime executes instead of the bytecode. Closures
+// avoid the dispatch and stack operations of the bytecode interpreter, at the
+// cost of a slower compilation.
+func WithClosures() Option {
+	return func(c *Compiler) error {
+		c.closures = true
+		return nil
+	}
+}
+
+// WithRegisters makes Compile also translate the bytecode to register code,
+// which the runtime executes instead of the bytecode. Register instructions
+// read their operands from registers that hold the inputs, constants and
+// locals directly, which avoids most of the stack operations of the bytecode.
+// It is experimental; see runtime.Program.CompileRegisters.
+func WithRegisters() Option {
+	return func(c *Compiler) error {
+		c.registers = true
+		return nil
+	}
 }
 
-// NewCompiler creates a new Compiler.
-func NewCompiler() *Compiler {
-	return &Compiler{
+// WithoutPeephole disables the peephole optimizer, which fuses and removes
+// instructions of the bytecode. See runtime.Builder.SetOptimize.
+func WithoutPeephole() Option {
+	return func(c *Compiler) error {
+		c.noPeephole = true
+		return nil
+	}
+}
+
+// WithoutCSE disables common sub-expression elimination, which evaluates
+// repeated sub-expressions that only call pure functions once. It is meant
+// for functions registered as pure that are not, for example, because they
+// count their calls.
+func WithoutCSE() Option {
+	return func(c *Compiler) error {
+		c.noCSE = true
+		return nil
+	}
+}
+
+// NewCompiler creates a new Compiler. If an option fails, the error is
+// returned by Compile.
+func NewCompiler(opts ...Option) *Compiler {
+	c := &Compiler{
 		ctx: context.NewContext(),
 	}
+	for _, opt := range opts {
+		if err := opt(c); err != nil && c.err == nil {
+			c.err = err
+		}
+	}
+	c.ctx.Builder.SetOptimize(!c.noPeephole)
+	return c
+}
+
+// checkName returns an error if name is a keyword, since expressions could
+// not reference it.
+func checkName(name string) error {
+	if parser.IsKeyword(name) {
+		return fmt.Errorf("%v is a keyword and cannot be used as a name", name)
+	}
+	return nil
 }
 
 // RegisterInput creates an input parameter that can be used in the expression.
+// The names of inputs, constants and functions cannot be keywords, such as
+// "in", "for", "if" or "matches".
 func (c *Compiler) RegisterInput(name string, typ types.Type) (int, error) {
+	err := checkName(name)
+	if err != nil {
+		return 0, err
+	}
+	if !types.ValueType(typ) {
+		return 0, fmt.Errorf("input %v has invalid type %v", name, typ)
+	}
 	inputIndex := c.ctx.Builder.NewInput(typ)
+	c.inputs = append(c.inputs, context.GoParam{Name: name, Type: typ})
 	inputSymbol := symbol.NewInputSymbol(name, typ, inputIndex)
-	err := c.ctx.GlobalScope.Add(inputSymbol)
+	err = c.ctx.GlobalScope.Add(inputSymbol)
 	if err != nil {
 		return 0, err
 	}
@@ -36,6 +133,13 @@ func (c *Compiler) RegisterInput(name string, typ types.Type) (int, error) {
 
 // RegisterConst creates a named constant that can be used in the expression.
 func (c *Compiler) RegisterConst(name string, v runtime.Value) error {
+	err := checkName(name)
+	if err != nil {
+		return err
+	}
+	if !types.ValueType(v.Type()) {
+		return fmt.Errorf("constant %v has invalid type %v", name, v.Type())
+	}
 	constIndex := c.ctx.Builder.NewConst(v)
 	constSymbol := symbol.NewConstSymbol(name, v.Type(), constIndex)
 	return c.ctx.GlobalScope.Add(constSymbol)
@@ -43,51 +147,241 @@ func (c *Compiler) RegisterConst(name string, v runtime.Value) error {
 
 // RegisterFunc registers a function that can be used in the expression. The
 // function implementation *must* return a value with the type specified at
-// registration.
+// registration. A function can be registered multiple times with the same name
+// and different parameter types; the call site selects the overload that
+// matches the argument types.
 func (c *Compiler) RegisterFunc(
 	name string,
 	fn runtime.FuncFn,
 	ret types.Type,
 	args ...types.Type,
 ) error {
-	fnType := &types.Function{
-		Params: make([]types.Type, len(args)),
+	return c.RegisterFuncDef(&FuncDef{
+		Name:   name,
+		Func:   fn,
 		Ret:    ret,
-	}
-	copy(fnType.Params, args)
-	return c.registerFunc(name, fnType, fn)
+		Params: args,
+	})
 }
 
-func (c *Compiler) registerFunc(
+// RegisterVariadicFunc is like RegisterFunc, but the last parameter accepts
+// zero or more arguments.
+func (c *Compiler) RegisterVariadicFunc(
 	name string,
-	typ *types.Function,
 	fn runtime.FuncFn,
+	ret types.Type,
+	args ...types.Type,
 ) error {
-	v := runtime.NewObject(typ, &runtime.Func{
-		Type: typ,
-		Func: fn,
+	return c.RegisterFuncDef(&FuncDef{
+		Name:     name,
+		Func:     fn,
+		Ret:      ret,
+		Params:   args,
+		Variadic: true,
+	})
+}
+
+// RegisterPureFunc is like RegisterFunc, but the function must always return
+// the same result for the same arguments and have no side effects. Calls with
+// constant arguments are evaluated at compile time, and repeated calls with
+// the same arguments are evaluated once.
+func (c *Compiler) RegisterPureFunc(
+	name string,
+	fn runtime.FuncFn,
+	ret types.Type,
+	args ...types.Type,
+) error {
+	return c.RegisterFuncDef(&FuncDef{
+		Name:   name,
+		Func:   fn,
+		Ret:    ret,
+		Params: args,
+		Pure:   true,
 	})
-	constIndex := c.ctx.Builder.NewConst(v)
-	fnSymbol := symbol.NewConstSymbol(name, typ, constIndex)
-	return c.ctx.GlobalScope.Add(fnSymbol)
+}
+
+// FuncDef describes a function to be registered with RegisterFuncDef.
+type FuncDef struct {
+	// Name is the name of the function in expressions.
+	Name string
+
+	// Func is the implementation of the function. It receives one argument
+	// per parameter, with omitted optional parameters set to their default
+	// values, followed by the arguments of the variadic parameter, if any.
+	// Arguments for parameters of type types.Any have the type of the
+	// argument expression.
+	Func runtime.FuncFn
+
+	// FuncErr can be set instead of Func for functions that can fail.
+	FuncErr runtime.FuncErrFn
+
+	// Ret is the type of the value returned by the function.
+	Ret types.Type
+
+	// Params are the types of the function parameters. The parameter types
+	// and Ret can use type variables (see types.TypeVar) to register a generic
+	// function. Type variables used by Ret must be used by Params.
+	Params []types.Type
+
+	// Variadic indicates that the last parameter accepts zero or more
+	// arguments.
+	Variadic bool
+
+	// Defaults are the default values of the trailing parameters, not
+	// including the variadic parameter, which are optional.
+	Defaults []runtime.Value
+
+	// Pure indicates that the function always returns the same result for
+	// the same arguments and has no side effects. Calls to pure functions with
+	// constant arguments are evaluated at compile time.
+	Pure bool
+}
+
+// RegisterFuncDef registers a function that can be used in the expression. See
+// RegisterFunc.
+func (c *Compiler) RegisterFuncDef(def *FuncDef) error {
+	if def.Ret == nil || def.Ret == types.Any {
+		return fmt.Errorf("function %v has invalid return type %v", def.Name, def.Ret)
+	}
+	if (def.Func == nil) == (def.FuncErr == nil) {
+		return fmt.Errorf("function %v must have exactly one of Func and FuncErr", def.Name)
+	}
+	if def.Variadic && len(def.Params) == 0 {
+		return fmt.Errorf("variadic function %v has no parameters", def.Name)
+	}
+	paramVars := types.TypeVars(&types.Function{Params: def.Params, Ret: types.Void})
+	for _, v := range types.TypeVars(def.Ret) {
+		if !containsTypeVar(paramVars, v) {
+			return fmt.Errorf(
+				"function %v result type uses type variable %v, which is not used by its parameters",
+				def.Name, v)
+		}
+	}
+
+	fnType := &types.Function{
+		Params:   make([]types.Type, len(def.Params)),
+		Ret:      def.Ret,
+		Variadic: def.Variadic,
+		Optional: len(def.Defaults),
+	}
+	copy(fnType.Params, def.Params)
+
+	fixedParams := len(fnType.Params)
+	if fnType.Variadic {
+		fixedParams--
+	}
+	if len(def.Defaults) > fixedParams {
+		return fmt.Errorf("function %v has more defaults than parameters", def.Name)
+	}
+
+	overload := &symbol.Overload{
+		Type:     fnType,
+		Defaults: make([]symbol.Default, len(def.Defaults)),
+		Pure:     def.Pure,
+	}
+	firstOptional := fixedParams - len(def.Defaults)
+	for i, v := range def.Defaults {
+		param := fnType.Params[firstOptional+i]
+		if !types.Assignable(param, v.Type()) {
+			return fmt.Errorf(
+				"function %v parameter %d has type %v but its default is %v",
+				def.Name, firstOptional+i, param, v.Type())
+		}
+		overload.Defaults[i] = symbol.Default{Value: v}
+	}
+
+	return c.registerFunc(def.Name, overload, &runtime.Func{
+		Type:    fnType,
+		Func:    def.Func,
+		FuncErr: def.FuncErr,
+	})
+}
+
+func containsTypeVar(vars []*types.TypeVar, v *types.TypeVar) bool {
+	for _, other := range vars {
+		if other == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Compiler) registerFunc(
+	name string,
+	overload *symbol.Overload,
+	fn *runtime.Func,
+) error {
+	err := checkName(name)
+	if err != nil {
+		return err
+	}
+	var fnSymbol *symbol.FuncSymbol
+	if c.ctx.GlobalScope.Has(name) {
+		sym, _ := c.ctx.GlobalScope.Get(name)
+		var ok bool
+		fnSymbol, ok = sym.(*symbol.FuncSymbol)
+		if !ok {
+			return fmt.Errorf("%v is already defined and is not a function", name)
+		}
+	} else {
+		fnSymbol = symbol.NewFuncSymbol(name)
+		err := c.ctx.GlobalScope.Add(fnSymbol)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The constants are created once the overload is added, so that a
+	// rejected overload does not add them to the programs.
+	err = fnSymbol.AddOverload(overload)
+	if err != nil {
+		return err
+	}
+	fn.Name = name
+	overload.Func = fn
+	overload.ConstIndex = c.ctx.Builder.NewConst(runtime.NewObject(overload.Type, fn))
+	for i := range overload.Defaults {
+		overload.Defaults[i].ConstIndex = c.ctx.Builder.NewConst(overload.Defaults[i].Value)
+	}
+	return nil
 }
 
 // Compile compiles an expression into a program.
 func (c *Compiler) Compile(expr string) (*runtime.Program, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
 	progAST, err := parser.Parse(expr)
 	if err != nil {
 		return nil, err
 	}
 
 	for _, pass := range context.Passes {
+		if pass == context.CSE && c.noCSE {
+			continue
+		}
 		err = progAST.RunPass(c.ctx, pass)
 		if err != nil {
 			return nil, err
 		}
 	}
 
+	if c.closures {
+		err = progAST.RunPass(c.ctx, context.Closure)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	prog := c.ctx.Builder.Build()
 	prog.ResultType = progAST.Type()
+	if c.registers {
+		err = prog.CompileRegisters()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", runtime.ErrInternal, err)
+		}
+	}
 	return prog, nil
 }
 
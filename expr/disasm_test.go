@@ -0,0 +1,169 @@
+package expr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgram_Disassemble(t *testing.T) {
+	run := func(input string, expected string) {
+		t.Run(input, func(t *testing.T) {
+			compiler := NewCompiler()
+			compiler.RegisterInput("a", types.Number)
+			compiler.RegisterInput("s", types.String)
+			compiler.RegisterGoFunc("double", func(v float64) float64 { return v * 2 })
+			prog, err := compiler.Compile(input)
+			require.NoError(t, err)
+			var listing strings.Builder
+			require.NoError(t, prog.Disassemble(&listing))
+			require.Equal(t, strings.TrimLeft(expected, "\n"), listing.String())
+		})
+	}
+
+	run(`a > 1 && s == "x"`, `
+result: bool
+input 0: number
+input 1: string
+const 0: func(number) number = double
+locals: 0
+
+expr 0:
+  0000  LoadInput        0
+  0001  PushNumber       1
+  0002  CompareGT
+  0003  JumpIfFalseOrPop L0
+  0004  LoadInput        1
+  0005  PushString       "x"
+  0006  CompareEqString
+L0:
+  0007  Return
+`)
+	run(`double(a) in [1, 2.5]`, `
+result: bool
+input 0: number
+input 1: string
+const 0: func(number) number = double
+const 1: array of number = [1, 2.5]
+locals: 0
+
+expr 0:
+  0000  LoadConst        0               ; double
+  0001  LoadInput        0
+  0002  Call             1
+  0003  LoadConst        1               ; [1, 2.5]
+  0004  InArrayNumber
+  0005  Return
+`)
+}
+
+func TestAssemble(t *testing.T) {
+	double := &runtime.Func{
+		Name: "double",
+		Type: &types.Function{Params: []types.Type{types.Number}, Ret: types.Number},
+		Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+			return runtime.NewNumber(args[0].Number() * 2)
+		},
+	}
+
+	// sum(double(x) for x in a) + 0.5
+	prog, err := runtime.Assemble(strings.NewReader(`
+result: number
+input 0: array of number
+const 0: func(number) number = double   ; linked later
+locals: 2
+
+expr 0:
+  PushNumber 0.5
+  PushNumber 0
+  LoadInput 0
+  IterInit 0
+loop:
+  IterNext 0, done
+  StoreLocal 1
+  LoadConst 0
+  LoadLocal 1
+  Call 1
+  Add
+  Jump loop
+done:
+  Add
+  Return
+`))
+	require.NoError(t, err)
+	require.NoError(t, prog.Link(testFuncs{double}))
+	require.NoError(t, prog.Verify())
+
+	res, err := runtime.NewRuntime(prog).Run(context.Background(), 0, []runtime.Value{
+		runtime.NewObject(
+			&types.Array{ElementType: types.Number},
+			[]runtime.RawValue{runtime.NewRawNumber(1), runtime.NewRawNumber(2)}),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 6.5, res.Number())
+}
+
+// testFuncs resolves functions by name.
+type testFuncs []*runtime.Func
+
+func (funcs testFuncs) ResolveFunc(name string, typ *types.Function) (*runtime.Func, error) {
+	for _, fn := range funcs {
+		if fn.Name == name && fn.Type.Equal(typ) {
+			return fn, nil
+		}
+	}
+	return nil, fmt.Errorf("function %v is not defined", name)
+}
+
+func TestAssemble_Values(t *testing.T) {
+	listing := `result: bool
+const 0: array of array of string = [["a", "b\n"], []]
+const 1: regexp = "^a+$"
+const 2: ip = "10.0.0.1"
+const 3: cidr = "::1/128"
+const 4: ip = ""
+const 5: number = NaN
+const 6: number = -Inf
+const 7: func(array of T, func(T) bool) array of T = filter
+const 8: func(string, any...) string = format
+const 9: func(number, bool?) void = f
+locals: 0
+
+expr 0:
+  0000  PushBool         true
+  0001  Return
+`
+	prog, err := runtime.Assemble(strings.NewReader(listing))
+	require.NoError(t, err)
+	var out strings.Builder
+	require.NoError(t, prog.Disassemble(&out))
+	require.Equal(t, listing, out.String())
+}
+
+func TestAssemble_Errors(t *testing.T) {
+	run := func(name, listing, expected string) {
+		t.Run(name, func(t *testing.T) {
+			_, err := runtime.Assemble(strings.NewReader(listing))
+			require.EqualError(t, err, expected)
+		})
+	}
+
+	run("invalid_op", "expr 0:\n  Frobnicate", `line 2: invalid operation "Frobnicate"`)
+	run("outside_expr", "PushNumber 1", `line 1: instruction outside of an expression`)
+	run("label_outside_expr", "L0:", `line 1: label outside of an expression`)
+	run("undefined_label", "expr 0:\n  Jump L3\n  Return", `line 3: label L3 is not defined`)
+	run("index_order", "input 1: number", `line 1: index 1 out of order, expected 0`)
+	run("expr_order", "expr 0:\nexpr 0:", `line 2: index 0 out of order, expected 1`)
+	run("invalid_type", "input 0: integer", `line 1: invalid type "integer"`)
+	run("type_var_outside_func", "input 0: array of T", `line 1: invalid type "T"`)
+	run("unnamed_func", "const 0: func() number = ?", `line 1: function without a name`)
+	run("trailing", "expr 0:\n  Add 1", `line 2: unexpected "1"`)
+	run("bad_string", `expr 0:`+"\n"+`  PushString "abc`, `line 2: invalid string: invalid syntax`)
+	run("bad_operand", "expr 0:\n  LoadInput x",
+		`line 2: strconv.Atoi: parsing "x": invalid syntax`)
+}
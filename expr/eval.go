@@ -0,0 +1,66 @@
+package expr
+
+import (
+	gocontext "context"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/internal/parser"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+)
+
+// Eval evaluates the first expression in expr with inputs by walking the typed
+// AST, instead of compiling it to bytecode. It is faster than compiling and
+// running an expression that is evaluated only once.
+//
+// Eval shares the type checker with Compile, and it returns the same results
+// and errors as running the compiled expression in a runtime.Runtime with the
+// budget set by SetEvalBudget.
+func (c *Compiler) Eval(
+	ctx gocontext.Context,
+	expr string,
+	inputs []runtime.Value,
+) (runtime.Value, error) {
+	if c.err != nil {
+		return runtime.Value{}, c.err
+	}
+
+	progAST, err := parser.Parse(expr)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+
+	// The constants and locals created by the passes are added to a fork of
+	// the builder, so that they do not accumulate in the Compiler.
+	evalCtx := *c.ctx
+	evalCtx.Builder = c.ctx.Builder.Fork()
+	for _, pass := range []context.Pass{
+		context.ResolveNames,
+		context.CheckTypes,
+		context.Fold,
+	} {
+		err = progAST.RunPass(&evalCtx, pass)
+		if err != nil {
+			return runtime.Value{}, err
+		}
+	}
+
+	err = evalCtx.Builder.CheckInputs(inputs)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+
+	evalCtx.Eval = context.NewEvaluation(
+		ctx, 0, inputs, evalCtx.Builder.LocalCount(), c.evalBudget)
+	err = progAST.RunPass(&evalCtx, context.Eval)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+	return evalCtx.Eval.Result, nil
+}
+
+// SetEvalBudget limits the number of loop iterations that a single call to
+// Eval can execute, like runtime.Runtime.SetBudget. Zero, the default, means
+// no limit.
+func (c *Compiler) SetEvalBudget(budget int) {
+	c.evalBudget = budget
+}
@@ -0,0 +1,146 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEval(t *testing.T) {
+	newCompiler := func(opts ...Option) *Compiler {
+		compiler := NewCompiler(opts...)
+		compiler.RegisterInput("a", &types.Array{ElementType: types.Number})
+		compiler.RegisterInput("s", types.String)
+		compiler.RegisterGoFunc("check", func(n float64) (float64, error) {
+			if n < 0 {
+				return 0, errors.New("negative")
+			}
+			return n, nil
+		})
+		elem := types.NewTypeVar("T", nil)
+		compiler.RegisterFuncDef(&FuncDef{
+			Name: "first",
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				arr := args[0].Object().([]runtime.RawValue)
+				elemType := args[0].Type().(*types.Array).ElementType
+				return runtime.NewValue(elemType, arr[0])
+			},
+			Ret:    elem,
+			Params: []types.Type{&types.Array{ElementType: elem}},
+		})
+		return compiler
+	}
+	inputs := func() []runtime.Value {
+		arr := []runtime.RawValue{
+			runtime.NewRawNumber(1),
+			runtime.NewRawNumber(-2),
+			runtime.NewRawNumber(3),
+		}
+		return []runtime.Value{
+			runtime.NewObject(&types.Array{ElementType: types.Number}, arr),
+			runtime.NewString("foo/bar.go"),
+		}
+	}
+
+	compileClosures := func(t *testing.T, input string) *runtime.Program {
+		prog, err := newCompiler(WithClosures()).Compile(input)
+		require.NoError(t, err)
+		return prog
+	}
+
+	run := func(name, input string, budget int, expected interface{}) {
+		t.Run(name, func(t *testing.T) {
+			compiler := newCompiler()
+			compiler.SetEvalBudget(budget)
+			res, err := compiler.Eval(context.Background(), input, inputs())
+
+			// The results and errors must match the runtime.
+			prog, compileErr := newCompiler().Compile(input)
+			if compileErr != nil {
+				require.EqualError(t, err, compileErr.Error())
+			} else {
+				for _, prog := range []*runtime.Program{prog, compileClosures(t, input)} {
+					r := runtime.NewRuntime(prog)
+					r.SetBudget(budget)
+					runRes, runErr := r.Run(context.Background(), 0, inputs())
+					if runErr != nil {
+						require.EqualError(t, err, runErr.Error())
+					} else {
+						require.NoError(t, err)
+						require.Equal(t, runRes, res)
+					}
+				}
+			}
+
+			if expectedErr, ok := expected.(error); ok {
+				require.EqualError(t, err, expectedErr.Error())
+				return
+			}
+			require.NoError(t, err)
+			switch res.Type() {
+			case types.Number:
+				require.Equal(t, expected, res.Number())
+			case types.Bool:
+				require.Equal(t, expected, res.Bool())
+			default:
+				require.Equal(t, expected, res.String())
+			}
+		})
+	}
+
+	run("arith", `count(a, x => x > 0) * 10 + check(3)`, 0, 23.0)
+	run("generic", `first(a) == 1 && first([first(["x"])]) == "x"`, 0, true)
+	run("generic_type", `first(a) == first(["x"])`, 0,
+		errors.New("invalid operation: mistmatched types number and string"))
+	run("reduce", `reduce(a, |acc, x| acc + x, 0)`, 0, 2.0)
+	run("glob", `glob("*/*.go", s) && s =~ "bar"`, 0, true)
+	run("ip", `ip("10.1.2.3") in cidr("10.0.0.0/8")`, 0, true)
+	run("func_error", `any(a, x => check(x) > 2)`, 0, errors.New("negative"))
+	run("budget", `count(a, x => any(a, y => y == x))`, 5, runtime.ErrBudgetExceeded)
+	run("budget_ok", `count(a, x => any(a, y => y == x))`, 9, 3.0)
+	run("regex_error", `regexFind(s, first(["(", s]))`, 0,
+		errors.New("invalid regular expression \"(\": "+
+			"error parsing regexp: missing closing ): `(`"))
+	run("compile_error", `s + 1`, 0, errors.New("operator requires number operands"))
+	run("syntax_error", `s +`, 0,
+		errors.New("1:4: syntax error: unexpected $end"))
+}
+
+func TestEval_Inputs(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.RegisterInput("a", types.Number)
+
+	_, err := compiler.Eval(context.Background(), `a + 1`, nil)
+	require.EqualError(t, err, "program expects 1 inputs but 0 were provided")
+
+	_, err = compiler.Eval(
+		context.Background(), `a + 1`, []runtime.Value{runtime.NewString("1")})
+	require.EqualError(t, err,
+		"program expects input index 0 type number but string was provided")
+}
+
+func TestEval_Reuse(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.RegisterInput("s", types.String)
+	inputs := []runtime.Value{runtime.NewString("abc")}
+
+	// The evaluations do not add locals to the compiler.
+	locals := compiler.ctx.Builder.LocalCount()
+	for i := 0; i < 3; i++ {
+		res, err := compiler.Eval(
+			context.Background(), `s =~ "^a" && any(["b"], x => x == "b")`, inputs)
+		require.NoError(t, err)
+		require.True(t, res.Bool())
+	}
+	require.Equal(t, locals, compiler.ctx.Builder.LocalCount())
+
+	prog, err := compiler.Compile(`regexFind(s, "b.")`)
+	require.NoError(t, err)
+	res, err := runtime.NewRuntime(prog).Run(context.Background(), 0, inputs)
+	require.NoError(t, err)
+	require.Equal(t, "bc", res.String())
+}
@@ -3,6 +3,10 @@ package expr
 import (
 	"context"
 	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"strings"
 	"testing"
 
 	"github.com/dcaiafa/go-expr/expr/runtime"
@@ -14,6 +18,9 @@ var compileError = errors.New("compile error expected")
 
 func runExpr(t *testing.T, input string, args ...interface{}) {
 	compiler := NewCompiler()
+	closureCompiler := NewCompiler(WithClosures())
+	unoptimizedCompiler := NewCompiler(WithoutPeephole())
+	registerCompiler := NewCompiler(WithRegisters())
 
 	var values []runtime.Value
 	for len(args) > 2 {
@@ -33,10 +40,27 @@ func runExpr(t *testing.T, input string, args ...interface{}) {
 		case bool:
 			typ = types.Bool
 			values = append(values, runtime.NewBool(n))
+		case []float64:
+			typ = &types.Array{ElementType: types.Number}
+			arr := make([]runtime.RawValue, len(n))
+			for i, elem := range n {
+				arr[i] = runtime.NewRawNumber(elem)
+			}
+			values = append(values, runtime.NewObject(typ, arr))
+		case []string:
+			typ = &types.Array{ElementType: types.String}
+			arr := make([]runtime.RawValue, len(n))
+			for i, elem := range n {
+				arr[i] = runtime.NewRawObject(elem)
+			}
+			values = append(values, runtime.NewObject(typ, arr))
 		default:
 			panic("invalid type")
 		}
 		compiler.RegisterInput(symbol, typ)
+		closureCompiler.RegisterInput(symbol, typ)
+		unoptimizedCompiler.RegisterInput(symbol, typ)
+		registerCompiler.RegisterInput(symbol, typ)
 		args = args[2:]
 	}
 
@@ -46,18 +70,64 @@ func runExpr(t *testing.T, input string, args ...interface{}) {
 	}
 
 	prog, err := compiler.Compile(input)
+	evalRes, evalErr := compiler.Eval(context.Background(), input, values)
 	if expected == compileError {
 		if err == nil {
 			t.Fatal("compilation error expected, but it succeeded")
 		} else {
+			require.EqualError(t, evalErr, err.Error())
 			return
 		}
 	}
 	require.NoError(t, err)
+	require.NoError(t, prog.Verify())
 
 	run := runtime.NewRuntime(prog)
 	res, err := run.Run(context.Background(), 0, values)
 	require.NoError(t, err)
+	require.NoError(t, evalErr)
+	require.Equal(t, res, evalRes)
+
+	closureProg, err := closureCompiler.Compile(input)
+	require.NoError(t, err)
+	closureRes, err := runtime.NewRuntime(closureProg).Run(context.Background(), 0, values)
+	require.NoError(t, err)
+	require.Equal(t, res, closureRes)
+
+	unoptimizedProg, err := unoptimizedCompiler.Compile(input)
+	require.NoError(t, err)
+	require.NoError(t, unoptimizedProg.Verify())
+	require.LessOrEqual(t, prog.InstrCount(0), unoptimizedProg.InstrCount(0))
+	unoptimizedRes, err := runtime.NewRuntime(unoptimizedProg).Run(context.Background(), 0, values)
+	require.NoError(t, err)
+	require.Equal(t, res, unoptimizedRes)
+
+	registerProg, err := registerCompiler.Compile(input)
+	require.NoError(t, err)
+	registerRes, err := runtime.NewRuntime(registerProg).Run(context.Background(), 0, values)
+	require.NoError(t, err)
+	require.Equal(t, res, registerRes)
+
+	data, err := prog.MarshalBinary()
+	require.NoError(t, err)
+	loadedProg, err := compiler.LoadProgram(data)
+	require.NoError(t, err)
+	loadedRes, err := runtime.NewRuntime(loadedProg).Run(context.Background(), 0, values)
+	require.NoError(t, err)
+	require.Equal(t, res, loadedRes)
+
+	var listing strings.Builder
+	require.NoError(t, prog.Disassemble(&listing))
+	asmProg, err := runtime.Assemble(strings.NewReader(listing.String()))
+	require.NoError(t, err)
+	require.NoError(t, asmProg.Link(funcResolver{compiler}))
+	require.NoError(t, asmProg.Verify())
+	var asmListing strings.Builder
+	require.NoError(t, asmProg.Disassemble(&asmListing))
+	require.Equal(t, listing.String(), asmListing.String())
+	asmRes, err := runtime.NewRuntime(asmProg).Run(context.Background(), 0, values)
+	require.NoError(t, err)
+	require.Equal(t, res, asmRes)
 
 	switch prog.ResultType {
 	case types.Number:
@@ -70,7 +140,29 @@ func runExpr(t *testing.T, input string, args ...interface{}) {
 		require.Equal(t, types.String, res.Type())
 		require.Equal(t, expected, res.String())
 	default:
-		panic("invalid result/expectation")
+		arr := res.Object().([]runtime.RawValue)
+		switch expected.(type) {
+		case []float64:
+			actual := []float64{}
+			for _, elem := range arr {
+				actual = append(actual, elem.Number())
+			}
+			require.Equal(t, expected, actual)
+		case []string:
+			actual := []string{}
+			for _, elem := range arr {
+				actual = append(actual, elem.String())
+			}
+			require.Equal(t, expected, actual)
+		case []bool:
+			actual := []bool{}
+			for _, elem := range arr {
+				actual = append(actual, elem.Bool())
+			}
+			require.Equal(t, expected, actual)
+		default:
+			panic("invalid result/expectation")
+		}
 	}
 }
 
@@ -111,6 +203,13 @@ func TestExpr_BinaryExpr_Vars(t *testing.T) {
 	run("eq_arr_str2", `[foo, bar] == [foo]`, "foo", "foo", "bar", "bar", false)
 	run("neq1", "a!=b", "a", 1, "b", 2, true)
 	run("neq2", "a!=b", "a", 1, "b", 1, false)
+	run("identity_mult", "a * 1", "a", 5, "b", 2, 5)
+	run("identity_mult2", "1 * a", "a", 5, "b", 2, 5)
+	run("identity_div", "a / 1", "a", 5, "b", 2, 5)
+	run("identity_minus", "a - 0", "a", 5, "b", 2, 5)
+	run("identity_plus", "a + -0", "a", 5, "b", 2, 5)
+	run("identity_plus2", "-0 + a", "a", 5, "b", 2, 5)
+	run("plus_zero", "a + 0", "a", 5, "b", 2, 5)
 }
 
 func TestExpr_BinaryExpr_Literals(t *testing.T) {
@@ -168,6 +267,10 @@ func TestExpr_AndExpr(t *testing.T) {
 	run("fold2", "false && true", false)
 	run("fold3", "true && false", false)
 	run("fold4", "false && false", false)
+	run("fold5", "false && a", "a", true, false)
+	run("fold6", "true && a", "a", false, false)
+	run("fold7", "a && true", "a", true, true)
+	run("fold8", "a && false", "a", true, false)
 
 	run("keyword1", "true and true", true)
 	run("keyword2", "false and true", false)
@@ -193,6 +296,10 @@ func TestExpr_OrExpr(t *testing.T) {
 	run("fold2", "false || true", true)
 	run("fold3", "true || false", true)
 	run("fold4", "false || false", false)
+	run("fold5", "true || a", "a", false, true)
+	run("fold6", "false || a", "a", true, true)
+	run("fold7", "a || false", "a", false, false)
+	run("fold8", "a || true", "a", false, true)
 
 	run("fold1", "true or true", true)
 	run("fold2", "false or true", true)
@@ -212,6 +319,8 @@ func TestExpr_NegateExpr(t *testing.T) {
 
 	run("fold1", "!true", false)
 	run("fold2", "!false", true)
+	run("double", "!(!a)", "a", true, true)
+	run("triple", "!(!(!a))", "a", true, false)
 
 	run("keyword1", "not true", false)
 	run("keyword2", "not false", true)
@@ -255,7 +364,198 @@ func TestExpr_InExpr(t *testing.T) {
 	run("str_2", `a in [b, "bar"]`, "a", "fruit", "b", "meat", false)
 
 	run("error_mismatched_types", `1 in ["foo"]`, compileError)
-	run("error_empty_literal", `1 in []`, compileError)
+	run("empty_literal", `1 in []`, false)
+	run("bool", `a in [true]`, "a", true, true)
+	run("array", `[a, 2] in [[1], [1, 2]]`, "a", 1, true)
+	run("array2", `[a, 2] in [[1], [1, 2]]`, "a", 2, false)
+	run("empty_left", `[] in [[1], []]`, true)
+	run("empty_left2", `[] in [[1]]`, false)
+	run("const_array", `[1, 2] in [[1], [1, 2]]`, true)
+	run("const_bool", `false in [true]`, false)
+
+	// Constant arrays of 16 elements or more are tested with a set.
+	const numbers = `[0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15]`
+	const letters = `["a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p"]`
+	run("set_1", `a in `+numbers, "a", 15, true)
+	run("set_2", `a in `+numbers, "a", 16, false)
+	run("set_3", `a * -1 in `+numbers, "a", 0, true)
+	run("set_4", `a / a in [0/0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15]`, "a", 0, false)
+	run("set_5", `a in [1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1]`, "a", 1, true)
+	run("set_6", `a in `+letters, "a", "p", true)
+	run("set_7", `a in `+letters, "a", "", false)
+	run("set_8", `count(a, x => x in `+numbers+`)`, "a", []float64{0, 16, -1, 2}, 2)
+}
+
+func TestExpr_ArrayLiteral(t *testing.T) {
+	run := func(name, input string, args ...interface{}) {
+		t.Run(name, func(t *testing.T) {
+			runExpr(t, input, args...)
+		})
+	}
+
+	run("empty_eq", "a == []", "a", []float64{}, true)
+	run("empty_eq2", "[] == a", "a", []float64{1}, false)
+	run("empty_ne", `a != []`, "a", []string{"foo"}, true)
+	run("annotated", "[]number", []float64{})
+	run("annotated_nested", "[][]string == []", true)
+	run("nested", "[[1, 2], [3]] == [[1, 2], [3]]", true)
+	run("nested2", "[[1, 2], [3]] == [[1, 2], [4]]", false)
+	run("nested3", "[[1, 2], [3]] == [[1, 2], [3], []]", false)
+	run("nested_empty", "[[], [a]] == [[], [1]]", "a", 1, true)
+	run("nested_vars", "[[a], [b, a]] == [[1], [2, 1]]", "a", 1, "b", 2, true)
+	run("deep", "[[[true]], [[false, true]]] != [[[true]], [[false, false]]]", true)
+	run("comprehension", "[[x, x] for x in a] == [[1, 1], [2, 2]]", "a", []float64{1, 2}, true)
+	run("count_nested", "count([[1, 2], [], [3]], x => x != [])", 2)
+	run("map_empty", "map([]number, x => x)", []float64{})
+
+	run("error_infer", "[]", compileError)
+	run("error_infer_nested", "[[], []]", compileError)
+	run("error_mismatched", "[[1], [\"a\"]]", compileError)
+	run("error_annotation", "[]foo", compileError)
+	run("error_hint", "1 == []", compileError)
+}
+
+func TestExpr_ArrayLiteral_FuncHint(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.RegisterFunc(
+		"len",
+		func(ctx context.Context, args []runtime.Value) runtime.Value {
+			a := args[0].Object().([]runtime.RawValue)
+			return runtime.NewNumber(float64(len(a)))
+		},
+		types.Number, &types.Array{ElementType: types.String},
+	)
+
+	prog, err := compiler.Compile("len([]) + len([\"a\", \"b\"])")
+	require.NoError(t, err)
+
+	r := runtime.NewRuntime(prog)
+	res, err := r.Run(context.Background(), 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, float64(2), res.Number())
+}
+
+func TestExpr_Lambda(t *testing.T) {
+	run := func(name, input string, args ...interface{}) {
+		t.Run(name, func(t *testing.T) {
+			runExpr(t, input, args...)
+		})
+	}
+
+	nums := []float64{1, 7, 3, 9}
+	strs := []string{"foo", "bar", "baz"}
+
+	run("map", "map(a, x => x * 2)", "a", nums, []float64{2, 14, 6, 18})
+	run("map_empty", "map(a, x => x * 2)", "a", []float64{}, []float64{})
+	run("map_str", `map(a, x => x == "bar")`, "a", strs, []bool{false, true, false})
+	run("map_pipe", "map(a, |x| x + 1)", "a", nums, []float64{2, 8, 4, 10})
+	run("filter", "filter(a, x => x > 5)", "a", nums, []float64{7, 9})
+	run("filter_str", `filter(a, x => x != "bar")`, "a", strs, []string{"foo", "baz"})
+	run("any1", "any(a, x => x > 5)", "a", nums, true)
+	run("any2", "any(a, x => x > 50)", "a", nums, false)
+	run("all1", "all(a, x => x > 0)", "a", nums, true)
+	run("all2", "all(a, x => x > 5)", "a", nums, false)
+	run("all_empty", "all(a, x => x > 5)", "a", []float64{}, true)
+	run("count", "count(a, x => x > 2)", "a", nums, 3)
+	run("find1", "find(a, x => x > 5)", "a", nums, 7)
+	run("find2", "find(a, x => x > 50)", "a", nums, 0)
+	run("find_str", `find(a, x => x == "baz")`, "a", strs, "baz")
+	run("reduce", "reduce(a, |acc, x| acc + x, 0)", "a", nums, 20)
+	run("reduce_str", `reduce(a, |acc, x| acc + 1, 10)`, "a", strs, 13)
+	run("nested", "any(a, x => any(b, y => y == x))", "a", nums, "b", []float64{5, 9}, true)
+	run("nested2", "any(a, x => any(b, y => y == x))", "a", nums, "b", []float64{5, 6}, false)
+	run("outer_ref", "count(a, x => x > b)", "a", nums, "b", 5, 2)
+	run("composed", "count(filter(map(a, x => x * 10), x => x > 20), x => x < 80)", "a", nums, 2)
+	run("and", "a > 1 && any(b, x => x == a)", "a", 3, "b", nums, true)
+
+	run("error_not_array", "map(a, x => x)", "a", 1, compileError)
+	run("error_not_lambda", "map(a, a)", "a", nums, compileError)
+	run("error_not_bool", "filter(a, x => x + 1)", "a", nums, compileError)
+	run("error_param_count", "filter(a, |x, y| x > y)", "a", nums, compileError)
+	run("error_reduce_type", `reduce(a, |acc, x| acc > x, 0)`, "a", nums, compileError)
+	run("error_lambda_value", "x => x", compileError)
+	run("error_scope", "any(a, x => x > 1) && x > 1", "a", nums, compileError)
+}
+
+func TestExpr_Lambda_Shadowing(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.RegisterFunc(
+		"count",
+		func(ctx context.Context, args []runtime.Value) runtime.Value {
+			return runtime.NewNumber(42)
+		},
+		types.Number, types.Number,
+	)
+
+	prog, err := compiler.Compile("count(1)")
+	require.NoError(t, err)
+
+	r := runtime.NewRuntime(prog)
+	res, err := r.Run(context.Background(), 0, nil)
+	require.NoError(t, err)
+	require.Equal(t, float64(42), res.Number())
+}
+
+func TestExpr_Comprehension(t *testing.T) {
+	run := func(name, input string, args ...interface{}) {
+		t.Run(name, func(t *testing.T) {
+			runExpr(t, input, args...)
+		})
+	}
+
+	nums := []float64{1, -7, 3, -9}
+	strs := []string{"foo", "bar", "baz"}
+
+	run("map", "[x * 2 for x in a]", "a", nums, []float64{2, -14, 6, -18})
+	run("filter", "[x * 2 for x in a if x > 0]", "a", nums, []float64{2, 6})
+	run("str", `[x for x in a if x != "bar"]`, "a", strs, []string{"foo", "baz"})
+	run("to_bool", `[x == "bar" for x in a]`, "a", strs, []bool{false, true, false})
+	run("error_multiple_for", "[x + y for x in a if x > 0 for y in b]", "a", nums, "b", nums, compileError)
+	run("source_expr", "[x for x in [y * 10 for y in a]]", "a", nums, []float64{10, -70, 30, -90})
+	run("outer_ref", "[x + b for x in a]", "a", nums, "b", 1, []float64{2, -6, 4, -8})
+	run("empty", "[x for x in a if x > 100]", "a", nums, []float64{})
+
+	run("any1", "any x in a: x > 2", "a", nums, true)
+	run("any2", "any x in a: x > 20", "a", nums, false)
+	run("all1", "all x in a: x > -10", "a", nums, true)
+	run("all2", "all x in a: x > 0", "a", nums, false)
+	run("any_str", `any x in a: x == "baz"`, "a", strs, true)
+	run("any_nested", "any x in a: all y in b: y != x", "a", nums, "b", []float64{1, 3}, true)
+	run("any_and", "(any x in a: x > 2) && b", "a", nums, "b", false, false)
+
+	run("error_quantifier", "some x in a: x > 2", "a", nums, compileError)
+	run("error_not_bool", "any x in a: x + 2", "a", nums, compileError)
+	run("error_not_array", "any x in a: x > 2", "a", 1, compileError)
+	run("error_filter", "[x for x in a if x]", "a", nums, compileError)
+	run("error_scope", "[x for x in a] == [x]", "a", nums, compileError)
+	run("error_source_scope", "[x for x in x]", "a", nums, compileError)
+}
+
+func TestExpr_Budget(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.RegisterInput("a", &types.Array{ElementType: types.Number})
+
+	prog, err := compiler.Compile("count(a, x => any(a, y => y == x))")
+	require.NoError(t, err)
+
+	arr := []runtime.RawValue{
+		runtime.NewRawNumber(1),
+		runtime.NewRawNumber(2),
+		runtime.NewRawNumber(3),
+	}
+	args := []runtime.Value{
+		runtime.NewObject(&types.Array{ElementType: types.Number}, arr),
+	}
+
+	r := runtime.NewRuntime(prog)
+	r.SetBudget(9)
+	res, err := r.Run(context.Background(), 0, args)
+	require.NoError(t, err)
+	require.Equal(t, float64(3), res.Number())
+
+	r.SetBudget(8)
+	_, err = r.Run(context.Background(), 0, args)
+	require.Equal(t, runtime.ErrBudgetExceeded, err)
 }
 
 func TestExpr_Func_Basic(t *testing.T) {
@@ -282,6 +582,826 @@ func TestExpr_Func_Basic(t *testing.T) {
 	require.Equal(t, float64(5), res.Number())
 }
 
+func TestExpr_Func_Overload(t *testing.T) {
+	numArray := &types.Array{ElementType: types.Number}
+	strArray := &types.Array{ElementType: types.String}
+
+	newCompiler := func() *Compiler {
+		compiler := NewCompiler()
+		err := compiler.RegisterFunc(
+			"max",
+			func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(math.Max(args[0].Number(), args[1].Number()))
+			},
+			types.Number, types.Number, types.Number,
+		)
+		require.NoError(t, err)
+		err = compiler.RegisterFunc(
+			"max",
+			func(ctx context.Context, args []runtime.Value) runtime.Value {
+				res := math.Inf(-1)
+				for _, elem := range args[0].Object().([]runtime.RawValue) {
+					res = math.Max(res, elem.Number())
+				}
+				return runtime.NewNumber(res)
+			},
+			types.Number, numArray,
+		)
+		require.NoError(t, err)
+		err = compiler.RegisterFunc(
+			"len",
+			func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(float64(len(args[0].String())))
+			},
+			types.Number, types.String,
+		)
+		require.NoError(t, err)
+		err = compiler.RegisterFunc(
+			"len",
+			func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(float64(len(args[0].Object().([]runtime.RawValue))))
+			},
+			types.Number, strArray,
+		)
+		require.NoError(t, err)
+		return compiler
+	}
+
+	run := func(name, input string, expected float64) {
+		t.Run(name, func(t *testing.T) {
+			prog, err := newCompiler().Compile(input)
+			require.NoError(t, err)
+			res, err := runtime.NewRuntime(prog).Run(context.Background(), 0, nil)
+			require.NoError(t, err)
+			require.Equal(t, expected, res.Number())
+		})
+	}
+
+	run("max_num", "max(3, 8)", 8)
+	run("max_array", "max([3, 9, 8])", 9)
+	run("len_string", `len("hello")`, 5)
+	run("len_array", `len(["a", "b"])`, 2)
+	run("len_empty_array", `len([]string)`, 0)
+	run("nested", `max(len("abc"), max([1, len(["x"])]))`, 3)
+
+	t.Run("no_match", func(t *testing.T) {
+		_, err := newCompiler().Compile(`max("a", 1)`)
+		require.EqualError(t, err,
+			"no overload of max matches arguments (string, number); candidates are:\n"+
+				"  func(number, number) number\n"+
+				"  func(array of number) number")
+	})
+
+	t.Run("ambiguous_ref", func(t *testing.T) {
+		_, err := newCompiler().Compile(`map([1], x => max)`)
+		require.Error(t, err)
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		compiler := newCompiler()
+		err := compiler.RegisterFunc(
+			"len",
+			func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(0)
+			},
+			types.Bool, types.String,
+		)
+		require.Error(t, err)
+		err = compiler.RegisterFuncDef(&FuncDef{
+			Name: "max",
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(0)
+			},
+			Ret:      types.Number,
+			Params:   []types.Type{types.Number, types.Number},
+			Defaults: []runtime.Value{runtime.NewNumber(1)},
+		})
+		require.Error(t, err)
+
+		// The rejected overloads do not add constants.
+		prog, err := compiler.Compile(`1`)
+		require.NoError(t, err)
+		var listing strings.Builder
+		require.NoError(t, prog.Disassemble(&listing))
+		require.Equal(t, 4, strings.Count(listing.String(), "\nconst "), listing.String())
+	})
+
+	t.Run("not_func", func(t *testing.T) {
+		compiler := newCompiler()
+		_, err := compiler.RegisterInput("a", types.Number)
+		require.NoError(t, err)
+		err = compiler.RegisterFunc(
+			"a",
+			func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(0)
+			},
+			types.Number, types.String,
+		)
+		require.Error(t, err)
+	})
+}
+
+func TestExpr_Func_Variadic(t *testing.T) {
+	newCompiler := func() *Compiler {
+		compiler := NewCompiler()
+		err := compiler.RegisterVariadicFunc(
+			"concat",
+			func(ctx context.Context, args []runtime.Value) runtime.Value {
+				var sb strings.Builder
+				for _, arg := range args {
+					require.Equal(t, types.String, arg.Type())
+					sb.WriteString(arg.String())
+				}
+				return runtime.NewString(sb.String())
+			},
+			types.String, types.String,
+		)
+		require.NoError(t, err)
+		err = compiler.RegisterFuncDef(&FuncDef{
+			Name: "format",
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				fmtArgs := make([]interface{}, len(args)-1)
+				for i, arg := range args[1:] {
+					switch arg.Type() {
+					case types.Number:
+						fmtArgs[i] = arg.Number()
+					case types.Bool:
+						fmtArgs[i] = arg.Bool()
+					case types.String:
+						fmtArgs[i] = arg.String()
+					default:
+						fmtArgs[i] = arg.Type().String()
+					}
+				}
+				return runtime.NewString(fmt.Sprintf(args[0].String(), fmtArgs...))
+			},
+			Ret:      types.String,
+			Params:   []types.Type{types.String, types.Any},
+			Variadic: true,
+		})
+		require.NoError(t, err)
+		err = compiler.RegisterFuncDef(&FuncDef{
+			Name: "pad",
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				s := args[0].String()
+				for len(s) < int(args[1].Number()) {
+					s = args[2].String() + s
+				}
+				return runtime.NewString(s)
+			},
+			Ret:      types.String,
+			Params:   []types.Type{types.String, types.Number, types.String},
+			Defaults: []runtime.Value{runtime.NewNumber(4), runtime.NewString(" ")},
+		})
+		require.NoError(t, err)
+		return compiler
+	}
+
+	run := func(name, input string, expected interface{}) {
+		t.Run(name, func(t *testing.T) {
+			prog, err := newCompiler().Compile(input)
+			if expected == compileError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			res, err := runtime.NewRuntime(prog).Run(context.Background(), 0, nil)
+			require.NoError(t, err)
+			require.Equal(t, expected, res.String())
+		})
+	}
+
+	run("concat0", `concat()`, "")
+	run("concat1", `concat("a")`, "a")
+	run("concat3", `concat("a", "b", "c")`, "abc")
+	run("format0", `format("hello")`, "hello")
+	run("format", `format("%v %v %v", 1, "a", true)`, "1 a true")
+	run("format_array", `format("%v", [1])`, "array of number")
+	run("pad1", `pad("a")`, "   a")
+	run("pad2", `pad("a", 2)`, " a")
+	run("pad3", `pad("a", 3, "-")`, "--a")
+
+	run("error_concat_type", `concat("a", 1)`, compileError)
+	run("error_format_min", `format()`, compileError)
+	run("error_pad_min", `pad()`, compileError)
+	run("error_pad_max", `pad("a", 2, "-", "-")`, compileError)
+	run("error_pad_type", `pad("a", "b")`, compileError)
+
+	compiler := NewCompiler()
+	nop := func(ctx context.Context, args []runtime.Value) runtime.Value {
+		return runtime.NewString("")
+	}
+	err := compiler.RegisterFuncDef(&FuncDef{
+		Name:     "bad",
+		Func:     nop,
+		Ret:      types.String,
+		Params:   []types.Type{types.String},
+		Defaults: []runtime.Value{runtime.NewNumber(1)},
+	})
+	require.Error(t, err)
+	err = compiler.RegisterFuncDef(&FuncDef{
+		Name:     "bad",
+		Func:     nop,
+		Ret:      types.String,
+		Params:   []types.Type{types.String},
+		Defaults: []runtime.Value{runtime.NewString("a"), runtime.NewString("b")},
+	})
+	require.Error(t, err)
+	err = compiler.RegisterFuncDef(&FuncDef{
+		Name:     "bad",
+		Func:     nop,
+		Ret:      types.String,
+		Variadic: true,
+	})
+	require.Error(t, err)
+	err = compiler.RegisterFuncDef(&FuncDef{
+		Name:   "bad",
+		Ret:    types.String,
+		Params: []types.Type{types.String},
+	})
+	require.Error(t, err)
+}
+
+func TestExpr_Register_InvalidType(t *testing.T) {
+	for _, typ := range []types.Type{
+		types.Any, types.Regexp, types.Void, &types.Array{ElementType: types.Any},
+	} {
+		t.Run(typ.String(), func(t *testing.T) {
+			compiler := NewCompiler()
+			_, err := compiler.RegisterInput("x", typ)
+			require.EqualError(t, err, "input x has invalid type "+typ.String())
+			err = compiler.RegisterConst("k", runtime.NewObject(typ, nil))
+			require.EqualError(t, err, "constant k has invalid type "+typ.String())
+
+			_, err = compiler.Compile(`x == k`)
+			require.EqualError(t, err, "undefined: x")
+		})
+	}
+}
+
+func TestExpr_Register_Keyword(t *testing.T) {
+	for _, name := range []string{"for", "if", "in", "matches"} {
+		t.Run(name, func(t *testing.T) {
+			expected := name + " is a keyword and cannot be used as a name"
+			compiler := NewCompiler()
+			_, err := compiler.RegisterInput(name, types.Number)
+			require.EqualError(t, err, expected)
+			err = compiler.RegisterConst(name, runtime.NewNumber(1))
+			require.EqualError(t, err, expected)
+			err = compiler.RegisterGoFunc(name, func(x float64) float64 { return x })
+			require.EqualError(t, err, expected)
+		})
+	}
+}
+
+func TestExpr_Regex(t *testing.T) {
+	run := func(name, input string, args ...interface{}) {
+		t.Run(name, func(t *testing.T) {
+			runExpr(t, input, args...)
+		})
+	}
+
+	run("match", `"abc" =~ "^a.c$"`, true)
+	run("match_keyword", `"abc" matches "^b"`, false)
+	run("match_input", `s =~ "[0-9]+"`, "s", "abc123", true)
+	run("match_input_pattern", `"abc123" =~ p`, "p", "^[a-z]+[0-9]+$", true)
+	run("match_and", `s =~ "a" && s =~ "z"`, "s", "abc", false)
+	run("match_type", `1 =~ "a"`, compileError)
+	run("match_pattern_type", `"a" =~ 1`, compileError)
+
+	run("find", `regexFind(s, "[0-9]+")`, "s", "ab12cd345", "12")
+	run("find_none", `regexFind("abc", "[0-9]+")`, "")
+	run("replace", `regexReplace(s, "([a-z])([0-9])", "$2$1")`, "s", "a1b2", "1a2b")
+	run("split", `regexSplit(s, " *, *")`, "s", "a , b,c", []string{"a", "b", "c"})
+	run("split_literal", `regexSplit("a1b", "[0-9]")`, []string{"a", "b"})
+	run("builtin_args", `regexFind("a")`, compileError)
+	run("builtin_type", `regexReplace("a", "b", 1)`, compileError)
+
+	t.Run("invalid_pattern", func(t *testing.T) {
+		_, err := NewCompiler().Compile(`["x"] == ["y"] ||
+  "a" =~ "(b"`)
+		require.EqualError(t, err,
+			"2:10: invalid regular expression \"(b\": error parsing regexp: "+
+				"missing closing ): `(b`")
+	})
+
+	t.Run("invalid_pattern_builtin", func(t *testing.T) {
+		_, err := NewCompiler().Compile(`regexSplit("a", "[")`)
+		require.Error(t, err)
+		require.True(t, strings.HasPrefix(err.Error(), "1:17: invalid regular expression"))
+	})
+
+	t.Run("invalid_pattern_input", func(t *testing.T) {
+		compiler := NewCompiler()
+		compiler.RegisterInput("p", types.String)
+		prog, err := compiler.Compile(`"a" =~ p`)
+		require.NoError(t, err)
+		_, err = runtime.NewRuntime(prog).Run(
+			context.Background(), 0, []runtime.Value{runtime.NewString("(")})
+		require.Error(t, err)
+	})
+
+	t.Run("consts", func(t *testing.T) {
+		compiler := NewCompiler()
+		compiler.RegisterInput("s", types.String)
+		compiler.RegisterInput("t", types.String)
+		constCount := func(input string) int {
+			prog, err := compiler.Compile(input)
+			require.NoError(t, err)
+			var listing strings.Builder
+			require.NoError(t, prog.Disassemble(&listing))
+			return strings.Count(listing.String(), "\nconst ")
+		}
+
+		// Calls that are folded do not add constants.
+		require.Equal(t, 0, constCount(`regexFind("ab1", "[0-9]+") == s`))
+		// The occurrences of a function and of a pattern share one constant,
+		// also across the expressions of the compiler.
+		require.Equal(t, 2, constCount(`s =~ "a+" || t =~ "a+"`))
+		require.Equal(t, 3, constCount(`regexFind(s, "a+") == t`))
+	})
+}
+
+func TestExpr_Glob(t *testing.T) {
+	run := func(name, input string, args ...interface{}) {
+		t.Run(name, func(t *testing.T) {
+			runExpr(t, input, args...)
+		})
+	}
+
+	run("literal", `glob("/a/b", "/a/b")`, true)
+	run("star", `glob("/a/*.txt", p)`, "p", "/a/b.txt", true)
+	run("star_no_slash", `glob("/a/*.txt", p)`, "p", "/a/b/c.txt", false)
+	run("double_star", `glob("/a/**.txt", p)`, "p", "/a/b/c.txt", true)
+	run("double_star_newline", `glob("/a/**", p)`, "p", "/a/b\nc/d", true)
+	run("star_newline", `glob("/a/*", p)`, "p", "/a/b\nc", true)
+	run("question", `glob("/a/?", p)`, "p", "/a/b", true)
+	run("question_no_slash", `glob("/a?b", p)`, "p", "/a/b", false)
+	run("class", `glob("/[a-c]x", p)`, "p", "/bx", true)
+	run("class_miss", `glob("/[a-c]x", p)`, "p", "/dx", false)
+	run("class_negated", `glob("/[^a-c]x", p)`, "p", "/dx", true)
+	run("class_no_slash", `glob("a[^b]c", p)`, "p", "a/c", false)
+	run("class_range_slash", `glob("a[+-0]c", p)`, "p", "a/c", false)
+	run("escape", `glob("a\\*", p)`, "p", "a*", true)
+	run("escape_miss", `glob("a\\*", p)`, "p", "ab", false)
+	run("regexp_meta", `glob("a.b(c)", p)`, "p", "a.b(c)", true)
+	run("anchored", `glob("b", p)`, "p", "abc", false)
+	run("dynamic", `glob(g, "/a/b")`, "g", "/a/*", true)
+	run("type", `glob("a", 1)`, compileError)
+
+	for _, pattern := range []string{`[`, `[]`, `[a`, `[b-a]`, `a\\`, `[/]`} {
+		t.Run("invalid "+pattern, func(t *testing.T) {
+			_, err := NewCompiler().Compile(`glob("` + pattern + `", "a")`)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "1:6: invalid glob pattern")
+		})
+	}
+}
+
+func TestExpr_IP(t *testing.T) {
+	run := func(name, input string, expected interface{}) {
+		t.Run(name, func(t *testing.T) {
+			compiler := NewCompiler()
+			compiler.RegisterInput("client", types.IP)
+			compiler.RegisterInput("s", types.String)
+			prog, err := compiler.Compile(input)
+			if expected == compileError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			args := []runtime.Value{
+				runtime.NewObject(types.IP, netip.MustParseAddr("192.168.1.20")),
+				runtime.NewString("10.1.2.3"),
+			}
+			res, err := runtime.NewRuntime(prog).Run(context.Background(), 0, args)
+			if expectedErr, ok := expected.(error); ok {
+				require.EqualError(t, err, expectedErr.Error())
+				return
+			}
+			require.NoError(t, err)
+			switch prog.ResultType {
+			case types.Bool:
+				require.Equal(t, expected, res.Bool())
+			default:
+				require.Equal(t, expected, res.Object())
+			}
+		})
+	}
+
+	run("ip", `ip("10.0.0.1")`, netip.MustParseAddr("10.0.0.1"))
+	run("ip_v6", `ip("2001:db8::1")`, netip.MustParseAddr("2001:db8::1"))
+	run("ip_unmap", `ip("::ffff:10.0.0.1") == ip("10.0.0.1")`, true)
+	run("cidr_masked", `cidr("10.1.2.3/8")`, netip.MustParsePrefix("10.0.0.0/8"))
+	run("in", `ip("10.0.0.1") in cidr("10.0.0.0/8")`, true)
+	run("not_in", `ip("11.0.0.1") in cidr("10.0.0.0/8")`, false)
+	run("in_input", `client in cidr("192.168.0.0/16")`, true)
+	run("in_v6", `client in cidr("2001:db8::/32")`, false)
+	run("in_dynamic", `ip(s) in cidr("10.0.0.0/8")`, true)
+	run("eq", `client == ip("192.168.1.20")`, true)
+	run("ne", `client != ip("192.168.1.20")`, false)
+	run("in_array", `client in [ip("10.0.0.1"), ip("192.168.1.20")]`, true)
+	run("in_empty_array", `client in []ip`, false)
+	run("policy", `client in cidr("10.0.0.0/8") || client in cidr("192.168.1.0/24")`, true)
+	run("dynamic_error", `ip(s + "x")`, compileError)
+	run("dynamic_invalid", `cidr(s) == cidr("10.0.0.0/8")`, errors.New(`invalid CIDR "10.1.2.3"`))
+	run("type", `ip(1)`, compileError)
+	run("args", `ip("10.0.0.1", "10.0.0.2")`, compileError)
+	run("in_type", `client in cidr("10.0.0.0/8") == cidr("10.0.0.0/8")`, compileError)
+	run("in_string", `"10.0.0.1" in cidr("10.0.0.0/8")`, compileError)
+
+	t.Run("invalid_literal", func(t *testing.T) {
+		_, err := NewCompiler().Compile(`ip("10.0.0.1") in cidr("10.0.0.0/33")`)
+		require.EqualError(t, err, `1:24: invalid CIDR "10.0.0.0/33"`)
+		_, err = NewCompiler().Compile(`ip("10.0.0.256")`)
+		require.EqualError(t, err, `1:4: invalid IP address "10.0.0.256"`)
+	})
+
+	t.Run("consts", func(t *testing.T) {
+		compiler := NewCompiler()
+		compiler.RegisterInput("client", types.IP)
+		compiler.RegisterInput("s", types.String)
+		compiler.RegisterInput("t", types.String)
+		prog, err := compiler.Compile(`ip(s) == client || ip(t) == client`)
+		require.NoError(t, err)
+		var listing strings.Builder
+		require.NoError(t, prog.Disassemble(&listing))
+		require.Equal(t, 1, strings.Count(listing.String(), "\nconst "), listing.String())
+	})
+}
+
+func TestExpr_Func_Generic(t *testing.T) {
+	elemT := types.NewTypeVar("T", nil)
+	arrT := &types.Array{ElementType: elemT}
+
+	newCompiler := func() *Compiler {
+		compiler := NewCompiler()
+		err := compiler.RegisterFuncDef(&FuncDef{
+			Name: "at",
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				arr := args[0].Object().([]runtime.RawValue)
+				elemType := args[0].Type().(*types.Array).ElementType
+				return runtime.NewValue(elemType, arr[int(args[1].Number())])
+			},
+			Ret:    elemT,
+			Params: []types.Type{arrT, types.Number},
+		})
+		require.NoError(t, err)
+		err = compiler.RegisterFuncDef(&FuncDef{
+			Name: "pair",
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				arr := []runtime.RawValue{args[0].RawValue, args[1].RawValue}
+				return runtime.NewObject(&types.Array{ElementType: args[0].Type()}, arr)
+			},
+			Ret:    arrT,
+			Params: []types.Type{elemT, elemT},
+		})
+		require.NoError(t, err)
+		err = compiler.RegisterFunc(
+			"count",
+			func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(42)
+			},
+			types.Number, &types.Array{ElementType: types.Number},
+		)
+		require.NoError(t, err)
+		return compiler
+	}
+
+	run := func(name, input string, expected interface{}) {
+		t.Run(name, func(t *testing.T) {
+			prog, err := newCompiler().Compile(input)
+			if expected == compileError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			res, err := runtime.NewRuntime(prog).Run(context.Background(), 0, nil)
+			require.NoError(t, err)
+			switch expected := expected.(type) {
+			case []string:
+				arr := res.Object().([]runtime.RawValue)
+				actual := make([]string, len(arr))
+				for i, elem := range arr {
+					actual[i] = elem.String()
+				}
+				require.Equal(t, expected, actual)
+			case string:
+				require.Equal(t, expected, res.String())
+			default:
+				require.Equal(t, expected, res.Number())
+			}
+		})
+	}
+
+	run("number", `at([1, 2, 3], 1) + 1`, float64(3))
+	run("string", `at(["a", "b"], 1)`, "b")
+	run("nested", `at(at([["a"], ["b", "c"]], 1), 1)`, "c")
+	run("result", `pair("a", "b")`, []string{"a", "b"})
+	run("result_nested", `at(pair(["a"], []), 0)`, []string{"a"})
+	run("mismatch", `pair("a", 1)`, compileError)
+	run("not_array", `at(1, 0)`, compileError)
+	run("cannot_infer", `at([], 0)`, compileError)
+	run("reference", `at`, compileError)
+
+	// A call with a lambda argument resolves to the builtin.
+	run("host_count", `count([1, 2])`, float64(42))
+	run("builtin_count", `count([1, 2], x => x > 1)`, float64(1))
+
+	err := NewCompiler().RegisterFuncDef(&FuncDef{
+		Name: "bad",
+		Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+			return runtime.Value{}
+		},
+		Ret:    elemT,
+		Params: []types.Type{types.Number},
+	})
+	require.Error(t, err)
+}
+
+func TestExpr_Func_Pure(t *testing.T) {
+	calls := 0
+	newCompiler := func() *Compiler {
+		compiler := NewCompiler()
+		err := compiler.RegisterFuncDef(&FuncDef{
+			Name: "double",
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				calls++
+				return runtime.NewNumber(args[0].Number() * 2)
+			},
+			Ret:    types.Number,
+			Params: []types.Type{types.Number},
+			Pure:   true,
+		})
+		require.NoError(t, err)
+		err = compiler.RegisterFuncDef(&FuncDef{
+			Name: "check",
+			FuncErr: func(ctx context.Context, args []runtime.Value) (runtime.Value, error) {
+				calls++
+				if args[0].Number() < 0 {
+					return runtime.Value{}, errors.New("negative")
+				}
+				return args[0], nil
+			},
+			Ret:    types.Number,
+			Params: []types.Type{types.Number},
+			Pure:   true,
+		})
+		require.NoError(t, err)
+		compiler.RegisterInput("a", types.Number)
+		return compiler
+	}
+
+	run := func(name, input string, expected interface{}, compileCalls, runCalls int) {
+		t.Run(name, func(t *testing.T) {
+			calls = 0
+			prog, err := newCompiler().Compile(input)
+			require.NoError(t, err)
+			require.Equal(t, compileCalls, calls)
+
+			calls = 0
+			args := []runtime.Value{runtime.NewNumber(3)}
+			res, err := runtime.NewRuntime(prog).Run(context.Background(), 0, args)
+			require.Equal(t, runCalls, calls)
+			if expectedErr, ok := expected.(error); ok {
+				require.EqualError(t, err, expectedErr.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, expected, res.Number())
+		})
+	}
+
+	run("literal", `double(2)`, 4.0, 1, 0)
+	run("nested", `double(double(1) + 1)`, 6.0, 2, 0)
+	run("input", `double(a)`, 6.0, 0, 1)
+	run("partial", `double(a) + double(1)`, 8.0, 1, 1)
+	run("error", `check(1 - 2)`, errors.New("negative"), 1, 1)
+}
+
+func TestExpr_Fold(t *testing.T) {
+	run := func(input string, expected string) {
+		t.Run(input, func(t *testing.T) {
+			compiler := NewCompiler()
+			compiler.RegisterPureFunc(
+				"lower",
+				func(ctx context.Context, args []runtime.Value) runtime.Value {
+					return runtime.NewString(strings.ToLower(args[0].String()))
+				},
+				types.String, types.String,
+			)
+			compiler.RegisterFunc(
+				"upper",
+				func(ctx context.Context, args []runtime.Value) runtime.Value {
+					return runtime.NewString(strings.ToUpper(args[0].String()))
+				},
+				types.String, types.String,
+			)
+			compiler.RegisterInput("s", types.String)
+			compiler.RegisterInput("a", types.Number)
+			compiler.RegisterConst("k", runtime.NewNumber(3))
+			compiler.RegisterConst("name", runtime.NewString("ABC"))
+			compiler.RegisterConst("nums", runtime.NewObject(
+				&types.Array{ElementType: types.Number},
+				[]runtime.RawValue{runtime.NewRawNumber(1)}))
+			prog, err := compiler.Compile(input)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimLeft(expected, "\n"), exprListing(t, prog))
+		})
+	}
+
+	run(`lower("ABC") == s`, `
+  0000  PushString       "abc"
+  0001  LoadInput        0
+  0002  CompareEqString
+  0003  Return
+`)
+	run(`lower(name) == s`, `
+  0000  PushString       "abc"
+  0001  LoadInput        0
+  0002  CompareEqString
+  0003  Return
+`)
+	run(`a < k * 2`, `
+  0000  LoadInput        1
+  0001  PushNumber       6
+  0002  CompareLT
+  0003  Return
+`)
+	// Impure functions and constants of other types are not folded.
+	run(`upper(name) == s`, `
+  0000  LoadConst        1               ; upper
+  0001  PushString       "ABC"
+  0002  Call             1
+  0003  LoadInput        0
+  0004  CompareEqString
+  0005  Return
+`)
+	run(`a in nums`, `
+  0000  LoadInput        1
+  0001  LoadConst        4               ; [1]
+  0002  InArrayNumber
+  0003  Return
+`)
+}
+
+func TestExpr_Simplify(t *testing.T) {
+	run := func(input string, instrs int) {
+		t.Run(input, func(t *testing.T) {
+			compiler := NewCompiler()
+			compiler.RegisterInput("a", types.Number)
+			compiler.RegisterInput("b", types.Bool)
+			compiler.RegisterConst("nums", runtime.NewObject(
+				&types.Array{ElementType: types.Number},
+				[]runtime.RawValue{runtime.NewRawNumber(1), runtime.NewRawNumber(2)}))
+			compiler.RegisterConst("matrix", runtime.NewObject(
+				&types.Array{ElementType: &types.Array{ElementType: types.Number}},
+				[]runtime.RawValue{runtime.NewRawObject([]runtime.RawValue{})}))
+			compiler.RegisterGoFunc("f", func(n float64) bool { return n > 0 })
+			prog, err := compiler.Compile(input)
+			require.NoError(t, err)
+			require.Equal(t, instrs, prog.InstrCount(0), exprListing(t, prog))
+		})
+	}
+
+	// Push, Return.
+	run(`false && f(a)`, 2)
+	run(`true || f(a)`, 2)
+	run(`[1, 2] == nums`, 2)
+	run(`[1] != [1]`, 2)
+	run(`2 in nums`, 2)
+	run(`[] in matrix`, 2)
+	run(`"a" in ["b", "c"]`, 2)
+
+	// LoadInput, Return.
+	run(`b && true`, 2)
+	run(`true && b`, 2)
+	run(`b || false`, 2)
+	run(`false || b`, 2)
+	run(`!(!b)`, 2)
+	run(`a * 1`, 2)
+	run(`1 * a`, 2)
+	run(`a / 1`, 2)
+	run(`a - 0`, 2)
+	run(`a + -0`, 2)
+
+	// LoadInput, PushNumber, Add, Return.
+	run(`a + 0`, 4)
+	// LoadInput, Duplicate, JumpIfFalse, PushBool, And, Return, optimized
+	// to LoadInput, JumpIfFalseOrPop, PushBool, Return.
+	run(`b && false`, 4)
+}
+
+func TestExpr_CSE(t *testing.T) {
+	run := func(input string, b bool, calls int) {
+		t.Run(input, func(t *testing.T) {
+			var count int
+			newCompiler := func(opts ...Option) *Compiler {
+				compiler := NewCompiler(opts...)
+				compiler.RegisterInput("a", types.Number)
+				compiler.RegisterInput("b", types.Bool)
+				compiler.RegisterInput("nums", &types.Array{ElementType: types.Number})
+				// f counts its calls, so it is not really pure.
+				compiler.RegisterPureFunc(
+					"f",
+					func(ctx context.Context, args []runtime.Value) runtime.Value {
+						count++
+						return runtime.NewNumber(args[0].Number() * 2)
+					},
+					types.Number, types.Number,
+				)
+				compiler.RegisterFunc(
+					"g",
+					func(ctx context.Context, args []runtime.Value) runtime.Value {
+						count++
+						return runtime.NewNumber(args[0].Number() * 2)
+					},
+					types.Number, types.Number,
+				)
+				return compiler
+			}
+			inputs := []runtime.Value{
+				runtime.NewNumber(3),
+				runtime.NewBool(b),
+				runtime.NewObject(
+					&types.Array{ElementType: types.Number},
+					[]runtime.RawValue{runtime.NewRawNumber(1), runtime.NewRawNumber(10)}),
+			}
+
+			prog, err := newCompiler(WithoutCSE()).Compile(input)
+			require.NoError(t, err)
+			expected, err := runtime.NewRuntime(prog).Run(context.Background(), 0, inputs)
+			require.NoError(t, err)
+
+			for _, opts := range [][]Option{nil, {WithClosures()}, {WithRegisters()}} {
+				prog, err := newCompiler(opts...).Compile(input)
+				require.NoError(t, err)
+				require.NoError(t, prog.Verify())
+				count = 0
+				res, err := runtime.NewRuntime(prog).Run(context.Background(), 0, inputs)
+				require.NoError(t, err)
+				require.Equal(t, expected, res)
+				require.Equal(t, calls, count, exprListing(t, prog))
+			}
+		})
+	}
+
+	run(`f(a) > 1 && f(a) < 100`, false, 1)
+	run(`f(a) + f(a)`, false, 1)
+	run(`f(a + 1) * f(a + 1) - f(a)`, false, 2)
+	run(`f(f(a)) + f(f(a))`, false, 2)
+	run(`[f(a), f(a)]`, false, 1)
+
+	// g is not pure.
+	run(`g(a) + g(a)`, false, 2)
+	run(`f(g(a)) + f(g(a))`, false, 4)
+
+	// The right side of && is not always evaluated, so its sub-expressions
+	// cannot be loaded after it.
+	run(`b && f(a) > 1 || f(a) > 2`, false, 1)
+	run(`b && f(a) > 10 || f(a) > 2`, true, 2)
+	run(`f(a) > 1 || b && f(a) > 2`, false, 1)
+	run(`f(a) > 10 || b && f(a) > 2`, true, 1)
+
+	// Loop bodies are evaluated once per element.
+	run(`count(nums, x => f(a) < x)`, false, 2)
+	run(`f(a) > 0 && count(nums, x => f(a) < x) > 0`, false, 1)
+	run(`count(nums, x => f(x) > 1 && f(x) < 100)`, false, 2)
+	run(`[f(x) + f(x) for x in nums if f(x) > 1]`, false, 2)
+	run(`any x in nums: f(x) == f(a)`, false, 4)
+	run(`reduce(nums, |acc, x| acc + f(x) * f(x), f(a))`, false, 3)
+
+	compiler := NewCompiler()
+	compiler.RegisterInput("a", types.Number)
+	compiler.RegisterPureFunc(
+		"f",
+		func(ctx context.Context, args []runtime.Value) runtime.Value {
+			return args[0]
+		},
+		types.Number, types.Number,
+	)
+	prog, err := compiler.Compile(`f(a + 1) > 1 && f(a + 1) < 100`)
+	require.NoError(t, err)
+	require.Equal(t, strings.TrimLeft(`
+  0000  LoadConst        0               ; f
+  0001  LoadInput        0
+  0002  PushNumber       1
+  0003  Add
+  0004  Call             1
+  0005  StoreLocal       0
+  0006  LoadLocal        0
+  0007  PushNumber       1
+  0008  CompareGT
+  0009  JumpIfFalseOrPop L0
+  0010  LoadLocal        0
+  0011  PushNumber       100
+  0012  CompareLT
+L0:
+  0013  Return
+`, "\n"), exprListing(t, prog))
+}
+
 func TestComplex1(t *testing.T) {
 	compiler := NewCompiler()
 
@@ -312,6 +1432,63 @@ func TestComplex1(t *testing.T) {
 	require.True(t, res.Bool())
 }
 
+// benchVariant is a way of compiling the expressions of a benchmark.
+type benchVariant struct {
+	name string
+	opts []Option
+}
+
+// benchRunner returns a function that benchmarks an expression compiled with
+// each of the variants. The expression can use the number inputs a and b, the
+// string input s, the input nums with the numbers 0 to 99, the constant k and
+// the function len. The instruction count of the expression, in register
+// code if it is compiled to registers, is reported as instrs.
+func benchRunner(b *testing.B, variants ...benchVariant) func(name, input string) {
+	nums := make([]runtime.RawValue, 100)
+	for i := range nums {
+		nums[i] = runtime.NewRawNumber(float64(i))
+	}
+	inputs := []runtime.Value{
+		runtime.NewNumber(1),
+		runtime.NewNumber(8),
+		runtime.NewString("hello"),
+		runtime.NewObject(&types.Array{ElementType: types.Number}, nums),
+	}
+
+	return func(name, input string) {
+		b.Run(name, func(b *testing.B) {
+			for _, variant := range variants {
+				b.Run(variant.name, func(b *testing.B) {
+					compiler := NewCompiler(variant.opts...)
+					compiler.RegisterInput("a", types.Number)
+					compiler.RegisterInput("b", types.Number)
+					compiler.RegisterInput("s", types.String)
+					compiler.RegisterInput("nums", &types.Array{ElementType: types.Number})
+					compiler.RegisterConst("k", runtime.NewNumber(3))
+					compiler.RegisterGoFunc("len", func(s string) float64 {
+						return float64(len(s))
+					})
+					prog, err := compiler.Compile(input)
+					require.NoError(b, err)
+					r := runtime.NewRuntime(prog)
+					b.ResetTimer()
+					for i := 0; i < b.N; i++ {
+						_, err := r.Run(context.Background(), 0, inputs)
+						if err != nil {
+							b.Fatal(err)
+						}
+					}
+					instrs := prog.RegisterInstrCount(0)
+					if instrs == 0 {
+						instrs = prog.InstrCount(0)
+					}
+					b.ReportMetric(float64(instrs), "instrs")
+				})
+			}
+		})
+	}
+}
+
 func Benchmark1(b *testing.B) {
 	compiler := NewCompiler()
 
@@ -0,0 +1,241 @@
+package expr
+
+import (
+	gocontext "context"
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// fuzzSeeds are taken from the table tests, with the inputs renamed to those
+// registered by newFuzzCompiler.
+var fuzzSeeds = []string{
+	`2 + 3*(2+3) - 5`,
+	`3.14`,
+	`"foobar"`,
+	`a < b`,
+	`a >= b || a / b > 1`,
+	`a == b and not c`,
+	`!c || !false`,
+	`1 < 2 && 9+7 in [1, 10 + 6, 3]`,
+	`a in [1, b, 3]`,
+	`s in ["foo", "bar"]`,
+	`a * b in [0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15] || s in [x for x in strs]`,
+	`[a, 2] in [[1], [1, 2]]`,
+	`[] in [[1], []]`,
+	`1 in []`,
+	`nums == []`,
+	`[] == strs`,
+	`[]number`,
+	`[][]string == []`,
+	`[[1, 2], [3]] == [[1, 2], [3], []]`,
+	`[[], [a]] == [[], [1]]`,
+	`[[[true]], [[false, true]]] != [[[true]], [[false, false]]]`,
+	`[[x, x] for x in nums] == [[1, 1], [2, 2]]`,
+	`count([[1, 2], [], [3]], x => x != [])`,
+	`map([]number, x => x)`,
+	`map(nums, x => x * 2)`,
+	`map(strs, |x| x == "bar")`,
+	`filter(nums, x => x > 5)`,
+	`any(nums, x => x > 5) && all(nums, x => x > 0)`,
+	`find(nums, x => x > 50)`,
+	`find(strs, x => x == "baz")`,
+	`reduce(nums, |acc, x| acc + x, 0)`,
+	`reduce(strs, |acc, x| acc + 1, 10)`,
+	`any(nums, x => any(nums, y => y == x + 6))`,
+	`count(filter(map(nums, x => x * 10), x => x > 20), x => x < 80)`,
+	`count(nums, x => x > b)`,
+	`[x * 2 for x in nums if x > 3]`,
+	`[x for x in [y * 10 for y in nums]]`,
+	`any x in nums: all y in nums: y != x`,
+	`(any x in nums: x > 2) && c`,
+	`len(s) + k == b`,
+	`check(a - b)`,
+	`check(a) + check(b - 10)`,
+	`s =~ "^f"`,
+	`s matches "(b"`,
+	`regexFind(s, "o+")`,
+	`regexReplace(s, "o+", "0")`,
+	`regexSplit("a,b,c", ",")`,
+	`glob("a/**/*.go", s)`,
+	`ip("10.0.0.1") in cidr("10.0.0.0/8")`,
+	`ip(s) == ip("::1")`,
+	`cidr(s)`,
+	`false && check(a) > 0 || c && true`,
+	`!(!c) || false`,
+	`a * 1 - 0 + -0 == a / 1`,
+	`twice(a) > 1 && twice(a) < 10 || twice(a + b) == twice(a + b)`,
+	`c && twice(a) > 1 || twice(a) > 2`,
+	`count(nums, x => twice(x) > twice(a) && twice(x) < 10) + twice(a)`,
+	`[twice(x) + twice(x) for x in nums if twice(x) > 2]`,
+	`s =~ "^f" && s =~ "^f" || ip(s) == ip(s)`,
+	`-0 + 0`,
+	`[1, 2] in [[1, 2]] && 2 in [1, 2]`,
+	`0/0 == 0/0`,
+	`[0/0] == [0/0]`,
+	`1 / 0`,
+	`x => x`,
+	`"unterminated`,
+	`a +`,
+	`1; 2`,
+	`[]`,
+}
+
+func newFuzzCompiler(opts ...Option) *Compiler {
+	compiler := NewCompiler(opts...)
+	compiler.RegisterInput("a", types.Number)
+	compiler.RegisterInput("b", types.Number)
+	compiler.RegisterInput("c", types.Bool)
+	compiler.RegisterInput("s", types.String)
+	compiler.RegisterInput("nums", &types.Array{ElementType: types.Number})
+	compiler.RegisterInput("strs", &types.Array{ElementType: types.String})
+	compiler.RegisterConst("k", runtime.NewNumber(3))
+	compiler.RegisterGoFunc("len", func(s string) float64 {
+		return float64(len(s))
+	})
+	compiler.RegisterGoFunc("check", func(n float64) (float64, error) {
+		if n < 0 {
+			return 0, errors.New("negative")
+		}
+		return n, nil
+	})
+	compiler.RegisterPureFunc(
+		"twice",
+		func(ctx gocontext.Context, args []runtime.Value) runtime.Value {
+			return runtime.NewNumber(args[0].Number() * 2)
+		},
+		types.Number, types.Number,
+	)
+	return compiler
+}
+
+func fuzzInputs() []runtime.Value {
+	nums := []runtime.RawValue{
+		runtime.NewRawNumber(1),
+		runtime.NewRawNumber(7),
+		runtime.NewRawNumber(3),
+		runtime.NewRawNumber(9),
+	}
+	strs := []runtime.RawValue{
+		runtime.NewRawObject("foo"),
+		runtime.NewRawObject("bar"),
+		runtime.NewRawObject("baz"),
+	}
+	return []runtime.Value{
+		runtime.NewNumber(2),
+		runtime.NewNumber(3),
+		runtime.NewBool(true),
+		runtime.NewString("foo"),
+		runtime.NewObject(&types.Array{ElementType: types.Number}, nums),
+		runtime.NewObject(&types.Array{ElementType: types.String}, strs),
+	}
+}
+
+// FuzzCompile verifies that the compiler reports errors, rather than
+// panicking, for any input, and that none of those errors are internal.
+func FuzzCompile(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		_, err := newFuzzCompiler().Compile(input)
+		if errors.Is(err, runtime.ErrInternal) {
+			t.Fatalf("Compile(%q): %v", input, err)
+		}
+	})
+}
+
+// FuzzRun compares the results of running a compiled program, with and
+// without closures or registers, with the result of evaluating the AST
+// directly. It also checks that the compiled program passes verification.
+func FuzzRun(f *testing.F) {
+	for _, seed := range fuzzSeeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		// The budget bounds the run time of nested loops.
+		const budget = 10000
+
+		compiler := newFuzzCompiler()
+		prog, compileErr := compiler.Compile(input)
+		compiler.SetEvalBudget(budget)
+		expected, evalErr := compiler.Eval(gocontext.Background(), input, fuzzInputs())
+		if compileErr != nil {
+			if evalErr == nil || evalErr.Error() != compileErr.Error() {
+				t.Fatalf("%q: Compile failed with %v, but Eval returned %v",
+					input, compileErr, evalErr)
+			}
+			return
+		}
+		if err := prog.Verify(); err != nil {
+			t.Fatalf("%q: Verify failed with %v", input, err)
+		}
+
+		closureProg, err := newFuzzCompiler(WithClosures()).Compile(input)
+		if err != nil {
+			t.Fatalf("%q: Compile with closures failed with %v", input, err)
+		}
+
+		registerProg, err := newFuzzCompiler(WithRegisters()).Compile(input)
+		if err != nil {
+			t.Fatalf("%q: Compile with registers failed with %v", input, err)
+		}
+
+		for _, prog := range []*runtime.Program{prog, closureProg, registerProg} {
+			r := runtime.NewRuntime(prog)
+			r.SetBudget(budget)
+			res, runErr := r.Run(gocontext.Background(), 0, fuzzInputs())
+			if runErr != nil || evalErr != nil {
+				if runErr == nil || evalErr == nil || runErr.Error() != evalErr.Error() {
+					t.Fatalf("%q: Run failed with %v, but Eval failed with %v",
+						input, runErr, evalErr)
+				}
+				continue
+			}
+
+			if !res.Type().Equal(expected.Type()) ||
+				!equalValues(res.Type(), res.RawValue, expected.RawValue) {
+				t.Fatalf("%q: Run returned %v, but Eval returned %v",
+					input, res, expected)
+			}
+		}
+	})
+}
+
+// equalValues compares two values of type typ. Unlike RawValue.Equal, NaN is
+// equal to NaN, 0 is not equal to -0, and all functions are equal.
+func equalValues(typ types.Type, a, b runtime.RawValue) bool {
+	switch typ := typ.(type) {
+	case *types.Array:
+		arrA := a.Object().([]runtime.RawValue)
+		arrB := b.Object().([]runtime.RawValue)
+		if len(arrA) != len(arrB) {
+			return false
+		}
+		for i := range arrA {
+			if !equalValues(typ.ElementType, arrA[i], arrB[i]) {
+				return false
+			}
+		}
+		return true
+	case *types.Function:
+		// Functions are not comparable, and the programs compiled with
+		// closures have their own instances of the host functions.
+		return true
+	}
+
+	switch typ {
+	case types.Number:
+		if math.IsNaN(a.Number()) || math.IsNaN(b.Number()) {
+			return math.IsNaN(a.Number()) && math.IsNaN(b.Number())
+		}
+		return math.Float64bits(a.Number()) == math.Float64bits(b.Number())
+	case types.String:
+		return a.String() == b.String()
+	default:
+		return a.Equal(b)
+	}
+}
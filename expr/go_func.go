@@ -0,0 +1,244 @@
+package expr
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// RegisterGoFunc registers a Go function that can be used in the expression.
+// The function signature determines the function type:
+//
+//	float64          number
+//	string           string
+//	bool             bool
+//	[]T              array of T
+//
+// The function can optionally take a context.Context as its first parameter,
+// and it can optionally return an error as its second result. A variadic Go
+// function is registered as a variadic function.
+func (c *Compiler) RegisterGoFunc(name string, fn interface{}) error {
+	def, err := newGoFuncDef(name, fn)
+	if err != nil {
+		return err
+	}
+	return c.RegisterFuncDef(def)
+}
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+func newGoFuncDef(name string, fn interface{}) (*FuncDef, error) {
+	if def := newFastGoFuncDef(fn); def != nil {
+		def.Name = name
+		return def, nil
+	}
+
+	fnValue := reflect.ValueOf(fn)
+	fnType := fnValue.Type()
+	if fnType.Kind() != reflect.Func {
+		return nil, fmt.Errorf("%v: %v is not a function", name, fnType)
+	}
+
+	def := &FuncDef{
+		Name:     name,
+		Variadic: fnType.IsVariadic(),
+	}
+
+	firstParam := 0
+	withContext := fnType.NumIn() > 0 && fnType.In(0) == contextType
+	if withContext {
+		firstParam = 1
+	}
+
+	params := make([]*goConverter, 0, fnType.NumIn()-firstParam)
+	for i := firstParam; i < fnType.NumIn(); i++ {
+		paramType := fnType.In(i)
+		if def.Variadic && i == fnType.NumIn()-1 {
+			paramType = paramType.Elem()
+		}
+		conv, err := newGoConverter(paramType)
+		if err != nil {
+			return nil, fmt.Errorf("%v: parameter %d: %v", name, i-firstParam, err)
+		}
+		params = append(params, conv)
+		def.Params = append(def.Params, conv.typ)
+	}
+
+	switch {
+	case fnType.NumOut() == 1:
+	case fnType.NumOut() == 2 && fnType.Out(1) == errorType:
+	default:
+		return nil, fmt.Errorf(
+			"%v: function must return a value, optionally followed by an error",
+			name)
+	}
+	ret, err := newGoConverter(fnType.Out(0))
+	if err != nil {
+		return nil, fmt.Errorf("%v: result: %v", name, err)
+	}
+	def.Ret = ret.typ
+
+	def.FuncErr = func(ctx context.Context, args []runtime.Value) (runtime.Value, error) {
+		in := make([]reflect.Value, 0, firstParam+len(args))
+		if withContext {
+			in = append(in, reflect.ValueOf(&ctx).Elem())
+		}
+		for i, arg := range args {
+			conv := params[len(params)-1]
+			if i < len(params) {
+				conv = params[i]
+			}
+			in = append(in, conv.toGo(arg.RawValue))
+		}
+		out := fnValue.Call(in)
+		if len(out) == 2 && !out[1].IsNil() {
+			return runtime.Value{}, out[1].Interface().(error)
+		}
+		return runtime.NewValue(ret.typ, ret.fromGo(out[0])), nil
+	}
+
+	return def, nil
+}
+
+// goConverter converts between a Go type and the corresponding expression
+// type.
+type goConverter struct {
+	typ    types.Type
+	toGo   func(v runtime.RawValue) reflect.Value
+	fromGo func(v reflect.Value) runtime.RawValue
+}
+
+func newGoConverter(t reflect.Type) (*goConverter, error) {
+	switch t.Kind() {
+	case reflect.Float64:
+		return &goConverter{
+			typ: types.Number,
+			toGo: func(v runtime.RawValue) reflect.Value {
+				return reflect.ValueOf(v.Number()).Convert(t)
+			},
+			fromGo: func(v reflect.Value) runtime.RawValue {
+				return runtime.NewRawNumber(v.Float())
+			},
+		}, nil
+
+	case reflect.String:
+		return &goConverter{
+			typ: types.String,
+			toGo: func(v runtime.RawValue) reflect.Value {
+				return reflect.ValueOf(v.String()).Convert(t)
+			},
+			fromGo: func(v reflect.Value) runtime.RawValue {
+				return runtime.NewRawObject(v.String())
+			},
+		}, nil
+
+	case reflect.Bool:
+		return &goConverter{
+			typ: types.Bool,
+			toGo: func(v runtime.RawValue) reflect.Value {
+				return reflect.ValueOf(v.Bool()).Convert(t)
+			},
+			fromGo: func(v reflect.Value) runtime.RawValue {
+				return runtime.NewRawBool(v.Bool())
+			},
+		}, nil
+
+	case reflect.Slice:
+		elem, err := newGoConverter(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return &goConverter{
+			typ: &types.Array{ElementType: elem.typ},
+			toGo: func(v runtime.RawValue) reflect.Value {
+				arr := v.Object().([]runtime.RawValue)
+				res := reflect.MakeSlice(t, len(arr), len(arr))
+				for i, e := range arr {
+					res.Index(i).Set(elem.toGo(e))
+				}
+				return res
+			},
+			fromGo: func(v reflect.Value) runtime.RawValue {
+				arr := make([]runtime.RawValue, v.Len())
+				for i := range arr {
+					arr[i] = elem.fromGo(v.Index(i))
+				}
+				return runtime.NewRawObject(arr)
+			},
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported Go type %v", t)
+	}
+}
+
+// newFastGoFuncDef returns a FuncDef that calls fn without reflection if fn
+// has one of the common signatures, or nil otherwise.
+func newFastGoFuncDef(fn interface{}) *FuncDef {
+	switch fn := fn.(type) {
+	case func(float64) float64:
+		return &FuncDef{
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(fn(args[0].Number()))
+			},
+			Ret:    types.Number,
+			Params: []types.Type{types.Number},
+		}
+	case func(float64, float64) float64:
+		return &FuncDef{
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(fn(args[0].Number(), args[1].Number()))
+			},
+			Ret:    types.Number,
+			Params: []types.Type{types.Number, types.Number},
+		}
+	case func(string) string:
+		return &FuncDef{
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewString(fn(args[0].String()))
+			},
+			Ret:    types.String,
+			Params: []types.Type{types.String},
+		}
+	case func(string) float64:
+		return &FuncDef{
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(fn(args[0].String()))
+			},
+			Ret:    types.Number,
+			Params: []types.Type{types.String},
+		}
+	case func(string) bool:
+		return &FuncDef{
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewBool(fn(args[0].String()))
+			},
+			Ret:    types.Bool,
+			Params: []types.Type{types.String},
+		}
+	case func(string, string) string:
+		return &FuncDef{
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewString(fn(args[0].String(), args[1].String()))
+			},
+			Ret:    types.String,
+			Params: []types.Type{types.String, types.String},
+		}
+	case func(string, string) bool:
+		return &FuncDef{
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewBool(fn(args[0].String(), args[1].String()))
+			},
+			Ret:    types.Bool,
+			Params: []types.Type{types.String, types.String},
+		}
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,164 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+type myString string
+
+func TestRegisterGoFunc(t *testing.T) {
+	errFailed := errors.New("failed")
+
+	newCompiler := func() *Compiler {
+		compiler := NewCompiler()
+		funcs := map[string]interface{}{
+			"upper": strings.ToUpper,
+			"contains": func(s, substr string) bool {
+				return strings.Contains(s, substr)
+			},
+			"half": func(n float64) float64 { return n / 2 },
+			"repeat": func(s string, n float64) string {
+				return strings.Repeat(s, int(n))
+			},
+			"fields": strings.Fields,
+			"join": func(sep string, elems ...string) string {
+				return strings.Join(elems, sep)
+			},
+			"sum": func(nums [][]float64) float64 {
+				var res float64
+				for _, arr := range nums {
+					for _, n := range arr {
+						res += n
+					}
+				}
+				return res
+			},
+			"fail": func(fail bool) (bool, error) {
+				if fail {
+					return false, errFailed
+				}
+				return true, nil
+			},
+			"key": func(ctx context.Context) string {
+				return ctx.Value(myString("key")).(string)
+			},
+			"named": func(s myString) myString {
+				return s + "!"
+			},
+		}
+		for name, fn := range funcs {
+			require.NoError(t, compiler.RegisterGoFunc(name, fn))
+		}
+		return compiler
+	}
+
+	run := func(name, input string, expected interface{}) {
+		t.Run(name, func(t *testing.T) {
+			prog, err := newCompiler().Compile(input)
+			if expected == compileError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			ctx := context.WithValue(context.Background(), myString("key"), "value")
+			res, err := runtime.NewRuntime(prog).Run(ctx, 0, nil)
+			if expectedErr, ok := expected.(error); ok {
+				require.Equal(t, expectedErr, err)
+				return
+			}
+			require.NoError(t, err)
+
+			switch prog.ResultType {
+			case types.Number:
+				require.Equal(t, expected, res.Number())
+			case types.String:
+				require.Equal(t, expected, res.String())
+			case types.Bool:
+				require.Equal(t, expected, res.Bool())
+			default:
+				arr := res.Object().([]runtime.RawValue)
+				actual := make([]string, len(arr))
+				for i, elem := range arr {
+					actual[i] = elem.String()
+				}
+				require.Equal(t, expected, actual)
+			}
+		})
+	}
+
+	run("upper", `upper("foo")`, "FOO")
+	run("contains", `contains("foobar", "oba")`, true)
+	run("half", `half(5)`, 2.5)
+	run("repeat", `repeat("ab", 3)`, "ababab")
+	run("fields", `fields(" a b  c ")`, []string{"a", "b", "c"})
+	run("variadic0", `join(",")`, "")
+	run("variadic", `join(",", "a", "b", "c")`, "a,b,c")
+	run("nested_array", `sum([[1, 2], [], [3]])`, float64(6))
+	run("error_nil", `fail(false)`, true)
+	run("error", `fail(true)`, errFailed)
+	run("context", `key()`, "value")
+	run("named", `named("hi")`, "hi!")
+	run("type_error", `half("a")`, compileError)
+	run("count_error", `repeat("a")`, compileError)
+
+	unsupported := map[string]interface{}{
+		"not_func":      42,
+		"int_param":     func(n int) float64 { return 0 },
+		"map_result":    func() map[string]string { return nil },
+		"no_result":     func() {},
+		"two_results":   func() (float64, float64) { return 0, 0 },
+		"context_later": func(s string, ctx context.Context) string { return s },
+	}
+	for name, fn := range unsupported {
+		t.Run(name, func(t *testing.T) {
+			err := NewCompiler().RegisterGoFunc(name, fn)
+			require.Error(t, err)
+		})
+	}
+
+	// The parameters are numbered as in the expression, without the context.
+	for name, fn := range map[string]interface{}{
+		"param_index":         func(s string, n int) float64 { return 0 },
+		"param_index_context": func(ctx context.Context, s string, n int) float64 { return 0 },
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := NewCompiler().RegisterGoFunc("f", fn)
+			require.EqualError(t, err, "f: parameter 1: unsupported Go type int")
+		})
+	}
+}
+
+func BenchmarkRegisterGoFunc(b *testing.B) {
+	run := func(b *testing.B, fn interface{}) {
+		compiler := NewCompiler()
+		require.NoError(b, compiler.RegisterGoFunc("f", fn))
+		compiler.RegisterInput("a", types.String)
+		prog, err := compiler.Compile("f(a, a)")
+		require.NoError(b, err)
+
+		r := runtime.NewRuntime(prog)
+		args := []runtime.Value{runtime.NewString("foo")}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			_, err := r.Run(context.Background(), 0, args)
+			if err != nil {
+				panic(err)
+			}
+		}
+	}
+
+	b.Run("fast", func(b *testing.B) {
+		run(b, func(a, b string) bool { return a == b })
+	})
+	b.Run("reflect", func(b *testing.B) {
+		run(b, func(a string, b ...string) bool { return a == b[0] })
+	})
+}
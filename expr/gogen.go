@@ -0,0 +1,114 @@
+package expr
+
+import (
+	"fmt"
+	"go/token"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/internal/parser"
+)
+
+// GoOptions configures the code generated by GenerateGo.
+type GoOptions struct {
+	// Package is the name of the package of the generated file.
+	Package string
+
+	// Func is the name of the generated function. The default is Eval.
+	Func string
+
+	// Funcs maps the names of the registered functions called by the
+	// expression to the Go functions that implement them.
+	Funcs map[string]GoFunc
+}
+
+// GoFunc is a Go function called by the code generated by GenerateGo. Its
+// parameters and result have the Go types of the parameters and result of the
+// registered function:
+//
+//	number           float64
+//	string           string
+//	bool             bool
+//	[]T              []T
+//	ip               netip.Addr
+//	cidr             netip.Prefix
+//	any              interface{}
+//
+// Generic functions are implemented by generic Go functions, and variadic
+// functions by variadic Go functions.
+type GoFunc struct {
+	// Name is the name of the function, qualified by its package name if the
+	// function is imported, such as strings.ToUpper.
+	Name string
+
+	// Import is the import path of the package of the function, if any.
+	Import string
+
+	// Err indicates that the function returns an error as its second result.
+	Err bool
+}
+
+// GenerateGo generates the source of a Go file with a function that evaluates
+// the first expression in expr as plain Go code, without a runtime. It is
+// meant to be used with go generate for expressions that are known when the
+// program is built.
+//
+// The parameters of the function are the registered inputs, in the order in
+// which they were registered. The function returns the result of the
+// expression and, if the expression can fail, an error: for example, if it
+// calls a function that returns an error, or parses an IP address that is not
+// constant. Like the runtime, the generated code returns the first error, and
+// it does not call any function after it. Budgets are not supported.
+//
+// The expression cannot use function values or call functions that are not in
+// opts.Funcs.
+func (c *Compiler) GenerateGo(expr string, opts GoOptions) ([]byte, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	if !token.IsIdentifier(opts.Package) {
+		return nil, fmt.Errorf("invalid package name %q", opts.Package)
+	}
+	if opts.Func == "" {
+		opts.Func = "Eval"
+	}
+	if !token.IsIdentifier(opts.Func) {
+		return nil, fmt.Errorf("invalid function name %q", opts.Func)
+	}
+
+	progAST, err := parser.Parse(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Like Eval, the passes use a fork of the builder, so that the constants
+	// and locals do not accumulate in the Compiler.
+	genCtx := *c.ctx
+	genCtx.Builder = c.ctx.Builder.Fork()
+	for _, pass := range []context.Pass{
+		context.ResolveNames,
+		context.CheckTypes,
+		context.Fold,
+	} {
+		err = progAST.RunPass(&genCtx, pass)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	funcs := make(map[string]context.GoFunc, len(opts.Funcs))
+	for name, fn := range opts.Funcs {
+		funcs[name] = context.GoFunc(fn)
+	}
+	genCtx.Go = &context.GoGenerator{
+		Package: opts.Package,
+		Func:    opts.Func,
+		Source:  expr,
+		Params:  c.inputs,
+		Funcs:   funcs,
+	}
+	err = progAST.RunPass(&genCtx, context.GoGen)
+	if err != nil {
+		return nil, err
+	}
+	return genCtx.Go.Output, nil
+}
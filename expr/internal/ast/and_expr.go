@@ -12,6 +12,10 @@ type AndExpr struct {
 	left  Expr
 	right Expr
 	value interface{}
+
+	// simplified is the expression that replaces this one, if the other
+	// side is true.
+	simplified Expr
 }
 
 func NewAndExpr(left Expr, right Expr) *AndExpr {
@@ -44,12 +48,24 @@ func (e *AndExpr) RunPass(ctx *context.Context, pass context.Pass) error {
 			return err
 		}
 
+	case context.Eval:
+		return setResult(ctx, e.eval)
+
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
 	case context.Fold:
 		err := e.fold(ctx)
 		if err != nil {
 			return err
 		}
 
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+
 	default:
 		err := e.left.RunPass(ctx, pass)
 		if err != nil {
@@ -93,19 +109,48 @@ func (e *AndExpr) fold(ctx *context.Context) error {
 		return err
 	}
 
-	if e.left.Value() == nil || e.right.Value() == nil {
-		return nil
+	// false && e is false without evaluating e, and true && e and
+	// e && true are e. e && false is not folded, because e can fail.
+	left, right := e.left.Value(), e.right.Value()
+	switch {
+	case left == false:
+		e.value = false
+	case left == true && right != nil:
+		e.value = right
+	case left == true:
+		e.simplified = e.right
+	case right == true:
+		e.simplified = e.left
 	}
-
-	e.value = e.left.Value().(bool) && e.right.Value().(bool)
 	return nil
 }
 
+// cse visits the right side in a new region, because it is evaluated only
+// if the left side does not decide the result.
+func (e *AndExpr) cse(ctx *context.Context) (string, bool, error) {
+	if e.simplified != nil {
+		key, err := cseExpr(ctx, &e.simplified)
+		return key, false, err
+	}
+	left, err := cseExpr(ctx, &e.left)
+	if err != nil {
+		return "", false, err
+	}
+	right, err := cseBranch(ctx, &e.right)
+	if err != nil {
+		return "", false, err
+	}
+	return cseKey("&&", left, right), true, nil
+}
+
 func (e *AndExpr) emit(ctx *context.Context) error {
 	if e.value != nil {
 		ctx.Builder.EmitPushBool(e.value.(bool))
 		return nil
 	}
+	if e.simplified != nil {
+		return e.simplified.RunPass(ctx, context.Emit)
+	}
 
 	err := e.left.RunPass(ctx, context.Emit)
 	if err != nil {
@@ -125,3 +170,49 @@ func (e *AndExpr) emit(ctx *context.Context) error {
 	ctx.Builder.AssignLabel(skipRight)
 	return nil
 }
+
+func (e *AndExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	left, err := evalExpr(ctx, e.left)
+	if err != nil || !left.Bool() {
+		return left, err
+	}
+	return evalExpr(ctx, e.right)
+}
+
+func (e *AndExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	if e.value != nil {
+		return foldedClosure(e.value)
+	}
+	if e.simplified != nil {
+		return closureExpr(ctx, e.simplified)
+	}
+	left, err := boolClosure(ctx, e.left)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	right, err := boolClosure(ctx, e.right)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	return runtime.Closure{Bool: func(env *runtime.Env) bool {
+		return left(env) && right(env)
+	}}, nil
+}
+
+func (e *AndExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	if e.value != nil {
+		return goFolded(ctx, e.value)
+	}
+	if e.simplified != nil {
+		return goExprOf(ctx, e.simplified)
+	}
+	left, err := goExprOf(ctx, e.left)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	right, err := goExprOf(ctx, e.right)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	return goBinary(left, "&&", 2, right), nil
+}
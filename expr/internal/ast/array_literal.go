@@ -2,7 +2,7 @@ package ast
 
 import (
 	"fmt"
-	"log"
+	"strings"
 
 	"github.com/dcaiafa/go-expr/expr/internal/context"
 	"github.com/dcaiafa/go-expr/expr/runtime"
@@ -11,7 +11,9 @@ import (
 
 type ArrayLiteralExpr struct {
 	exprImpl
-	elements []Expr
+	elements   []Expr
+	annotation *TypeRef
+	hint       types.Type
 }
 
 func NewArrayLiteralExpr(element Expr) *ArrayLiteralExpr {
@@ -20,34 +22,52 @@ func NewArrayLiteralExpr(element Expr) *ArrayLiteralExpr {
 	}
 }
 
+// NewEmptyArrayLiteralExpr creates an empty array literal. The annotation is
+// optional; without it the type of the array is inferred from the context.
+func NewEmptyArrayLiteralExpr(annotation *TypeRef) *ArrayLiteralExpr {
+	return &ArrayLiteralExpr{
+		annotation: annotation,
+	}
+}
+
 func (e *ArrayLiteralExpr) AddElement(element Expr) {
 	e.elements = append(e.elements, element)
 }
 
 func (e *ArrayLiteralExpr) Print(p *context.GraphPrinter) {
+	if e.annotation != nil {
+		p.PrintNode(e.annotation.String())
+		return
+	}
 	p.PrintNode("array_literal", exprsAsPrinters(e.elements)...)
 }
 
 func (e *ArrayLiteralExpr) RunPass(ctx *context.Context, pass context.Pass) error {
 	switch pass {
 	case context.CheckTypes:
-		for _, ast := range e.elements {
-			err := ast.RunPass(ctx, pass)
-			if err != nil {
-				return err
-			}
-		}
-		err := e.checkTypes()
+		err := e.checkTypes(ctx)
 		if err != nil {
 			return err
 		}
 
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+
 	case context.Emit:
 		err := e.emit(ctx)
 		if err != nil {
 			return err
 		}
 
+	case context.Eval:
+		return setResult(ctx, e.eval)
+
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
 	default:
 		for _, ast := range e.elements {
 			err := ast.RunPass(ctx, pass)
@@ -60,15 +80,66 @@ func (e *ArrayLiteralExpr) RunPass(ctx *context.Context, pass context.Pass) erro
 	return nil
 }
 
-func (e *ArrayLiteralExpr) checkTypes() error {
-	if len(e.elements) == 0 {
-		e.typ = types.Void
-		return nil
+func (e *ArrayLiteralExpr) needsTypeHint() bool {
+	if e.annotation != nil {
+		return false
+	}
+	for _, element := range e.elements {
+		if !needsTypeHint(element) {
+			return false
+		}
 	}
+	return true
+}
 
-	elemType := e.elements[0].Type()
+func (e *ArrayLiteralExpr) setTypeHint(hint types.Type) {
+	e.hint = hint
+}
 
-	for _, element := range e.elements {
+func (e *ArrayLiteralExpr) checkTypes(ctx *context.Context) error {
+	if e.annotation != nil {
+		var err error
+		e.typ, err = e.annotation.Resolve()
+		return err
+	}
+
+	// The element type is determined by the first element that does not need
+	// a hint. The remaining elements are checked with the element type as
+	// hint, so that nested empty arrays can be inferred.
+	var elemType types.Type
+	if hintArray, ok := e.hint.(*types.Array); ok {
+		elemType = hintArray.ElementType
+	}
+	first := -1
+	for i, element := range e.elements {
+		if needsTypeHint(element) {
+			continue
+		}
+		err := element.RunPass(ctx, context.CheckTypes)
+		if err != nil {
+			return err
+		}
+		elemType = element.Type()
+		first = i
+		break
+	}
+
+	if elemType == nil {
+		if e.hint != nil {
+			return fmt.Errorf("cannot use array literal as %v", e.hint)
+		}
+		return fmt.Errorf(
+			"cannot infer the type of array literal; " +
+				"use an annotation such as []number")
+	}
+
+	for i, element := range e.elements {
+		if i != first {
+			err := checkTypesWithHint(ctx, element, elemType)
+			if err != nil {
+				return err
+			}
+		}
 		if !element.Type().Equal(elemType) {
 			return fmt.Errorf("all elements in array must have the same type")
 		}
@@ -79,28 +150,26 @@ func (e *ArrayLiteralExpr) checkTypes() error {
 	return nil
 }
 
-func (e *ArrayLiteralExpr) emit(ctx *context.Context) error {
-	optimize := true
-	for _, elem := range e.elements {
-		if elem.Value() == nil {
-			optimize = false
-			break
-		}
-	}
-	if optimize {
-		array := make([]runtime.RawValue, len(e.elements))
-		for i, elem := range e.elements {
-			switch v := elem.Value().(type) {
-			case float64:
-				array[i] = runtime.NewRawNumber(v)
-			case bool:
-				array[i] = runtime.NewRawBool(v)
-			case string:
-				array[i] = runtime.NewRawObject(v)
-			default:
-				log.Fatal("invalid array literal folded value")
-			}
+// cse returns the key of the array. Arrays are not shared, so that each
+// evaluation of an array literal creates a new array.
+func (e *ArrayLiteralExpr) cse(ctx *context.Context) (string, bool, error) {
+	keys := make([]string, len(e.elements))
+	for i := range e.elements {
+		var err error
+		keys[i], err = cseExpr(ctx, &e.elements[i])
+		if err != nil {
+			return "", false, err
 		}
+	}
+	return cseKey(e.typ.String(), keys...), false, nil
+}
+
+func (e *ArrayLiteralExpr) emit(ctx *context.Context) error {
+	array, ok, err := e.foldedArray()
+	if err != nil {
+		return err
+	}
+	if ok {
 		arrayValue := runtime.NewObject(e.Type(), array)
 		arrayConst := ctx.Builder.NewConst(arrayValue)
 		ctx.Builder.EmitLoadConst(arrayConst)
@@ -116,3 +185,80 @@ func (e *ArrayLiteralExpr) emit(ctx *context.Context) error {
 	ctx.Builder.EmitPushArray(len(e.elements))
 	return nil
 }
+
+func (e *ArrayLiteralExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	array := make([]runtime.RawValue, len(e.elements))
+	for i, elem := range e.elements {
+		v, err := evalExpr(ctx, elem)
+		if err != nil {
+			return runtime.Value{}, err
+		}
+		array[i] = v.RawValue
+	}
+	return runtime.NewObject(e.typ, array), nil
+}
+
+func (e *ArrayLiteralExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	array, ok, err := e.foldedArray()
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	if ok {
+		return constClosure(e.typ, runtime.NewRawObject(array)), nil
+	}
+
+	elements := make([]rawFunc, len(e.elements))
+	for i, elem := range e.elements {
+		elements[i], err = rawClosure(ctx, elem)
+		if err != nil {
+			return runtime.Closure{}, err
+		}
+	}
+	return runtime.Closure{Value: func(env *runtime.Env) runtime.RawValue {
+		array := make([]runtime.RawValue, len(elements))
+		for i, elem := range elements {
+			array[i] = elem(env)
+		}
+		return runtime.NewRawObject(array)
+	}}, nil
+}
+
+// foldedArray returns the elements of the array if all of them are folded.
+func (e *ArrayLiteralExpr) foldedArray() ([]runtime.RawValue, bool, error) {
+	for _, elem := range e.elements {
+		if elem.Value() == nil {
+			return nil, false, nil
+		}
+	}
+	array := make([]runtime.RawValue, len(e.elements))
+	for i, elem := range e.elements {
+		switch v := elem.Value().(type) {
+		case float64:
+			array[i] = runtime.NewRawNumber(v)
+		case bool:
+			array[i] = runtime.NewRawBool(v)
+		case string:
+			array[i] = runtime.NewRawObject(v)
+		default:
+			return nil, false, fmt.Errorf("%w: invalid array literal folded value %T",
+				runtime.ErrInternal, v)
+		}
+	}
+	return array, true, nil
+}
+
+func (e *ArrayLiteralExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	typ, err := goType(ctx, e.typ)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	elements, err := goArgs(ctx, e.elements)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	codes := make([]string, len(elements))
+	for i, elem := range elements {
+		codes[i] = elem.Code
+	}
+	return goPrimary(typ + "{" + strings.Join(codes, ", ") + "}"), nil
+}
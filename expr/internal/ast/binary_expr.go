@@ -2,6 +2,7 @@ package ast
 
 import (
 	"fmt"
+	"math"
 
 	"github.com/dcaiafa/go-expr/expr/internal/context"
 	"github.com/dcaiafa/go-expr/expr/runtime"
@@ -55,6 +56,10 @@ type BinaryExpr struct {
 	left  Expr
 	op    BinaryOp
 	right Expr
+
+	// simplified is the operand that replaces the expression if the other
+	// operand is an identity element.
+	simplified Expr
 }
 
 func NewBinaryExpr(left Expr, op BinaryOp, right Expr) *BinaryExpr {
@@ -83,12 +88,24 @@ func (e *BinaryExpr) RunPass(ctx *context.Context, pass context.Pass) error {
 			return err
 		}
 
+	case context.Eval:
+		return setResult(ctx, e.eval)
+
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
 	case context.Fold:
 		err := e.fold(ctx)
 		if err != nil {
 			return err
 		}
 
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+
 	default:
 		err := e.runPassChildren(ctx, pass)
 		if err != nil {
@@ -114,13 +131,13 @@ func (e *BinaryExpr) runPassChildren(ctx *context.Context, pass context.Pass) er
 }
 
 func (e *BinaryExpr) checkTypes(ctx *context.Context) error {
-	err := e.runPassChildren(ctx, context.CheckTypes)
+	err := checkOperandTypes(ctx, e.left, e.right)
 	if err != nil {
 		return err
 	}
 
 	if e.left.Type() == nil || e.right.Type() == nil {
-		panic("sub-expressions have unevaluated types")
+		return fmt.Errorf("%w: sub-expressions have unevaluated types", runtime.ErrInternal)
 	}
 
 	switch e.op {
@@ -141,18 +158,13 @@ func (e *BinaryExpr) checkTypes(ctx *context.Context) error {
 			return fmt.Errorf("invalid operation: mistmatched types %v and %v",
 				e.left.Type(), e.right.Type())
 		}
-		if e.left.Type() != types.String &&
-			e.left.Type() != types.Number &&
-			e.left.Type() != types.Bool &&
-			!e.left.Type().Equal(&types.Array{ElementType: types.Bool}) &&
-			!e.left.Type().Equal(&types.Array{ElementType: types.Number}) &&
-			!e.left.Type().Equal(&types.Array{ElementType: types.String}) {
+		if !types.Comparable(e.left.Type()) {
 			return fmt.Errorf("invalid operation: cannot compare type %v", e.left.Type())
 		}
 		e.typ = types.Bool
 
 	default:
-		panic("invalid operator")
+		return fmt.Errorf("%w: invalid binary operator %v", runtime.ErrInternal, e.op)
 	}
 
 	return nil
@@ -165,7 +177,7 @@ func (e *BinaryExpr) fold(ctx *context.Context) error {
 	}
 
 	if e.left.Value() == nil || e.right.Value() == nil {
-		return nil
+		return e.simplify(ctx)
 	}
 
 	switch e.op {
@@ -195,7 +207,7 @@ func (e *BinaryExpr) fold(ctx *context.Context) error {
 		} else if e.left.Type() == types.Bool {
 			e.value = e.left.Value().(bool) == e.right.Value().(bool)
 		} else {
-			panic("unexpected type")
+			return fmt.Errorf("%w: unexpected folded type %v", runtime.ErrInternal, e.left.Type())
 		}
 		if e.op == Ne {
 			e.value = !e.value.(bool)
@@ -205,10 +217,78 @@ func (e *BinaryExpr) fold(ctx *context.Context) error {
 	return nil
 }
 
+// simplify folds comparisons of constant arrays, and replaces arithmetic with
+// an identity element by the other operand. x + 0 is not simplified, because
+// it is 0 if x is -0.
+func (e *BinaryExpr) simplify(ctx *context.Context) error {
+	negZero := math.Copysign(0, -1)
+
+	switch e.op {
+	case Eq, Ne:
+		left, ok, err := constValue(ctx, e.left)
+		if !ok || err != nil {
+			return err
+		}
+		right, ok, err := constValue(ctx, e.right)
+		if !ok || err != nil {
+			return err
+		}
+		e.value = left.Equal(right) == (e.op == Eq)
+
+	case Plus:
+		if isNumber(e.right, negZero) {
+			e.simplified = e.left
+		} else if isNumber(e.left, negZero) {
+			e.simplified = e.right
+		}
+	case Minus:
+		if isNumber(e.right, 0) {
+			e.simplified = e.left
+		}
+	case Times:
+		if isNumber(e.right, 1) {
+			e.simplified = e.left
+		} else if isNumber(e.left, 1) {
+			e.simplified = e.right
+		}
+	case Div:
+		if isNumber(e.right, 1) {
+			e.simplified = e.left
+		}
+	}
+
+	return nil
+}
+
+// isNumber returns true if expr is folded to n. Unlike ==, it distinguishes 0
+// and -0.
+func isNumber(expr Expr, n float64) bool {
+	v, ok := expr.Value().(float64)
+	return ok && math.Float64bits(v) == math.Float64bits(n)
+}
+
+func (e *BinaryExpr) cse(ctx *context.Context) (string, bool, error) {
+	if e.simplified != nil {
+		key, err := cseExpr(ctx, &e.simplified)
+		return key, false, err
+	}
+	left, err := cseExpr(ctx, &e.left)
+	if err != nil {
+		return "", false, err
+	}
+	right, err := cseExpr(ctx, &e.right)
+	if err != nil {
+		return "", false, err
+	}
+	return cseKey(e.op.String(), left, right), true, nil
+}
+
 func (e *BinaryExpr) emit(ctx *context.Context) error {
 	if e.value != nil {
-		ctx.Builder.EmitPushBasicValue(e.value)
-		return nil
+		return ctx.Builder.EmitPushBasicValue(e.value)
+	}
+	if e.simplified != nil {
+		return e.simplified.RunPass(ctx, context.Emit)
 	}
 
 	err := e.runPassChildren(ctx, context.Emit)
@@ -242,14 +322,8 @@ func (e *BinaryExpr) emit(ctx *context.Context) error {
 			ctx.Builder.EmitOp(runtime.CompareEqString)
 		} else if e.left.Type() == types.Bool {
 			ctx.Builder.EmitOp(runtime.CompareEqBool)
-		} else if e.left.Type().Equal(&types.Array{ElementType: types.Bool}) {
-			ctx.Builder.EmitOp(runtime.CompareEqArrayBool)
-		} else if e.left.Type().Equal(&types.Array{ElementType: types.Number}) {
-			ctx.Builder.EmitOp(runtime.CompareEqArrayNumber)
-		} else if e.left.Type().Equal(&types.Array{ElementType: types.String}) {
-			ctx.Builder.EmitOp(runtime.CompareEqArrayString)
 		} else {
-			panic("unexpected type with == operator")
+			ctx.Builder.EmitOp(runtime.CompareEq)
 		}
 		if e.op == Ne {
 			ctx.Builder.EmitOp(runtime.Negate)
@@ -258,3 +332,194 @@ func (e *BinaryExpr) emit(ctx *context.Context) error {
 
 	return nil
 }
+
+func (e *BinaryExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	left, err := evalExpr(ctx, e.left)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+	right, err := evalExpr(ctx, e.right)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+
+	switch e.op {
+	case Lt:
+		return runtime.NewBool(left.Number() < right.Number()), nil
+	case Le:
+		return runtime.NewBool(left.Number() <= right.Number()), nil
+	case Gt:
+		return runtime.NewBool(left.Number() > right.Number()), nil
+	case Ge:
+		return runtime.NewBool(left.Number() >= right.Number()), nil
+
+	case Plus:
+		return runtime.NewNumber(left.Number() + right.Number()), nil
+	case Minus:
+		return runtime.NewNumber(left.Number() - right.Number()), nil
+	case Times:
+		return runtime.NewNumber(left.Number() * right.Number()), nil
+	case Div:
+		return runtime.NewNumber(left.Number() / right.Number()), nil
+
+	case Eq:
+		return runtime.NewBool(left.Equal(right.RawValue)), nil
+	case Ne:
+		return runtime.NewBool(!left.Equal(right.RawValue)), nil
+
+	default:
+		return runtime.Value{}, fmt.Errorf("%w: invalid binary operator %v",
+			runtime.ErrInternal, e.op)
+	}
+}
+
+func (e *BinaryExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	if e.value != nil {
+		return foldedClosure(e.value)
+	}
+	if e.simplified != nil {
+		return closureExpr(ctx, e.simplified)
+	}
+
+	switch e.op {
+	case Eq, Ne:
+		return e.equalClosure(ctx)
+	}
+
+	left, err := numberClosure(ctx, e.left)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	right, err := numberClosure(ctx, e.right)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+
+	switch e.op {
+	case Lt:
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			return left(env) < right(env)
+		}}, nil
+	case Le:
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			return left(env) <= right(env)
+		}}, nil
+	case Gt:
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			return left(env) > right(env)
+		}}, nil
+	case Ge:
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			return left(env) >= right(env)
+		}}, nil
+
+	case Plus:
+		return runtime.Closure{Number: func(env *runtime.Env) float64 {
+			return left(env) + right(env)
+		}}, nil
+	case Minus:
+		return runtime.Closure{Number: func(env *runtime.Env) float64 {
+			return left(env) - right(env)
+		}}, nil
+	case Times:
+		return runtime.Closure{Number: func(env *runtime.Env) float64 {
+			return left(env) * right(env)
+		}}, nil
+	case Div:
+		return runtime.Closure{Number: func(env *runtime.Env) float64 {
+			return left(env) / right(env)
+		}}, nil
+
+	default:
+		return runtime.Closure{}, fmt.Errorf("%w: invalid binary operator %v",
+			runtime.ErrInternal, e.op)
+	}
+}
+
+// equalClosure returns the closure of an == or != comparison.
+func (e *BinaryExpr) equalClosure(ctx *context.Context) (runtime.Closure, error) {
+	var equal func(env *runtime.Env) bool
+	switch e.left.Type() {
+	case types.Number:
+		left, err := numberClosure(ctx, e.left)
+		if err != nil {
+			return runtime.Closure{}, err
+		}
+		right, err := numberClosure(ctx, e.right)
+		if err != nil {
+			return runtime.Closure{}, err
+		}
+		equal = func(env *runtime.Env) bool { return left(env) == right(env) }
+
+	case types.Bool:
+		left, err := boolClosure(ctx, e.left)
+		if err != nil {
+			return runtime.Closure{}, err
+		}
+		right, err := boolClosure(ctx, e.right)
+		if err != nil {
+			return runtime.Closure{}, err
+		}
+		equal = func(env *runtime.Env) bool { return left(env) == right(env) }
+
+	default:
+		left, err := rawClosure(ctx, e.left)
+		if err != nil {
+			return runtime.Closure{}, err
+		}
+		right, err := rawClosure(ctx, e.right)
+		if err != nil {
+			return runtime.Closure{}, err
+		}
+		if e.left.Type() == types.String {
+			equal = func(env *runtime.Env) bool {
+				return left(env).String() == right(env).String()
+			}
+		} else {
+			equal = func(env *runtime.Env) bool { return left(env).Equal(right(env)) }
+		}
+	}
+
+	if e.op == Ne {
+		return runtime.Closure{Bool: func(env *runtime.Env) bool { return !equal(env) }}, nil
+	}
+	return runtime.Closure{Bool: equal}, nil
+}
+
+func (e *BinaryExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	if e.value != nil {
+		return goFolded(ctx, e.value)
+	}
+	if e.simplified != nil {
+		return goExprOf(ctx, e.simplified)
+	}
+	left, err := goExprOf(ctx, e.left)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	right, err := goExprOf(ctx, e.right)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+
+	switch e.op {
+	case Lt, Le, Gt, Ge:
+		return goBinary(left, e.op.String(), 3, right), nil
+	case Plus, Minus:
+		return goBinary(left, e.op.String(), 4, right), nil
+	case Times:
+		return goBinary(left, e.op.String(), 5, right), nil
+	case Div:
+		// Go does not allow the division by a constant zero.
+		if v, ok := e.right.Value().(float64); ok && v == 0 && !math.Signbit(v) {
+			ctx.Go.Import("math")
+			right = goPrimary("math.Copysign(0, 1)")
+		}
+		return goBinary(left, e.op.String(), 5, right), nil
+	case Eq, Ne:
+		return goCompare(ctx, e.left.Type(), left, right, e.op == Eq)
+	default:
+		return context.GoExpr{}, fmt.Errorf("%w: invalid binary operator %v",
+			runtime.ErrInternal, e.op)
+	}
+}
@@ -0,0 +1,411 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// builtin is a function that is implemented by the compiler instead of the
+// host. A builtin type checks its own arguments and emits its own code. This
+// allows builtins to accept lambdas, which are compiled inline into bytecode
+// loops.
+type builtin interface {
+	checkTypes(ctx *context.Context, call *CallExpr) error
+	cse(ctx *context.Context, call *CallExpr) (string, bool, error)
+	emit(ctx *context.Context, call *CallExpr) error
+	eval(ctx *context.Context, call *CallExpr) (runtime.Value, error)
+	closure(ctx *context.Context, call *CallExpr) (runtime.Closure, error)
+	goExpr(ctx *context.Context, call *CallExpr) (context.GoExpr, error)
+}
+
+// builtins are resolved by name when the name is not defined in scope, so
+// symbols registered by the host take precedence, unless the call has a lambda
+// argument, which host functions cannot take.
+var builtins = map[string]builtin{
+	"all":    &iterBuiltin{name: "all", kind: iterAll},
+	"any":    &iterBuiltin{name: "any", kind: iterAny},
+	"count":  &iterBuiltin{name: "count", kind: iterCount},
+	"filter": &iterBuiltin{name: "filter", kind: iterFilter},
+	"find":   &iterBuiltin{name: "find", kind: iterFind},
+	"map":    &iterBuiltin{name: "map", kind: iterMap},
+	"reduce": &reduceBuiltin{},
+
+	"cidr": &parseBuiltin{fn: parseCIDR},
+	"glob": &regexBuiltin{fn: globMatch},
+	"ip":   &parseBuiltin{fn: parseIP},
+
+	"regexFind":    &regexBuiltin{fn: regexFind},
+	"regexReplace": &regexBuiltin{fn: regexReplace},
+	"regexSplit":   &regexBuiltin{fn: regexSplit},
+}
+
+// builtinFuncPrefix prefixes the names of the runtime functions that implement
+// builtins, which cannot clash with registered functions.
+const builtinFuncPrefix = "$"
+
+// BuiltinFunc returns the runtime function name of type typ that implements a
+// builtin, for linking deserialized programs. It returns false if there is no
+// such function.
+func BuiltinFunc(name string, typ *types.Function) (*runtime.Func, bool) {
+	if !strings.HasPrefix(name, builtinFuncPrefix) {
+		return nil, false
+	}
+	var candidates []*runtime.Func
+	for _, fn := range []*regexFunc{regexMatch, regexFind, regexReplace, regexSplit, globMatch} {
+		candidates = append(candidates, fn.runtimeFunc(false), fn.runtimeFunc(true))
+	}
+	for _, fn := range []*parseFunc{parseIP, parseCIDR} {
+		candidates = append(candidates, fn.runtimeFunc())
+	}
+	for _, fn := range candidates {
+		if fn.Name == name && fn.Type.Equal(typ) {
+			return fn, true
+		}
+	}
+	return nil, false
+}
+
+type iterKind int
+
+const (
+	iterAll iterKind = iota
+	iterAny
+	iterCount
+	iterFilter
+	iterFind
+	iterMap
+)
+
+// iterBuiltin implements the builtins that take an array and a lambda
+// with a single parameter: the array element.
+type iterBuiltin struct {
+	name string
+	kind iterKind
+}
+
+func (b *iterBuiltin) checkTypes(ctx *context.Context, call *CallExpr) error {
+	args := call.params.params
+	if len(args) != 2 {
+		return fmt.Errorf("%v expects 2 arguments but %d were provided",
+			b.name, len(args))
+	}
+
+	arrType, err := checkArrayArg(ctx, b.name, args[0])
+	if err != nil {
+		return err
+	}
+
+	lambda, ok := args[1].(*LambdaExpr)
+	if !ok {
+		return fmt.Errorf("second argument of %v must be a lambda", b.name)
+	}
+	err = lambda.bind(arrType.ElementType)
+	if err != nil {
+		return err
+	}
+	err = lambda.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return err
+	}
+
+	if b.kind == iterMap {
+		call.typ = &types.Array{ElementType: lambda.body.Type()}
+		return nil
+	}
+
+	if lambda.body.Type() != types.Bool {
+		return fmt.Errorf("%v predicate must return bool, but it returns %v",
+			b.name, lambda.body.Type())
+	}
+
+	switch b.kind {
+	case iterAll, iterAny:
+		call.typ = types.Bool
+	case iterCount:
+		call.typ = types.Number
+	case iterFilter:
+		call.typ = arrType
+	case iterFind:
+		if !hasZeroValue(arrType.ElementType) {
+			return fmt.Errorf("find is not supported for arrays of %v",
+				arrType.ElementType)
+		}
+		call.typ = arrType.ElementType
+	}
+
+	return nil
+}
+
+func (b *iterBuiltin) cse(ctx *context.Context, call *CallExpr) (string, bool, error) {
+	args := call.params.params
+	lambda := args[1].(*LambdaExpr)
+	return "", false, cseLoop(ctx, &args[0], nil, &lambda.body)
+}
+
+func (b *iterBuiltin) emit(ctx *context.Context, call *CallExpr) error {
+	return b.loop(call).emit(ctx)
+}
+
+func (b *iterBuiltin) eval(ctx *context.Context, call *CallExpr) (runtime.Value, error) {
+	return b.loop(call).eval(ctx)
+}
+
+func (b *iterBuiltin) closure(ctx *context.Context, call *CallExpr) (runtime.Closure, error) {
+	return b.loop(call).closure(ctx)
+}
+
+func (b *iterBuiltin) goExpr(ctx *context.Context, call *CallExpr) (context.GoExpr, error) {
+	return b.loop(call).goExpr(ctx)
+}
+
+// loop returns the loop that implements the call.
+func (b *iterBuiltin) loop(call *CallExpr) *iterLoop {
+	args := call.params.params
+	lambda := args[1].(*LambdaExpr)
+	return &iterLoop{
+		kind:   b.kind,
+		source: args[0],
+		elem:   lambda.syms[0].LocalIndex(),
+		name:   lambda.params[0],
+		body:   lambda.body,
+		typ:    call.typ,
+	}
+}
+
+// reduceBuiltin implements reduce(array, lambda(acc, elem), init).
+type reduceBuiltin struct{}
+
+func (b *reduceBuiltin) checkTypes(ctx *context.Context, call *CallExpr) error {
+	args := call.params.params
+	if len(args) != 3 {
+		return fmt.Errorf("reduce expects 3 arguments but %d were provided",
+			len(args))
+	}
+
+	arrType, err := checkArrayArg(ctx, "reduce", args[0])
+	if err != nil {
+		return err
+	}
+
+	err = args[2].RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return err
+	}
+	accType := args[2].Type()
+
+	lambda, ok := args[1].(*LambdaExpr)
+	if !ok {
+		return fmt.Errorf("second argument of reduce must be a lambda")
+	}
+	err = lambda.bind(accType, arrType.ElementType)
+	if err != nil {
+		return err
+	}
+	err = lambda.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return err
+	}
+
+	if !lambda.body.Type().Equal(accType) {
+		return fmt.Errorf(
+			"reduce lambda must return the type of the initial value %v, but it returns %v",
+			accType, lambda.body.Type())
+	}
+
+	call.typ = accType
+	return nil
+}
+
+// cse visits the arguments in the order in which they are evaluated. The
+// lambda body is evaluated repeatedly, so it is visited in a new region.
+func (b *reduceBuiltin) cse(ctx *context.Context, call *CallExpr) (string, bool, error) {
+	args := call.params.params
+	lambda := args[1].(*LambdaExpr)
+
+	_, err := cseExpr(ctx, &args[0])
+	if err != nil {
+		return "", false, err
+	}
+	_, err = cseExpr(ctx, &args[2])
+	if err != nil {
+		return "", false, err
+	}
+	_, err = cseBranch(ctx, &lambda.body)
+	return "", false, err
+}
+
+func (b *reduceBuiltin) emit(ctx *context.Context, call *CallExpr) error {
+	args := call.params.params
+	lambda := args[1].(*LambdaExpr)
+
+	err := args[0].RunPass(ctx, context.Emit)
+	if err != nil {
+		return err
+	}
+
+	iter := ctx.Builder.NewLocal()
+	ctx.Builder.EmitIterInit(iter)
+
+	// The accumulator is kept on the stack.
+	err = args[2].RunPass(ctx, context.Emit)
+	if err != nil {
+		return err
+	}
+
+	loop := ctx.Builder.NewLabel()
+	done := ctx.Builder.NewLabel()
+
+	ctx.Builder.AssignLabel(loop)
+	ctx.Builder.EmitIterNext(iter, done)
+	lambda.emitStoreParams(ctx)
+	err = lambda.emitBody(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Builder.EmitJump(runtime.Jump, loop)
+	ctx.Builder.AssignLabel(done)
+
+	return nil
+}
+
+func (b *reduceBuiltin) eval(ctx *context.Context, call *CallExpr) (runtime.Value, error) {
+	args := call.params.params
+	lambda := args[1].(*LambdaExpr)
+
+	source, err := evalExpr(ctx, args[0])
+	if err != nil {
+		return runtime.Value{}, err
+	}
+	elemType := args[0].Type().(*types.Array).ElementType
+
+	acc, err := evalExpr(ctx, args[2])
+	if err != nil {
+		return runtime.Value{}, err
+	}
+
+	for _, elem := range source.Object().([]runtime.RawValue) {
+		err = ctx.Eval.Iterate()
+		if err != nil {
+			return runtime.Value{}, err
+		}
+		ctx.Eval.Locals[lambda.syms[0].LocalIndex()] = acc
+		ctx.Eval.Locals[lambda.syms[1].LocalIndex()] = runtime.NewValue(elemType, elem)
+		acc, err = evalExpr(ctx, lambda.body)
+		if err != nil {
+			return runtime.Value{}, err
+		}
+	}
+
+	return acc, nil
+}
+
+func (b *reduceBuiltin) closure(ctx *context.Context, call *CallExpr) (runtime.Closure, error) {
+	args := call.params.params
+	lambda := args[1].(*LambdaExpr)
+
+	source, err := rawClosure(ctx, args[0])
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	init, err := rawClosure(ctx, args[2])
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	body, err := rawClosure(ctx, lambda.body)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	accIndex := lambda.syms[0].LocalIndex()
+	elemIndex := lambda.syms[1].LocalIndex()
+
+	return closureFromRaw(call.typ, func(env *runtime.Env) runtime.RawValue {
+		array := source(env).Object().([]runtime.RawValue)
+		acc := init(env)
+		for _, elem := range array {
+			if !env.Iterate() {
+				break
+			}
+			env.SetLocal(accIndex, acc)
+			env.SetLocal(elemIndex, elem)
+			acc = body(env)
+		}
+		return acc
+	}), nil
+}
+
+func (b *reduceBuiltin) goExpr(ctx *context.Context, call *CallExpr) (context.GoExpr, error) {
+	args := call.params.params
+	lambda := args[1].(*LambdaExpr)
+
+	source, err := goExprOf(ctx, args[0])
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	init, err := goExprOf(ctx, args[2])
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	acc := goLocal(ctx, lambda.syms[0].LocalIndex(), lambda.params[0])
+	goLocal(ctx, lambda.syms[1].LocalIndex(), lambda.params[1])
+	body, err := goExprOf(ctx, lambda.body)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	typ, err := goType(ctx, call.typ)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+
+	// The source is evaluated before the initial value, unless the order does
+	// not matter.
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "func() %s {\n", typ)
+	array := source.Code
+	if !source.Simple && !init.Simple {
+		array = ctx.Go.Fresh("array")
+		fmt.Fprintf(&sb, "%s := %s\n", array, source.Code)
+	}
+	fmt.Fprintf(&sb, "%s := %s\n", acc, goTyped(init, call.typ))
+	sb.WriteString(goRange(ctx, lambda.syms[1].LocalIndex(), array))
+	fmt.Fprintf(&sb, "%s%s = %s\n}\n", goLoopCheck(ctx), acc, body.Code)
+	fmt.Fprintf(&sb, "return %s\n}()", acc)
+	return goPrimary(sb.String()), nil
+}
+
+func checkArrayArg(ctx *context.Context, name string, arg Expr) (*types.Array, error) {
+	err := arg.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return nil, err
+	}
+	arrType, ok := arg.Type().(*types.Array)
+	if !ok {
+		return nil, fmt.Errorf("first argument of %v must be an array, but it is %v",
+			name, arg.Type())
+	}
+	return arrType, nil
+}
+
+func hasZeroValue(typ types.Type) bool {
+	switch typ.(type) {
+	case *types.Array:
+		return true
+	}
+	return typ == types.Number || typ == types.String || typ == types.Bool
+}
+
+func emitZeroValue(builder *runtime.Builder, typ types.Type) {
+	switch typ {
+	case types.Number:
+		builder.EmitPushNumber(0)
+	case types.String:
+		builder.EmitPushString("")
+	case types.Bool:
+		builder.EmitPushBool(false)
+	default:
+		builder.EmitPushArray(0)
+	}
+}
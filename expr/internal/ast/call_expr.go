@@ -1,9 +1,13 @@
 package ast
 
 import (
+	gocontext "context"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/internal/symbol"
 	"github.com/dcaiafa/go-expr/expr/runtime"
 	"github.com/dcaiafa/go-expr/expr/types"
 )
@@ -12,6 +16,13 @@ type CallExpr struct {
 	exprImpl
 	receiver Expr
 	params   *Params
+	builtin  builtin
+	fnType   *types.Function
+
+	// lowered is set by builtins that are implemented by another expression.
+	lowered Expr
+
+	overload *symbol.Overload
 }
 
 func NewCallExpr(receiver Expr, params *Params) *CallExpr {
@@ -26,54 +37,546 @@ func (e *CallExpr) Print(p *context.GraphPrinter) {
 }
 
 func (e *CallExpr) RunPass(ctx *context.Context, pass context.Pass) error {
+	if pass == context.ResolveNames {
+		e.resolveBuiltin(ctx)
+	}
+	if e.builtin != nil {
+		return e.runBuiltinPass(ctx, pass)
+	}
+
+	switch pass {
+	case context.CheckTypes:
+		return e.checkTypes(ctx)
+	case context.Fold:
+		return e.fold(ctx)
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+	case context.Emit:
+		return e.emit(ctx)
+	case context.Eval:
+		return setResult(ctx, e.eval)
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+	}
+
 	err := e.receiver.RunPass(ctx, pass)
 	if err != nil {
 		return err
 	}
-	err = e.params.RunPass(ctx, pass)
-	if err != nil {
-		return err
+	return e.params.RunPass(ctx, pass)
+}
+
+func (e *CallExpr) resolveBuiltin(ctx *context.Context) {
+	ref, ok := e.receiver.(*SimpleRefExpr)
+	if !ok {
+		return
+	}
+	b, ok := builtins[ref.id]
+	if !ok {
+		return
+	}
+	// Host functions cannot take lambdas, so a call with a lambda argument
+	// resolves to the builtin even if the name is defined in scope.
+	if ctx.Scope.Has(ref.id) && !e.hasLambdaArg() {
+		return
 	}
+	e.builtin = b
+}
+
+func (e *CallExpr) hasLambdaArg() bool {
+	for _, arg := range e.params.params {
+		if _, ok := arg.(*LambdaExpr); ok {
+			return true
+		}
+	}
+	return false
+}
 
+func (e *CallExpr) runBuiltinPass(ctx *context.Context, pass context.Pass) error {
 	switch pass {
 	case context.CheckTypes:
-		err = e.checkTypes()
-		if err != nil {
-			return err
-		}
+		return e.builtin.checkTypes(ctx, e)
+	case context.CSE:
+		return setCSE(ctx, func(ctx *context.Context) (string, bool, error) {
+			return e.builtin.cse(ctx, e)
+		})
 	case context.Emit:
-		e.emit(ctx.Builder)
+		return e.builtin.emit(ctx, e)
+	case context.Eval:
+		return setResult(ctx, func(ctx *context.Context) (runtime.Value, error) {
+			return e.builtin.eval(ctx, e)
+		})
+	case context.Closure:
+		return setClosure(ctx, func(ctx *context.Context) (runtime.Closure, error) {
+			return e.builtin.closure(ctx, e)
+		})
+	case context.GoGen:
+		return setGoExpr(ctx, func(ctx *context.Context) (context.GoExpr, error) {
+			return e.builtin.goExpr(ctx, e)
+		})
+	case context.Fold:
+		if e.lowered != nil {
+			err := e.lowered.RunPass(ctx, pass)
+			if err != nil {
+				return err
+			}
+			e.value = e.lowered.Value()
+			return nil
+		}
+		return e.params.RunPass(ctx, pass)
+	default:
+		return e.params.RunPass(ctx, pass)
 	}
-
-	return nil
 }
 
-func (e *CallExpr) checkTypes() error {
+func (e *CallExpr) checkTypes(ctx *context.Context) error {
+	if ref, ok := e.receiver.(*SimpleRefExpr); ok {
+		if fnSym := ref.funcSymbol(); fnSym != nil {
+			return e.checkFuncSymbolTypes(ctx, ref, fnSym)
+		}
+	}
+
+	err := e.receiver.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return err
+	}
+
 	fn, ok := e.receiver.Type().(*types.Function)
 	if !ok {
 		return fmt.Errorf("receiver is not a function")
 	}
 
-	if len(fn.Params) != len(e.params.params) {
+	// Default values are only known for registered functions.
+	fixedParams := len(fn.Params)
+	if fn.Variadic {
+		fixedParams--
+	}
+	if len(e.params.params) < fixedParams {
 		return fmt.Errorf("function expected %d parameters but %d were provided",
-			len(fn.Params), len(e.params.params))
+			fixedParams, len(e.params.params))
 	}
 
-	for i, arg := range fn.Params {
-		if !arg.Equal(e.params.params[i].Type()) {
-			return fmt.Errorf(
-				"parameter %d expected type is %v but %v was provided",
-				i, arg, e.params.params[i].Type())
-		}
+	fn, err = checkArgs(ctx, fn, e.params.params)
+	if err != nil {
+		return err
 	}
 
+	e.fnType = fn
 	e.typ = fn.Ret
 
 	return nil
 }
 
-func (e *CallExpr) emit(builder *runtime.Builder) {
-	builder.EmitCall(len(e.params.params))
+func (e *CallExpr) checkFuncSymbolTypes(
+	ctx *context.Context,
+	ref *SimpleRefExpr,
+	fnSym *symbol.FuncSymbol,
+) error {
+	if len(fnSym.Overloads) == 1 {
+		overload := fnSym.Overloads[0]
+		fnType, err := checkArgs(ctx, overload.Type, e.params.params)
+		if err != nil {
+			return err
+		}
+		e.selectOverload(ref, overload, fnType)
+		return nil
+	}
+
+	args := e.params.params
+	for i, arg := range args {
+		err := checkTypesWithHint(ctx, arg, overloadParamHint(fnSym, len(args), i))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Prefer an overload whose parameters match the arguments exactly over
+	// one that requires default values or variadic arguments.
+	var match *symbol.Overload
+	var matchType *types.Function
+	for _, overload := range fnSym.Overloads {
+		fnType := overloadMatches(overload.Type, args)
+		if fnType == nil {
+			continue
+		}
+		if !overload.Type.Variadic && len(overload.Type.Params) == len(args) {
+			match, matchType = overload, fnType
+			break
+		}
+		if match == nil {
+			match, matchType = overload, fnType
+		}
+	}
+	if match != nil {
+		e.selectOverload(ref, match, matchType)
+		return nil
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "no overload of %v matches arguments (", ref.id)
+	for i, arg := range args {
+		if i != 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(arg.Type().String())
+	}
+	sb.WriteString("); candidates are:")
+	for _, overload := range fnSym.Overloads {
+		sb.WriteString("\n  ")
+		sb.WriteString(overload.Type.String())
+	}
+	return errors.New(sb.String())
+}
+
+// selectOverload selects the overload called by the expression. fnType is the
+// type of the overload, or its instance if the overload is generic.
+func (e *CallExpr) selectOverload(
+	ref *SimpleRefExpr,
+	overload *symbol.Overload,
+	fnType *types.Function,
+) {
+	ref.selectOverload(overload, fnType)
+	e.overload = overload
+	e.fnType = fnType
+	e.typ = fnType.Ret
+}
+
+// checkArgs runs the CheckTypes pass on args and checks that they can be
+// passed to fn. It returns the type of the function, instantiated for the
+// argument types if fn is generic.
+func checkArgs(
+	ctx *context.Context,
+	fn *types.Function,
+	args []Expr,
+) (*types.Function, error) {
+	if !fn.AcceptsArgs(len(args)) {
+		switch {
+		case fn.Variadic:
+			return nil, fmt.Errorf(
+				"function expected at least %d parameters but %d were provided",
+				fn.MinArgs(), len(args))
+		case fn.Optional != 0:
+			return nil, fmt.Errorf(
+				"function expected %d to %d parameters but %d were provided",
+				fn.MinArgs(), len(fn.Params), len(args))
+		default:
+			return nil, fmt.Errorf("function expected %d parameters but %d were provided",
+				len(fn.Params), len(args))
+		}
+	}
+
+	// Arguments that need a type hint are checked last, so that the type
+	// variables of their parameters are bound by the other arguments.
+	bindings := types.Bindings{}
+	for _, hinted := range []bool{false, true} {
+		for i, arg := range args {
+			if needsTypeHint(arg) != hinted {
+				continue
+			}
+			param := fn.ParamType(i)
+			err := checkTypesWithHint(ctx, arg, paramHint(param, bindings))
+			if err != nil {
+				return nil, err
+			}
+			if !types.Unify(param, arg.Type(), bindings) {
+				return nil, fmt.Errorf(
+					"parameter %d expected type is %v but %v was provided",
+					i, types.Subst(param, bindings), arg.Type())
+			}
+		}
+	}
+
+	return instantiate(fn, bindings)
+}
+
+// paramHint returns the type hint for an argument of a generic parameter, or
+// nil if the parameter type depends on unbound type variables.
+func paramHint(param types.Type, bindings types.Bindings) types.Type {
+	param = types.Subst(param, bindings)
+	if types.IsGeneric(param) {
+		return nil
+	}
+	return param
+}
+
+// instantiate returns the instance of the generic function fn for the
+// bindings of its type variables. It returns fn if fn is not generic.
+func instantiate(fn *types.Function, bindings types.Bindings) (*types.Function, error) {
+	if !types.IsGeneric(fn) {
+		return fn, nil
+	}
+	inst := types.Subst(fn, bindings).(*types.Function)
+	if types.IsGeneric(inst.Ret) {
+		return nil, fmt.Errorf("cannot infer the result type %v of %v",
+			inst.Ret, fn)
+	}
+	return inst, nil
+}
+
+// overloadParamHint returns the type hint for argument i of a call with
+// argCount arguments. A hint is only provided if all the overloads that accept
+// argCount arguments agree on the type of the parameter, and it is not
+// generic.
+func overloadParamHint(fnSym *symbol.FuncSymbol, argCount int, i int) types.Type {
+	var hint types.Type
+	for _, overload := range fnSym.Overloads {
+		if !overload.Type.AcceptsArgs(argCount) {
+			continue
+		}
+		param := overload.Type.ParamType(i)
+		if types.IsGeneric(param) || (hint != nil && !hint.Equal(param)) {
+			return nil
+		}
+		hint = param
+	}
+	return hint
+}
+
+// overloadMatches returns the type of fn, instantiated if fn is generic, if
+// fn can be called with args. Otherwise it returns nil.
+func overloadMatches(fn *types.Function, args []Expr) *types.Function {
+	if !fn.AcceptsArgs(len(args)) {
+		return nil
+	}
+	bindings := types.Bindings{}
+	for i, arg := range args {
+		if !types.Unify(fn.ParamType(i), arg.Type(), bindings) {
+			return nil
+		}
+	}
+	inst, err := instantiate(fn, bindings)
+	if err != nil {
+		return nil
+	}
+	return inst
+}
+
+// fold evaluates calls to pure functions with constant arguments.
+func (e *CallExpr) fold(ctx *context.Context) error {
+	err := e.params.RunPass(ctx, context.Fold)
+	if err != nil {
+		return err
+	}
+
+	if e.overload == nil || !e.overload.Pure {
+		return nil
+	}
+
+	// The arguments for parameters of type Any keep their type, like in a
+	// Call instruction.
+	args := make([]runtime.Value, 0, len(e.fnType.Params))
+	for i, param := range e.params.params {
+		v, ok, err := constValue(ctx, param)
+		if !ok || err != nil {
+			return err
+		}
+		typ := e.fnType.ParamType(i)
+		if typ == types.Any {
+			typ = param.Type()
+		}
+		args = append(args, runtime.NewValue(typ, v))
+	}
+	firstOptional := e.fnType.MinArgs()
+	for i := len(args); i < firstOptional+len(e.overload.Defaults); i++ {
+		args = append(args, e.overload.Defaults[i-firstOptional].Value)
+	}
+
+	// A failing call is not folded, so that the error is reported when the
+	// expression is evaluated.
+	var res runtime.Value
+	fn := e.overload.Func
+	if fn.FuncErr != nil {
+		res, err = fn.FuncErr(gocontext.Background(), args)
+		if err != nil {
+			return nil
+		}
+	} else {
+		res = fn.Func(gocontext.Background(), args)
+	}
+	if !res.Type().Equal(e.fnType.Ret) {
+		return fmt.Errorf("function returned %v expected %v",
+			res.Type(), e.fnType.Ret)
+	}
+
+	e.value = foldedFromValue(res)
+	return nil
+}
+
+// cse returns the key of calls to pure functions. Calls to other functions
+// and to function values are not shared.
+func (e *CallExpr) cse(ctx *context.Context) (string, bool, error) {
+	ref, ok := e.receiver.(*SimpleRefExpr)
+	isFuncSymbol := ok && ref.funcSymbol() != nil
+	if !isFuncSymbol {
+		_, err := cseExpr(ctx, &e.receiver)
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	args := e.params.params
+	keys := make([]string, len(args))
+	for i := range args {
+		var err error
+		keys[i], err = cseExpr(ctx, &args[i])
+		if err != nil {
+			return "", false, err
+		}
+	}
+
+	if !isFuncSymbol || !e.overload.Pure {
+		return "", false, nil
+	}
+	return cseKey(ref.id+" "+e.fnType.String(), keys...), true, nil
+}
+
+func (e *CallExpr) emit(ctx *context.Context) error {
+	if e.value != nil {
+		return ctx.Builder.EmitPushBasicValue(e.value)
+	}
+
+	err := e.receiver.RunPass(ctx, context.Emit)
+	if err != nil {
+		return err
+	}
+
+	args := e.params.params
+	for i, arg := range args {
+		err = arg.RunPass(ctx, context.Emit)
+		if err != nil {
+			return err
+		}
+		if e.fnType.ParamType(i) == types.Any {
+			ctx.Builder.EmitBox(arg.Type())
+		}
+	}
+
+	argCount := len(args)
+
+	// Pass the default values of the omitted optional parameters.
+	if e.overload != nil {
+		firstOptional := e.fnType.MinArgs()
+		for i := argCount; i < firstOptional+len(e.overload.Defaults); i++ {
+			def := e.overload.Defaults[i-firstOptional]
+			ctx.Builder.EmitLoadConst(def.ConstIndex)
+			if e.fnType.Params[i] == types.Any {
+				ctx.Builder.EmitBox(def.Value.Type())
+			}
+			argCount++
+		}
+	}
+
+	ctx.Builder.EmitCall(argCount)
+	return nil
+}
+
+func (e *CallExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	receiver, err := evalExpr(ctx, e.receiver)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+
+	args := make([]runtime.Value, 0, len(e.params.params))
+	for _, arg := range e.params.params {
+		v, err := evalExpr(ctx, arg)
+		if err != nil {
+			return runtime.Value{}, err
+		}
+		args = append(args, v)
+	}
+	if e.overload != nil {
+		firstOptional := e.fnType.MinArgs()
+		for i := len(args); i < firstOptional+len(e.overload.Defaults); i++ {
+			args = append(args, e.overload.Defaults[i-firstOptional].Value)
+		}
+	}
+
+	return callFunc(ctx, receiver.Object().(*runtime.Func), args)
+}
+
+func (e *CallExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	if e.value != nil {
+		return foldedClosure(e.value)
+	}
+
+	// Calls to function symbols are resolved at compile time.
+	var fn *runtime.Func
+	var receiver rawFunc
+	if ref, ok := e.receiver.(*SimpleRefExpr); ok && ref.funcSymbol() != nil {
+		fn = ref.runtimeFunc()
+	} else {
+		var err error
+		receiver, err = rawClosure(ctx, e.receiver)
+		if err != nil {
+			return runtime.Closure{}, err
+		}
+	}
+
+	args, err := argClosures(ctx, e.params.params)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	if e.overload != nil {
+		firstOptional := e.fnType.MinArgs()
+		for i := len(args); i < firstOptional+len(e.overload.Defaults); i++ {
+			args = append(args, constArg(e.overload.Defaults[i-firstOptional].Value))
+		}
+	}
+
+	return callClosure(e.typ, fn, receiver, args), nil
+}
+
+func (e *CallExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	if e.value != nil {
+		return goFolded(ctx, e.value)
+	}
+
+	ref, ok := e.receiver.(*SimpleRefExpr)
+	if !ok || ref.funcSymbol() == nil {
+		return context.GoExpr{}, fmt.Errorf(
+			"calls to function values are not supported by the Go generator")
+	}
+	fn, ok := ctx.Go.Funcs[ref.id]
+	if !ok {
+		return context.GoExpr{}, fmt.Errorf(
+			"function %v has no Go implementation", ref.id)
+	}
+	if fn.Import != "" {
+		ctx.Go.Import(fn.Import)
+	}
+
+	args, err := goArgs(ctx, e.params.params)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	argTypes := make([]types.Type, len(args))
+	for i, arg := range e.params.params {
+		argTypes[i] = arg.Type()
+	}
+	firstOptional := e.fnType.MinArgs()
+	for i := len(args); i < firstOptional+len(e.overload.Defaults); i++ {
+		def := e.overload.Defaults[i-firstOptional].Value
+		code, err := goValue(ctx, def)
+		if err != nil {
+			return context.GoExpr{}, err
+		}
+		args = append(args, code)
+		argTypes = append(argTypes, def.Type())
+	}
+
+	generic := types.IsGeneric(e.overload.Type)
+	for i := range args {
+		if generic || e.fnType.ParamType(i) == types.Any {
+			args[i].Code = goTyped(args[i], argTypes[i])
+			args[i].Untyped = false
+		}
+	}
+
+	return goCall(ctx, e.typ, args, argTypes, fn.Err, func(args []string) string {
+		return fn.Name + "(" + strings.Join(args, ", ") + ")"
+	})
 }
 
 type Params struct {
@@ -0,0 +1,225 @@
+package ast
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// rawFunc is a closure that evaluates an expression of any type.
+type rawFunc = func(env *runtime.Env) runtime.RawValue
+
+// closureExpr runs the Closure pass on expr and returns its closure.
+func closureExpr(ctx *context.Context, expr Expr) (runtime.Closure, error) {
+	err := expr.RunPass(ctx, context.Closure)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	return ctx.Closure, nil
+}
+
+// setClosure runs the Closure pass implementation of an expression and stores
+// its closure as the result.
+func setClosure(
+	ctx *context.Context,
+	compile func(ctx *context.Context) (runtime.Closure, error),
+) error {
+	c, err := compile(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Closure = c
+	return nil
+}
+
+// rawClosure returns the closure of expr as a rawFunc.
+func rawClosure(ctx *context.Context, expr Expr) (rawFunc, error) {
+	c, err := closureExpr(ctx, expr)
+	if err != nil {
+		return nil, err
+	}
+	return c.Raw(), nil
+}
+
+// numberClosure returns the closure of expr, which must be a number.
+func numberClosure(ctx *context.Context, expr Expr) (func(env *runtime.Env) float64, error) {
+	c, err := closureExpr(ctx, expr)
+	if err != nil {
+		return nil, err
+	}
+	if c.Number == nil {
+		return nil, fmt.Errorf("%w: expected number closure for %v",
+			runtime.ErrInternal, expr.Type())
+	}
+	return c.Number, nil
+}
+
+// boolClosure returns the closure of expr, which must be a bool.
+func boolClosure(ctx *context.Context, expr Expr) (func(env *runtime.Env) bool, error) {
+	c, err := closureExpr(ctx, expr)
+	if err != nil {
+		return nil, err
+	}
+	if c.Bool == nil {
+		return nil, fmt.Errorf("%w: expected bool closure for %v",
+			runtime.ErrInternal, expr.Type())
+	}
+	return c.Bool, nil
+}
+
+// closureFromRaw returns the closure of an expression of type typ that is
+// evaluated by fn.
+func closureFromRaw(typ types.Type, fn rawFunc) runtime.Closure {
+	switch typ {
+	case types.Number:
+		return runtime.Closure{Number: func(env *runtime.Env) float64 {
+			return fn(env).Number()
+		}}
+	case types.Bool:
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			return fn(env).Bool()
+		}}
+	default:
+		return runtime.Closure{Value: fn}
+	}
+}
+
+// constClosure returns the closure of a constant v of type typ.
+func constClosure(typ types.Type, v runtime.RawValue) runtime.Closure {
+	switch typ {
+	case types.Number:
+		num := v.Number()
+		return runtime.Closure{Number: func(env *runtime.Env) float64 {
+			return num
+		}}
+	case types.Bool:
+		b := v.Bool()
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			return b
+		}}
+	default:
+		return runtime.Closure{Value: func(env *runtime.Env) runtime.RawValue {
+			return v
+		}}
+	}
+}
+
+// foldedClosure returns the closure of an expression folded to v.
+func foldedClosure(v interface{}) (runtime.Closure, error) {
+	folded, ok := valueFromFolded(v)
+	if !ok {
+		return runtime.Closure{}, fmt.Errorf("%w: invalid folded value %T",
+			runtime.ErrInternal, v)
+	}
+	return constClosure(folded.Type(), folded.RawValue), nil
+}
+
+// callArg is an argument of a call compiled by the Closure pass.
+type callArg struct {
+	typ types.Type
+	fn  rawFunc
+}
+
+// argClosures returns the closures of the arguments of a call.
+func argClosures(ctx *context.Context, args []Expr) ([]callArg, error) {
+	callArgs := make([]callArg, len(args))
+	for i, arg := range args {
+		fn, err := rawClosure(ctx, arg)
+		if err != nil {
+			return nil, err
+		}
+		callArgs[i] = callArg{typ: arg.Type(), fn: fn}
+	}
+	return callArgs, nil
+}
+
+// constArg returns an argument with the constant value v.
+func constArg(v runtime.Value) callArg {
+	return callArg{
+		typ: v.Type(),
+		fn:  func(env *runtime.Env) runtime.RawValue { return v.RawValue },
+	}
+}
+
+// callSite is a call compiled by the Closure pass.
+type callSite struct {
+	// fn is the function if it is known at compile time. Otherwise, it is
+	// returned by receiver.
+	fn       *runtime.Func
+	receiver rawFunc
+	args     []callArg
+
+	// failed is the placeholder returned if the call fails.
+	failed runtime.RawValue
+}
+
+// call evaluates the arguments and calls the function. Like the Call
+// instruction, the arguments are evaluated after the receiver.
+func (c *callSite) call(env *runtime.Env) runtime.RawValue {
+	fn := c.fn
+	if fn == nil {
+		fn = c.receiver(env).Object().(*runtime.Func)
+	}
+	mark := env.ArgMark()
+	for i := range c.args {
+		arg := &c.args[i]
+		env.PushArg(runtime.NewValue(arg.typ, arg.fn(env)))
+	}
+	res, ok := env.Call(fn, mark)
+	if !ok {
+		return c.failed
+	}
+	return res
+}
+
+// callClosure returns the closure of a call with result type ret to fn, or,
+// if fn is nil, to the function returned by receiver.
+func callClosure(
+	ret types.Type,
+	fn *runtime.Func,
+	receiver rawFunc,
+	args []callArg,
+) runtime.Closure {
+	site := &callSite{
+		fn:       fn,
+		receiver: receiver,
+		args:     args,
+		failed:   placeholder(ret),
+	}
+	switch ret {
+	case types.Number:
+		return runtime.Closure{Number: func(env *runtime.Env) float64 {
+			return site.call(env).Number()
+		}}
+	case types.Bool:
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			return site.call(env).Bool()
+		}}
+	default:
+		return runtime.Closure{Value: site.call}
+	}
+}
+
+// placeholder returns the value of a failed call of type typ. The value is
+// discarded by the runtime, but it must be valid for the closures that consume
+// it while the execution winds down.
+func placeholder(typ types.Type) runtime.RawValue {
+	switch typ {
+	case types.String:
+		return runtime.NewRawObject("")
+	case types.IP:
+		return runtime.NewRawObject(netip.Addr{})
+	case types.CIDR:
+		return runtime.NewRawObject(netip.Prefix{})
+	}
+	switch typ.(type) {
+	case *types.Array:
+		return runtime.NewRawObject([]runtime.RawValue(nil))
+	case *types.Function:
+		return runtime.NewRawObject((*runtime.Func)(nil))
+	}
+	return runtime.RawValue{}
+}
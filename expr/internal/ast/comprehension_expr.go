@@ -0,0 +1,170 @@
+package ast
+
+import (
+	"fmt"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/internal/symbol"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// ComprehensionExpr is an array comprehension:
+//
+//	[elem for name in source if filter]
+//
+// The filter is optional.
+type ComprehensionExpr struct {
+	exprImpl
+	elem   Expr
+	name   string
+	source Expr
+	filter Expr
+	sym    *symbol.LocalSymbol
+}
+
+func NewComprehensionExpr(elem Expr, name string, source Expr, filter Expr) *ComprehensionExpr {
+	return &ComprehensionExpr{
+		elem:   elem,
+		name:   name,
+		source: source,
+		filter: filter,
+	}
+}
+
+func (e *ComprehensionExpr) Print(p *context.GraphPrinter) {
+	if e.filter == nil {
+		p.PrintNode("for "+e.name, e.elem, e.source)
+	} else {
+		p.PrintNode("for "+e.name, e.elem, e.source, e.filter)
+	}
+}
+
+func (e *ComprehensionExpr) RunPass(ctx *context.Context, pass context.Pass) error {
+	switch pass {
+	case context.ResolveNames:
+		err := e.resolveNames(ctx)
+		if err != nil {
+			return err
+		}
+
+	case context.CheckTypes:
+		err := e.checkTypes(ctx)
+		if err != nil {
+			return err
+		}
+
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+
+	case context.Emit:
+		err := e.emit(ctx)
+		if err != nil {
+			return err
+		}
+
+	case context.Eval:
+		return setResult(ctx, e.eval)
+
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
+	default:
+		err := e.runPassChildren(ctx, pass)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *ComprehensionExpr) runPassChildren(ctx *context.Context, pass context.Pass) error {
+	err := e.source.RunPass(ctx, pass)
+	if err != nil {
+		return err
+	}
+	if e.filter != nil {
+		err = e.filter.RunPass(ctx, pass)
+		if err != nil {
+			return err
+		}
+	}
+	return e.elem.RunPass(ctx, pass)
+}
+
+func (e *ComprehensionExpr) resolveNames(ctx *context.Context) error {
+	// The loop variable is not visible in the source expression.
+	err := e.source.RunPass(ctx, context.ResolveNames)
+	if err != nil {
+		return err
+	}
+	e.sym, err = resolveLoopVar(ctx, e.name, e.filter, e.elem)
+	return err
+}
+
+func (e *ComprehensionExpr) checkTypes(ctx *context.Context) error {
+	_, err := checkLoopSource(ctx, e.source, e.sym)
+	if err != nil {
+		return err
+	}
+
+	if e.filter != nil {
+		err = e.filter.RunPass(ctx, context.CheckTypes)
+		if err != nil {
+			return err
+		}
+		if e.filter.Type() != types.Bool {
+			return fmt.Errorf("comprehension condition must be bool, but it is %v",
+				e.filter.Type())
+		}
+	}
+
+	err = e.elem.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return err
+	}
+
+	e.typ = &types.Array{ElementType: e.elem.Type()}
+	return nil
+}
+
+func (e *ComprehensionExpr) cse(ctx *context.Context) (string, bool, error) {
+	var filter *Expr
+	if e.filter != nil {
+		filter = &e.filter
+	}
+	return "", false, cseLoop(ctx, &e.source, filter, &e.elem)
+}
+
+func (e *ComprehensionExpr) emit(ctx *context.Context) error {
+	return e.loop().emit(ctx)
+}
+
+func (e *ComprehensionExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	return e.loop().eval(ctx)
+}
+
+func (e *ComprehensionExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	return e.loop().closure(ctx)
+}
+
+func (e *ComprehensionExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	return e.loop().goExpr(ctx)
+}
+
+// loop returns the loop that implements the expression.
+func (e *ComprehensionExpr) loop() *iterLoop {
+	return &iterLoop{
+		kind:   iterMap,
+		source: e.source,
+		elem:   e.sym.LocalIndex(),
+		name:   e.name,
+		filter: e.filter,
+		body:   e.elem,
+		typ:    e.typ,
+	}
+}
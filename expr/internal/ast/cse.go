@@ -0,0 +1,116 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+)
+
+// cseExpr runs the CSE pass on the expression at *p and returns its key. If
+// the expression is shared, it is replaced by a SharedExpr.
+func cseExpr(ctx *context.Context, p *Expr) (string, error) {
+	expr := *p
+	if v := expr.Value(); v != nil {
+		return fmt.Sprintf("%#v", v), nil
+	}
+
+	mark := ctx.CSE.Mark()
+	err := expr.RunPass(ctx, context.CSE)
+	if err != nil {
+		return "", err
+	}
+	key := ctx.CSE.Key
+	if key != "" && ctx.CSE.Shared {
+		ctx.CSE.Visit(key, mark, func(local int, load bool) {
+			*p = &SharedExpr{Expr: expr, local: local, load: load}
+		})
+	}
+	return key, nil
+}
+
+// setCSE runs the CSE pass implementation of an expression and stores its key
+// as the result.
+func setCSE(
+	ctx *context.Context,
+	cse func(ctx *context.Context) (key string, shared bool, err error),
+) error {
+	key, shared, err := cse(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.CSE.Key = key
+	ctx.CSE.Shared = shared && key != ""
+	return nil
+}
+
+// cseBranch runs the CSE pass on the expression at *p in a new region, because
+// it is evaluated conditionally or repeatedly.
+func cseBranch(ctx *context.Context, p *Expr) (string, error) {
+	end := ctx.CSE.Branch()
+	defer end()
+	return cseExpr(ctx, p)
+}
+
+// cseKey returns the key of an operation on operands with the given keys, or
+// "" if any of the operands cannot be shared.
+func cseKey(op string, operands ...string) string {
+	for _, operand := range operands {
+		if operand == "" {
+			return ""
+		}
+	}
+	return op + "(" + strings.Join(operands, ", ") + ")"
+}
+
+// SharedExpr is an occurrence of a common sub-expression found by the CSE
+// pass. The first occurrence evaluates the expression and stores its value in
+// a local, and the others load the local instead.
+type SharedExpr struct {
+	Expr
+	local int
+	load  bool
+}
+
+func (e *SharedExpr) RunPass(ctx *context.Context, pass context.Pass) error {
+	switch pass {
+	case context.Emit:
+		return e.emit(ctx)
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+	default:
+		return e.Expr.RunPass(ctx, pass)
+	}
+}
+
+func (e *SharedExpr) emit(ctx *context.Context) error {
+	if !e.load {
+		err := e.Expr.RunPass(ctx, context.Emit)
+		if err != nil {
+			return err
+		}
+		ctx.Builder.EmitStoreLocal(e.local)
+	}
+	ctx.Builder.EmitLoadLocal(e.local)
+	return nil
+}
+
+func (e *SharedExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	local := e.local
+	if e.load {
+		return closureFromRaw(e.Type(), func(env *runtime.Env) runtime.RawValue {
+			return env.Local(local)
+		}), nil
+	}
+
+	expr, err := rawClosure(ctx, e.Expr)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	return closureFromRaw(e.Type(), func(env *runtime.Env) runtime.RawValue {
+		v := expr(env)
+		env.SetLocal(local, v)
+		return v
+	}), nil
+}
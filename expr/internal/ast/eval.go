@@ -0,0 +1,50 @@
+package ast
+
+import (
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// evalExpr runs the Eval pass on expr and returns its value.
+func evalExpr(ctx *context.Context, expr Expr) (runtime.Value, error) {
+	err := expr.RunPass(ctx, context.Eval)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+	return ctx.Eval.Result, nil
+}
+
+// setResult runs the Eval pass implementation of an expression and stores its
+// value as the result.
+func setResult(
+	ctx *context.Context,
+	eval func(ctx *context.Context) (runtime.Value, error),
+) error {
+	v, err := eval(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Eval.Result = v
+	return nil
+}
+
+// callFunc calls fn with args in the same way as the Call instruction of the
+// runtime.
+func callFunc(ctx *context.Context, fn *runtime.Func, args []runtime.Value) (runtime.Value, error) {
+	return runtime.CallFunc(ctx.Eval.Context, fn, args)
+}
+
+// zeroValue returns the value pushed by emitZeroValue.
+func zeroValue(typ types.Type) runtime.Value {
+	switch typ {
+	case types.Number:
+		return runtime.NewNumber(0)
+	case types.String:
+		return runtime.NewString("")
+	case types.Bool:
+		return runtime.NewBool(false)
+	default:
+		return runtime.NewObject(typ, []runtime.RawValue{})
+	}
+}
@@ -0,0 +1,61 @@
+package ast
+
+import (
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/internal/symbol"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// valueFromFolded converts the folded value of an expression, as returned by
+// Expr.Value, to a runtime.Value. It returns false if the expression was not
+// folded.
+func valueFromFolded(v interface{}) (runtime.Value, bool) {
+	switch v := v.(type) {
+	case float64:
+		return runtime.NewNumber(v), true
+	case bool:
+		return runtime.NewBool(v), true
+	case string:
+		return runtime.NewString(v), true
+	default:
+		return runtime.Value{}, false
+	}
+}
+
+// foldedFromValue converts a runtime.Value to a folded value that can be
+// returned by Expr.Value. It returns nil if the value's type cannot be folded.
+func foldedFromValue(v runtime.Value) interface{} {
+	switch v.Type() {
+	case types.Number:
+		return v.Number()
+	case types.Bool:
+		return v.Bool()
+	case types.String:
+		return v.String()
+	default:
+		return nil
+	}
+}
+
+// constValue returns the value of expr if it is known at compile time: a
+// folded value, an array literal whose elements are folded, or a reference to
+// a registered constant.
+func constValue(ctx *context.Context, expr Expr) (runtime.RawValue, bool, error) {
+	if v, ok := valueFromFolded(expr.Value()); ok {
+		return v.RawValue, true, nil
+	}
+	switch expr := expr.(type) {
+	case *ArrayLiteralExpr:
+		array, ok, err := expr.foldedArray()
+		if !ok || err != nil {
+			return runtime.RawValue{}, false, err
+		}
+		return runtime.NewRawObject(array), true, nil
+	case *SimpleRefExpr:
+		if sym, ok := expr.sym.(*symbol.ConstSymbol); ok {
+			return ctx.Builder.Const(sym.ConstIndex()).RawValue, true, nil
+		}
+	}
+	return runtime.RawValue{}, false, nil
+}
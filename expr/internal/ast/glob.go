@@ -0,0 +1,134 @@
+package ast
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// compileGlob compiles a glob pattern to an equivalent regular expression.
+// The glob syntax is that of path.Match, plus "**". A "*" matches any
+// sequence of characters other than "/", "**" matches any sequence of
+// characters including "/", and "?" matches any single character other than
+// "/". A class such as "[a-z]" matches a character in the class, and "[^a-z]"
+// a character not in it; classes never match "/". A "\" escapes the next
+// character, and "\", "-", "]" and "^" must be escaped within a class.
+// Newlines are characters like any other.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	// With the s flag, the "." of "**" also matches newlines.
+	sb.WriteString(`(?s)^`)
+	for i := 0; i < len(pattern); {
+		switch c := pattern[i]; c {
+		case '*':
+			if strings.HasPrefix(pattern[i:], "**") {
+				sb.WriteString(`.*`)
+				i += 2
+			} else {
+				sb.WriteString(`[^/]*`)
+				i++
+			}
+		case '?':
+			sb.WriteString(`[^/]`)
+			i++
+		case '[':
+			n, err := writeGlobClass(&sb, pattern[i:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid glob pattern %q: %v", pattern, err)
+			}
+			i += n
+		default:
+			if c == '\\' {
+				i++
+				if i == len(pattern) {
+					return nil, fmt.Errorf(
+						"invalid glob pattern %q: trailing backslash", pattern)
+				}
+			}
+			r, n := utf8.DecodeRuneInString(pattern[i:])
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+			i += n
+		}
+	}
+	sb.WriteString(`$`)
+	return regexp.Compile(sb.String())
+}
+
+// writeGlobClass writes the regular expression for the character class at
+// the start of pattern, and returns the length of the class in pattern.
+func writeGlobClass(sb *strings.Builder, pattern string) (int, error) {
+	i := 1
+	negated := strings.HasPrefix(pattern[i:], "^")
+	if negated {
+		sb.WriteString(`[^/`)
+		i++
+	} else {
+		sb.WriteString(`[`)
+	}
+
+	// classChar reads a possibly escaped character.
+	classChar := func() (rune, error) {
+		if i < len(pattern) && pattern[i] == '\\' {
+			i++
+		}
+		if i == len(pattern) {
+			return 0, fmt.Errorf("unterminated character class")
+		}
+		r, n := utf8.DecodeRuneInString(pattern[i:])
+		i += n
+		return r, nil
+	}
+
+	empty, onlySlash := true, true
+	for {
+		if i == len(pattern) {
+			return 0, fmt.Errorf("unterminated character class")
+		}
+		if pattern[i] == ']' {
+			break
+		}
+		lo, err := classChar()
+		if err != nil {
+			return 0, err
+		}
+		hi := lo
+		if i < len(pattern) && pattern[i] == '-' {
+			i++
+			hi, err = classChar()
+			if err != nil {
+				return 0, err
+			}
+			if hi < lo {
+				return 0, fmt.Errorf("invalid character range %c-%c", lo, hi)
+			}
+		}
+		empty = false
+		onlySlash = onlySlash && lo == '/' && hi == '/'
+		if lo <= '/' && '/' <= hi {
+			// The class must not match /.
+			writeClassRange(sb, lo, '/'-1)
+			writeClassRange(sb, '/'+1, hi)
+		} else {
+			writeClassRange(sb, lo, hi)
+		}
+	}
+	if empty {
+		return 0, fmt.Errorf("empty character class")
+	}
+	if onlySlash && !negated {
+		return 0, fmt.Errorf("character class only matches /")
+	}
+	sb.WriteString(`]`)
+	return i + 1, nil
+}
+
+func writeClassRange(sb *strings.Builder, lo, hi rune) {
+	if lo > hi {
+		return
+	}
+	fmt.Fprintf(sb, `\x{%x}`, lo)
+	if hi != lo {
+		fmt.Fprintf(sb, `-\x{%x}`, hi)
+	}
+}
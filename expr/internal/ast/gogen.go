@@ -0,0 +1,372 @@
+package ast
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// goExprOf runs the GoGen pass on expr and returns its code.
+func goExprOf(ctx *context.Context, expr Expr) (context.GoExpr, error) {
+	err := expr.RunPass(ctx, context.GoGen)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	return ctx.Go.Result, nil
+}
+
+// setGoExpr runs the GoGen pass implementation of an expression and stores its
+// code as the result.
+func setGoExpr(
+	ctx *context.Context,
+	gen func(ctx *context.Context) (context.GoExpr, error),
+) error {
+	code, err := gen(ctx)
+	if err != nil {
+		return err
+	}
+	ctx.Go.Result = code
+	return nil
+}
+
+// goPrimary returns a primary expression, such as a call.
+func goPrimary(code string) context.GoExpr {
+	return context.GoExpr{Code: code, Prec: context.GoPrimary}
+}
+
+// goType returns the Go type of values of type typ.
+func goType(ctx *context.Context, typ types.Type) (string, error) {
+	switch typ {
+	case types.Number:
+		return "float64", nil
+	case types.String:
+		return "string", nil
+	case types.Bool:
+		return "bool", nil
+	case types.IP:
+		ctx.Go.Import("net/netip")
+		return "netip.Addr", nil
+	case types.CIDR:
+		ctx.Go.Import("net/netip")
+		return "netip.Prefix", nil
+	}
+	if arrType, ok := typ.(*types.Array); ok {
+		elem, err := goType(ctx, arrType.ElementType)
+		if err != nil {
+			return "", err
+		}
+		return "[]" + elem, nil
+	}
+	if _, ok := typ.(*types.Function); ok {
+		return "", fmt.Errorf("function values are not supported by the Go generator")
+	}
+	return "", fmt.Errorf("type %v is not supported by the Go generator", typ)
+}
+
+// goZero returns the zero value of the Go type of typ, which is also the
+// placeholder returned by operations skipped after an error.
+func goZero(ctx *context.Context, typ types.Type) string {
+	switch typ {
+	case types.Number:
+		return "0"
+	case types.String:
+		return `""`
+	case types.Bool:
+		return "false"
+	case types.IP:
+		ctx.Go.Import("net/netip")
+		return "netip.Addr{}"
+	case types.CIDR:
+		ctx.Go.Import("net/netip")
+		return "netip.Prefix{}"
+	default:
+		return "nil"
+	}
+}
+
+// goNumber returns the code of the number v. Infinities, NaN and negative
+// zero are not constants in Go, so they are returned as calls.
+func goNumber(ctx *context.Context, v float64) context.GoExpr {
+	switch {
+	case math.IsNaN(v):
+		ctx.Go.Import("math")
+		return goPrimary("math.NaN()")
+	case math.IsInf(v, 1):
+		ctx.Go.Import("math")
+		return goPrimary("math.Inf(1)")
+	case math.IsInf(v, -1):
+		ctx.Go.Import("math")
+		return goPrimary("math.Inf(-1)")
+	case v == 0 && math.Signbit(v):
+		ctx.Go.Import("math")
+		return goPrimary("math.Copysign(0, -1)")
+	}
+
+	format := byte('g')
+	if abs := math.Abs(v); abs == 0 || (abs >= 1e-4 && abs < 1e21) {
+		format = 'f'
+	}
+	prec := context.GoPrimary
+	if v < 0 {
+		prec = context.GoUnary
+	}
+	return context.GoExpr{
+		Code:    strconv.FormatFloat(v, format, -1, 64),
+		Prec:    prec,
+		Untyped: true,
+		Simple:  true,
+	}
+}
+
+// goFolded returns the code of the folded value v.
+func goFolded(ctx *context.Context, v interface{}) (context.GoExpr, error) {
+	switch v := v.(type) {
+	case float64:
+		return goNumber(ctx, v), nil
+	case string:
+		return context.GoExpr{
+			Code:    strconv.Quote(v),
+			Prec:    context.GoPrimary,
+			Untyped: true,
+			Simple:  true,
+		}, nil
+	case bool:
+		return context.GoExpr{
+			Code:    strconv.FormatBool(v),
+			Prec:    context.GoPrimary,
+			Untyped: true,
+			Simple:  true,
+		}, nil
+	default:
+		return context.GoExpr{}, fmt.Errorf("%w: invalid folded value %T",
+			runtime.ErrInternal, v)
+	}
+}
+
+// goValue returns the code of the constant v.
+func goValue(ctx *context.Context, v runtime.Value) (context.GoExpr, error) {
+	if folded := foldedFromValue(v); folded != nil {
+		return goFolded(ctx, folded)
+	}
+
+	switch v.Type() {
+	case types.IP:
+		addr := v.Object().(netip.Addr)
+		if !addr.IsValid() {
+			return goPrimary(goZero(ctx, types.IP)), nil
+		}
+		return goPrimary(fmt.Sprintf("netip.MustParseAddr(%q)", addr)), nil
+	case types.CIDR:
+		prefix := v.Object().(netip.Prefix)
+		if !prefix.IsValid() {
+			return goPrimary(goZero(ctx, types.CIDR)), nil
+		}
+		return goPrimary(fmt.Sprintf("netip.MustParsePrefix(%q)", prefix)), nil
+	}
+
+	arrType, ok := v.Type().(*types.Array)
+	if !ok {
+		return context.GoExpr{}, fmt.Errorf(
+			"constant of type %v is not supported by the Go generator", v.Type())
+	}
+	typ, err := goType(ctx, arrType)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	array := v.Object().([]runtime.RawValue)
+	elems := make([]string, len(array))
+	for i, elem := range array {
+		code, err := goValue(ctx, runtime.NewValue(arrType.ElementType, elem))
+		if err != nil {
+			return context.GoExpr{}, err
+		}
+		elems[i] = code.Code
+	}
+	return goPrimary(typ + "{" + strings.Join(elems, ", ") + "}"), nil
+}
+
+// goParen returns the code of e, parenthesized if its precedence is lower
+// than prec.
+func goParen(e context.GoExpr, prec int) string {
+	if e.Prec < prec {
+		return "(" + e.Code + ")"
+	}
+	return e.Code
+}
+
+// goBinary returns the code of a left-associative binary operation.
+func goBinary(left context.GoExpr, op string, prec int, right context.GoExpr) context.GoExpr {
+	return context.GoExpr{
+		Code: goParen(left, prec) + " " + op + " " + goParen(right, prec+1),
+		Prec: prec,
+	}
+}
+
+// goNot returns the code of the negation of the bool expression e.
+func goNot(e context.GoExpr) string {
+	return "!" + goParen(e, context.GoUnary)
+}
+
+// goTyped returns the code of e, converted to float64 if e is an untyped
+// number, so that it does not default to int when it is assigned to a
+// variable or passed to a parameter of type interface{} or a type parameter.
+func goTyped(e context.GoExpr, typ types.Type) string {
+	if e.Untyped && typ == types.Number {
+		return "float64(" + e.Code + ")"
+	}
+	return e.Code
+}
+
+// goArgs returns the code of the arguments of a call.
+func goArgs(ctx *context.Context, args []Expr) ([]context.GoExpr, error) {
+	codes := make([]context.GoExpr, len(args))
+	for i, arg := range args {
+		code, err := goExprOf(ctx, arg)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+	return codes, nil
+}
+
+// goCall returns the code of a call with result type ret. call returns the
+// call given the code of the arguments. If fails is set, the call returns an
+// error as its second result.
+//
+// In checked code, the arguments are evaluated first, and the call is
+// skipped if the execution failed. Otherwise the call is returned as is, and
+// if it fails, the code must be generated again in checked mode.
+func goCall(
+	ctx *context.Context,
+	ret types.Type,
+	args []context.GoExpr,
+	argTypes []types.Type,
+	fails bool,
+	call func(args []string) string,
+) (context.GoExpr, error) {
+	g := ctx.Go
+	codes := make([]string, len(args))
+	if !g.Checked {
+		if fails {
+			g.Fails = true
+		}
+		for i, arg := range args {
+			codes[i] = arg.Code
+		}
+		return goPrimary(call(codes)), nil
+	}
+
+	retType, err := goType(ctx, ret)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "func() %s {\n", retType)
+	for i, arg := range args {
+		if arg.Simple {
+			codes[i] = arg.Code
+			continue
+		}
+		codes[i] = g.Fresh("x")
+		fmt.Fprintf(&sb, "%s := %s\n", codes[i], goTyped(arg, argTypes[i]))
+	}
+	fmt.Fprintf(&sb, "if %s != nil {\nreturn %s\n}\n", g.ErrVar, goZero(ctx, ret))
+	if fails {
+		resVar, errVar := g.Fresh("r"), g.Fresh("e")
+		fmt.Fprintf(&sb, "%s, %s := %s\n", resVar, errVar, call(codes))
+		fmt.Fprintf(&sb, "if %s != nil {\n%s = %s\n}\n", errVar, g.ErrVar, errVar)
+		fmt.Fprintf(&sb, "return %s\n", resVar)
+	} else {
+		fmt.Fprintf(&sb, "return %s\n", call(codes))
+	}
+	sb.WriteString("}()")
+	return goPrimary(sb.String()), nil
+}
+
+// goCompare returns the code that compares left and right, of type typ, for
+// equality like RawValue.Equal, or for inequality if equal is false.
+func goCompare(
+	ctx *context.Context,
+	typ types.Type,
+	left, right context.GoExpr,
+	equal bool,
+) (context.GoExpr, error) {
+	arrType, ok := typ.(*types.Array)
+	if !ok {
+		if equal {
+			return goBinary(left, "==", 3, right), nil
+		}
+		return goBinary(left, "!=", 3, right), nil
+	}
+	name, err := goEqualFunc(ctx, arrType)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	code := fmt.Sprintf("%s(%s, %s)", name, left.Code, right.Code)
+	if equal {
+		return goPrimary(code), nil
+	}
+	return context.GoExpr{Code: "!" + code, Prec: context.GoUnary}, nil
+}
+
+// goEqualFunc declares a function that compares arrays of type typ and
+// returns its name.
+func goEqualFunc(ctx *context.Context, typ *types.Array) (string, error) {
+	goTyp, err := goType(ctx, typ)
+	if err != nil {
+		return "", err
+	}
+	elemNotEqual, err := goCompare(ctx, typ.ElementType,
+		goPrimary("a[i]"), goPrimary("b[i]"), false)
+	if err != nil {
+		return "", err
+	}
+	return ctx.Go.Declare("equal "+goTyp, "equal", func(name string) string {
+		return fmt.Sprintf(`func %s(a, b %s) bool {
+			if len(a) != len(b) {
+				return false
+			}
+			for i := range a {
+				if %s {
+					return false
+				}
+			}
+			return true
+		}`, name, goTyp, elemNotEqual.Code)
+	}), nil
+}
+
+// goLocal declares the local at localIndex, with a name based on name.
+func goLocal(ctx *context.Context, localIndex int, name string) string {
+	if name == "_" {
+		name = "elem"
+	}
+	goName := ctx.Go.Fresh(name)
+	ctx.Go.Locals[localIndex] = goName
+	return goName
+}
+
+// goRange returns the header of a loop over source that declares the local
+// at localIndex, which is omitted if the local is not used.
+func goRange(ctx *context.Context, localIndex int, source string) string {
+	if !ctx.Go.Used[localIndex] {
+		return fmt.Sprintf("for range %s {\n", source)
+	}
+	return fmt.Sprintf("for _, %s := range %s {\n", ctx.Go.Locals[localIndex], source)
+}
+
+// goLoopCheck returns the statement that stops a loop after an error in
+// checked code.
+func goLoopCheck(ctx *context.Context) string {
+	if !ctx.Go.Checked {
+		return ""
+	}
+	return fmt.Sprintf("if %s != nil {\nbreak\n}\n", ctx.Go.ErrVar)
+}
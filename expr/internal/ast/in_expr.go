@@ -2,16 +2,45 @@ package ast
 
 import (
 	"fmt"
-	"log"
+	"net/netip"
+	"strconv"
+	"strings"
 
 	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/internal/symbol"
 	"github.com/dcaiafa/go-expr/expr/runtime"
 	"github.com/dcaiafa/go-expr/expr/types"
 )
 
+// minSetSize is the minimum number of elements of a constant array for which
+// the membership test uses a hash set. The linear scan of smaller arrays is
+// faster. See BenchmarkInSet.
+const minSetSize = 16
+
+// setKey is the key of the shared constant of the elements of a set, so that
+// equal constant arrays share one constant and one set.
+type setKey struct {
+	elemType types.Type
+	elems    string
+}
+
+func newSetKey(elemType types.Type, elems []runtime.RawValue) setKey {
+	var sb strings.Builder
+	for _, elem := range elems {
+		if elemType == types.Number {
+			sb.WriteString(strconv.FormatFloat(elem.Number(), 'g', -1, 64))
+		} else {
+			sb.WriteString(strconv.Quote(elem.String()))
+		}
+		sb.WriteByte(',')
+	}
+	return setKey{elemType: elemType, elems: sb.String()}
+}
+
 type InExpr struct {
 	left  Expr
 	right Expr
+	value interface{}
 }
 
 func NewInExpr(left, right Expr) *InExpr {
@@ -23,7 +52,7 @@ func (e *InExpr) Type() types.Type {
 }
 
 func (e *InExpr) Value() interface{} {
-	return nil
+	return e.value
 }
 
 func (e *InExpr) Print(p *context.GraphPrinter) {
@@ -38,12 +67,30 @@ func (e *InExpr) RunPass(ctx *context.Context, pass context.Pass) error {
 			return err
 		}
 
+	case context.Fold:
+		err := e.fold(ctx)
+		if err != nil {
+			return err
+		}
+
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+
 	case context.Emit:
 		err := e.emit(ctx)
 		if err != nil {
 			return err
 		}
 
+	case context.Eval:
+		return setResult(ctx, e.eval)
+
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
 	default:
 		err := e.left.RunPass(ctx, pass)
 		if err != nil {
@@ -60,19 +107,39 @@ func (e *InExpr) RunPass(ctx *context.Context, pass context.Pass) error {
 }
 
 func (e *InExpr) checkTypes(ctx *context.Context) error {
-	err := e.left.RunPass(ctx, context.CheckTypes)
-	if err != nil {
-		return err
+	if needsTypeHint(e.left) && !needsTypeHint(e.right) {
+		err := e.right.RunPass(ctx, context.CheckTypes)
+		if err != nil {
+			return err
+		}
+		var hint types.Type
+		if arrayType, ok := e.right.Type().(*types.Array); ok {
+			hint = arrayType.ElementType
+		}
+		err = checkTypesWithHint(ctx, e.left, hint)
+		if err != nil {
+			return err
+		}
+	} else {
+		err := e.left.RunPass(ctx, context.CheckTypes)
+		if err != nil {
+			return err
+		}
+		err = checkTypesWithHint(
+			ctx, e.right, &types.Array{ElementType: e.left.Type()})
+		if err != nil {
+			return err
+		}
 	}
-	err = e.right.RunPass(ctx, context.CheckTypes)
-	if err != nil {
-		return err
+
+	if e.isPrefixMatch() {
+		return nil
 	}
 
-	if !e.left.Type().Equal(types.String) && !e.left.Type().Equal(types.Number) {
+	if !types.Comparable(e.left.Type()) {
 		return fmt.Errorf(
-			"only number and string supported by 'in' expression, "+
-				"but left side is %v", e.left.Type())
+			"left side of 'in' expression must be comparable, but it is %v",
+			e.left.Type())
 	}
 
 	arrayType, ok := e.right.Type().(*types.Array)
@@ -85,23 +152,270 @@ func (e *InExpr) checkTypes(ctx *context.Context) error {
 	return nil
 }
 
+// fold evaluates the membership test if both sides are constant.
+func (e *InExpr) fold(ctx *context.Context) error {
+	err := e.left.RunPass(ctx, context.Fold)
+	if err != nil {
+		return err
+	}
+	err = e.right.RunPass(ctx, context.Fold)
+	if err != nil {
+		return err
+	}
+
+	if e.isPrefixMatch() {
+		return nil
+	}
+	left, ok, err := constValue(ctx, e.left)
+	if !ok || err != nil {
+		return err
+	}
+	right, ok, err := constValue(ctx, e.right)
+	if !ok || err != nil {
+		return err
+	}
+	e.value = false
+	for _, elem := range right.Object().([]runtime.RawValue) {
+		if left.Equal(elem) {
+			e.value = true
+			break
+		}
+	}
+	return nil
+}
+
+func (e *InExpr) cse(ctx *context.Context) (string, bool, error) {
+	left, err := cseExpr(ctx, &e.left)
+	if err != nil {
+		return "", false, err
+	}
+	right, err := cseExpr(ctx, &e.right)
+	if err != nil {
+		return "", false, err
+	}
+	return cseKey("in", left, right), true, nil
+}
+
+// setElems returns the elements of the right side if it is a constant array
+// of numbers or strings, with at least minSetSize elements, whose membership
+// test uses a hash set built at compile time.
+func (e *InExpr) setElems(ctx *context.Context) ([]runtime.RawValue, bool, error) {
+	if e.left.Type() != types.Number && e.left.Type() != types.String {
+		return nil, false, nil
+	}
+	right, ok, err := constValue(ctx, e.right)
+	if !ok || err != nil {
+		return nil, false, err
+	}
+	elems := right.Object().([]runtime.RawValue)
+	return elems, len(elems) >= minSetSize, nil
+}
+
 func (e *InExpr) emit(ctx *context.Context) error {
+	if e.value != nil {
+		ctx.Builder.EmitPushBool(e.value.(bool))
+		return nil
+	}
+
 	err := e.left.RunPass(ctx, context.Emit)
 	if err != nil {
 		return err
 	}
+
+	elems, useSet, err := e.setElems(ctx)
+	if err != nil {
+		return err
+	}
+	if useSet {
+		e.emitInSet(ctx, elems)
+		return nil
+	}
+
 	err = e.right.RunPass(ctx, context.Emit)
 	if err != nil {
 		return err
 	}
 
-	if e.left.Type() == types.Number {
+	if e.isPrefixMatch() {
+		ctx.Builder.EmitOp(runtime.InPrefix)
+	} else if e.left.Type() == types.Number {
 		ctx.Builder.EmitOp(runtime.InArrayNumber)
 	} else if e.left.Type() == types.String {
 		ctx.Builder.EmitOp(runtime.InArrayString)
 	} else {
-		log.Fatal("unexpected left type")
+		ctx.Builder.EmitOp(runtime.InArray)
 	}
 
 	return nil
 }
+
+// emitInSet emits the membership test in the set of elems, the elements of
+// the constant right side. Registered constants are not copied, and equal
+// constant arrays share one constant.
+func (e *InExpr) emitInSet(ctx *context.Context, elems []runtime.RawValue) {
+	constIndex := -1
+	if ref, ok := e.right.(*SimpleRefExpr); ok {
+		if sym, ok := ref.sym.(*symbol.ConstSymbol); ok {
+			constIndex = sym.ConstIndex()
+		}
+	}
+	if constIndex == -1 {
+		constIndex = ctx.Builder.SharedConst(newSetKey(e.left.Type(), elems),
+			runtime.NewObject(e.right.Type(), elems))
+	}
+
+	if e.left.Type() == types.Number {
+		ctx.Builder.EmitInSet(runtime.InSetNumber, constIndex)
+	} else {
+		ctx.Builder.EmitInSet(runtime.InSetString, constIndex)
+	}
+}
+
+func (e *InExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	left, err := evalExpr(ctx, e.left)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+	right, err := evalExpr(ctx, e.right)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+
+	if e.isPrefixMatch() {
+		prefix := right.Object().(netip.Prefix)
+		return runtime.NewBool(prefix.Contains(left.Object().(netip.Addr))), nil
+	}
+	for _, elem := range right.Object().([]runtime.RawValue) {
+		if left.Equal(elem) {
+			return runtime.NewBool(true), nil
+		}
+	}
+	return runtime.NewBool(false), nil
+}
+
+// isPrefixMatch returns true if the expression tests whether an IP address is
+// in a CIDR prefix.
+func (e *InExpr) isPrefixMatch() bool {
+	return e.left.Type() == types.IP && e.right.Type() == types.CIDR
+}
+
+func (e *InExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	if e.value != nil {
+		return foldedClosure(e.value)
+	}
+	left, err := rawClosure(ctx, e.left)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+
+	elems, useSet, err := e.setElems(ctx)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	if useSet {
+		set := runtime.NewSet(e.left.Type(), elems)
+		if e.left.Type() == types.Number {
+			return runtime.Closure{Bool: func(env *runtime.Env) bool {
+				return set.ContainsNumber(left(env).Number())
+			}}, nil
+		}
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			return set.ContainsString(left(env).String())
+		}}, nil
+	}
+
+	right, err := rawClosure(ctx, e.right)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+
+	if e.isPrefixMatch() {
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			addr := left(env).Object().(netip.Addr)
+			prefix := right(env).Object().(netip.Prefix)
+			return prefix.Contains(addr)
+		}}, nil
+	}
+
+	switch e.left.Type() {
+	case types.Number:
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			num := left(env).Number()
+			for _, elem := range right(env).Object().([]runtime.RawValue) {
+				if num == elem.Number() {
+					return true
+				}
+			}
+			return false
+		}}, nil
+
+	case types.String:
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			str := left(env).String()
+			for _, elem := range right(env).Object().([]runtime.RawValue) {
+				if str == elem.String() {
+					return true
+				}
+			}
+			return false
+		}}, nil
+
+	default:
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			v := left(env)
+			for _, elem := range right(env).Object().([]runtime.RawValue) {
+				if v.Equal(elem) {
+					return true
+				}
+			}
+			return false
+		}}, nil
+	}
+}
+
+func (e *InExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	if e.value != nil {
+		return goFolded(ctx, e.value)
+	}
+	left, err := goExprOf(ctx, e.left)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	right, err := goExprOf(ctx, e.right)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+
+	// The left side is evaluated first, unless the order does not matter.
+	ordered := !left.Simple && !right.Simple
+	if e.isPrefixMatch() && !ordered {
+		return goPrimary(fmt.Sprintf("%s.Contains(%s)",
+			goParen(right, context.GoPrimary), left.Code)), nil
+	}
+
+	// The left side is bound to a variable unless it is simple, so that it is
+	// evaluated once, before the right side, and also if the right side is
+	// empty.
+	var sb strings.Builder
+	sb.WriteString("func() bool {\n")
+	value := left
+	if !left.Simple {
+		value = goPrimary(ctx.Go.Fresh("x"))
+		fmt.Fprintf(&sb, "%s := %s\n", value.Code, goTyped(left, e.left.Type()))
+	}
+
+	if e.isPrefixMatch() {
+		fmt.Fprintf(&sb, "return %s.Contains(%s)\n}()",
+			goParen(right, context.GoPrimary), value.Code)
+		return goPrimary(sb.String()), nil
+	}
+
+	elem := ctx.Go.Fresh("elem")
+	equal, err := goCompare(ctx, e.left.Type(), goPrimary(elem), value, true)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	fmt.Fprintf(&sb, "for _, %s := range %s {\nif %s {\nreturn true\n}\n}\nreturn false\n}()",
+		elem, right.Code, equal.Code)
+	return goPrimary(sb.String()), nil
+}
@@ -0,0 +1,460 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/internal/symbol"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// iterLoop emits a bytecode loop over the elements of an array. It is shared
+// by the higher-order builtins, array comprehensions and quantifiers.
+type iterLoop struct {
+	kind iterKind
+
+	// source is the array to iterate.
+	source Expr
+
+	// elem is the local that holds the current element, and name is the name
+	// of the loop variable.
+	elem int
+	name string
+
+	// filter is optional. Elements for which filter is false are skipped.
+	filter Expr
+
+	// body is the mapped value for iterMap, and the predicate otherwise.
+	body Expr
+
+	// typ is the result type of the loop.
+	typ types.Type
+}
+
+func (l *iterLoop) emit(ctx *context.Context) error {
+	err := l.source.RunPass(ctx, context.Emit)
+	if err != nil {
+		return err
+	}
+
+	iter := ctx.Builder.NewLocal()
+	ctx.Builder.EmitIterInit(iter)
+
+	// Accumulators are kept on the stack, below the current element.
+	switch l.kind {
+	case iterCount:
+		ctx.Builder.EmitPushNumber(0)
+	case iterFilter, iterMap:
+		ctx.Builder.EmitPushArray(0)
+	}
+
+	loop := ctx.Builder.NewLabel()
+	done := ctx.Builder.NewLabel()
+	end := ctx.Builder.NewLabel()
+
+	ctx.Builder.AssignLabel(loop)
+	ctx.Builder.EmitIterNext(iter, done)
+	ctx.Builder.EmitStoreLocal(l.elem)
+
+	if l.filter != nil {
+		err = l.filter.RunPass(ctx, context.Emit)
+		if err != nil {
+			return err
+		}
+		ctx.Builder.EmitJump(runtime.JumpIfFalse, loop)
+	}
+
+	err = l.body.RunPass(ctx, context.Emit)
+	if err != nil {
+		return err
+	}
+
+	switch l.kind {
+	case iterAll:
+		ctx.Builder.EmitJump(runtime.JumpIfTrue, loop)
+		ctx.Builder.EmitPushBool(false)
+		ctx.Builder.EmitJump(runtime.Jump, end)
+		ctx.Builder.AssignLabel(done)
+		ctx.Builder.EmitPushBool(true)
+
+	case iterAny:
+		ctx.Builder.EmitJump(runtime.JumpIfFalse, loop)
+		ctx.Builder.EmitPushBool(true)
+		ctx.Builder.EmitJump(runtime.Jump, end)
+		ctx.Builder.AssignLabel(done)
+		ctx.Builder.EmitPushBool(false)
+
+	case iterCount:
+		ctx.Builder.EmitJump(runtime.JumpIfFalse, loop)
+		ctx.Builder.EmitPushNumber(1)
+		ctx.Builder.EmitOp(runtime.Add)
+		ctx.Builder.EmitJump(runtime.Jump, loop)
+		ctx.Builder.AssignLabel(done)
+
+	case iterFilter:
+		ctx.Builder.EmitJump(runtime.JumpIfFalse, loop)
+		ctx.Builder.EmitLoadLocal(l.elem)
+		ctx.Builder.EmitOp(runtime.ArrayAppend)
+		ctx.Builder.EmitJump(runtime.Jump, loop)
+		ctx.Builder.AssignLabel(done)
+
+	case iterFind:
+		ctx.Builder.EmitJump(runtime.JumpIfFalse, loop)
+		ctx.Builder.EmitLoadLocal(l.elem)
+		ctx.Builder.EmitJump(runtime.Jump, end)
+		ctx.Builder.AssignLabel(done)
+		emitZeroValue(ctx.Builder, l.typ)
+
+	case iterMap:
+		ctx.Builder.EmitOp(runtime.ArrayAppend)
+		ctx.Builder.EmitJump(runtime.Jump, loop)
+		ctx.Builder.AssignLabel(done)
+	}
+
+	ctx.Builder.AssignLabel(end)
+	return nil
+}
+
+func (l *iterLoop) eval(ctx *context.Context) (runtime.Value, error) {
+	source, err := evalExpr(ctx, l.source)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+	elemType := l.source.Type().(*types.Array).ElementType
+
+	count := 0.0
+	array := []runtime.RawValue{}
+	for _, elem := range source.Object().([]runtime.RawValue) {
+		err = ctx.Eval.Iterate()
+		if err != nil {
+			return runtime.Value{}, err
+		}
+		ctx.Eval.Locals[l.elem] = runtime.NewValue(elemType, elem)
+
+		if l.filter != nil {
+			ok, err := evalExpr(ctx, l.filter)
+			if err != nil {
+				return runtime.Value{}, err
+			}
+			if !ok.Bool() {
+				continue
+			}
+		}
+
+		body, err := evalExpr(ctx, l.body)
+		if err != nil {
+			return runtime.Value{}, err
+		}
+
+		switch l.kind {
+		case iterAll:
+			if !body.Bool() {
+				return runtime.NewBool(false), nil
+			}
+		case iterAny:
+			if body.Bool() {
+				return runtime.NewBool(true), nil
+			}
+		case iterCount:
+			if body.Bool() {
+				count++
+			}
+		case iterFilter:
+			if body.Bool() {
+				array = append(array, elem)
+			}
+		case iterFind:
+			if body.Bool() {
+				return runtime.NewValue(l.typ, elem), nil
+			}
+		case iterMap:
+			array = append(array, body.RawValue)
+		}
+	}
+
+	switch l.kind {
+	case iterAll:
+		return runtime.NewBool(true), nil
+	case iterAny:
+		return runtime.NewBool(false), nil
+	case iterCount:
+		return runtime.NewNumber(count), nil
+	case iterFind:
+		return zeroValue(l.typ), nil
+	default:
+		return runtime.NewObject(l.typ, array), nil
+	}
+}
+
+func (l *iterLoop) closure(ctx *context.Context) (runtime.Closure, error) {
+	source, err := rawClosure(ctx, l.source)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	var filter func(env *runtime.Env) bool
+	if l.filter != nil {
+		filter, err = boolClosure(ctx, l.filter)
+		if err != nil {
+			return runtime.Closure{}, err
+		}
+	}
+
+	// accept stores elem in the loop variable and reports whether it passes
+	// the filter.
+	elemIndex := l.elem
+	accept := func(env *runtime.Env, elem runtime.RawValue) bool {
+		env.SetLocal(elemIndex, elem)
+		return filter == nil || filter(env)
+	}
+
+	if l.kind == iterMap {
+		body, err := rawClosure(ctx, l.body)
+		if err != nil {
+			return runtime.Closure{}, err
+		}
+		return runtime.Closure{Value: func(env *runtime.Env) runtime.RawValue {
+			array := []runtime.RawValue{}
+			for _, elem := range source(env).Object().([]runtime.RawValue) {
+				if !env.Iterate() {
+					break
+				}
+				if accept(env, elem) {
+					array = append(array, body(env))
+				}
+			}
+			return runtime.NewRawObject(array)
+		}}, nil
+	}
+
+	pred, err := boolClosure(ctx, l.body)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+
+	switch l.kind {
+	case iterAll:
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			for _, elem := range source(env).Object().([]runtime.RawValue) {
+				if !env.Iterate() {
+					break
+				}
+				if accept(env, elem) && !pred(env) {
+					return false
+				}
+			}
+			return true
+		}}, nil
+
+	case iterAny:
+		return runtime.Closure{Bool: func(env *runtime.Env) bool {
+			for _, elem := range source(env).Object().([]runtime.RawValue) {
+				if !env.Iterate() {
+					break
+				}
+				if accept(env, elem) && pred(env) {
+					return true
+				}
+			}
+			return false
+		}}, nil
+
+	case iterCount:
+		return runtime.Closure{Number: func(env *runtime.Env) float64 {
+			count := 0.0
+			for _, elem := range source(env).Object().([]runtime.RawValue) {
+				if !env.Iterate() {
+					break
+				}
+				if accept(env, elem) && pred(env) {
+					count++
+				}
+			}
+			return count
+		}}, nil
+
+	case iterFilter:
+		return runtime.Closure{Value: func(env *runtime.Env) runtime.RawValue {
+			array := []runtime.RawValue{}
+			for _, elem := range source(env).Object().([]runtime.RawValue) {
+				if !env.Iterate() {
+					break
+				}
+				if accept(env, elem) && pred(env) {
+					array = append(array, elem)
+				}
+			}
+			return runtime.NewRawObject(array)
+		}}, nil
+
+	case iterFind:
+		zero := zeroValue(l.typ).RawValue
+		return closureFromRaw(l.typ, func(env *runtime.Env) runtime.RawValue {
+			for _, elem := range source(env).Object().([]runtime.RawValue) {
+				if !env.Iterate() {
+					break
+				}
+				if accept(env, elem) && pred(env) {
+					return elem
+				}
+			}
+			return zero
+		}), nil
+
+	default:
+		return runtime.Closure{}, fmt.Errorf("%w: invalid loop kind %v",
+			runtime.ErrInternal, l.kind)
+	}
+}
+
+func (l *iterLoop) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	g := ctx.Go
+	source, err := goExprOf(ctx, l.source)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	elem := goLocal(ctx, l.elem, l.name)
+	var filter context.GoExpr
+	if l.filter != nil {
+		filter, err = goExprOf(ctx, l.filter)
+		if err != nil {
+			return context.GoExpr{}, err
+		}
+	}
+	body, err := goExprOf(ctx, l.body)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	typ, err := goType(ctx, l.typ)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	if l.kind == iterFilter || l.kind == iterFind {
+		g.Used[l.elem] = true
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "func() %s {\n", typ)
+	var acc string
+	switch l.kind {
+	case iterCount:
+		acc = g.Fresh("count")
+		fmt.Fprintf(&sb, "%s := 0.0\n", acc)
+	case iterFilter, iterMap:
+		acc = g.Fresh("array")
+		fmt.Fprintf(&sb, "%s := %s{}\n", acc, typ)
+	}
+
+	sb.WriteString(goRange(ctx, l.elem, source.Code))
+	sb.WriteString(goLoopCheck(ctx))
+	if l.filter != nil {
+		fmt.Fprintf(&sb, "if %s {\ncontinue\n}\n", goNot(filter))
+	}
+	switch l.kind {
+	case iterAll:
+		fmt.Fprintf(&sb, "if %s {\nreturn false\n}\n", goNot(body))
+	case iterAny:
+		fmt.Fprintf(&sb, "if %s {\nreturn true\n}\n", body.Code)
+	case iterCount:
+		fmt.Fprintf(&sb, "if %s {\n%s++\n}\n", body.Code, acc)
+	case iterFilter:
+		fmt.Fprintf(&sb, "if %s {\n%s = append(%s, %s)\n}\n", body.Code, acc, acc, elem)
+	case iterFind:
+		fmt.Fprintf(&sb, "if %s {\nreturn %s\n}\n", body.Code, elem)
+	case iterMap:
+		fmt.Fprintf(&sb, "%s = append(%s, %s)\n", acc, acc, body.Code)
+	}
+	sb.WriteString("}\n")
+
+	switch l.kind {
+	case iterAll:
+		sb.WriteString("return true\n")
+	case iterAny:
+		sb.WriteString("return false\n")
+	case iterCount, iterFilter, iterMap:
+		fmt.Fprintf(&sb, "return %s\n", acc)
+	case iterFind:
+		// Like zeroValue, the zero value of arrays is an empty array.
+		zero := goZero(ctx, l.typ)
+		if _, ok := l.typ.(*types.Array); ok {
+			zero = typ + "{}"
+		}
+		fmt.Fprintf(&sb, "return %s\n", zero)
+	}
+	sb.WriteString("}()")
+	return goPrimary(sb.String()), nil
+}
+
+// cseLoop runs the CSE pass on the parts of a loop, which are the expressions
+// of an iterLoop. filter is optional. The source is evaluated once, and the
+// filter and the body are visited in a new region, because they are evaluated
+// for each element. The body is evaluated only if the filter passes.
+func cseLoop(ctx *context.Context, source, filter, body *Expr) error {
+	_, err := cseExpr(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	end := ctx.CSE.Branch()
+	defer end()
+	if filter == nil {
+		_, err = cseExpr(ctx, body)
+		return err
+	}
+	_, err = cseExpr(ctx, filter)
+	if err != nil {
+		return err
+	}
+	_, err = cseBranch(ctx, body)
+	return err
+}
+
+// resolveLoopVar declares the loop variable name in a new scope and resolves
+// the names in exprs within that scope.
+func resolveLoopVar(
+	ctx *context.Context,
+	name string,
+	exprs ...Expr,
+) (*symbol.LocalSymbol, error) {
+	scope := ctx.PushScope()
+	defer ctx.PopScope()
+
+	sym := symbol.NewLocalSymbol(name, ctx.Builder.NewLocal())
+	err := scope.Add(sym)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+		err = expr.RunPass(ctx, context.ResolveNames)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sym, nil
+}
+
+// checkLoopSource checks that source is an array and sets the type of the
+// loop variable to the array element type.
+func checkLoopSource(
+	ctx *context.Context,
+	source Expr,
+	sym *symbol.LocalSymbol,
+) (*types.Array, error) {
+	err := source.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return nil, err
+	}
+	arrType, ok := source.Type().(*types.Array)
+	if !ok {
+		return nil, fmt.Errorf("cannot iterate over %v, it is not an array",
+			source.Type())
+	}
+	sym.SetType(arrType.ElementType)
+	return arrType, nil
+}
@@ -0,0 +1,126 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/internal/symbol"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// LambdaExpr is an anonymous function passed to a higher-order builtin such as
+// map or filter. Lambdas are not values: the builtin that receives the lambda
+// binds its parameter types and emits its body inline.
+type LambdaExpr struct {
+	exprImpl
+	params []string
+	syms   []*symbol.LocalSymbol
+	body   Expr
+	bound  bool
+}
+
+func NewLambdaExpr(params []string, body Expr) *LambdaExpr {
+	return &LambdaExpr{
+		params: params,
+		body:   body,
+	}
+}
+
+func (e *LambdaExpr) Print(p *context.GraphPrinter) {
+	p.PrintNode("lambda "+strings.Join(e.params, ","), e.body)
+}
+
+func (e *LambdaExpr) RunPass(ctx *context.Context, pass context.Pass) error {
+	switch pass {
+	case context.ResolveNames:
+		err := e.resolveNames(ctx)
+		if err != nil {
+			return err
+		}
+
+	case context.CheckTypes:
+		err := e.checkTypes(ctx)
+		if err != nil {
+			return err
+		}
+
+	case context.Emit, context.Eval, context.Closure, context.GoGen:
+		return fmt.Errorf("lambda cannot be used as a value")
+
+	default:
+		err := e.body.RunPass(ctx, pass)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *LambdaExpr) resolveNames(ctx *context.Context) error {
+	scope := ctx.PushScope()
+	defer ctx.PopScope()
+
+	e.syms = make([]*symbol.LocalSymbol, len(e.params))
+	for i, param := range e.params {
+		e.syms[i] = symbol.NewLocalSymbol(param, ctx.Builder.NewLocal())
+		err := scope.Add(e.syms[i])
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.body.RunPass(ctx, context.ResolveNames)
+}
+
+// bind sets the types of the lambda parameters. It must be called by the
+// receiving builtin before the CheckTypes pass runs on the lambda.
+func (e *LambdaExpr) bind(paramTypes ...types.Type) error {
+	if len(paramTypes) != len(e.params) {
+		return fmt.Errorf("lambda expected %d parameters but has %d",
+			len(paramTypes), len(e.params))
+	}
+	for i, sym := range e.syms {
+		sym.SetType(paramTypes[i])
+	}
+	e.bound = true
+	return nil
+}
+
+func (e *LambdaExpr) checkTypes(ctx *context.Context) error {
+	if !e.bound {
+		return fmt.Errorf("lambda can only be used as an argument to a higher-order function")
+	}
+
+	err := e.body.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return err
+	}
+
+	fnType := &types.Function{
+		Params: make([]types.Type, len(e.syms)),
+		Ret:    e.body.Type(),
+	}
+	for i, sym := range e.syms {
+		fnType.Params[i] = sym.Type()
+	}
+	e.typ = fnType
+
+	return nil
+}
+
+// emitBody emits the lambda body, which expects the lambda parameters to be
+// already stored in their locals.
+func (e *LambdaExpr) emitBody(ctx *context.Context) error {
+	return e.body.RunPass(ctx, context.Emit)
+}
+
+// emitStoreParams emits instructions that pop the lambda arguments from the
+// stack into the lambda parameter locals. The last parameter is expected at the
+// top of the stack.
+func (e *LambdaExpr) emitStoreParams(ctx *context.Context) {
+	for i := len(e.syms) - 1; i >= 0; i-- {
+		ctx.Builder.EmitStoreLocal(e.syms[i].LocalIndex())
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"fmt"
 
 	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/runtime"
 	"github.com/dcaiafa/go-expr/expr/types"
 )
 
 type LiteralExpr struct {
 	exprImpl
+	pos Pos
 }
 
 func NewLiteralExpr(typ types.Type, value interface{}) *LiteralExpr {
@@ -20,6 +22,16 @@ func NewLiteralExpr(typ types.Type, value interface{}) *LiteralExpr {
 	}
 }
 
+// At sets the position of the literal in the source.
+func (e *LiteralExpr) At(pos Pos) *LiteralExpr {
+	e.pos = pos
+	return e
+}
+
+func (e *LiteralExpr) Pos() Pos {
+	return e.pos
+}
+
 func (e *LiteralExpr) Print(p *context.GraphPrinter) {
 	p.PrintNode(fmt.Sprintf("%v", e.value))
 }
@@ -31,11 +43,36 @@ func (e *LiteralExpr) RunPass(ctx *context.Context, pass context.Pass) error {
 		if err != nil {
 			return err
 		}
+
+	case context.Eval:
+		return setResult(ctx, e.eval)
+
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
 	}
 	return nil
 }
 
 func (e *LiteralExpr) emit(ctx *context.Context) error {
-	ctx.Builder.EmitPushBasicValue(e.value)
-	return nil
+	return ctx.Builder.EmitPushBasicValue(e.value)
+}
+
+func (e *LiteralExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	v, ok := valueFromFolded(e.value)
+	if !ok {
+		return runtime.Value{}, fmt.Errorf("%w: invalid literal value %T",
+			runtime.ErrInternal, e.value)
+	}
+	return v, nil
+}
+
+func (e *LiteralExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	return foldedClosure(e.value)
+}
+
+func (e *LiteralExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	return goFolded(ctx, e.value)
 }
@@ -11,6 +11,10 @@ import (
 type NegateExpr struct {
 	expr  Expr
 	value interface{}
+
+	// simplified is the expression that replaces this one if it negates
+	// another negation.
+	simplified Expr
 }
 
 func NewNegateExpr(expr Expr) *NegateExpr {
@@ -43,12 +47,24 @@ func (e *NegateExpr) RunPass(ctx *context.Context, pass context.Pass) error {
 			return err
 		}
 
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+
 	case context.Emit:
 		err := e.emit(ctx)
 		if err != nil {
 			return err
 		}
 
+	case context.Eval:
+		return setResult(ctx, e.eval)
+
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
 	default:
 		err := e.expr.RunPass(ctx, pass)
 		if err != nil {
@@ -79,6 +95,9 @@ func (e *NegateExpr) fold(ctx *context.Context) error {
 	}
 
 	if e.expr.Value() == nil {
+		if negate, ok := e.expr.(*NegateExpr); ok {
+			e.simplified = negate.expr
+		}
 		return nil
 	}
 
@@ -87,11 +106,26 @@ func (e *NegateExpr) fold(ctx *context.Context) error {
 	return nil
 }
 
+func (e *NegateExpr) cse(ctx *context.Context) (string, bool, error) {
+	if e.simplified != nil {
+		key, err := cseExpr(ctx, &e.simplified)
+		return key, false, err
+	}
+	key, err := cseExpr(ctx, &e.expr)
+	if err != nil {
+		return "", false, err
+	}
+	return cseKey("!", key), true, nil
+}
+
 func (e *NegateExpr) emit(ctx *context.Context) error {
 	if e.value != nil {
 		ctx.Builder.EmitPushBool(e.value.(bool))
 		return nil
 	}
+	if e.simplified != nil {
+		return e.simplified.RunPass(ctx, context.Emit)
+	}
 
 	err := e.expr.RunPass(ctx, context.Emit)
 	if err != nil {
@@ -102,3 +136,44 @@ func (e *NegateExpr) emit(ctx *context.Context) error {
 
 	return nil
 }
+
+func (e *NegateExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	v, err := evalExpr(ctx, e.expr)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+	return runtime.NewBool(!v.Bool()), nil
+}
+
+func (e *NegateExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	if e.value != nil {
+		return foldedClosure(e.value)
+	}
+	if e.simplified != nil {
+		return closureExpr(ctx, e.simplified)
+	}
+	expr, err := boolClosure(ctx, e.expr)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	return runtime.Closure{Bool: func(env *runtime.Env) bool {
+		return !expr(env)
+	}}, nil
+}
+
+func (e *NegateExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	if e.value != nil {
+		return goFolded(ctx, e.value)
+	}
+	if e.simplified != nil {
+		return goExprOf(ctx, e.simplified)
+	}
+	expr, err := goExprOf(ctx, e.expr)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	return context.GoExpr{
+		Code: goNot(expr),
+		Prec: context.GoUnary,
+	}, nil
+}
@@ -12,6 +12,10 @@ type OrExpr struct {
 	left  Expr
 	right Expr
 	value interface{}
+
+	// simplified is the expression that replaces this one, if the other
+	// side is false.
+	simplified Expr
 }
 
 func NewOrExpr(left Expr, right Expr) *OrExpr {
@@ -44,6 +48,24 @@ func (e *OrExpr) RunPass(ctx *context.Context, pass context.Pass) error {
 			return err
 		}
 
+	case context.Eval:
+		return setResult(ctx, e.eval)
+
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
+	case context.Fold:
+		err := e.fold(ctx)
+		if err != nil {
+			return err
+		}
+
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+
 	default:
 		err := e.left.RunPass(ctx, pass)
 		if err != nil {
@@ -86,19 +108,48 @@ func (e *OrExpr) fold(ctx *context.Context) error {
 		return err
 	}
 
-	if e.left.Value() == nil || e.right.Value() == nil {
-		return nil
+	// true || e is true without evaluating e, and false || e and
+	// e || false are e. e || true is not folded, because e can fail.
+	left, right := e.left.Value(), e.right.Value()
+	switch {
+	case left == true:
+		e.value = true
+	case left == false && right != nil:
+		e.value = right
+	case left == false:
+		e.simplified = e.right
+	case right == false:
+		e.simplified = e.left
 	}
-
-	e.value = e.left.Value().(bool) || e.right.Value().(bool)
 	return nil
 }
 
+// cse visits the right side in a new region, because it is evaluated only
+// if the left side does not decide the result.
+func (e *OrExpr) cse(ctx *context.Context) (string, bool, error) {
+	if e.simplified != nil {
+		key, err := cseExpr(ctx, &e.simplified)
+		return key, false, err
+	}
+	left, err := cseExpr(ctx, &e.left)
+	if err != nil {
+		return "", false, err
+	}
+	right, err := cseBranch(ctx, &e.right)
+	if err != nil {
+		return "", false, err
+	}
+	return cseKey("||", left, right), true, nil
+}
+
 func (e *OrExpr) emit(ctx *context.Context) error {
 	if e.value != nil {
 		ctx.Builder.EmitPushBool(e.value.(bool))
 		return nil
 	}
+	if e.simplified != nil {
+		return e.simplified.RunPass(ctx, context.Emit)
+	}
 
 	err := e.left.RunPass(ctx, context.Emit)
 	if err != nil {
@@ -119,3 +170,49 @@ func (e *OrExpr) emit(ctx *context.Context) error {
 
 	return nil
 }
+
+func (e *OrExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	left, err := evalExpr(ctx, e.left)
+	if err != nil || left.Bool() {
+		return left, err
+	}
+	return evalExpr(ctx, e.right)
+}
+
+func (e *OrExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	if e.value != nil {
+		return foldedClosure(e.value)
+	}
+	if e.simplified != nil {
+		return closureExpr(ctx, e.simplified)
+	}
+	left, err := boolClosure(ctx, e.left)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	right, err := boolClosure(ctx, e.right)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	return runtime.Closure{Bool: func(env *runtime.Env) bool {
+		return left(env) || right(env)
+	}}, nil
+}
+
+func (e *OrExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	if e.value != nil {
+		return goFolded(ctx, e.value)
+	}
+	if e.simplified != nil {
+		return goExprOf(ctx, e.simplified)
+	}
+	left, err := goExprOf(ctx, e.left)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	right, err := goExprOf(ctx, e.right)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	return goBinary(left, "||", 1, right), nil
+}
@@ -0,0 +1,274 @@
+package ast
+
+import (
+	gocontext "context"
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// parseFunc converts a string to a value of another type.
+type parseFunc struct {
+	name  string
+	typ   types.Type
+	parse func(s string) (interface{}, error)
+
+	// goFunc is the source of a Go function like parse, given its name.
+	goFunc string
+}
+
+var (
+	parseIP = &parseFunc{
+		name: "ip",
+		typ:  types.IP,
+		parse: func(s string) (interface{}, error) {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid IP address %q", s)
+			}
+			return addr.Unmap(), nil
+		},
+		goFunc: `func %s(s string) (netip.Addr, error) {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return netip.Addr{}, fmt.Errorf("invalid IP address %%q", s)
+			}
+			return addr.Unmap(), nil
+		}`,
+	}
+	parseCIDR = &parseFunc{
+		name: "cidr",
+		typ:  types.CIDR,
+		parse: func(s string) (interface{}, error) {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", s)
+			}
+			return prefix.Masked(), nil
+		},
+		goFunc: `func %s(s string) (netip.Prefix, error) {
+			prefix, err := netip.ParsePrefix(s)
+			if err != nil {
+				return netip.Prefix{}, fmt.Errorf("invalid CIDR %%q", s)
+			}
+			return prefix.Masked(), nil
+		}`,
+	}
+)
+
+// runtimeFunc returns the implementation of f for strings that are not
+// constant.
+func (f *parseFunc) runtimeFunc() *runtime.Func {
+	return &runtime.Func{
+		Name: builtinFuncPrefix + f.name,
+		Type: &types.Function{
+			Params: []types.Type{types.String},
+			Ret:    f.typ,
+		},
+		FuncErr: func(ctx gocontext.Context, args []runtime.Value) (runtime.Value, error) {
+			v, err := f.parse(args[0].String())
+			if err != nil {
+				return runtime.Value{}, err
+			}
+			return runtime.NewObject(f.typ, v), nil
+		},
+	}
+}
+
+// ParseExpr converts a string to a value of another type, such as an IP
+// address. If the string is constant, it is parsed by the Fold pass, and
+// invalid strings are reported as compile errors.
+type ParseExpr struct {
+	exprImpl
+	fn  *parseFunc
+	arg Expr
+
+	// constIndex is the index of the parsed value if the string is constant.
+	constIndex int
+	parsed     bool
+}
+
+func newParseExpr(fn *parseFunc, arg Expr) *ParseExpr {
+	return &ParseExpr{fn: fn, arg: arg}
+}
+
+func (e *ParseExpr) Print(p *context.GraphPrinter) {
+	p.PrintNode(e.fn.name, e.arg)
+}
+
+func (e *ParseExpr) RunPass(ctx *context.Context, pass context.Pass) error {
+	switch pass {
+	case context.CheckTypes:
+		return e.checkTypes(ctx)
+	case context.Fold:
+		return e.fold(ctx)
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+	case context.Emit:
+		return e.emit(ctx)
+	case context.Eval:
+		return setResult(ctx, e.eval)
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
+	default:
+		return e.arg.RunPass(ctx, pass)
+	}
+}
+
+func (e *ParseExpr) checkTypes(ctx *context.Context) error {
+	err := e.arg.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return err
+	}
+	if e.arg.Type() != types.String {
+		return fmt.Errorf("%v expects a string but %v was provided",
+			e.fn.name, e.arg.Type())
+	}
+	e.typ = e.fn.typ
+	return nil
+}
+
+func (e *ParseExpr) fold(ctx *context.Context) error {
+	err := e.arg.RunPass(ctx, context.Fold)
+	if err != nil {
+		return err
+	}
+
+	s, ok := e.arg.Value().(string)
+	if !ok {
+		return nil
+	}
+	v, err := e.fn.parse(s)
+	if err != nil {
+		return posOf(e.arg).Errorf("%v", err)
+	}
+	e.constIndex = ctx.Builder.NewConst(runtime.NewObject(e.fn.typ, v))
+	e.parsed = true
+	return nil
+}
+
+// cse returns the key of the parsed value. Constant strings are parsed at
+// compile time, so they are not shared.
+func (e *ParseExpr) cse(ctx *context.Context) (string, bool, error) {
+	key, err := cseExpr(ctx, &e.arg)
+	if err != nil {
+		return "", false, err
+	}
+	return cseKey(e.fn.name, key), !e.parsed, nil
+}
+
+func (e *ParseExpr) emit(ctx *context.Context) error {
+	if e.parsed {
+		ctx.Builder.EmitLoadConst(e.constIndex)
+		return nil
+	}
+
+	fn := e.fn.runtimeFunc()
+	ctx.Builder.EmitLoadConst(ctx.Builder.SharedConst(e.fn, runtime.NewObject(fn.Type, fn)))
+	err := e.arg.RunPass(ctx, context.Emit)
+	if err != nil {
+		return err
+	}
+	ctx.Builder.EmitCall(1)
+	return nil
+}
+
+func (e *ParseExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	if e.parsed {
+		return ctx.Builder.Const(e.constIndex), nil
+	}
+	arg, err := evalExpr(ctx, e.arg)
+	if err != nil {
+		return runtime.Value{}, err
+	}
+	return callFunc(ctx, e.fn.runtimeFunc(), []runtime.Value{arg})
+}
+
+func (e *ParseExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	if e.parsed {
+		return constClosure(e.typ, ctx.Builder.Const(e.constIndex).RawValue), nil
+	}
+	args, err := argClosures(ctx, []Expr{e.arg})
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	return callClosure(e.typ, e.fn.runtimeFunc(), nil, args), nil
+}
+
+func (e *ParseExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	if e.parsed {
+		code, err := goValue(ctx, ctx.Builder.Const(e.constIndex))
+		if err != nil {
+			return context.GoExpr{}, err
+		}
+		name := ctx.Go.Declare("parsed "+code.Code, e.fn.name, func(name string) string {
+			return fmt.Sprintf("var %s = %s", name, code.Code)
+		})
+		return context.GoExpr{Code: name, Prec: context.GoPrimary, Simple: true}, nil
+	}
+
+	arg, err := goExprOf(ctx, e.arg)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	ctx.Go.Import("fmt")
+	ctx.Go.Import("net/netip")
+	fn := ctx.Go.Declare("parseFunc "+e.fn.name, "parse"+strings.ToUpper(e.fn.name),
+		func(name string) string {
+			return fmt.Sprintf(e.fn.goFunc, name)
+		})
+	return goCall(ctx, e.typ, []context.GoExpr{arg}, []types.Type{types.String}, true,
+		func(args []string) string {
+			return fn + "(" + args[0] + ")"
+		})
+}
+
+// parseBuiltin implements the builtins that convert a string to another type
+// by lowering the call to a ParseExpr.
+type parseBuiltin struct {
+	fn *parseFunc
+}
+
+func (b *parseBuiltin) checkTypes(ctx *context.Context, call *CallExpr) error {
+	args := call.params.params
+	if len(args) != 1 {
+		return fmt.Errorf("%v expects 1 argument but %d were provided",
+			b.fn.name, len(args))
+	}
+	expr := newParseExpr(b.fn, args[0])
+	err := expr.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return err
+	}
+	call.lowered = expr
+	call.typ = expr.Type()
+	return nil
+}
+
+func (b *parseBuiltin) cse(ctx *context.Context, call *CallExpr) (string, bool, error) {
+	key, err := cseExpr(ctx, &call.lowered)
+	return key, false, err
+}
+
+func (b *parseBuiltin) emit(ctx *context.Context, call *CallExpr) error {
+	return call.lowered.RunPass(ctx, context.Emit)
+}
+
+func (b *parseBuiltin) eval(ctx *context.Context, call *CallExpr) (runtime.Value, error) {
+	return evalExpr(ctx, call.lowered)
+}
+
+func (b *parseBuiltin) closure(ctx *context.Context, call *CallExpr) (runtime.Closure, error) {
+	return closureExpr(ctx, call.lowered)
+}
+
+func (b *parseBuiltin) goExpr(ctx *context.Context, call *CallExpr) (context.GoExpr, error) {
+	return goExprOf(ctx, call.lowered)
+}
@@ -0,0 +1,42 @@
+package ast
+
+import "fmt"
+
+// Pos is a position in the source of an expression. Line and Col start at 1;
+// Col counts bytes. The zero Pos is an unknown position.
+type Pos struct {
+	Line int
+	Col  int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// IsValid returns true if the position is known.
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+// Errorf creates an error prefixed by the position, if it is known.
+func (p Pos) Errorf(format string, args ...interface{}) error {
+	err := fmt.Errorf(format, args...)
+	if !p.IsValid() {
+		return err
+	}
+	return fmt.Errorf("%v: %w", p, err)
+}
+
+// positioned is implemented by expressions that know their position in the
+// source.
+type positioned interface {
+	Pos() Pos
+}
+
+// posOf returns the position of expr, or the zero Pos if it is unknown.
+func posOf(expr Expr) Pos {
+	if p, ok := expr.(positioned); ok {
+		return p.Pos()
+	}
+	return Pos{}
+}
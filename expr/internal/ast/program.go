@@ -2,6 +2,10 @@ package ast
 
 import (
 	"fmt"
+	"go/format"
+	"sort"
+	"strings"
+	"unicode"
 
 	"github.com/dcaiafa/go-expr/expr/internal/context"
 	"github.com/dcaiafa/go-expr/expr/runtime"
@@ -30,6 +34,16 @@ func (p *Program) Print(gp *context.GraphPrinter) {
 }
 
 func (p *Program) RunPass(ctx *context.Context, pass context.Pass) error {
+	if pass == context.Eval {
+		return p.eval(ctx)
+	}
+	if pass == context.GoGen {
+		return p.goGen(ctx)
+	}
+	if pass == context.CSE {
+		return p.cse(ctx)
+	}
+
 	for _, expr := range p.exprs {
 		err := expr.RunPass(ctx, pass)
 		if err != nil {
@@ -45,14 +59,138 @@ func (p *Program) RunPass(ctx *context.Context, pass context.Pass) error {
 			}
 		}
 
+		if pass == context.Closure {
+			ctx.Builder.AddClosure(ctx.Closure)
+		}
+
 		if pass == context.Emit {
 			ctx.Builder.EmitOp(runtime.Return)
-			ctx.Builder.FinishExpr()
+			err := ctx.Builder.FinishExpr()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// cse shares the common sub-expressions of each expression.
+func (p *Program) cse(ctx *context.Context) error {
+	for i := range p.exprs {
+		ctx.CSE = context.NewCSEState()
+		_, err := cseExpr(ctx, &p.exprs[i])
+		if err != nil {
+			return err
 		}
+		ctx.CSE.Finish(ctx.Builder)
+	}
+	return nil
+}
+
+// eval evaluates the expression at ctx.Eval.ExprIndex. Like the runtime, the
+// result has the type of the program.
+func (p *Program) eval(ctx *context.Context) error {
+	v, err := evalExpr(ctx, p.exprs[ctx.Eval.ExprIndex])
+	if err != nil {
+		return err
 	}
+	ctx.Eval.Result = runtime.NewValue(p.typ, v.RawValue)
 	return nil
 }
 
+// goGen generates a Go source file with a function that evaluates the first
+// expression. If the expression can fail, its code is generated again with
+// error checks, and the function also returns an error.
+func (p *Program) goGen(ctx *context.Context) error {
+	if len(p.exprs) != 1 {
+		return fmt.Errorf("the Go generator only supports a single expression")
+	}
+	g := ctx.Go
+	g.Reset(false)
+	code, err := goExprOf(ctx, p.exprs[0])
+	if err != nil {
+		return err
+	}
+	if g.Fails {
+		g.Reset(true)
+		code, err = goExprOf(ctx, p.exprs[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	ret, err := goType(ctx, p.typ)
+	if err != nil {
+		return err
+	}
+	params := make([]string, len(g.Params))
+	for i, param := range g.Params {
+		typ, err := goType(ctx, param.Type)
+		if err != nil {
+			return fmt.Errorf("input %v: %w", param.Name, err)
+		}
+		params[i] = g.ParamNames[i] + " " + typ
+	}
+
+	var body string
+	if g.Checked {
+		res := g.Fresh("res")
+		body = fmt.Sprintf(
+			"var %[1]s error\n%[2]s := %[3]s\nif %[1]s != nil {\nreturn %[4]s, %[1]s\n}\nreturn %[2]s, nil\n",
+			g.ErrVar, res, goTyped(code, p.typ), goZero(ctx, p.typ))
+		ret = "(" + ret + ", error)"
+	} else {
+		body = "return " + code.Code + "\n"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("// Code generated by go-expr. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&sb, "package %s\n\n", g.Package)
+
+	// The standard library imports are grouped before the others.
+	imports := g.Imports()
+	sort.Slice(imports, func(i, j int) bool {
+		iStd, jStd := isStdImport(imports[i]), isStdImport(imports[j])
+		if iStd != jStd {
+			return iStd
+		}
+		return imports[i] < imports[j]
+	})
+	if len(imports) != 0 {
+		sb.WriteString("import (\n")
+		for i, path := range imports {
+			if i != 0 && isStdImport(path) != isStdImport(imports[i-1]) {
+				sb.WriteString("\n")
+			}
+			fmt.Fprintf(&sb, "%q\n", path)
+		}
+		sb.WriteString(")\n\n")
+	}
+
+	fmt.Fprintf(&sb, "// %s evaluates the expression:\n//\n", g.Func)
+	for _, line := range strings.Split(strings.TrimSpace(g.Source), "\n") {
+		fmt.Fprintf(&sb, "//\t%s\n", strings.TrimRightFunc(line, unicode.IsSpace))
+	}
+	fmt.Fprintf(&sb, "func %s(%s) %s {\n%s}\n",
+		g.Func, strings.Join(params, ", "), ret, body)
+	for _, decl := range g.Decls() {
+		fmt.Fprintf(&sb, "\n%s\n", decl)
+	}
+
+	g.Output, err = format.Source([]byte(sb.String()))
+	if err != nil {
+		return fmt.Errorf("%w: invalid Go code: %v", runtime.ErrInternal, err)
+	}
+	return nil
+}
+
+// isStdImport returns true if path is the import path of a package of the
+// standard library.
+func isStdImport(path string) bool {
+	first, _, _ := strings.Cut(path, "/")
+	return !strings.Contains(first, ".")
+}
+
 func exprsAsPrinters(exprs []Expr) []context.Printer {
 	printers := make([]context.Printer, len(exprs))
 	for i, expr := range exprs {
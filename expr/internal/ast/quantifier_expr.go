@@ -0,0 +1,159 @@
+package ast
+
+import (
+	"fmt"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/internal/symbol"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// QuantifierExpr is a quantified predicate over the elements of an array:
+//
+//	any name in source: body
+//	all name in source: body
+type QuantifierExpr struct {
+	quantifier string
+	name       string
+	source     Expr
+	body       Expr
+	kind       iterKind
+	sym        *symbol.LocalSymbol
+}
+
+func NewQuantifierExpr(quantifier string, name string, source Expr, body Expr) *QuantifierExpr {
+	return &QuantifierExpr{
+		quantifier: quantifier,
+		name:       name,
+		source:     source,
+		body:       body,
+	}
+}
+
+func (e *QuantifierExpr) Type() types.Type {
+	return types.Bool
+}
+
+func (e *QuantifierExpr) Value() interface{} {
+	return nil
+}
+
+func (e *QuantifierExpr) Print(p *context.GraphPrinter) {
+	p.PrintNode(e.quantifier+" "+e.name, e.source, e.body)
+}
+
+func (e *QuantifierExpr) RunPass(ctx *context.Context, pass context.Pass) error {
+	switch pass {
+	case context.ResolveNames:
+		err := e.resolveNames(ctx)
+		if err != nil {
+			return err
+		}
+
+	case context.CheckTypes:
+		err := e.checkTypes(ctx)
+		if err != nil {
+			return err
+		}
+
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+
+	case context.Emit:
+		err := e.emit(ctx)
+		if err != nil {
+			return err
+		}
+
+	case context.Eval:
+		return setResult(ctx, e.eval)
+
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
+	default:
+		err := e.source.RunPass(ctx, pass)
+		if err != nil {
+			return err
+		}
+		err = e.body.RunPass(ctx, pass)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (e *QuantifierExpr) resolveNames(ctx *context.Context) error {
+	switch e.quantifier {
+	case "any":
+		e.kind = iterAny
+	case "all":
+		e.kind = iterAll
+	default:
+		return fmt.Errorf("unknown quantifier %q, expected any or all", e.quantifier)
+	}
+
+	// The loop variable is not visible in the source expression.
+	err := e.source.RunPass(ctx, context.ResolveNames)
+	if err != nil {
+		return err
+	}
+	e.sym, err = resolveLoopVar(ctx, e.name, e.body)
+	return err
+}
+
+func (e *QuantifierExpr) checkTypes(ctx *context.Context) error {
+	_, err := checkLoopSource(ctx, e.source, e.sym)
+	if err != nil {
+		return err
+	}
+
+	err = e.body.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return err
+	}
+	if e.body.Type() != types.Bool {
+		return fmt.Errorf("%v predicate must be bool, but it is %v",
+			e.quantifier, e.body.Type())
+	}
+
+	return nil
+}
+
+func (e *QuantifierExpr) cse(ctx *context.Context) (string, bool, error) {
+	return "", false, cseLoop(ctx, &e.source, nil, &e.body)
+}
+
+func (e *QuantifierExpr) emit(ctx *context.Context) error {
+	return e.loop().emit(ctx)
+}
+
+func (e *QuantifierExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	return e.loop().eval(ctx)
+}
+
+func (e *QuantifierExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	return e.loop().closure(ctx)
+}
+
+func (e *QuantifierExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	return e.loop().goExpr(ctx)
+}
+
+// loop returns the loop that implements the expression.
+func (e *QuantifierExpr) loop() *iterLoop {
+	return &iterLoop{
+		kind:   e.kind,
+		source: e.source,
+		elem:   e.sym.LocalIndex(),
+		name:   e.name,
+		body:   e.body,
+		typ:    types.Bool,
+	}
+}
@@ -0,0 +1,444 @@
+package ast
+
+import (
+	gocontext "context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// regexFunc is an operation on a string and a regular expression pattern,
+// optionally followed by extra arguments.
+type regexFunc struct {
+	name  string
+	extra []types.Type
+	ret   types.Type
+	fn    func(re *regexp.Regexp, s string, extra []runtime.Value) runtime.Value
+
+	// patternFirst indicates that the pattern is the first argument, followed
+	// by the string. Otherwise, the string is the first argument.
+	patternFirst bool
+
+	// compile compiles the pattern. If nil, the pattern is a regular
+	// expression.
+	compile func(pattern string) (*regexp.Regexp, error)
+
+	// goMethod is the *regexp.Regexp method that implements fn in generated
+	// Go code, and goExtra are its arguments after the extra arguments.
+	goMethod string
+	goExtra  []string
+}
+
+// argIndices returns the index of the string and the pattern arguments.
+func (f *regexFunc) argIndices() (s, pattern int) {
+	if f.patternFirst {
+		return 1, 0
+	}
+	return 0, 1
+}
+
+func (f *regexFunc) compilePattern(pattern string) (*regexp.Regexp, error) {
+	if f.compile != nil {
+		return f.compile(pattern)
+	}
+	return compileRegex(pattern)
+}
+
+var (
+	regexMatch = &regexFunc{
+		name:     "matches",
+		goMethod: "MatchString",
+		ret:      types.Bool,
+		fn: func(re *regexp.Regexp, s string, extra []runtime.Value) runtime.Value {
+			return runtime.NewBool(re.MatchString(s))
+		},
+	}
+	regexFind = &regexFunc{
+		name:     "regexFind",
+		goMethod: "FindString",
+		ret:      types.String,
+		fn: func(re *regexp.Regexp, s string, extra []runtime.Value) runtime.Value {
+			return runtime.NewString(re.FindString(s))
+		},
+	}
+	regexReplace = &regexFunc{
+		name:     "regexReplace",
+		goMethod: "ReplaceAllString",
+		extra:    []types.Type{types.String},
+		ret:      types.String,
+		fn: func(re *regexp.Regexp, s string, extra []runtime.Value) runtime.Value {
+			return runtime.NewString(re.ReplaceAllString(s, extra[0].String()))
+		},
+	}
+	regexSplit = &regexFunc{
+		name:     "regexSplit",
+		goMethod: "Split",
+		goExtra:  []string{"-1"},
+		ret:      &types.Array{ElementType: types.String},
+		fn: func(re *regexp.Regexp, s string, extra []runtime.Value) runtime.Value {
+			parts := re.Split(s, -1)
+			arr := make([]runtime.RawValue, len(parts))
+			for i, part := range parts {
+				arr[i] = runtime.NewRawObject(part)
+			}
+			return runtime.NewObject(&types.Array{ElementType: types.String}, arr)
+		},
+	}
+	globMatch = &regexFunc{
+		name:     "glob",
+		goMethod: "MatchString",
+		ret:      types.Bool,
+		fn: func(re *regexp.Regexp, s string, extra []runtime.Value) runtime.Value {
+			return runtime.NewBool(re.MatchString(s))
+		},
+		patternFirst: true,
+		compile:      compileGlob,
+	}
+)
+
+// runtimeFunc returns the implementation of f. If compiled is set, the pattern
+// argument is a *regexp.Regexp compiled by the compiler. Otherwise, it is the
+// pattern string, which is compiled on each call.
+func (f *regexFunc) runtimeFunc(compiled bool) *runtime.Func {
+	sIndex, patternIndex := f.argIndices()
+	fnType := &types.Function{
+		Params: append([]types.Type{types.String, types.String}, f.extra...),
+		Ret:    f.ret,
+	}
+
+	if compiled {
+		fnType.Params[patternIndex] = types.Regexp
+		return &runtime.Func{
+			Name: builtinFuncPrefix + f.name,
+			Type: fnType,
+			Func: func(ctx gocontext.Context, args []runtime.Value) runtime.Value {
+				re := args[patternIndex].Object().(*regexp.Regexp)
+				return f.fn(re, args[sIndex].String(), args[2:])
+			},
+		}
+	}
+	return &runtime.Func{
+		Name: builtinFuncPrefix + f.name,
+		Type: fnType,
+		FuncErr: func(ctx gocontext.Context, args []runtime.Value) (runtime.Value, error) {
+			re, err := f.compilePattern(args[patternIndex].String())
+			if err != nil {
+				return runtime.Value{}, err
+			}
+			return f.fn(re, args[sIndex].String(), args[2:]), nil
+		},
+	}
+}
+
+func compileRegex(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %v", pattern, err)
+	}
+	return re, nil
+}
+
+// RegexExpr applies a regular expression to a string: s =~ pattern, or one of
+// the regex and glob builtins. If the pattern is constant, it is compiled by the Fold
+// pass, and invalid patterns are reported as compile errors.
+type RegexExpr struct {
+	exprImpl
+	fn   *regexFunc
+	args []Expr
+
+	// re is the compiled pattern if the pattern is constant.
+	re *regexp.Regexp
+}
+
+// regexFuncKey is the key of the shared constant of the runtime function of a
+// regexFunc.
+type regexFuncKey struct {
+	fn       *regexFunc
+	compiled bool
+}
+
+// regexKey is the key of the shared constant of a compiled pattern. Regex and
+// glob patterns are both compiled by regexp.Compile, so the source of the
+// compiled pattern identifies it.
+type regexKey string
+
+// NewMatchExpr creates the expression s =~ pattern.
+func NewMatchExpr(s, pattern Expr) *RegexExpr {
+	return newRegexExpr(regexMatch, []Expr{s, pattern})
+}
+
+func newRegexExpr(fn *regexFunc, args []Expr) *RegexExpr {
+	return &RegexExpr{fn: fn, args: args}
+}
+
+func (e *RegexExpr) Print(p *context.GraphPrinter) {
+	p.PrintNode(e.fn.name, exprsAsPrinters(e.args)...)
+}
+
+func (e *RegexExpr) RunPass(ctx *context.Context, pass context.Pass) error {
+	switch pass {
+	case context.CheckTypes:
+		return e.checkTypes(ctx)
+	case context.Fold:
+		return e.fold(ctx)
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+	case context.Emit:
+		return e.emit(ctx)
+	case context.Eval:
+		return setResult(ctx, e.eval)
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
+	default:
+		for _, arg := range e.args {
+			err := arg.RunPass(ctx, pass)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func (e *RegexExpr) checkTypes(ctx *context.Context) error {
+	if len(e.args) != 2+len(e.fn.extra) {
+		return fmt.Errorf("%v expects %d arguments but %d were provided",
+			e.fn.name, 2+len(e.fn.extra), len(e.args))
+	}
+	for i, arg := range e.args {
+		err := arg.RunPass(ctx, context.CheckTypes)
+		if err != nil {
+			return err
+		}
+		var expected types.Type = types.String
+		if i >= 2 {
+			expected = e.fn.extra[i-2]
+		}
+		if arg.Type() != expected {
+			return fmt.Errorf("%v argument %d expected type is %v but %v was provided",
+				e.fn.name, i, expected, arg.Type())
+		}
+	}
+	e.typ = e.fn.ret
+	return nil
+}
+
+func (e *RegexExpr) fold(ctx *context.Context) error {
+	for _, arg := range e.args {
+		err := arg.RunPass(ctx, context.Fold)
+		if err != nil {
+			return err
+		}
+	}
+
+	sIndex, patternIndex := e.fn.argIndices()
+	pattern, ok := e.args[patternIndex].Value().(string)
+	if !ok {
+		return nil
+	}
+	re, err := e.fn.compilePattern(pattern)
+	if err != nil {
+		return posOf(e.args[patternIndex]).Errorf("%v", err)
+	}
+	e.re = re
+
+	s, ok := e.args[sIndex].Value().(string)
+	if !ok {
+		return nil
+	}
+	extra := make([]runtime.Value, 0, len(e.fn.extra))
+	for _, arg := range e.args[2:] {
+		v, ok := valueFromFolded(arg.Value())
+		if !ok {
+			return nil
+		}
+		extra = append(extra, v)
+	}
+	e.value = foldedFromValue(e.fn.fn(re, s, extra))
+	return nil
+}
+
+func (e *RegexExpr) cse(ctx *context.Context) (string, bool, error) {
+	keys := make([]string, len(e.args))
+	for i := range e.args {
+		var err error
+		keys[i], err = cseExpr(ctx, &e.args[i])
+		if err != nil {
+			return "", false, err
+		}
+	}
+	return cseKey(e.fn.name, keys...), true, nil
+}
+
+func (e *RegexExpr) emit(ctx *context.Context) error {
+	if e.value != nil {
+		return ctx.Builder.EmitPushBasicValue(e.value)
+	}
+
+	fn := e.fn.runtimeFunc(e.re != nil)
+	ctx.Builder.EmitLoadConst(ctx.Builder.SharedConst(
+		regexFuncKey{e.fn, e.re != nil}, runtime.NewObject(fn.Type, fn)))
+
+	_, patternIndex := e.fn.argIndices()
+	for i, arg := range e.args {
+		if i == patternIndex && e.re != nil {
+			ctx.Builder.EmitLoadConst(ctx.Builder.SharedConst(
+				regexKey(e.re.String()), runtime.NewObject(types.Regexp, e.re)))
+			continue
+		}
+		err := arg.RunPass(ctx, context.Emit)
+		if err != nil {
+			return err
+		}
+	}
+	ctx.Builder.EmitCall(len(e.args))
+	return nil
+}
+
+func (e *RegexExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	_, patternIndex := e.fn.argIndices()
+	args := make([]runtime.Value, len(e.args))
+	for i, arg := range e.args {
+		if i == patternIndex && e.re != nil {
+			args[i] = runtime.NewObject(types.Regexp, e.re)
+			continue
+		}
+		v, err := evalExpr(ctx, arg)
+		if err != nil {
+			return runtime.Value{}, err
+		}
+		args[i] = v
+	}
+	return callFunc(ctx, e.fn.runtimeFunc(e.re != nil), args)
+}
+
+func (e *RegexExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	if e.value != nil {
+		return foldedClosure(e.value)
+	}
+	_, patternIndex := e.fn.argIndices()
+	args, err := argClosures(ctx, e.args)
+	if err != nil {
+		return runtime.Closure{}, err
+	}
+	if e.re != nil {
+		args[patternIndex] = constArg(runtime.NewObject(types.Regexp, e.re))
+	}
+	fn := e.fn.runtimeFunc(e.re != nil)
+	return callClosure(e.typ, fn, nil, args), nil
+}
+
+func (e *RegexExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	if e.value != nil {
+		return goFolded(ctx, e.value)
+	}
+
+	sIndex, _ := e.fn.argIndices()
+	args, err := goArgs(ctx, e.args)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	ctx.Go.Import("regexp")
+
+	if e.re != nil {
+		pattern := strconv.Quote(e.re.String())
+		if strconv.CanBackquote(e.re.String()) {
+			pattern = "`" + e.re.String() + "`"
+		}
+		re := ctx.Go.Declare("regexp "+pattern, "regexp", func(name string) string {
+			return fmt.Sprintf("var %s = regexp.MustCompile(%s)", name, pattern)
+		})
+		methodArgs := []string{args[sIndex].Code}
+		for _, arg := range args[2:] {
+			methodArgs = append(methodArgs, arg.Code)
+		}
+		methodArgs = append(methodArgs, e.fn.goExtra...)
+		return goPrimary(fmt.Sprintf("%s.%s(%s)",
+			re, e.fn.goMethod, strings.Join(methodArgs, ", "))), nil
+	}
+
+	if e.fn.compile != nil {
+		return context.GoExpr{}, fmt.Errorf(
+			"%v with a pattern that is not constant is not supported by the Go generator",
+			e.fn.name)
+	}
+
+	// Patterns that are not constant are compiled by a function declared for
+	// each operation, which returns an error if the pattern is invalid.
+	ret, err := goType(ctx, e.typ)
+	if err != nil {
+		return context.GoExpr{}, err
+	}
+	ctx.Go.Import("fmt")
+	extraParams := ""
+	extraArgs := ""
+	for i := range e.fn.extra {
+		extraParams += fmt.Sprintf(", arg%d string", i)
+		extraArgs += fmt.Sprintf(", arg%d", i)
+	}
+	for _, extra := range e.fn.goExtra {
+		extraArgs += ", " + extra
+	}
+	fn := ctx.Go.Declare("regexFunc "+e.fn.name, e.fn.name, func(name string) string {
+		return fmt.Sprintf(`func %s(s, pattern string%s) (%s, error) {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return %s, fmt.Errorf("invalid regular expression %%q: %%v", pattern, err)
+			}
+			return re.%s(s%s), nil
+		}`, name, extraParams, ret, goZero(ctx, e.typ), e.fn.goMethod, extraArgs)
+	})
+	argTypes := make([]types.Type, len(e.args))
+	for i, arg := range e.args {
+		argTypes[i] = arg.Type()
+	}
+	return goCall(ctx, e.typ, args, argTypes, true, func(args []string) string {
+		return fn + "(" + strings.Join(args, ", ") + ")"
+	})
+}
+
+// regexBuiltin implements the regex builtins by lowering the call to a
+// RegexExpr.
+type regexBuiltin struct {
+	fn *regexFunc
+}
+
+func (b *regexBuiltin) checkTypes(ctx *context.Context, call *CallExpr) error {
+	re := newRegexExpr(b.fn, call.params.params)
+	err := re.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return err
+	}
+	call.lowered = re
+	call.typ = re.Type()
+	return nil
+}
+
+func (b *regexBuiltin) cse(ctx *context.Context, call *CallExpr) (string, bool, error) {
+	key, err := cseExpr(ctx, &call.lowered)
+	return key, false, err
+}
+
+func (b *regexBuiltin) emit(ctx *context.Context, call *CallExpr) error {
+	return call.lowered.RunPass(ctx, context.Emit)
+}
+
+func (b *regexBuiltin) eval(ctx *context.Context, call *CallExpr) (runtime.Value, error) {
+	return evalExpr(ctx, call.lowered)
+}
+
+func (b *regexBuiltin) closure(ctx *context.Context, call *CallExpr) (runtime.Closure, error) {
+	return closureExpr(ctx, call.lowered)
+}
+
+func (b *regexBuiltin) goExpr(ctx *context.Context, call *CallExpr) (context.GoExpr, error) {
+	return goExprOf(ctx, call.lowered)
+}
@@ -1,14 +1,20 @@
 package ast
 
 import (
+	"fmt"
+
 	"github.com/dcaiafa/go-expr/expr/internal/context"
 	"github.com/dcaiafa/go-expr/expr/internal/symbol"
+	"github.com/dcaiafa/go-expr/expr/runtime"
 	"github.com/dcaiafa/go-expr/expr/types"
 )
 
 type SimpleRefExpr struct {
-	id  string
-	sym symbol.Symbol
+	id       string
+	sym      symbol.Symbol
+	overload *symbol.Overload
+	fnType   *types.Function
+	value    interface{}
 }
 
 func NewSimpleRefExpr(id string) *SimpleRefExpr {
@@ -18,11 +24,14 @@ func NewSimpleRefExpr(id string) *SimpleRefExpr {
 }
 
 func (e *SimpleRefExpr) Type() types.Type {
+	if e.overload != nil {
+		return e.fnType
+	}
 	return e.sym.Type()
 }
 
 func (e *SimpleRefExpr) Value() interface{} {
-	return nil
+	return e.value
 }
 
 func (e *SimpleRefExpr) Print(p *context.GraphPrinter) {
@@ -37,12 +46,33 @@ func (e *SimpleRefExpr) RunPass(ctx *context.Context, pass context.Pass) error {
 			return err
 		}
 
+	case context.CheckTypes:
+		err := e.checkTypes(ctx)
+		if err != nil {
+			return err
+		}
+
+	case context.Fold:
+		e.fold(ctx)
+
+	case context.CSE:
+		return setCSE(ctx, e.cse)
+
 	case context.Emit:
 		err := e.emit(ctx)
 		if err != nil {
 			return err
 		}
 
+	case context.Eval:
+		return setResult(ctx, e.eval)
+
+	case context.Closure:
+		return setClosure(ctx, e.closure)
+
+	case context.GoGen:
+		return setGoExpr(ctx, e.goExpr)
+
 	default:
 	}
 
@@ -51,11 +81,184 @@ func (e *SimpleRefExpr) RunPass(ctx *context.Context, pass context.Pass) error {
 
 func (e *SimpleRefExpr) resolveNames(ctx *context.Context) error {
 	var err error
-	e.sym, err = ctx.GlobalScope.Get(e.id)
+	e.sym, err = ctx.Scope.Get(e.id)
 	return err
 }
 
+func (e *SimpleRefExpr) checkTypes(ctx *context.Context) error {
+	if e.Type() == nil {
+		return fmt.Errorf("ambiguous reference to overloaded function %v", e.id)
+	}
+	if types.IsGeneric(e.Type()) {
+		return fmt.Errorf("generic function %v can only be called", e.id)
+	}
+	return nil
+}
+
+// funcSymbol returns the function symbol referenced by the expression, or nil
+// if the expression does not reference a function symbol.
+func (e *SimpleRefExpr) funcSymbol() *symbol.FuncSymbol {
+	fnSym, _ := e.sym.(*symbol.FuncSymbol)
+	return fnSym
+}
+
+// selectOverload selects the overload of the referenced function symbol.
+// fnType is the type of the overload, or its instance if the overload is
+// generic.
+func (e *SimpleRefExpr) selectOverload(overload *symbol.Overload, fnType *types.Function) {
+	e.overload = overload
+	e.fnType = fnType
+}
+
+// fold folds references to constants of basic types, so that the expressions
+// that use them can be folded too.
+func (e *SimpleRefExpr) fold(ctx *context.Context) {
+	if sym, ok := e.sym.(*symbol.ConstSymbol); ok {
+		e.value = foldedFromValue(ctx.Builder.Const(sym.ConstIndex()))
+	}
+}
+
+// cse returns the key of the referenced symbol. Symbols are not shared,
+// because loading a local is not faster than loading the symbol.
+func (e *SimpleRefExpr) cse(ctx *context.Context) (string, bool, error) {
+	switch sym := e.sym.(type) {
+	case *symbol.ConstSymbol:
+		return fmt.Sprintf("const %d", sym.ConstIndex()), false, nil
+	case *symbol.InputSymbol:
+		return fmt.Sprintf("input %d", sym.InputIndex()), false, nil
+	case *symbol.LocalSymbol:
+		return fmt.Sprintf("local %d", sym.LocalIndex()), false, nil
+	default:
+		return "", false, nil
+	}
+}
+
 func (e *SimpleRefExpr) emit(ctx *context.Context) error {
+	if e.value != nil {
+		return ctx.Builder.EmitPushBasicValue(e.value)
+	}
+	if e.overload != nil {
+		e.funcSymbol().EmitOverloadAccess(ctx.Builder, e.overload, e.fnType)
+		return nil
+	}
 	e.sym.EmitAccess(ctx.Builder)
 	return nil
 }
+
+func (e *SimpleRefExpr) eval(ctx *context.Context) (runtime.Value, error) {
+	switch sym := e.sym.(type) {
+	case *symbol.ConstSymbol:
+		return ctx.Builder.Const(sym.ConstIndex()), nil
+	case *symbol.InputSymbol:
+		return ctx.Eval.Inputs[sym.InputIndex()], nil
+	case *symbol.LocalSymbol:
+		return ctx.Eval.Locals[sym.LocalIndex()], nil
+	case *symbol.FuncSymbol:
+		fn := e.runtimeFunc()
+		return runtime.NewObject(fn.Type, fn), nil
+	default:
+		return runtime.Value{}, fmt.Errorf("%w: invalid symbol %T",
+			runtime.ErrInternal, e.sym)
+	}
+}
+
+func (e *SimpleRefExpr) closure(ctx *context.Context) (runtime.Closure, error) {
+	switch sym := e.sym.(type) {
+	case *symbol.ConstSymbol:
+		return constClosure(e.Type(), ctx.Builder.Const(sym.ConstIndex()).RawValue), nil
+
+	case *symbol.InputSymbol:
+		inputIndex := sym.InputIndex()
+		switch e.Type() {
+		case types.Number:
+			return runtime.Closure{Number: func(env *runtime.Env) float64 {
+				return env.Input(inputIndex).Number()
+			}}, nil
+		case types.Bool:
+			return runtime.Closure{Bool: func(env *runtime.Env) bool {
+				return env.Input(inputIndex).Bool()
+			}}, nil
+		default:
+			return runtime.Closure{Value: func(env *runtime.Env) runtime.RawValue {
+				return env.Input(inputIndex)
+			}}, nil
+		}
+
+	case *symbol.LocalSymbol:
+		localIndex := sym.LocalIndex()
+		switch e.Type() {
+		case types.Number:
+			return runtime.Closure{Number: func(env *runtime.Env) float64 {
+				return env.Local(localIndex).Number()
+			}}, nil
+		case types.Bool:
+			return runtime.Closure{Bool: func(env *runtime.Env) bool {
+				return env.Local(localIndex).Bool()
+			}}, nil
+		default:
+			return runtime.Closure{Value: func(env *runtime.Env) runtime.RawValue {
+				return env.Local(localIndex)
+			}}, nil
+		}
+
+	case *symbol.FuncSymbol:
+		return constClosure(e.Type(), runtime.NewRawObject(e.runtimeFunc())), nil
+
+	default:
+		return runtime.Closure{}, fmt.Errorf("%w: invalid symbol %T",
+			runtime.ErrInternal, e.sym)
+	}
+}
+
+// runtimeFunc returns the implementation of the referenced function, which
+// is the selected overload or the only overload of the function symbol.
+func (e *SimpleRefExpr) runtimeFunc() *runtime.Func {
+	sym := e.funcSymbol()
+	overload, fnType := e.overload, e.fnType
+	if overload == nil {
+		overload = sym.Overloads[0]
+		fnType = overload.Type
+	}
+	return sym.OverloadFunc(overload, fnType)
+}
+
+func (e *SimpleRefExpr) goExpr(ctx *context.Context) (context.GoExpr, error) {
+	switch sym := e.sym.(type) {
+	case *symbol.ConstSymbol:
+		// Constants are declared as variables, because Go evaluates constant
+		// expressions with arbitrary precision.
+		v := ctx.Builder.Const(sym.ConstIndex())
+		code, err := goValue(ctx, v)
+		if err != nil {
+			return context.GoExpr{}, fmt.Errorf("%v: %w", e.id, err)
+		}
+		name := ctx.Go.Declare("const "+e.id, e.id, func(name string) string {
+			return fmt.Sprintf("var %s = %s", name, goTyped(code, v.Type()))
+		})
+		return context.GoExpr{Code: name, Prec: context.GoPrimary, Simple: true}, nil
+
+	case *symbol.InputSymbol:
+		return context.GoExpr{
+			Code:   ctx.Go.ParamNames[sym.InputIndex()],
+			Prec:   context.GoPrimary,
+			Simple: true,
+		}, nil
+
+	case *symbol.LocalSymbol:
+		name, ok := ctx.Go.Locals[sym.LocalIndex()]
+		if !ok {
+			return context.GoExpr{}, fmt.Errorf("%w: undeclared local %v",
+				runtime.ErrInternal, e.id)
+		}
+		ctx.Go.Used[sym.LocalIndex()] = true
+		return context.GoExpr{Code: name, Prec: context.GoPrimary, Simple: true}, nil
+
+	case *symbol.FuncSymbol:
+		return context.GoExpr{}, fmt.Errorf(
+			"function values are not supported by the Go generator")
+
+	default:
+		return context.GoExpr{}, fmt.Errorf("%w: invalid symbol %T",
+			runtime.ErrInternal, e.sym)
+	}
+}
@@ -0,0 +1,45 @@
+package ast
+
+import (
+	"github.com/dcaiafa/go-expr/expr/internal/context"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// hintable is implemented by expressions whose type cannot always be
+// determined on their own, such as the empty array literal, and must be
+// inferred from the context in which they are used.
+type hintable interface {
+	needsTypeHint() bool
+	setTypeHint(hint types.Type)
+}
+
+func needsTypeHint(expr Expr) bool {
+	h, ok := expr.(hintable)
+	return ok && h.needsTypeHint()
+}
+
+// checkTypesWithHint runs the CheckTypes pass on expr, providing hint as the
+// type expected by the context. The hint is only used by expressions that
+// cannot determine their type otherwise; it is not a type check.
+func checkTypesWithHint(ctx *context.Context, expr Expr, hint types.Type) error {
+	if h, ok := expr.(hintable); ok && hint != nil {
+		h.setTypeHint(hint)
+	}
+	return expr.RunPass(ctx, context.CheckTypes)
+}
+
+// checkOperandTypes runs the CheckTypes pass on the operands of a binary
+// operation whose operands are expected to have the same type. The operand
+// that can determine its own type is checked first, and its type is used as a
+// hint for the other.
+func checkOperandTypes(ctx *context.Context, left Expr, right Expr) error {
+	first, second := left, right
+	if needsTypeHint(left) && !needsTypeHint(right) {
+		first, second = right, left
+	}
+	err := first.RunPass(ctx, context.CheckTypes)
+	if err != nil {
+		return err
+	}
+	return checkTypesWithHint(ctx, second, first.Type())
+}
@@ -0,0 +1,57 @@
+package ast
+
+import (
+	"fmt"
+
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// TypeRef is a type written in the source, such as the []number annotation of
+// an empty array literal.
+type TypeRef struct {
+	name string
+	elem *TypeRef
+}
+
+// NewNamedTypeRef creates a reference to a basic type by name.
+func NewNamedTypeRef(name string) *TypeRef {
+	return &TypeRef{name: name}
+}
+
+// NewArrayTypeRef creates a reference to an array type.
+func NewArrayTypeRef(elem *TypeRef) *TypeRef {
+	return &TypeRef{elem: elem}
+}
+
+func (r *TypeRef) String() string {
+	if r.elem != nil {
+		return "[]" + r.elem.String()
+	}
+	return r.name
+}
+
+// Resolve returns the type referenced by r.
+func (r *TypeRef) Resolve() (types.Type, error) {
+	if r.elem != nil {
+		elemType, err := r.elem.Resolve()
+		if err != nil {
+			return nil, err
+		}
+		return &types.Array{ElementType: elemType}, nil
+	}
+
+	switch r.name {
+	case "number":
+		return types.Number, nil
+	case "string":
+		return types.String, nil
+	case "bool":
+		return types.Bool, nil
+	case "ip":
+		return types.IP, nil
+	case "cidr":
+		return types.CIDR, nil
+	default:
+		return nil, fmt.Errorf("unknown type %v", r.name)
+	}
+}
@@ -7,13 +7,40 @@ import (
 
 type Context struct {
 	GlobalScope  *symbol.Scope
+	Scope        *symbol.Scope
 	Builder      *runtime.Builder
 	GraphPrinter *GraphPrinter
+
+	// Eval is the state of the Eval pass.
+	Eval *Evaluation
+
+	// Closure is the result of the last expression compiled by the Closure
+	// pass.
+	Closure runtime.Closure
+
+	// Go is the state of the GoGen pass.
+	Go *GoGenerator
+
+	// CSE is the state of the CSE pass.
+	CSE *CSEState
 }
 
 func NewContext() *Context {
+	globalScope := symbol.NewScope()
 	return &Context{
-		GlobalScope: symbol.NewScope(),
+		GlobalScope: globalScope,
+		Scope:       globalScope,
 		Builder:     runtime.NewBuilder(),
 	}
 }
+
+// PushScope makes a new scope nested in the current scope the current scope.
+func (c *Context) PushScope() *symbol.Scope {
+	c.Scope = symbol.NewNestedScope(c.Scope)
+	return c.Scope
+}
+
+// PopScope restores the scope that was current before the last PushScope.
+func (c *Context) PopScope() {
+	c.Scope = c.Scope.Parent()
+}
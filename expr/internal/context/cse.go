@@ -0,0 +1,122 @@
+package context
+
+import (
+	"github.com/dcaiafa/go-expr/expr/runtime"
+)
+
+// CSEState is the state of the CSE pass, which finds the common
+// sub-expressions of an expression. The pass visits the sub-expressions in
+// evaluation order, and identifies each one by a key that describes its
+// structure. If an occurrence of a key is always evaluated before another,
+// the first occurrence stores its value in a local, and the other loads it
+// instead of evaluating it again.
+type CSEState struct {
+	// Key is the key of the last expression visited. Expressions with the
+	// same key evaluate to the same value. Key is empty if the expression
+	// cannot be shared, for example, because it calls an impure function.
+	Key string
+
+	// Shared is true if the last expression visited is worth sharing.
+	// Leaves, such as inputs and literals, have keys, but loading them from a
+	// local is not faster than evaluating them.
+	Shared bool
+
+	region      *cseRegion
+	occurrences []*cseOccurrence
+	stores      map[string][]*cseOccurrence
+}
+
+// cseRegion is code that is evaluated conditionally or repeatedly, such as
+// the right side of && or the body of a loop. An occurrence can only be
+// shared with the occurrences in its region and the regions nested in it.
+type cseRegion struct {
+	parent *cseRegion
+}
+
+// encloses returns true if other is r or is nested in r.
+func (r *cseRegion) encloses(other *cseRegion) bool {
+	for ; other != nil; other = other.parent {
+		if other == r {
+			return true
+		}
+	}
+	return false
+}
+
+type cseOccurrence struct {
+	region *cseRegion
+	share  func(local int, load bool)
+
+	// source is the occurrence that stores the value loaded by this one, if
+	// any, and loads is the number of occurrences that load its value.
+	source *cseOccurrence
+	loads  int
+
+	// dropped is set for the occurrences in a load, which are not evaluated.
+	dropped bool
+}
+
+func NewCSEState() *CSEState {
+	return &CSEState{
+		region: &cseRegion{},
+		stores: make(map[string][]*cseOccurrence),
+	}
+}
+
+// Branch starts a region of code that is evaluated conditionally or
+// repeatedly, and returns a function that ends it.
+func (c *CSEState) Branch() (end func()) {
+	c.region = &cseRegion{parent: c.region}
+	return func() {
+		c.region = c.region.parent
+	}
+}
+
+// Mark returns a mark that Visit uses to identify the occurrences visited
+// after it, which are the sub-expressions of the next expression visited.
+func (c *CSEState) Mark() int {
+	return len(c.occurrences)
+}
+
+// Visit records an occurrence of key, whose sub-expressions were visited
+// after mark. share is called by Finish if the occurrence is shared: with
+// load set if it must load the value from local, and otherwise if it must
+// store its value in local.
+func (c *CSEState) Visit(key string, mark int, share func(local int, load bool)) {
+	occurrence := &cseOccurrence{region: c.region, share: share}
+	for _, store := range c.stores[key] {
+		if store.dropped || !store.region.encloses(c.region) {
+			continue
+		}
+		for _, sub := range c.occurrences[mark:] {
+			sub.dropped = true
+			if sub.source != nil {
+				sub.source.loads--
+			}
+		}
+		occurrence.source = store
+		store.loads++
+		break
+	}
+	if occurrence.source == nil {
+		c.stores[key] = append(c.stores[key], occurrence)
+	}
+	c.occurrences = append(c.occurrences, occurrence)
+}
+
+// Finish creates a local for each occurrence whose value is loaded by
+// others, and shares the occurrences.
+func (c *CSEState) Finish(builder *runtime.Builder) {
+	locals := make(map[*cseOccurrence]int)
+	for _, occurrence := range c.occurrences {
+		if occurrence.dropped {
+			continue
+		}
+		if occurrence.source != nil {
+			occurrence.share(locals[occurrence.source], true)
+		} else if occurrence.loads != 0 {
+			locals[occurrence] = builder.NewLocal()
+			occurrence.share(locals[occurrence], false)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package context
+
+import (
+	gocontext "context"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+)
+
+// Evaluation is the state of the Eval pass.
+type Evaluation struct {
+	Context gocontext.Context
+
+	// ExprIndex is the index of the program expression to evaluate.
+	ExprIndex int
+
+	Inputs []runtime.Value
+	Locals []runtime.Value
+
+	// Result is the value of the last evaluated expression.
+	Result runtime.Value
+
+	// remaining is the number of loop iterations left if budget is set.
+	budget    int
+	remaining int
+}
+
+// NewEvaluation creates the state to evaluate an expression with inputs. The
+// budget limits the number of loop iterations like Runtime.SetBudget; zero
+// means no limit.
+func NewEvaluation(
+	ctx gocontext.Context,
+	exprIndex int,
+	inputs []runtime.Value,
+	locals int,
+	budget int,
+) *Evaluation {
+	return &Evaluation{
+		Context:   ctx,
+		ExprIndex: exprIndex,
+		Inputs:    inputs,
+		Locals:    make([]runtime.Value, locals),
+		budget:    budget,
+		remaining: budget,
+	}
+}
+
+// Iterate accounts for a loop iteration. It returns
+// runtime.ErrBudgetExceeded if the budget is exhausted.
+func (e *Evaluation) Iterate() error {
+	if e.budget != 0 {
+		if e.remaining == 0 {
+			return runtime.ErrBudgetExceeded
+		}
+		e.remaining--
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package context
+
+import (
+	"go/token"
+	"strconv"
+	"strings"
+
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// GoFunc is a Go function that implements a registered function in the code
+// generated by the GoGen pass.
+type GoFunc struct {
+	// Name is the name of the function, qualified by its package name if the
+	// function is imported.
+	Name string
+
+	// Import is the import path of the package of the function, if any.
+	Import string
+
+	// Err indicates that the function returns an error as its second result.
+	Err bool
+}
+
+// GoParam is a parameter of a generated Go function.
+type GoParam struct {
+	Name string
+	Type types.Type
+}
+
+// GoExpr is a Go expression generated by the GoGen pass.
+type GoExpr struct {
+	Code string
+
+	// Prec is the precedence of the outermost operator of Code: 1 to 5 for
+	// the binary operators, as defined by the Go specification, GoUnary for
+	// unary expressions and GoPrimary for operands, calls and conversions.
+	Prec int
+
+	// Untyped indicates that Code is an untyped constant.
+	Untyped bool
+
+	// Simple indicates that Code is a constant or a variable, which can be
+	// evaluated in any order.
+	Simple bool
+}
+
+const (
+	GoUnary   = 6
+	GoPrimary = 7
+)
+
+// GoGenerator is the state of the GoGen pass, which generates a Go function that
+// evaluates the first expression of the program.
+type GoGenerator struct {
+	Package string
+	Func    string
+	Source  string
+	Params  []GoParam
+	Funcs   map[string]GoFunc
+
+	// Checked indicates that the generated code checks for errors: after the
+	// first error, which is stored in ErrVar, the remaining calls are skipped
+	// and loops stop, like in the runtime.
+	Checked bool
+	ErrVar  string
+
+	// Fails is set by the pass if the expression has an operation that can
+	// fail. The code of such expressions must be generated with Checked set.
+	Fails bool
+
+	// ParamNames are the Go names of Params.
+	ParamNames []string
+
+	// Locals are the Go names of the locals declared by the generated code,
+	// by local index. Used is the set of locals referenced by the code.
+	Locals map[int]string
+	Used   map[int]bool
+
+	// Result is the last generated expression.
+	Result GoExpr
+
+	// Output is the generated source file.
+	Output []byte
+
+	imports map[string]bool
+	decls   []string
+	keys    map[string]string
+	names   map[string]bool
+}
+
+// Reset clears the state of a previous run of the pass, and prepares the
+// names of the parameters.
+func (g *GoGenerator) Reset(checked bool) {
+	g.Checked = checked
+	g.Fails = false
+	g.Locals = make(map[int]string)
+	g.Used = make(map[int]bool)
+	g.imports = make(map[string]bool)
+	g.decls = nil
+	g.keys = make(map[string]string)
+	g.names = make(map[string]bool)
+
+	for _, name := range goReserved {
+		g.names[name] = true
+	}
+	for _, fn := range g.Funcs {
+		if pkg, _, ok := strings.Cut(fn.Name, "."); ok {
+			g.names[pkg] = true
+		}
+	}
+
+	g.ParamNames = make([]string, len(g.Params))
+	for i, param := range g.Params {
+		g.ParamNames[i] = g.Fresh(param.Name)
+	}
+	g.ErrVar = ""
+	if checked {
+		g.ErrVar = g.Fresh("err")
+	}
+}
+
+// Fresh returns a name based on base that is not used by any other
+// declaration in the generated code.
+func (g *GoGenerator) Fresh(base string) string {
+	if !token.IsIdentifier(base) {
+		base = "v"
+	}
+	name := base
+	for i := 1; g.names[name]; i++ {
+		name = base + strconv.Itoa(i)
+	}
+	g.names[name] = true
+	return name
+}
+
+// Import adds path to the imports of the generated file.
+func (g *GoGenerator) Import(path string) {
+	g.imports[path] = true
+}
+
+// Imports returns the imports of the generated file.
+func (g *GoGenerator) Imports() []string {
+	imports := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		imports = append(imports, path)
+	}
+	return imports
+}
+
+// Declare adds a package-level declaration to the generated file, unless a
+// declaration with the same key was already added, and returns its name. The
+// name is base prefixed by the name of the generated function, so that several
+// generated functions can share a package. decl returns the source of the
+// declaration given its name.
+func (g *GoGenerator) Declare(key, base string, decl func(name string) string) string {
+	if name, ok := g.keys[key]; ok {
+		return name
+	}
+	prefix := strings.ToLower(g.Func[:1]) + g.Func[1:]
+	name := g.Fresh(prefix + strings.ToUpper(base[:1]) + base[1:])
+	g.keys[key] = name
+	g.decls = append(g.decls, decl(name))
+	return name
+}
+
+// Decls returns the package-level declarations of the generated file.
+func (g *GoGenerator) Decls() []string {
+	return g.decls
+}
+
+// goReserved are the Go keywords, the predeclared identifiers and the packages
+// imported by the generated code, which cannot be used as names.
+var goReserved = []string{
+	"break", "case", "chan", "const", "continue", "default", "defer", "else",
+	"fallthrough", "for", "func", "go", "goto", "if", "import", "interface",
+	"map", "package", "range", "return", "select", "struct", "switch", "type",
+	"var",
+
+	"any", "append", "bool", "byte", "cap", "clear", "close", "comparable",
+	"complex", "complex128", "complex64", "copy", "delete", "error", "false",
+	"float32", "float64", "imag", "int", "int16", "int32", "int64", "int8",
+	"iota", "len", "make", "max", "min", "new", "nil", "panic", "print",
+	"println", "real", "recover", "rune", "string", "true", "uint", "uint16",
+	"uint32", "uint64", "uint8", "uintptr",
+
+	"fmt", "math", "netip", "regexp",
+}
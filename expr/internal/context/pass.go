@@ -8,13 +8,32 @@ const (
 	ResolveNames
 	CheckTypes
 	Fold
+
+	// CSE replaces the common sub-expressions of an expression with a local
+	// that holds their value. It runs after the Fold pass, and it can be
+	// skipped.
+	CSE
+
 	Emit
+
+	// Eval evaluates the expression by walking the AST, as an alternative to
+	// Emit. It runs after the Fold pass.
+	Eval
+
+	// Closure compiles the expression to Go closures, in addition to the
+	// bytecode. It runs after the Emit pass.
+	Closure
+
+	// GoGen generates Go source code that evaluates the expression. It runs
+	// after the Fold pass.
+	GoGen
 )
 
 var Passes = []Pass{
 	ResolveNames,
 	CheckTypes,
 	Fold,
+	CSE,
 	Emit,
 }
 
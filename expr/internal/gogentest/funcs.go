@@ -0,0 +1,45 @@
+// Package gogentest holds the Go code generated by the tests of
+// expr.Compiler.GenerateGo. The generated files are golden files: the tests
+// check that the generator output matches them, and since they are compiled
+// with the package, the tests also run them and compare their results with the
+// runtime. Run the tests with -update to regenerate them.
+package gogentest
+
+import (
+	"errors"
+	"strings"
+)
+
+// The following functions implement the functions registered by the tests.
+
+func check(n float64) (float64, error) {
+	if n < 0 {
+		return 0, errors.New("negative")
+	}
+	return n, nil
+}
+
+func strLen(s string) float64 {
+	return float64(len(s))
+}
+
+func join(sep string, parts ...string) string {
+	return strings.Join(parts, sep)
+}
+
+func contains[T comparable](arr []T, elem T) bool {
+	for _, e := range arr {
+		if e == elem {
+			return true
+		}
+	}
+	return false
+}
+
+func first[T any](arr []T) (T, error) {
+	if len(arr) == 0 {
+		var zero T
+		return zero, errors.New("first of empty array")
+	}
+	return arr[0], nil
+}
@@ -0,0 +1,244 @@
+package gogentest
+
+import (
+	"context"
+	"flag"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/stdlib/arrays"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update the generated files")
+
+var (
+	numberArray = &types.Array{ElementType: types.Number}
+	stringArray = &types.Array{ElementType: types.String}
+)
+
+func newCompiler() *expr.Compiler {
+	compiler := expr.NewCompiler(expr.WithModule(arrays.Module))
+	compiler.RegisterInput("a", types.Number)
+	compiler.RegisterInput("b", types.Number)
+	compiler.RegisterInput("s", types.String)
+	compiler.RegisterInput("p", types.String)
+	compiler.RegisterInput("nums", numberArray)
+	compiler.RegisterInput("strs", stringArray)
+	compiler.RegisterConst("k", runtime.NewNumber(3))
+	compiler.RegisterConst("names", runtime.NewObject(stringArray, []runtime.RawValue{
+		runtime.NewRawObject("x"), runtime.NewRawObject("y"),
+	}))
+	compiler.RegisterGoFunc("check", check)
+	compiler.RegisterGoFunc("strLen", strLen)
+	compiler.RegisterGoFunc("upper", strings.ToUpper)
+	compiler.RegisterGoFunc("join", join)
+	return compiler
+}
+
+var goFuncs = map[string]expr.GoFunc{
+	"check":    {Name: "check", Err: true},
+	"strLen":   {Name: "strLen"},
+	"upper":    {Name: "strings.ToUpper", Import: "strings"},
+	"join":     {Name: "join"},
+	"contains": {Name: "contains"},
+	"first":    {Name: "first", Err: true},
+}
+
+type inputs struct {
+	a, b float64
+	s, p string
+	nums []float64
+	strs []string
+}
+
+func (in inputs) values() []runtime.Value {
+	nums := make([]runtime.RawValue, len(in.nums))
+	for i, n := range in.nums {
+		nums[i] = runtime.NewRawNumber(n)
+	}
+	strs := make([]runtime.RawValue, len(in.strs))
+	for i, s := range in.strs {
+		strs[i] = runtime.NewRawObject(s)
+	}
+	return []runtime.Value{
+		runtime.NewNumber(in.a),
+		runtime.NewNumber(in.b),
+		runtime.NewString(in.s),
+		runtime.NewString(in.p),
+		runtime.NewObject(numberArray, nums),
+		runtime.NewObject(stringArray, strs),
+	}
+}
+
+var testInputs = []inputs{
+	{a: 1, b: 2, s: "hello", p: "10.1.2.3", nums: []float64{1, 2, 3}, strs: []string{"a", "b"}},
+	{a: -1, b: 2, s: "a,b.go", p: "(", nums: []float64{}, strs: []string{}},
+	{a: 2, b: 2, s: "10.0.0.0/8", p: "::ffff:10.0.0.1", nums: []float64{-1, 5}, strs: []string{"x", ""}},
+}
+
+func TestGenerateGo(t *testing.T) {
+	run := func(name, input string, eval func(in inputs) (interface{}, error)) {
+		t.Run(name, func(t *testing.T) {
+			src, err := newCompiler().GenerateGo(input, expr.GoOptions{
+				Package: "gogentest",
+				Func:    "Eval" + strings.ToUpper(name[:1]) + name[1:],
+				Funcs:   goFuncs,
+			})
+			require.NoError(t, err)
+
+			file := name + "_gen.go"
+			if *update {
+				require.NoError(t, os.WriteFile(file, src, 0o644))
+			} else {
+				golden, err := os.ReadFile(file)
+				require.NoError(t, err)
+				require.Equal(t, string(golden), string(src))
+			}
+
+			prog, err := newCompiler().Compile(input)
+			require.NoError(t, err)
+			r := runtime.NewRuntime(prog)
+			for _, in := range testInputs {
+				expected, expectedErr := r.Run(context.Background(), 0, in.values())
+				actual, err := eval(in)
+				if expectedErr != nil {
+					require.EqualError(t, err, expectedErr.Error(), "inputs: %+v", in)
+					continue
+				}
+				require.NoError(t, err, "inputs: %+v", in)
+				require.Equal(t, nativeValue(expected), nativeGo(actual), "inputs: %+v", in)
+			}
+		})
+	}
+
+	run("compare", `a < b && s != "x" || !(a == b)`,
+		func(in inputs) (interface{}, error) {
+			return EvalCompare(in.a, in.b, in.s, in.p, in.nums, in.strs), nil
+		})
+	run("arith", `(a + b) * 2 - a / (b - k) >= k * -0.5`,
+		func(in inputs) (interface{}, error) {
+			return EvalArith(in.a, in.b, in.s, in.p, in.nums, in.strs), nil
+		})
+	run("special", `a / 0 == 1 / 0 || a * 0.0000001 < 1000000 * 1000000 * 1000000 * 1000000 && -0 == 0`,
+		func(in inputs) (interface{}, error) {
+			return EvalSpecial(in.a, in.b, in.s, in.p, in.nums, in.strs), nil
+		})
+	run("call", `strLen(s) + k == b || upper(s) == "HELLO" || join(",", s, "x") == "a,x"`,
+		func(in inputs) (interface{}, error) {
+			return EvalCall(in.a, in.b, in.s, in.p, in.nums, in.strs), nil
+		})
+	run("generic", `contains(nums, 2) || contains(names, s) && contains(strs, upper(s))`,
+		func(in inputs) (interface{}, error) {
+			return EvalGeneric(in.a, in.b, in.s, in.p, in.nums, in.strs), nil
+		})
+	run("check", `check(a) + count(nums, |x| check(x) > b) + first(nums)`,
+		func(in inputs) (interface{}, error) {
+			return EvalCheck(in.a, in.b, in.s, in.p, in.nums, in.strs)
+		})
+	run("comprehension", `[x * 2 for x in nums if x > a]`,
+		func(in inputs) (interface{}, error) {
+			return EvalComprehension(in.a, in.b, in.s, in.p, in.nums, in.strs), nil
+		})
+	run("loops", `count(nums, |x| any(nums, |y| y > x)) + count(filter(strs, |s| s != ""), |x| true)`,
+		func(in inputs) (interface{}, error) {
+			return EvalLoops(in.a, in.b, in.s, in.p, in.nums, in.strs), nil
+		})
+	run("find", `find(strs, |x| x =~ "^b") == "" && (all x in nums: x >= 0)`,
+		func(in inputs) (interface{}, error) {
+			return EvalFind(in.a, in.b, in.s, in.p, in.nums, in.strs), nil
+		})
+	run("reduce", `reduce(nums, |acc, x| acc + x * k, 0)`,
+		func(in inputs) (interface{}, error) {
+			return EvalReduce(in.a, in.b, in.s, in.p, in.nums, in.strs), nil
+		})
+	run("in", `s in strs || a in [1, 2, 3] && [a] in [[b], nums]`,
+		func(in inputs) (interface{}, error) {
+			return EvalIn(in.a, in.b, in.s, in.p, in.nums, in.strs), nil
+		})
+	run("inCheck", `check(a) in nums`,
+		func(in inputs) (interface{}, error) {
+			return EvalInCheck(in.a, in.b, in.s, in.p, in.nums, in.strs)
+		})
+	run("regex", `s =~ "^h.*o$" || regexReplace(s, "l+", "L") == "heLo" || glob("*.go", s) || count(regexSplit(s, ","), |x| true) > 1`,
+		func(in inputs) (interface{}, error) {
+			return EvalRegex(in.a, in.b, in.s, in.p, in.nums, in.strs), nil
+		})
+	run("dynamicRegex", `regexFind(s, p)`,
+		func(in inputs) (interface{}, error) {
+			return EvalDynamicRegex(in.a, in.b, in.s, in.p, in.nums, in.strs)
+		})
+	run("ip", `ip(p) in cidr("10.0.0.0/8") || ip("10.0.0.1") in cidr(s)`,
+		func(in inputs) (interface{}, error) {
+			return EvalIp(in.a, in.b, in.s, in.p, in.nums, in.strs)
+		})
+	run("names", `count(nums, |err| check(err) > a) + count(strs, |string| string == "")`,
+		func(in inputs) (interface{}, error) {
+			return EvalNames(in.a, in.b, in.s, in.p, in.nums, in.strs)
+		})
+}
+
+// nativeValue converts v to the Go value returned by the generated code, with
+// arrays converted like nativeGo.
+func nativeValue(v runtime.Value) interface{} {
+	if arrType, ok := v.Type().(*types.Array); ok {
+		elems := v.Object().([]runtime.RawValue)
+		array := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			array[i] = nativeValue(runtime.NewValue(arrType.ElementType, elem))
+		}
+		return array
+	}
+	switch v.Type() {
+	case types.Number:
+		return v.Number()
+	case types.Bool:
+		return v.Bool()
+	default:
+		return v.Object()
+	}
+}
+
+// nativeGo converts slices in v to []interface{}.
+func nativeGo(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice {
+		return v
+	}
+	array := make([]interface{}, rv.Len())
+	for i := range array {
+		array[i] = nativeGo(rv.Index(i).Interface())
+	}
+	return array
+}
+
+func TestGenerateGo_Errors(t *testing.T) {
+	run := func(name, input string, opts expr.GoOptions, expected string) {
+		t.Run(name, func(t *testing.T) {
+			if opts.Package == "" {
+				opts.Package = "gogentest"
+			}
+			if opts.Funcs == nil {
+				opts.Funcs = goFuncs
+			}
+			_, err := newCompiler().GenerateGo(input, opts)
+			require.EqualError(t, err, expected)
+		})
+	}
+
+	run("package", `a`, expr.GoOptions{Package: "a-b"}, `invalid package name "a-b"`)
+	run("func", `a`, expr.GoOptions{Func: "func"}, `invalid function name "func"`)
+	run("no_impl", `strLen(s)`, expr.GoOptions{Funcs: map[string]expr.GoFunc{}},
+		"function strLen has no Go implementation")
+	run("func_value", `count([strLen], |f| true)`, expr.GoOptions{},
+		"function values are not supported by the Go generator")
+	run("dynamic_glob", `glob(p, s)`, expr.GoOptions{},
+		"glob with a pattern that is not constant is not supported by the Go generator")
+	run("multiple", `a; b`, expr.GoOptions{},
+		"the Go generator only supports a single expression")
+}
@@ -0,0 +1,44 @@
+// Code generated by go-expr. DO NOT EDIT.
+
+package gogentest
+
+// EvalIn evaluates the expression:
+//
+//	s in strs || a in [1, 2, 3] && [a] in [[b], nums]
+func EvalIn(a float64, b float64, s string, p string, nums []float64, strs []string) bool {
+	return func() bool {
+		for _, elem := range strs {
+			if elem == s {
+				return true
+			}
+		}
+		return false
+	}() || func() bool {
+		for _, elem1 := range []float64{1, 2, 3} {
+			if elem1 == a {
+				return true
+			}
+		}
+		return false
+	}() && func() bool {
+		x := []float64{a}
+		for _, elem2 := range [][]float64{[]float64{b}, nums} {
+			if evalInEqual(elem2, x) {
+				return true
+			}
+		}
+		return false
+	}()
+}
+
+func evalInEqual(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,41 @@
+// Code generated by go-expr. DO NOT EDIT.
+
+package gogentest
+
+// EvalLoops evaluates the expression:
+//
+//	count(nums, |x| any(nums, |y| y > x)) + count(filter(strs, |s| s != ""), |x| true)
+func EvalLoops(a float64, b float64, s string, p string, nums []float64, strs []string) float64 {
+	return func() float64 {
+		count := 0.0
+		for _, x := range nums {
+			if func() bool {
+				for _, y := range nums {
+					if y > x {
+						return true
+					}
+				}
+				return false
+			}() {
+				count++
+			}
+		}
+		return count
+	}() + func() float64 {
+		count1 := 0.0
+		for range func() []string {
+			array := []string{}
+			for _, s1 := range strs {
+				if s1 != "" {
+					array = append(array, s1)
+				}
+			}
+			return array
+		}() {
+			if true {
+				count1++
+			}
+		}
+		return count1
+	}()
+}
@@ -0,0 +1,30 @@
+// Code generated by go-expr. DO NOT EDIT.
+
+package gogentest
+
+import (
+	"regexp"
+)
+
+// EvalRegex evaluates the expression:
+//
+//	s =~ "^h.*o$" || regexReplace(s, "l+", "L") == "heLo" || glob("*.go", s) || count(regexSplit(s, ","), |x| true) > 1
+func EvalRegex(a float64, b float64, s string, p string, nums []float64, strs []string) bool {
+	return evalRegexRegexp.MatchString(s) || evalRegexRegexp1.ReplaceAllString(s, "L") == "heLo" || evalRegexRegexp2.MatchString(s) || func() float64 {
+		count := 0.0
+		for range evalRegexRegexp3.Split(s, -1) {
+			if true {
+				count++
+			}
+		}
+		return count
+	}() > 1
+}
+
+var evalRegexRegexp = regexp.MustCompile(`^h.*o$`)
+
+var evalRegexRegexp1 = regexp.MustCompile(`l+`)
+
+var evalRegexRegexp2 = regexp.MustCompile(`(?s)^[^/]*\.go$`)
+
+var evalRegexRegexp3 = regexp.MustCompile(`,`)
@@ -2,7 +2,7 @@ package parser
 
 import (
 	"bytes"
-	"errors"
+	"sort"
 	"strconv"
 	"strings"
 
@@ -10,12 +10,22 @@ import (
 )
 
 var keywords = map[string]int{
-	"and":   kAND,
-	"false": kFALSE,
-	"in":    kIN,
-	"not":   kNOT,
-	"or":    kOR,
-	"true":  kTRUE,
+	"and":     kAND,
+	"false":   kFALSE,
+	"for":     kFOR,
+	"if":      kIF,
+	"in":      kIN,
+	"matches": kMATCHES,
+	"not":     kNOT,
+	"or":      kOR,
+	"true":    kTRUE,
+}
+
+// IsKeyword returns true if name is a keyword, which cannot be used as the
+// name of an input, a constant or a function.
+func IsKeyword(name string) bool {
+	_, ok := keywords[name]
+	return ok
 }
 
 type lex struct {
@@ -23,14 +33,26 @@ type lex struct {
 
 	input *strings.Reader
 	buf   bytes.Buffer
-	pos   int
 	err   error
+
+	// lines are the offsets of the start of each line of the input.
+	lines []int
+
+	// pos is the position of the last token.
+	pos ast.Pos
 }
 
 func newLex(input string) *lex {
-	return &lex{
+	l := &lex{
 		input: strings.NewReader(input),
+		lines: []int{0},
 	}
+	for i, c := range input {
+		if c == '\n' {
+			l.lines = append(l.lines, i+1)
+		}
+	}
+	return l
 }
 
 func (l *lex) Lex(lval *yySymType) int {
@@ -39,13 +61,17 @@ func (l *lex) Lex(lval *yySymType) int {
 
 func (l *lex) scan(lval *yySymType) int {
 	for {
+		offset := int(l.input.Size()) - l.input.Len()
 		r := l.read()
 		if r == 0 {
+			l.pos = l.position(offset)
 			return 0
 		}
 		if isSpace(r) {
 			continue
 		}
+		l.pos = l.position(offset)
+		lval.pos = l.pos
 		switch r {
 		case '&':
 			r = l.read()
@@ -56,15 +82,22 @@ func (l *lex) scan(lval *yySymType) int {
 		case '|':
 			r = l.read()
 			if r != '|' {
-				return LEXERR
+				l.unread()
+				return '|'
 			}
 			return OR
 		case '=':
 			r = l.read()
-			if r != '=' {
+			switch r {
+			case '=':
+				return EQ
+			case '>':
+				return ARROW
+			case '~':
+				return MATCH
+			default:
 				return LEXERR
 			}
-			return EQ
 		case '<':
 			r = l.read()
 			if r != '=' {
@@ -89,7 +122,7 @@ func (l *lex) scan(lval *yySymType) int {
 		case '"':
 			l.unread()
 			return l.scanQuotedString(lval)
-		case '+', '-', '*', '/', ';', '(', ')', ',', '[', ']':
+		case '+', '-', '*', '/', ';', ':', '(', ')', ',', '[', ']':
 			return int(r)
 		default:
 			if isNumber(r) {
@@ -152,7 +185,7 @@ func (l *lex) scanQuotedString(lval *yySymType) int {
 			default:
 				return LEXERR
 			}
-		} else if r == '\n' || r == '\r' {
+		} else if r == '\n' || r == '\r' || r == 0 {
 			return LEXERR
 		} else {
 			l.buf.WriteRune(r)
@@ -217,8 +250,14 @@ func (l *lex) unread() {
 	l.input.UnreadRune()
 }
 
+// position converts an offset in the input to a position.
+func (l *lex) position(offset int) ast.Pos {
+	line := sort.SearchInts(l.lines, offset+1)
+	return ast.Pos{Line: line, Col: offset - l.lines[line-1] + 1}
+}
+
 func (l *lex) Error(s string) {
-	l.err = errors.New(s)
+	l.err = l.pos.Errorf("%v", s)
 }
 
 func isNumber(r rune) bool {
@@ -3,6 +3,7 @@ package parser
 import (
 	"testing"
 
+	"github.com/dcaiafa/go-expr/expr/internal/ast"
 	"github.com/stretchr/testify/require"
 )
 
@@ -51,8 +52,32 @@ func TestLex(t *testing.T) {
 	run("stringEmpty", `""`, STRING, "")
 	run("string0", `"abcd*&fooo"`, STRING, "abcd*&fooo")
 	run("stringEscape", `"\"\\\""`, STRING, `"\"`)
+	run("stringUnterminated", `"abc`, LEXERR)
 	run("true_false", "true false", kTRUE, "true", kFALSE, "false")
 	run("id", `foobar1+_barFoo`, ID, "foobar1", int('+'), 0, ID, "_barFoo")
 	run("mix", `123+foobar`, NUMBER, float64(123), int('+'), 0, ID, "foobar")
+	run("lambda", "x => |y, z|", ID, "x", ARROW, 0, int('|'), 0, ID, "y", int(','), 0, ID, "z", int('|'), 0)
+	run("comprehension", "[x for x in a if x]",
+		int('['), 0, ID, "x", kFOR, "for", ID, "x", kIN, "in", ID, "a", kIF, "if", ID, "x", int(']'), 0)
+	run("quantifier", "any x in a: x", ID, "any", ID, "x", kIN, "in", ID, "a", int(':'), 0, ID, "x")
 	run("in", "seg in [ONE, TWO]", ID, "seg", kIN, "in", int('['), 0, ID, "ONE", int(','), 0, ID, "TWO", int(']'), 0)
+	run("match", `s =~ "a" matches`, ID, "s", MATCH, 0, STRING, "a", kMATCHES, "matches")
+}
+
+func TestLex_Pos(t *testing.T) {
+	l := newLex("a +\n  \"b\"\n\n c")
+	var positions []ast.Pos
+	for {
+		var v yySymType
+		if l.Lex(&v) == 0 {
+			break
+		}
+		positions = append(positions, v.pos)
+	}
+	require.Equal(t, []ast.Pos{
+		{Line: 1, Col: 1},
+		{Line: 1, Col: 3},
+		{Line: 2, Col: 3},
+		{Line: 4, Col: 2},
+	}, positions)
 }
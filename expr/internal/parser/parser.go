@@ -9,9 +9,14 @@ func init() {
 	yyErrorVerbose = true
 }
 
+// Parse parses input into a program. On a syntax error, no program is
+// returned.
 func Parse(input string) (*ast.Program, error) {
 	l := newLex(input)
 	p := yyNewParser()
 	p.Parse(l)
-	return l.Program, l.err
+	if l.err != nil {
+		return nil, l.err
+	}
+	return l.Program, nil
 }
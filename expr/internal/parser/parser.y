@@ -11,24 +11,29 @@ import (
 %union {
   num float64
   str string
+  strs []string
+  typeRef *ast.TypeRef
   ast ast.AST
   expr ast.Expr
+  pos ast.Pos
 }
 
-%token LEXERR
-%token ID kTRUE kFALSE kIN kAND kOR kNOT
+%token LEXERR ARROW MATCH
+%token ID kTRUE kFALSE kIN kAND kOR kNOT kFOR kIF kMATCHES
 %token <num> NUMBER
 %token <str> STRING
 %token <str> ID
 
 %type <ast> exprs opt_params params
 %type <expr> expr binary_expr unary_expr term invocation number
-%type <expr> array_literal array_elems
+%type <expr> array_literal array_elems lambda quantifier
+%type <strs> lambda_params
+%type <typeRef> type_ref
 
 %left OR kOR
 %left AND kAND
 %nonassoc kIN
-%nonassoc '<' LE '>' GE EQ NE
+%nonassoc '<' LE '>' GE EQ NE MATCH kMATCHES
 %left '+' '-'
 %left '*' '/'
 
@@ -42,6 +47,8 @@ exprs: exprs ';' expr                     { $1.(*ast.Program).AddExpr($3.(ast.Ex
      | expr                               { $$ = ast.NewProgram($1.(ast.Expr)) }
 
 expr: binary_expr 
+    | lambda
+    | quantifier
 
 binary_expr: unary_expr
            | binary_expr AND binary_expr  { $$ = ast.NewAndExpr($1, $3) }
@@ -59,22 +66,24 @@ binary_expr: unary_expr
            | binary_expr '*' binary_expr  { $$ = ast.NewBinaryExpr($1, ast.Times, $3) }
            | binary_expr '/' binary_expr  { $$ = ast.NewBinaryExpr($1, ast.Div, $3) }
            | binary_expr kIN binary_expr  { $$ = ast.NewInExpr($1, $3) }
+           | binary_expr MATCH binary_expr     { $$ = ast.NewMatchExpr($1, $3) }
+           | binary_expr kMATCHES binary_expr  { $$ = ast.NewMatchExpr($1, $3) }
 
 unary_expr: '!' term                      { $$ = ast.NewNegateExpr($2) }
           | kNOT term                     { $$ = ast.NewNegateExpr($2) }
           | term    
 
 term: number
-    | STRING                              { $$ = ast.NewLiteralExpr(types.String, $1) }
-    | kTRUE                               { $$ = ast.NewLiteralExpr(types.Bool, true) }
-    | kFALSE                              { $$ = ast.NewLiteralExpr(types.Bool, false) }
+    | STRING                              { $$ = ast.NewLiteralExpr(types.String, $1).At($<pos>1) }
+    | kTRUE                               { $$ = ast.NewLiteralExpr(types.Bool, true).At($<pos>1) }
+    | kFALSE                              { $$ = ast.NewLiteralExpr(types.Bool, false).At($<pos>1) }
     | ID                                  { $$ = ast.NewSimpleRefExpr($1) }
     | invocation
     | array_literal
     | '(' expr ')'                        { $$ = $2 }
 
-number: '-' NUMBER                        { $$ = ast.NewLiteralExpr(types.Number, -$2) } 
-      | NUMBER                            { $$ = ast.NewLiteralExpr(types.Number,  $1) } 
+number: '-' NUMBER                        { $$ = ast.NewLiteralExpr(types.Number, -$2).At($<pos>1) }
+      | NUMBER                            { $$ = ast.NewLiteralExpr(types.Number,  $1).At($<pos>1) }
 
 invocation: term '(' opt_params ')'       { $$ = ast.NewCallExpr($1, $3.(*ast.Params)) }
 
@@ -84,7 +93,24 @@ opt_params: params
 params: params ',' expr                   { $1.(*ast.Params).AddParam($3.(ast.Expr)); $$ = $1 }
       | expr                              { $$ = ast.NewParams($1) }
 
+lambda: ID ARROW expr                     { $$ = ast.NewLambdaExpr([]string{$1}, $3) }
+      | '|' lambda_params '|' expr        { $$ = ast.NewLambdaExpr($2, $4) }
+
+lambda_params: lambda_params ',' ID       { $$ = append($1, $3) }
+             | ID                         { $$ = []string{$1} }
+
+quantifier: ID ID kIN binary_expr ':' expr { $$ = ast.NewQuantifierExpr($1, $2, $4, $6) }
+
 array_literal: '[' array_elems ']'        { $$ = $2 }
+             | '[' ']'                    { $$ = ast.NewEmptyArrayLiteralExpr(nil) }
+             | '[' ']' type_ref           { $$ = ast.NewEmptyArrayLiteralExpr(ast.NewArrayTypeRef($3)) }
+             | '[' expr kFOR ID kIN binary_expr ']'
+                                          { $$ = ast.NewComprehensionExpr($2, $4, $6, nil) }
+             | '[' expr kFOR ID kIN binary_expr kIF expr ']'
+                                          { $$ = ast.NewComprehensionExpr($2, $4, $6, $8) }
 
 array_elems: array_elems ',' expr         { $1.(*ast.ArrayLiteralExpr).AddElement($3.(ast.Expr)); $$= $1 }
      | expr                               { $$ = ast.NewArrayLiteralExpr($1) }
+
+type_ref: ID                              { $$ = ast.NewNamedTypeRef($1) }
+        | '[' ']' type_ref                { $$ = ast.NewArrayTypeRef($3) }
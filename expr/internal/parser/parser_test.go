@@ -12,3 +12,33 @@ func TestParser(t *testing.T) {
 	_, err = Parse(`123+foorbar(hello() + 3425, "hi")*3`)
 	require.NoError(t, err)
 }
+
+// FuzzParse verifies that the parser returns either a program or an error,
+// without panicking, for any input.
+func FuzzParse(f *testing.F) {
+	seeds := []string{
+		`123+foorbar=="elephant"||_id < trob;butt&&less<=3`,
+		`123+foorbar(hello() + 3425, "hi")*3`,
+		`! &&|| > >= < <= == !=`,
+		`and or not`,
+		`3.14.15`,
+		`"\"\\\""`,
+		`x => |y, z|`,
+		`[x for x in a if x]`,
+		`any x in a: x`,
+		`seg in [ONE, TWO]`,
+		`s =~ "a" matches`,
+		`[]number == [][]string`,
+		`reduce(a, |acc, x| acc + x, 0)`,
+		"a\n  + \"b",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		prog, err := Parse(input)
+		if (prog == nil) == (err == nil) {
+			t.Fatalf("Parse(%q) returned program %v and error %v", input, prog, err)
+		}
+	})
+}
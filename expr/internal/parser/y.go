@@ -1,4 +1,4 @@
-// Code generated by goyacc parser.y. DO NOT EDIT.
+// Code generated by goyacc -o y.go parser.y. DO NOT EDIT.
 
 //line parser.y:2
 package parser
@@ -14,35 +14,45 @@ import (
 
 //line parser.y:11
 type yySymType struct {
-	yys  int
-	num  float64
-	str  string
-	ast  ast.AST
-	expr ast.Expr
+	yys     int
+	num     float64
+	str     string
+	strs    []string
+	typeRef *ast.TypeRef
+	ast     ast.AST
+	expr    ast.Expr
+	pos     ast.Pos
 }
 
 const LEXERR = 57346
-const ID = 57347
-const kTRUE = 57348
-const kFALSE = 57349
-const kIN = 57350
-const kAND = 57351
-const kOR = 57352
-const kNOT = 57353
-const NUMBER = 57354
-const STRING = 57355
-const OR = 57356
-const AND = 57357
-const LE = 57358
-const GE = 57359
-const EQ = 57360
-const NE = 57361
+const ARROW = 57347
+const MATCH = 57348
+const ID = 57349
+const kTRUE = 57350
+const kFALSE = 57351
+const kIN = 57352
+const kAND = 57353
+const kOR = 57354
+const kNOT = 57355
+const kFOR = 57356
+const kIF = 57357
+const kMATCHES = 57358
+const NUMBER = 57359
+const STRING = 57360
+const OR = 57361
+const AND = 57362
+const LE = 57363
+const GE = 57364
+const EQ = 57365
+const NE = 57366
 
 var yyToknames = [...]string{
 	"$end",
 	"error",
 	"$unk",
 	"LEXERR",
+	"ARROW",
+	"MATCH",
 	"ID",
 	"kTRUE",
 	"kFALSE",
@@ -50,6 +60,9 @@ var yyToknames = [...]string{
 	"kAND",
 	"kOR",
 	"kNOT",
+	"kFOR",
+	"kIF",
+	"kMATCHES",
 	"NUMBER",
 	"STRING",
 	"OR",
@@ -69,9 +82,12 @@ var yyToknames = [...]string{
 	"'('",
 	"')'",
 	"','",
+	"'|'",
+	"':'",
 	"'['",
 	"']'",
 }
+
 var yyStatenames = [...]string{}
 
 const yyEofCode = 1
@@ -79,155 +95,222 @@ const yyErrCode = 2
 const yyInitialStackSize = 16
 
 //line yacctab:1
-var yyExca = [...]int{
+var yyExca = [...]int8{
 	-1, 1,
 	1, -1,
 	-2, 0,
-	-1, 48,
-	16, 0,
-	17, 0,
-	18, 0,
-	19, 0,
-	20, 0,
-	21, 0,
-	-2, 10,
-	-1, 49,
-	16, 0,
-	17, 0,
-	18, 0,
-	19, 0,
-	20, 0,
-	21, 0,
-	-2, 11,
-	-1, 50,
+	-1, 59,
+	6, 0,
 	16, 0,
-	17, 0,
-	18, 0,
-	19, 0,
-	20, 0,
 	21, 0,
+	22, 0,
+	23, 0,
+	24, 0,
+	25, 0,
+	26, 0,
 	-2, 12,
-	-1, 51,
+	-1, 60,
+	6, 0,
 	16, 0,
-	17, 0,
-	18, 0,
-	19, 0,
-	20, 0,
 	21, 0,
+	22, 0,
+	23, 0,
+	24, 0,
+	25, 0,
+	26, 0,
 	-2, 13,
-	-1, 52,
+	-1, 61,
+	6, 0,
 	16, 0,
-	17, 0,
-	18, 0,
-	19, 0,
-	20, 0,
 	21, 0,
+	22, 0,
+	23, 0,
+	24, 0,
+	25, 0,
+	26, 0,
 	-2, 14,
-	-1, 53,
+	-1, 62,
+	6, 0,
 	16, 0,
-	17, 0,
-	18, 0,
-	19, 0,
-	20, 0,
 	21, 0,
+	22, 0,
+	23, 0,
+	24, 0,
+	25, 0,
+	26, 0,
 	-2, 15,
-	-1, 58,
-	8, 0,
-	-2, 20,
+	-1, 63,
+	6, 0,
+	16, 0,
+	21, 0,
+	22, 0,
+	23, 0,
+	24, 0,
+	25, 0,
+	26, 0,
+	-2, 16,
+	-1, 64,
+	6, 0,
+	16, 0,
+	21, 0,
+	22, 0,
+	23, 0,
+	24, 0,
+	25, 0,
+	26, 0,
+	-2, 17,
+	-1, 69,
+	10, 0,
+	-2, 22,
+	-1, 70,
+	6, 0,
+	16, 0,
+	21, 0,
+	22, 0,
+	23, 0,
+	24, 0,
+	25, 0,
+	26, 0,
+	-2, 23,
+	-1, 71,
+	6, 0,
+	16, 0,
+	21, 0,
+	22, 0,
+	23, 0,
+	24, 0,
+	25, 0,
+	26, 0,
+	-2, 24,
 }
 
 const yyPrivate = 57344
 
-const yyLast = 142
-
-var yyAct = [...]int{
-
-	3, 4, 25, 26, 27, 28, 29, 30, 31, 32,
-	33, 34, 64, 66, 63, 40, 65, 39, 62, 38,
-	42, 43, 20, 44, 45, 46, 47, 48, 49, 50,
-	51, 52, 53, 54, 55, 56, 57, 58, 1, 61,
-	13, 11, 12, 33, 34, 41, 7, 18, 10, 31,
-	32, 33, 34, 8, 15, 9, 14, 5, 17, 60,
-	36, 37, 6, 16, 59, 67, 19, 68, 35, 22,
-	24, 2, 0, 0, 23, 21, 25, 26, 27, 28,
-	29, 30, 31, 32, 33, 34, 13, 11, 12, 0,
-	0, 0, 0, 18, 10, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 17, 0, 35, 22, 0, 16,
-	0, 0, 19, 21, 25, 26, 27, 28, 29, 30,
-	31, 32, 33, 34, 35, 0, 0, 0, 0, 0,
-	0, 0, 25, 26, 27, 28, 29, 30, 31, 32,
-	33, 34,
+const yyLast = 316
+
+var yyAct = [...]int8{
+	4, 82, 81, 103, 92, 90, 80, 75, 74, 89,
+	79, 48, 83, 34, 35, 36, 37, 36, 37, 50,
+	23, 85, 3, 97, 73, 55, 56, 57, 58, 59,
+	60, 61, 62, 63, 64, 65, 66, 67, 68, 69,
+	70, 71, 49, 84, 12, 53, 54, 41, 93, 42,
+	88, 44, 1, 43, 6, 45, 47, 5, 51, 18,
+	13, 17, 7, 39, 72, 77, 76, 38, 25, 27,
+	2, 78, 101, 40, 86, 0, 26, 24, 28, 29,
+	30, 31, 32, 33, 34, 35, 36, 37, 0, 0,
+	0, 0, 0, 0, 96, 0, 100, 87, 99, 0,
+	0, 0, 0, 0, 91, 0, 0, 0, 0, 0,
+	0, 0, 0, 95, 0, 39, 0, 98, 0, 38,
+	25, 27, 0, 0, 102, 40, 0, 0, 26, 24,
+	28, 29, 30, 31, 32, 33, 34, 35, 36, 37,
+	0, 8, 15, 16, 0, 0, 94, 11, 0, 0,
+	0, 21, 14, 8, 15, 16, 0, 0, 0, 11,
+	0, 0, 20, 21, 14, 0, 10, 19, 0, 0,
+	9, 0, 22, 52, 20, 46, 15, 16, 10, 19,
+	0, 11, 9, 0, 22, 21, 14, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 20, 0, 39, 0,
+	10, 19, 38, 25, 27, 0, 22, 0, 40, 0,
+	0, 26, 24, 28, 29, 30, 31, 32, 33, 34,
+	35, 36, 37, 46, 15, 16, 0, 0, 0, 0,
+	0, 0, 0, 21, 14, 0, 0, 0, 0, 0,
+	0, 39, 0, 0, 20, 38, 25, 0, 0, 19,
+	0, 40, 0, 0, 22, 24, 28, 29, 30, 31,
+	32, 33, 34, 35, 36, 37, 39, 0, 0, 0,
+	38, 0, 0, 0, 0, 0, 40, 0, 0, 0,
+	0, 28, 29, 30, 31, 32, 33, 34, 35, 36,
+	37, 39, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 40, 0, 0, 0, 0, 28, 29, 30, 31,
+	32, 33, 34, 35, 36, 37,
 }
-var yyPact = [...]int{
-
-	35, -1000, -4, -1000, 60, -1000, 81, 81, -9, -1000,
-	-1000, -1000, -1000, -1000, -1000, -1000, 35, 3, -1000, 35,
-	35, 35, 35, 35, 35, 35, 35, 35, 35, 35,
-	35, 35, 35, 35, 35, 35, -9, -9, 35, -11,
-	-1000, -18, -1000, -1000, 116, 116, 98, 98, 27, 27,
-	27, 27, 27, 27, 19, 19, -1000, -1000, -14, -13,
-	-17, -1000, -1000, -1000, 35, -1000, 35, -1000, -1000,
+
+var yyPact = [...]int16{
+	146, -32768, -11, -32768, 192, -32768, -32768, -32768, 42, 44,
+	216, 216, -22, -32768, -32768, -32768, -32768, -32768, -32768, 146,
+	2, -32768, 134, 146, 168, 168, 168, 168, 168, 168,
+	168, 168, 168, 168, 168, 168, 168, 168, 168, 168,
+	168, 146, 14, -28, -32768, -22, -32768, -22, 146, -24,
+	-32768, -33, 5, 7, -32768, 260, 260, 235, 235, -14,
+	-14, -14, -14, -14, -14, -12, -12, -32768, -32768, 285,
+	-14, -14, -32768, 168, 146, 43, -25, -30, -32768, -32768,
+	-32768, 146, -32768, -32768, -35, 41, 109, -32768, -32768, -32768,
+	146, -32768, 5, 13, 146, -32768, -32768, 168, -32768, 57,
+	-32768, 146, -36, -32768,
 }
-var yyPgo = [...]int{
 
-	0, 71, 64, 59, 0, 1, 57, 53, 56, 55,
-	54, 45, 38,
+var yyPgo = [...]int8{
+	0, 70, 66, 65, 22, 0, 62, 44, 61, 60,
+	59, 58, 57, 54, 53, 1, 52,
 }
-var yyR1 = [...]int{
 
-	0, 12, 1, 1, 4, 5, 5, 5, 5, 5,
+var yyR1 = [...]int8{
+	0, 16, 1, 1, 4, 4, 4, 5, 5, 5,
 	5, 5, 5, 5, 5, 5, 5, 5, 5, 5,
-	5, 6, 6, 6, 7, 7, 7, 7, 7, 7,
-	7, 7, 9, 9, 8, 2, 2, 3, 3, 10,
-	11, 11,
+	5, 5, 5, 5, 5, 6, 6, 6, 7, 7,
+	7, 7, 7, 7, 7, 7, 9, 9, 8, 2,
+	2, 3, 3, 12, 12, 14, 14, 13, 10, 10,
+	10, 10, 10, 11, 11, 15, 15,
 }
-var yyR2 = [...]int{
 
-	0, 1, 3, 1, 1, 1, 3, 3, 3, 3,
+var yyR2 = [...]int8{
+	0, 1, 3, 1, 1, 1, 1, 1, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 2, 2, 1, 1, 1, 1, 1, 1, 1,
-	1, 3, 2, 1, 4, 1, 0, 3, 1, 3,
-	3, 1,
+	3, 3, 3, 3, 3, 2, 2, 1, 1, 1,
+	1, 1, 1, 1, 1, 3, 2, 1, 4, 1,
+	0, 3, 1, 3, 4, 3, 1, 6, 3, 2,
+	3, 7, 9, 3, 1, 1, 3,
 }
-var yyChk = [...]int{
-
-	-1000, -12, -1, -4, -5, -6, 27, 11, -7, -9,
-	13, 6, 7, 5, -8, -10, 28, 23, 12, 31,
-	26, 15, 9, 14, 10, 16, 17, 18, 19, 20,
-	21, 22, 23, 24, 25, 8, -7, -7, 28, -4,
-	12, -11, -4, -4, -5, -5, -5, -5, -5, -5,
-	-5, -5, -5, -5, -5, -5, -5, -5, -5, -2,
-	-3, -4, 29, 32, 30, 29, 30, -4, -4,
+
+var yyChk = [...]int16{
+	-32768, -16, -1, -4, -5, -12, -13, -6, 7, 36,
+	32, 13, -7, -9, 18, 8, 9, -8, -10, 33,
+	28, 17, 38, 31, 20, 11, 19, 12, 21, 22,
+	23, 24, 25, 26, 27, 28, 29, 30, 10, 6,
+	16, 5, 7, -14, 7, -7, 7, -7, 33, -4,
+	17, -11, 39, -4, -4, -5, -5, -5, -5, -5,
+	-5, -5, -5, -5, -5, -5, -5, -5, -5, -5,
+	-5, -5, -4, 10, 36, 35, -2, -3, -4, 34,
+	39, 35, -15, 7, 38, 14, -5, -4, 7, 34,
+	35, -4, 39, 7, 37, -4, -15, 10, -4, -5,
+	39, 15, -4, 39,
 }
-var yyDef = [...]int{
 
-	0, -2, 1, 3, 4, 5, 0, 0, 23, 24,
-	25, 26, 27, 28, 29, 30, 0, 0, 33, 0,
+var yyDef = [...]int8{
+	0, -2, 1, 3, 4, 5, 6, 7, 32, 0,
+	0, 0, 27, 28, 29, 30, 31, 33, 34, 0,
+	0, 37, 0, 0, 0, 0, 0, 0, 0, 0,
 	0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-	0, 0, 0, 0, 0, 0, 21, 22, 36, 0,
-	32, 0, 41, 2, 6, 7, 8, 9, -2, -2,
-	-2, -2, -2, -2, 16, 17, 18, 19, -2, 0,
-	35, 38, 31, 39, 0, 34, 0, 40, 37,
+	0, 0, 0, 0, 46, 25, 32, 26, 40, 0,
+	36, 0, 49, 54, 2, 8, 9, 10, 11, -2,
+	-2, -2, -2, -2, -2, 18, 19, 20, 21, -2,
+	-2, -2, 43, 0, 0, 0, 0, 39, 42, 35,
+	48, 0, 50, 55, 0, 0, 0, 44, 45, 38,
+	0, 53, 0, 0, 0, 41, 56, 0, 47, 0,
+	51, 0, 0, 52,
 }
-var yyTok1 = [...]int{
 
+var yyTok1 = [...]int8{
 	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 27, 3, 3, 3, 3, 3, 3,
-	28, 29, 24, 22, 30, 23, 3, 25, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 3, 26,
-	16, 3, 18, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 32, 3, 3, 3, 3, 3, 3,
+	33, 34, 29, 27, 35, 28, 3, 30, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 37, 31,
+	21, 3, 23, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 38, 3, 39, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 31, 3, 32,
+	3, 3, 3, 3, 36,
 }
-var yyTok2 = [...]int{
 
+var yyTok2 = [...]int8{
 	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
-	12, 13, 14, 15, 17, 19, 20, 21,
+	12, 13, 14, 15, 16, 17, 18, 19, 20, 22,
+	24, 25, 26,
 }
-var yyTok3 = [...]int{
+
+var yyTok3 = [...]int8{
 	0,
 }
 
@@ -270,7 +353,7 @@ func yyNewParser() yyParser {
 	return &yyParserImpl{}
 }
 
-const yyFlag = -1000
+const yyFlag = -32768
 
 func yyTokname(c int) string {
 	if c >= 1 && c-1 < len(yyToknames) {
@@ -309,9 +392,9 @@ func yyErrorMessage(state, lookAhead int) string {
 	expected := make([]int, 0, 4)
 
 	// Look for shiftable tokens.
-	base := yyPact[state]
+	base := int(yyPact[state])
 	for tok := TOKSTART; tok-1 < len(yyToknames); tok++ {
-		if n := base + tok; n >= 0 && n < yyLast && yyChk[yyAct[n]] == tok {
+		if n := base + tok; n >= 0 && n < yyLast && int(yyChk[int(yyAct[n])]) == tok {
 			if len(expected) == cap(expected) {
 				return res
 			}
@@ -321,13 +404,13 @@ func yyErrorMessage(state, lookAhead int) string {
 
 	if yyDef[state] == -2 {
 		i := 0
-		for yyExca[i] != -1 || yyExca[i+1] != state {
+		for yyExca[i] != -1 || int(yyExca[i+1]) != state {
 			i += 2
 		}
 
 		// Look for tokens that we accept or reduce.
 		for i += 2; yyExca[i] >= 0; i += 2 {
-			tok := yyExca[i]
+			tok := int(yyExca[i])
 			if tok < TOKSTART || yyExca[i+1] == 0 {
 				continue
 			}
@@ -358,30 +441,30 @@ func yylex1(lex yyLexer, lval *yySymType) (char, token int) {
 	token = 0
 	char = lex.Lex(lval)
 	if char <= 0 {
-		token = yyTok1[0]
+		token = int(yyTok1[0])
 		goto out
 	}
 	if char < len(yyTok1) {
-		token = yyTok1[char]
+		token = int(yyTok1[char])
 		goto out
 	}
 	if char >= yyPrivate {
 		if char < yyPrivate+len(yyTok2) {
-			token = yyTok2[char-yyPrivate]
+			token = int(yyTok2[char-yyPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(yyTok3); i += 2 {
-		token = yyTok3[i+0]
+		token = int(yyTok3[i+0])
 		if token == char {
-			token = yyTok3[i+1]
+			token = int(yyTok3[i+1])
 			goto out
 		}
 	}
 
 out:
 	if token == 0 {
-		token = yyTok2[1] /* unknown char */
+		token = int(yyTok2[1]) /* unknown char */
 	}
 	if yyDebug >= 3 {
 		__yyfmt__.Printf("lex %s(%d)\n", yyTokname(token), uint(char))
@@ -436,7 +519,7 @@ yystack:
 	yyS[yyp].yys = yystate
 
 yynewstate:
-	yyn = yyPact[yystate]
+	yyn = int(yyPact[yystate])
 	if yyn <= yyFlag {
 		goto yydefault /* simple state */
 	}
@@ -447,8 +530,8 @@ yynewstate:
 	if yyn < 0 || yyn >= yyLast {
 		goto yydefault
 	}
-	yyn = yyAct[yyn]
-	if yyChk[yyn] == yytoken { /* valid shift */
+	yyn = int(yyAct[yyn])
+	if int(yyChk[yyn]) == yytoken { /* valid shift */
 		yyrcvr.char = -1
 		yytoken = -1
 		yyVAL = yyrcvr.lval
@@ -461,7 +544,7 @@ yynewstate:
 
 yydefault:
 	/* default state action */
-	yyn = yyDef[yystate]
+	yyn = int(yyDef[yystate])
 	if yyn == -2 {
 		if yyrcvr.char < 0 {
 			yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
@@ -470,18 +553,18 @@ yydefault:
 		/* look through exception table */
 		xi := 0
 		for {
-			if yyExca[xi+0] == -1 && yyExca[xi+1] == yystate {
+			if yyExca[xi+0] == -1 && int(yyExca[xi+1]) == yystate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			yyn = yyExca[xi+0]
+			yyn = int(yyExca[xi+0])
 			if yyn < 0 || yyn == yytoken {
 				break
 			}
 		}
-		yyn = yyExca[xi+1]
+		yyn = int(yyExca[xi+1])
 		if yyn < 0 {
 			goto ret0
 		}
@@ -503,10 +586,10 @@ yydefault:
 
 			/* find a state where "error" is a legal shift action */
 			for yyp >= 0 {
-				yyn = yyPact[yyS[yyp].yys] + yyErrCode
+				yyn = int(yyPact[yyS[yyp].yys]) + yyErrCode
 				if yyn >= 0 && yyn < yyLast {
-					yystate = yyAct[yyn] /* simulate a shift of "error" */
-					if yyChk[yystate] == yyErrCode {
+					yystate = int(yyAct[yyn]) /* simulate a shift of "error" */
+					if int(yyChk[yystate]) == yyErrCode {
 						goto yystack
 					}
 				}
@@ -542,7 +625,7 @@ yydefault:
 	yypt := yyp
 	_ = yypt // guard against "declared and not used"
 
-	yyp -= yyR2[yyn]
+	yyp -= int(yyR2[yyn])
 	// yyp is now the index of $0. Perform the default action. Iff the
 	// reduced production is ε, $1 is possibly out of range.
 	if yyp+1 >= len(yyS) {
@@ -553,16 +636,16 @@ yydefault:
 	yyVAL = yyS[yyp+1]
 
 	/* consult goto table to find next state */
-	yyn = yyR1[yyn]
-	yyg := yyPgo[yyn]
+	yyn = int(yyR1[yyn])
+	yyg := int(yyPgo[yyn])
 	yyj := yyg + yyS[yyp].yys + 1
 
 	if yyj >= yyLast {
-		yystate = yyAct[yyg]
+		yystate = int(yyAct[yyg])
 	} else {
-		yystate = yyAct[yyj]
-		if yyChk[yystate] != -yyn {
-			yystate = yyAct[yyg]
+		yystate = int(yyAct[yyj])
+		if int(yyChk[yystate]) != -yyn {
+			yystate = int(yyAct[yyg])
 		}
 	}
 	// dummy call; replaced with literal code
@@ -570,210 +653,288 @@ yydefault:
 
 	case 1:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.y:39
+//line parser.y:44
 		{
 			yylex.(*lex).Program = yyDollar[1].ast.(*ast.Program)
 		}
 	case 2:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:41
+//line parser.y:46
 		{
 			yyDollar[1].ast.(*ast.Program).AddExpr(yyDollar[3].expr.(ast.Expr))
 		}
 	case 3:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.y:42
+//line parser.y:47
 		{
 			yyVAL.ast = ast.NewProgram(yyDollar[1].expr.(ast.Expr))
 		}
-	case 6:
+	case 8:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:47
+//line parser.y:54
 		{
 			yyVAL.expr = ast.NewAndExpr(yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 7:
+	case 9:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:48
+//line parser.y:55
 		{
 			yyVAL.expr = ast.NewAndExpr(yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 8:
+	case 10:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:49
+//line parser.y:56
 		{
 			yyVAL.expr = ast.NewOrExpr(yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 9:
+	case 11:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:50
+//line parser.y:57
 		{
 			yyVAL.expr = ast.NewOrExpr(yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 10:
+	case 12:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:51
+//line parser.y:58
 		{
 			yyVAL.expr = ast.NewBinaryExpr(yyDollar[1].expr, ast.Lt, yyDollar[3].expr)
 		}
-	case 11:
+	case 13:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:52
+//line parser.y:59
 		{
 			yyVAL.expr = ast.NewBinaryExpr(yyDollar[1].expr, ast.Le, yyDollar[3].expr)
 		}
-	case 12:
+	case 14:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:53
+//line parser.y:60
 		{
 			yyVAL.expr = ast.NewBinaryExpr(yyDollar[1].expr, ast.Gt, yyDollar[3].expr)
 		}
-	case 13:
+	case 15:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:54
+//line parser.y:61
 		{
 			yyVAL.expr = ast.NewBinaryExpr(yyDollar[1].expr, ast.Ge, yyDollar[3].expr)
 		}
-	case 14:
+	case 16:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:55
+//line parser.y:62
 		{
 			yyVAL.expr = ast.NewBinaryExpr(yyDollar[1].expr, ast.Eq, yyDollar[3].expr)
 		}
-	case 15:
+	case 17:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:56
+//line parser.y:63
 		{
 			yyVAL.expr = ast.NewBinaryExpr(yyDollar[1].expr, ast.Ne, yyDollar[3].expr)
 		}
-	case 16:
+	case 18:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:57
+//line parser.y:64
 		{
 			yyVAL.expr = ast.NewBinaryExpr(yyDollar[1].expr, ast.Plus, yyDollar[3].expr)
 		}
-	case 17:
+	case 19:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:58
+//line parser.y:65
 		{
 			yyVAL.expr = ast.NewBinaryExpr(yyDollar[1].expr, ast.Minus, yyDollar[3].expr)
 		}
-	case 18:
+	case 20:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:59
+//line parser.y:66
 		{
 			yyVAL.expr = ast.NewBinaryExpr(yyDollar[1].expr, ast.Times, yyDollar[3].expr)
 		}
-	case 19:
+	case 21:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:60
+//line parser.y:67
 		{
 			yyVAL.expr = ast.NewBinaryExpr(yyDollar[1].expr, ast.Div, yyDollar[3].expr)
 		}
-	case 20:
+	case 22:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:61
+//line parser.y:68
 		{
 			yyVAL.expr = ast.NewInExpr(yyDollar[1].expr, yyDollar[3].expr)
 		}
-	case 21:
+	case 23:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line parser.y:69
+		{
+			yyVAL.expr = ast.NewMatchExpr(yyDollar[1].expr, yyDollar[3].expr)
+		}
+	case 24:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line parser.y:70
+		{
+			yyVAL.expr = ast.NewMatchExpr(yyDollar[1].expr, yyDollar[3].expr)
+		}
+	case 25:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.y:63
+//line parser.y:72
 		{
 			yyVAL.expr = ast.NewNegateExpr(yyDollar[2].expr)
 		}
-	case 22:
+	case 26:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.y:64
+//line parser.y:73
 		{
 			yyVAL.expr = ast.NewNegateExpr(yyDollar[2].expr)
 		}
-	case 25:
+	case 29:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.y:68
+//line parser.y:77
 		{
-			yyVAL.expr = ast.NewLiteralExpr(types.String, yyDollar[1].str)
+			yyVAL.expr = ast.NewLiteralExpr(types.String, yyDollar[1].str).At(yyDollar[1].pos)
 		}
-	case 26:
+	case 30:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.y:69
+//line parser.y:78
 		{
-			yyVAL.expr = ast.NewLiteralExpr(types.Bool, true)
+			yyVAL.expr = ast.NewLiteralExpr(types.Bool, true).At(yyDollar[1].pos)
 		}
-	case 27:
+	case 31:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.y:70
+//line parser.y:79
 		{
-			yyVAL.expr = ast.NewLiteralExpr(types.Bool, false)
+			yyVAL.expr = ast.NewLiteralExpr(types.Bool, false).At(yyDollar[1].pos)
 		}
-	case 28:
+	case 32:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.y:71
+//line parser.y:80
 		{
 			yyVAL.expr = ast.NewSimpleRefExpr(yyDollar[1].str)
 		}
-	case 31:
+	case 35:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:74
+//line parser.y:83
 		{
 			yyVAL.expr = yyDollar[2].expr
 		}
-	case 32:
+	case 36:
 		yyDollar = yyS[yypt-2 : yypt+1]
-//line parser.y:76
+//line parser.y:85
 		{
-			yyVAL.expr = ast.NewLiteralExpr(types.Number, -yyDollar[2].num)
+			yyVAL.expr = ast.NewLiteralExpr(types.Number, -yyDollar[2].num).At(yyDollar[1].pos)
 		}
-	case 33:
+	case 37:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.y:77
+//line parser.y:86
 		{
-			yyVAL.expr = ast.NewLiteralExpr(types.Number, yyDollar[1].num)
+			yyVAL.expr = ast.NewLiteralExpr(types.Number, yyDollar[1].num).At(yyDollar[1].pos)
 		}
-	case 34:
+	case 38:
 		yyDollar = yyS[yypt-4 : yypt+1]
-//line parser.y:79
+//line parser.y:88
 		{
 			yyVAL.expr = ast.NewCallExpr(yyDollar[1].expr, yyDollar[3].ast.(*ast.Params))
 		}
-	case 36:
+	case 40:
 		yyDollar = yyS[yypt-0 : yypt+1]
-//line parser.y:82
+//line parser.y:91
 		{
 			yyVAL.ast = &ast.Params{}
 		}
-	case 37:
+	case 41:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:84
+//line parser.y:93
 		{
 			yyDollar[1].ast.(*ast.Params).AddParam(yyDollar[3].expr.(ast.Expr))
 			yyVAL.ast = yyDollar[1].ast
 		}
-	case 38:
+	case 42:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.y:85
+//line parser.y:94
 		{
 			yyVAL.ast = ast.NewParams(yyDollar[1].expr)
 		}
-	case 39:
+	case 43:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:87
+//line parser.y:96
+		{
+			yyVAL.expr = ast.NewLambdaExpr([]string{yyDollar[1].str}, yyDollar[3].expr)
+		}
+	case 44:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line parser.y:97
+		{
+			yyVAL.expr = ast.NewLambdaExpr(yyDollar[2].strs, yyDollar[4].expr)
+		}
+	case 45:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line parser.y:99
+		{
+			yyVAL.strs = append(yyDollar[1].strs, yyDollar[3].str)
+		}
+	case 46:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line parser.y:100
+		{
+			yyVAL.strs = []string{yyDollar[1].str}
+		}
+	case 47:
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line parser.y:102
+		{
+			yyVAL.expr = ast.NewQuantifierExpr(yyDollar[1].str, yyDollar[2].str, yyDollar[4].expr, yyDollar[6].expr)
+		}
+	case 48:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line parser.y:104
 		{
 			yyVAL.expr = yyDollar[2].expr
 		}
-	case 40:
+	case 49:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line parser.y:105
+		{
+			yyVAL.expr = ast.NewEmptyArrayLiteralExpr(nil)
+		}
+	case 50:
 		yyDollar = yyS[yypt-3 : yypt+1]
-//line parser.y:89
+//line parser.y:106
+		{
+			yyVAL.expr = ast.NewEmptyArrayLiteralExpr(ast.NewArrayTypeRef(yyDollar[3].typeRef))
+		}
+	case 51:
+		yyDollar = yyS[yypt-7 : yypt+1]
+//line parser.y:108
+		{
+			yyVAL.expr = ast.NewComprehensionExpr(yyDollar[2].expr, yyDollar[4].str, yyDollar[6].expr, nil)
+		}
+	case 52:
+		yyDollar = yyS[yypt-9 : yypt+1]
+//line parser.y:110
+		{
+			yyVAL.expr = ast.NewComprehensionExpr(yyDollar[2].expr, yyDollar[4].str, yyDollar[6].expr, yyDollar[8].expr)
+		}
+	case 53:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line parser.y:112
 		{
 			yyDollar[1].expr.(*ast.ArrayLiteralExpr).AddElement(yyDollar[3].expr.(ast.Expr))
 			yyVAL.expr = yyDollar[1].expr
 		}
-	case 41:
+	case 54:
 		yyDollar = yyS[yypt-1 : yypt+1]
-//line parser.y:90
+//line parser.y:113
 		{
 			yyVAL.expr = ast.NewArrayLiteralExpr(yyDollar[1].expr)
 		}
+	case 55:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line parser.y:115
+		{
+			yyVAL.typeRef = ast.NewNamedTypeRef(yyDollar[1].str)
+		}
+	case 56:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line parser.y:116
+		{
+			yyVAL.typeRef = ast.NewArrayTypeRef(yyDollar[3].typeRef)
+		}
 	}
 	goto yystack /* stack new state and value */
 }
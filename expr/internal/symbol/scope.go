@@ -5,6 +5,7 @@ import (
 )
 
 type Scope struct {
+	parent  *Scope
 	symbols map[string]Symbol
 }
 
@@ -14,6 +15,18 @@ func NewScope() *Scope {
 	}
 }
 
+// NewNestedScope creates a scope whose lookups fall back to parent when a
+// name is not defined locally.
+func NewNestedScope(parent *Scope) *Scope {
+	s := NewScope()
+	s.parent = parent
+	return s
+}
+
+func (s *Scope) Parent() *Scope {
+	return s.parent
+}
+
 func (s *Scope) Add(sym Symbol) error {
 	if _, ok := s.symbols[sym.Name()]; ok {
 		return fmt.Errorf("Scope already has a symbol named %v", sym.Name())
@@ -23,13 +36,22 @@ func (s *Scope) Add(sym Symbol) error {
 }
 
 func (s *Scope) Has(name string) bool {
-	return s.symbols[name] != nil
+	return s.lookup(name) != nil
 }
 
 func (s *Scope) Get(name string) (Symbol, error) {
-	sym := s.symbols[name]
+	sym := s.lookup(name)
 	if sym == nil {
 		return nil, fmt.Errorf("undefined: %v", name)
 	}
 	return sym, nil
 }
+
+func (s *Scope) lookup(name string) Symbol {
+	for scope := s; scope != nil; scope = scope.parent {
+		if sym := scope.symbols[name]; sym != nil {
+			return sym
+		}
+	}
+	return nil
+}
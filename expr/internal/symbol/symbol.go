@@ -1,6 +1,8 @@
 package symbol
 
 import (
+	"fmt"
+
 	"github.com/dcaiafa/go-expr/expr/runtime"
 	"github.com/dcaiafa/go-expr/expr/types"
 )
@@ -39,6 +41,10 @@ func NewConstSymbol(name string, typ types.Type, constIndex int) *ConstSymbol {
 	}
 }
 
+func (s *ConstSymbol) ConstIndex() int {
+	return s.constIndex
+}
+
 func (s *ConstSymbol) EmitAccess(builder *runtime.Builder) {
 	builder.EmitLoadConst(s.constIndex)
 }
@@ -58,6 +64,173 @@ func NewInputSymbol(name string, typ types.Type, inputIndex int) *InputSymbol {
 	}
 }
 
+func (s *InputSymbol) InputIndex() int {
+	return s.inputIndex
+}
+
 func (s *InputSymbol) EmitAccess(builder *runtime.Builder) {
 	builder.EmitLoadInput(s.inputIndex)
 }
+
+// LocalSymbol is a variable introduced by an expression, such as a lambda
+// parameter. Its type is not known when the symbol is created; it is set
+// during type checking by the expression that binds it.
+type LocalSymbol struct {
+	symbolImpl
+	localIndex int
+}
+
+func NewLocalSymbol(name string, localIndex int) *LocalSymbol {
+	return &LocalSymbol{
+		symbolImpl: symbolImpl{
+			name: name,
+		},
+		localIndex: localIndex,
+	}
+}
+
+func (s *LocalSymbol) SetType(typ types.Type) {
+	s.typ = typ
+}
+
+func (s *LocalSymbol) LocalIndex() int {
+	return s.localIndex
+}
+
+func (s *LocalSymbol) EmitAccess(builder *runtime.Builder) {
+	builder.EmitLoadLocal(s.localIndex)
+}
+
+// Overload is one of the implementations of a function symbol.
+type Overload struct {
+	Type       *types.Function
+	Func       *runtime.Func
+	ConstIndex int
+
+	// Defaults are the default values of the optional parameters.
+	Defaults []Default
+
+	// Pure indicates that the function always returns the same result for
+	// the same arguments and has no side effects, so calls with constant
+	// arguments can be evaluated at compile time.
+	Pure bool
+
+	// instances are the constant indices of the instances of a generic
+	// overload, by function type.
+	instances map[string]int
+}
+
+// Default is the default value of an optional parameter.
+type Default struct {
+	Value      runtime.Value
+	ConstIndex int
+}
+
+// FuncSymbol is a function registered by the host. A function can have
+// multiple overloads with different parameter types; the overload is selected
+// at each call site based on the argument types.
+type FuncSymbol struct {
+	name      string
+	Overloads []*Overload
+}
+
+func NewFuncSymbol(name string) *FuncSymbol {
+	return &FuncSymbol{name: name}
+}
+
+func (s *FuncSymbol) Name() string {
+	return s.name
+}
+
+// Type returns the type of the function if it has a single overload, or nil
+// if the function is overloaded.
+func (s *FuncSymbol) Type() types.Type {
+	if len(s.Overloads) != 1 {
+		return nil
+	}
+	return s.Overloads[0].Type
+}
+
+// EmitAccess emits access to the function if it has a single overload. Access
+// to a specific overload must be emitted with EmitOverloadAccess.
+func (s *FuncSymbol) EmitAccess(builder *runtime.Builder) {
+	overload := s.Overloads[0]
+	s.EmitOverloadAccess(builder, overload, overload.Type)
+}
+
+// EmitOverloadAccess emits access to an overload of the function. fnType is
+// the type of the overload, or its instance if the overload is generic.
+func (s *FuncSymbol) EmitOverloadAccess(
+	builder *runtime.Builder,
+	overload *Overload,
+	fnType *types.Function,
+) {
+	builder.EmitLoadConst(s.OverloadConst(builder, overload, fnType))
+}
+
+// OverloadConst returns the index of the constant that holds an overload of
+// the function. fnType is the type of the overload, or its instance if the
+// overload is generic. The constants of the instances are created on demand.
+func (s *FuncSymbol) OverloadConst(
+	builder *runtime.Builder,
+	overload *Overload,
+	fnType *types.Function,
+) int {
+	if fnType == overload.Type {
+		return overload.ConstIndex
+	}
+
+	key := fnType.String()
+	constIndex, ok := overload.instances[key]
+	if !ok {
+		fn := s.OverloadFunc(overload, fnType)
+		constIndex = builder.NewConst(runtime.NewObject(fnType, fn))
+		if overload.instances == nil {
+			overload.instances = make(map[string]int)
+		}
+		overload.instances[key] = constIndex
+	}
+	return constIndex
+}
+
+// OverloadFunc returns the implementation of an overload of the function.
+// fnType is the type of the overload, or its instance if the overload is
+// generic. The instance shares the implementation of the overload, but it has
+// the concrete parameter and result types, so that the arguments and the
+// result are typed correctly at run time.
+func (s *FuncSymbol) OverloadFunc(overload *Overload, fnType *types.Function) *runtime.Func {
+	if fnType == overload.Type {
+		return overload.Func
+	}
+	return &runtime.Func{
+		Name:    overload.Func.Name,
+		Type:    fnType,
+		Func:    overload.Func.Func,
+		FuncErr: overload.Func.FuncErr,
+	}
+}
+
+// AddOverload adds an implementation of the function. It is an error to add
+// two overloads with the same parameter types.
+func (s *FuncSymbol) AddOverload(overload *Overload) error {
+	for _, other := range s.Overloads {
+		if sameParams(other.Type, overload.Type) {
+			return fmt.Errorf("function %v already has an overload %v",
+				s.name, other.Type)
+		}
+	}
+	s.Overloads = append(s.Overloads, overload)
+	return nil
+}
+
+func sameParams(a, b *types.Function) bool {
+	if len(a.Params) != len(b.Params) || a.Variadic != b.Variadic {
+		return false
+	}
+	for i := range a.Params {
+		if !a.Params[i].Equal(b.Params[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,275 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+// exprListing returns the listing of the first expression of prog.
+func exprListing(t testing.TB, prog *runtime.Program) string {
+	var listing strings.Builder
+	require.NoError(t, prog.Disassemble(&listing))
+	_, instrs, ok := strings.Cut(listing.String(), "expr 0:\n")
+	require.True(t, ok)
+	return instrs
+}
+
+func TestBuilder_Optimize_Compiled(t *testing.T) {
+	run := func(input string, expected string) {
+		t.Run(input, func(t *testing.T) {
+			compiler := NewCompiler()
+			compiler.RegisterInput("a", types.Number)
+			compiler.RegisterInput("b", types.Bool)
+			compiler.RegisterInput("c", types.Bool)
+			compiler.RegisterInput("nums", &types.Array{ElementType: types.Number})
+			prog, err := compiler.Compile(input)
+			require.NoError(t, err)
+			require.NoError(t, prog.Verify())
+			require.Equal(t, strings.TrimLeft(expected, "\n"), exprListing(t, prog))
+		})
+	}
+
+	run(`a != 1`, `
+  0000  LoadInput        0
+  0001  PushNumber       1
+  0002  CompareNeNumber
+  0003  Return
+`)
+	run(`a > 1 && b`, `
+  0000  LoadInput        0
+  0001  PushNumber       1
+  0002  CompareGT
+  0003  JumpIfFalseOrPop L0
+  0004  LoadInput        1
+L0:
+  0005  Return
+`)
+	run(`b && c && a > 1`, `
+  0000  LoadInput        1
+  0001  JumpIfFalseOrPop L0
+  0002  LoadInput        2
+  0003  JumpIfFalseOrPop L0
+  0004  LoadInput        0
+  0005  PushNumber       1
+  0006  CompareGT
+L0:
+  0007  Return
+`)
+	run(`b && c || a > 1`, `
+  0000  LoadInput        1
+  0001  JumpIfFalse      L0
+  0002  LoadInput        2
+  0003  JumpIfTrueOrPop  L1
+L0:
+  0004  LoadInput        0
+  0005  PushNumber       1
+  0006  CompareGT
+L1:
+  0007  Return
+`)
+	run(`any x in nums: (x != a || b)`, `
+  0000  LoadInput        3
+  0001  IterInit         1
+L0:
+  0002  IterNext         1, L2
+  0003  StoreLocal       0
+  0004  LoadLocal        0
+  0005  LoadInput        0
+  0006  CompareNeNumber
+  0007  JumpIfTrue       L1
+  0008  LoadInput        1
+  0009  JumpIfFalse      L0
+L1:
+  0010  PushBool         true
+  0011  Jump             L3
+L2:
+  0012  PushBool         false
+L3:
+  0013  Return
+`)
+}
+
+func TestBuilder_Optimize(t *testing.T) {
+	run := func(name string, build func(b *runtime.Builder), expected string) {
+		t.Run(name, func(t *testing.T) {
+			b := runtime.NewBuilder()
+			b.NewInput(types.Bool)
+			b.NewInput(types.Number)
+			b.SetOptimize(true)
+			build(b)
+			require.NoError(t, b.FinishExpr())
+			require.Equal(t, strings.TrimLeft(expected, "\n"), exprListing(t, b.Build()))
+		})
+	}
+
+	run("dead_code", func(b *runtime.Builder) {
+		end := b.NewLabel()
+		b.EmitLoadInput(1)
+		b.EmitJump(runtime.Jump, end)
+		b.EmitPushNumber(1)
+		b.EmitOp(runtime.Add)
+		b.AssignLabel(end)
+		b.EmitOp(runtime.Return)
+	}, `
+  0000  LoadInput        1
+  0001  Return
+`)
+	run("thread_jumps", func(b *runtime.Builder) {
+		middle, end := b.NewLabel(), b.NewLabel()
+		b.EmitLoadInput(0)
+		b.EmitJump(runtime.JumpIfTrue, middle)
+		b.EmitPushNumber(1)
+		b.EmitOp(runtime.Return)
+		b.AssignLabel(middle)
+		b.EmitJump(runtime.Jump, end)
+		b.EmitPushNumber(2)
+		b.AssignLabel(end)
+		b.EmitPushNumber(3)
+		b.EmitOp(runtime.Return)
+	}, `
+  0000  LoadInput        0
+  0001  JumpIfTrue       L0
+  0002  PushNumber       1
+  0003  Return
+L0:
+  0004  PushNumber       3
+  0005  Return
+`)
+	run("jump_cycle", func(b *runtime.Builder) {
+		// The jumps are not threaded, but the jump to the next instruction
+		// is removed.
+		loop, other := b.NewLabel(), b.NewLabel()
+		b.AssignLabel(loop)
+		b.EmitJump(runtime.Jump, other)
+		b.AssignLabel(other)
+		b.EmitJump(runtime.Jump, loop)
+	}, `
+L0:
+  0000  Jump             L0
+`)
+	run("negate_target", func(b *runtime.Builder) {
+		// The Negate is a jump target, so it cannot be fused.
+		negate := b.NewLabel()
+		b.EmitLoadInput(0)
+		b.EmitJump(runtime.JumpIfFalse, negate)
+		b.EmitLoadInput(1)
+		b.EmitPushNumber(1)
+		b.EmitOp(runtime.CompareEqNumber)
+		b.AssignLabel(negate)
+		b.EmitOp(runtime.Negate)
+		b.EmitOp(runtime.Return)
+	}, `
+  0000  LoadInput        0
+  0001  JumpIfFalse      L0
+  0002  LoadInput        1
+  0003  PushNumber       1
+  0004  CompareEqNumber
+L0:
+  0005  Negate
+  0006  Return
+`)
+	run("and_pops_condition", func(b *runtime.Builder) {
+		// The right side of the And replaces the condition instead of
+		// pushing a value, so it cannot be fused.
+		skip := b.NewLabel()
+		b.EmitLoadInput(0)
+		b.EmitOp(runtime.Duplicate)
+		b.EmitJump(runtime.JumpIfFalse, skip)
+		b.EmitOp(runtime.Negate)
+		b.EmitLoadInput(0)
+		b.EmitOp(runtime.And)
+		b.AssignLabel(skip)
+		b.EmitOp(runtime.Return)
+	}, `
+  0000  LoadInput        0
+  0001  Duplicate
+  0002  JumpIfFalse      L0
+  0003  Negate
+  0004  LoadInput        0
+  0005  And
+L0:
+  0006  Return
+`)
+}
+
+func BenchmarkPeephole(b *testing.B) {
+	run := benchRunner(b,
+		benchVariant{"before", []Option{WithoutPeephole()}},
+		benchVariant{"after", nil},
+	)
+
+	run("ne", `a != b`)
+	run("and", `a > 0 && b > 0 && s != "" && a != b`)
+	run("or", `a == 0 || b == 0 || s == "" || a == b`)
+	run("and_or", `(a > 0 && b > 0) || (a < 0 && b < 0)`)
+	run("quantifier", `all x in nums: (x != a && x != b || x == 0)`)
+	run("comprehension", `[x for x in nums if x != a && x != b]`)
+}
+
+// TestBuilder_Optimize_Concurrent checks that compiling expressions does not
+// change the expressions of the programs returned before, which can be running.
+// Run it with -race.
+func TestBuilder_Optimize_Concurrent(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.RegisterInput("a", types.Number)
+	compiler.RegisterInput("b", types.Bool)
+	compile := func(inputs ...string) []*runtime.Program {
+		var progs []*runtime.Program
+		for _, input := range inputs {
+			prog, err := compiler.Compile(input)
+			require.NoError(t, err)
+			progs = append(progs, prog)
+		}
+		return progs
+	}
+
+	// The programs share the expressions of the builder, so that the later
+	// ones have room for the expressions compiled while they run.
+	progs := compile(`a != 1 && b || a > 2`, `a != 2 || b`, `b || !(a != 3)`)
+	inputs := []runtime.Value{runtime.NewNumber(3), runtime.NewBool(false)}
+	run := func() error {
+		for _, prog := range progs {
+			res, err := runtime.NewRuntime(prog).Run(context.Background(), 0, inputs)
+			if err != nil {
+				return err
+			}
+			if !res.Bool() {
+				return errors.New("unexpected result false")
+			}
+		}
+		return nil
+	}
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+	go func() {
+		defer close(errs)
+		for i := 0; ; i++ {
+			err := run()
+			if i == 0 {
+				close(started)
+			}
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case <-done:
+				return
+			default:
+			}
+		}
+	}()
+
+	<-started
+	compile(`a != 4 && b`, `b || a != 5 && b`, `!(a != 6) || b`)
+	close(done)
+	require.NoError(t, <-errs)
+}
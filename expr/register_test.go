@@ -0,0 +1,173 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegisters(t *testing.T) {
+	newCompiler := func(opts ...Option) *Compiler {
+		compiler := NewCompiler(opts...)
+		compiler.RegisterInput("a", types.Number)
+		compiler.RegisterInput("s", types.String)
+		compiler.RegisterInput("nums", &types.Array{ElementType: types.Number})
+		compiler.RegisterConst("k", runtime.NewNumber(3))
+		compiler.RegisterGoFunc("len", func(s string) float64 { return float64(len(s)) })
+		compiler.RegisterGoFunc("check", func(n float64) (float64, error) {
+			if n < 0 {
+				return 0, errors.New("negative")
+			}
+			return n, nil
+		})
+		compiler.RegisterFunc(
+			"typeOf",
+			func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewString(args[0].Type().String())
+			},
+			types.String, types.Any,
+		)
+		return compiler
+	}
+
+	run := func(input string, a float64) {
+		t.Run(input, func(t *testing.T) {
+			inputs := []runtime.Value{
+				runtime.NewNumber(a),
+				runtime.NewString("hello"),
+				runtime.NewObject(
+					&types.Array{ElementType: types.Number},
+					[]runtime.RawValue{runtime.NewRawNumber(1), runtime.NewRawNumber(5)}),
+			}
+
+			prog, err := newCompiler().Compile(input)
+			require.NoError(t, err)
+			expected, expectedErr := runtime.NewRuntime(prog).Run(context.Background(), 0, inputs)
+
+			regProg, err := newCompiler(WithRegisters()).Compile(input)
+			require.NoError(t, err)
+			require.NotZero(t, regProg.RegisterInstrCount(0))
+			r := runtime.NewRuntime(regProg)
+			for i := 0; i < 2; i++ {
+				res, err := r.Run(context.Background(), 0, inputs)
+				if expectedErr != nil {
+					require.EqualError(t, err, expectedErr.Error())
+					continue
+				}
+				require.NoError(t, err)
+				require.Equal(t, expected, res)
+			}
+		})
+	}
+
+	run(`a < k`, 1)
+	run(`(a + k) * 2 - a / k >= k`, 1)
+	run(`len(s) + k == a`, 2)
+	run(`check(a) + check(k)`, 1)
+	run(`check(a) + check(k)`, -1)
+	run(`typeOf(a) == typeOf([s])`, 1)
+	run(`a > 0 && s != "" || a == k`, 1)
+	run(`a > 0 && s != "" || a == k`, 0)
+	run(`any x in nums: (x == a)`, 5)
+	run(`all x in nums: (x > a || x == 5)`, 2)
+	run(`count(nums, x => x > a && x < k * 10)`, 1)
+	run(`[x * a for x in nums if x > a]`, 2)
+	run(`[[x, a] for x in nums]`, 2)
+	run(`reduce(nums, |acc, x| acc + x * a, k)`, 2)
+	run(`find(nums, |x| x > a)`, 2)
+	run(`a in nums && s in ["a", s]`, 5)
+	run(`ip("10.0.0.1") in cidr("10.0.0.0/8")`, 1)
+	run(`s =~ "h.*o" && regexFind(s, "l+") == "ll"`, 1)
+}
+
+func TestRegisters_Budget(t *testing.T) {
+	compiler := NewCompiler(WithRegisters())
+	compiler.RegisterInput("nums", &types.Array{ElementType: types.Number})
+	prog, err := compiler.Compile(`all x in nums: (x > 0)`)
+	require.NoError(t, err)
+
+	r := runtime.NewRuntime(prog)
+	r.SetBudget(1)
+	_, err = r.Run(context.Background(), 0, []runtime.Value{
+		runtime.NewObject(
+			&types.Array{ElementType: types.Number},
+			[]runtime.RawValue{runtime.NewRawNumber(1), runtime.NewRawNumber(2)}),
+	})
+	require.True(t, errors.Is(err, runtime.ErrBudgetExceeded))
+}
+
+func TestProgram_DisassembleRegisters(t *testing.T) {
+	run := func(input string, expected string) {
+		t.Run(input, func(t *testing.T) {
+			compiler := NewCompiler(WithRegisters())
+			compiler.RegisterInput("a", types.Number)
+			compiler.RegisterInput("s", types.String)
+			compiler.RegisterInput("nums", &types.Array{ElementType: types.Number})
+			compiler.RegisterConst("k", runtime.NewNumber(3))
+			prog, err := compiler.Compile(input)
+			require.NoError(t, err)
+			var listing strings.Builder
+			require.NoError(t, prog.DisassembleRegisters(&listing))
+			require.Equal(t, strings.TrimLeft(expected, "\n"), listing.String())
+		})
+	}
+
+	run(`a > 1 && s == "x"`, `
+expr 0:
+  0000  CompareGT        r0, input[0], 1
+  0001  JumpIfFalse      r0, L0
+  0002  CompareEqString  r0, input[1], "x"
+L0:
+  0003  Return           r0
+`)
+	run(`a < k`, `
+expr 0:
+  0000  CompareLT        r0, input[0], 3
+  0001  Return           r0
+`)
+	run(`[x * 2 for x in nums if x != a]`, `
+expr 0:
+  0000  IterInit         local[1], input[2]
+  0001  PushArray        r0
+L0:
+  0002  IterNext         local[0], local[1], L1
+  0003  CompareNeNumber  r1, local[0], input[0]
+  0004  JumpIfFalse      r1, L0
+  0005  Multiply         r1, local[0], 2
+  0006  ArrayAppend      r0, r0, r1
+  0007  Jump             L0
+L1:
+  0008  Return           r0
+`)
+
+	prog, err := NewCompiler().Compile(`1`)
+	require.NoError(t, err)
+	require.EqualError(t, prog.DisassembleRegisters(&strings.Builder{}),
+		"program was not compiled to registers")
+}
+
+// BenchmarkRegisters compares the stack and the register code on the
+// expressions of the tests. Complex1 is the expression of TestComplex1 and
+// Benchmark1, with its inputs renamed.
+func BenchmarkRegisters(b *testing.B) {
+	run := benchRunner(b,
+		benchVariant{"stack", nil},
+		benchVariant{"registers", []Option{WithRegisters()}},
+	)
+
+	run("Complex1", `len(s) + k == b`)
+	run("lt", `a < b`)
+	run("plus", `a + b`)
+	run("div", `a / b`)
+	run("eq_arr_num1", `[a,b] == [a,b]`)
+	run("and", `a > 0 && s != "" || a == k`)
+	run("in", `a in [1, b, 3]`)
+	run("count", `count(nums, x => x > b)`)
+	run("comprehension", `[x * 2 for x in nums if x > 3]`)
+	run("quantifier", `any x in nums: (x == a)`)
+}
@@ -1,7 +1,7 @@
 package runtime
 
 import (
-	"log"
+	"fmt"
 
 	"github.com/dcaiafa/go-expr/expr/types"
 )
@@ -15,7 +15,12 @@ type Builder struct {
 	instr     []Instruction
 	exprs     []Expr
 	consts    []Value
+	constMap  map[interface{}]int
 	inputs    []types.Type
+	locals    int
+	boxTypes  []types.Type
+	closures  []Closure
+	optimize  bool
 }
 
 // NewBuilder creates a new Builder.
@@ -26,6 +31,24 @@ func NewBuilder() *Builder {
 	return b
 }
 
+// Fork creates a Builder with the inputs, constants and locals of b. The
+// inputs, constants and locals created with the fork are not added to b, and
+// the fork does not share the constants of b created with SharedConst.
+func (b *Builder) Fork() *Builder {
+	return &Builder{
+		stringMap: make(map[string]int),
+		consts:    b.consts[:len(b.consts):len(b.consts)],
+		inputs:    b.inputs[:len(b.inputs):len(b.inputs)],
+		locals:    b.locals,
+		optimize:  b.optimize,
+	}
+}
+
+// CheckInputs checks that inputs match the inputs created with NewInput.
+func (b *Builder) CheckInputs(inputs []Value) error {
+	return checkInputs(b.inputs, inputs)
+}
+
 // NewInput creates a new input that can be referenced in a LoadInput
 // instruction.
 func (b *Builder) NewInput(t types.Type) int {
@@ -41,6 +64,38 @@ func (b *Builder) NewConst(v Value) int {
 	return constIndex
 }
 
+// SharedConst returns the index of the constant created for key, creating it
+// with v on the first call. Expressions that load the same value use it to
+// share one constant. Keys are compared with ==.
+func (b *Builder) SharedConst(key interface{}, v Value) int {
+	constIndex, ok := b.constMap[key]
+	if !ok {
+		if b.constMap == nil {
+			b.constMap = make(map[interface{}]int)
+		}
+		constIndex = b.NewConst(v)
+		b.constMap[key] = constIndex
+	}
+	return constIndex
+}
+
+// Const returns the value of the constant at constIndex.
+func (b *Builder) Const(constIndex int) Value {
+	return b.consts[constIndex]
+}
+
+// NewLocal creates a new local variable slot that can be referenced in
+// LoadLocal, StoreLocal and iteration instructions.
+func (b *Builder) NewLocal() int {
+	b.locals++
+	return b.locals - 1
+}
+
+// LocalCount returns the number of local variable slots created so far.
+func (b *Builder) LocalCount() int {
+	return b.locals
+}
+
 // NewLabel creates a new label that can be used in EmitJump. The label is
 // immediately ready to be used, but it must be assigned using AssignLabel
 // before Build is called.
@@ -74,6 +129,30 @@ func (b *Builder) EmitLoadInput(inputIndex int) {
 	b.addInstr(Instruction{op: LoadInput, extra: inputIndex})
 }
 
+// EmitLoadLocal emits a LoadLocal instruction.
+func (b *Builder) EmitLoadLocal(localIndex int) {
+	b.addInstr(Instruction{op: LoadLocal, extra: localIndex})
+}
+
+// EmitStoreLocal emits a StoreLocal instruction that pops the top of the stack
+// into a local.
+func (b *Builder) EmitStoreLocal(localIndex int) {
+	b.addInstr(Instruction{op: StoreLocal, extra: localIndex})
+}
+
+// EmitIterInit emits an IterInit instruction that pops an array and stores an
+// iterator over it in the local iterIndex.
+func (b *Builder) EmitIterInit(iterIndex int) {
+	b.addInstr(Instruction{op: IterInit, extra: iterIndex})
+}
+
+// EmitIterNext emits an IterNext instruction that pushes the next element of
+// the iterator stored in the local iterIndex, or jumps to label if there are
+// no more elements.
+func (b *Builder) EmitIterNext(iterIndex int, label *Label) {
+	b.addInstr(Instruction{op: IterNext, extra: label.index, arg: iterIndex})
+}
+
 // EmitPushNumber emits a PushNumber instruction.
 func (b *Builder) EmitPushNumber(num float64) {
 	b.addInstr(Instruction{op: PushNumber, vnum: num})
@@ -85,7 +164,9 @@ func (b *Builder) EmitPushString(str string) {
 	b.addInstr(Instruction{op: PushString, extra: strIndex})
 }
 
-func (b *Builder) EmitPushBasicValue(v interface{}) {
+// EmitPushBasicValue emits a PushNumber, PushBool or PushString instruction,
+// depending on the type of v.
+func (b *Builder) EmitPushBasicValue(v interface{}) error {
 	switch v := v.(type) {
 	case float64:
 		b.EmitPushNumber(v)
@@ -94,8 +175,9 @@ func (b *Builder) EmitPushBasicValue(v interface{}) {
 	case string:
 		b.EmitPushString(v)
 	default:
-		log.Fatal("invalid basic type")
+		return fmt.Errorf("%w: invalid basic value type %T", ErrInternal, v)
 	}
+	return nil
 }
 
 // EmitPushBool emits a PushBool instruction.
@@ -111,7 +193,23 @@ func (b *Builder) EmitPushArray(elemCount int) {
 	b.addInstr(Instruction{op: PushArray, extra: elemCount})
 }
 
-// EmitJump emits a Jump, JumpIfTrue or JumpIfFalse instruction.
+// EmitBox emits a Box instruction that wraps the value at the top of the stack,
+// of type typ, into a Value. Arguments for parameters of type Any must be
+// boxed.
+func (b *Builder) EmitBox(typ types.Type) {
+	b.boxTypes = append(b.boxTypes, typ)
+	b.addInstr(Instruction{op: Box, extra: len(b.boxTypes) - 1})
+}
+
+// EmitInSet emits an InSetNumber or InSetString instruction that pops a number
+// or a string and pushes whether it is an element of the constant array at
+// constIndex. The set of the elements is created by Build.
+func (b *Builder) EmitInSet(op Operation, constIndex int) {
+	b.addInstr(Instruction{op: op, extra: constIndex})
+}
+
+// EmitJump emits a Jump, JumpIfTrue, JumpIfFalse, JumpIfTrueOrPop or
+// JumpIfFalseOrPop instruction.
 func (b *Builder) EmitJump(op Operation, label *Label) {
 	b.addInstr(Instruction{op: op, extra: label.index})
 }
@@ -126,35 +224,52 @@ func (b *Builder) addInstr(i Instruction) {
 	b.instr = append(b.instr, i)
 }
 
-// FinishExpr finishes the current expression.
-func (b *Builder) FinishExpr() {
+// FinishExpr finishes the current expression, resolving its jump labels, and
+// optimizes it if enabled by SetOptimize. Finished expressions are never
+// changed, since the programs returned by Build share them.
+func (b *Builder) FinishExpr() error {
 	for i := 0; i < len(b.instr); i++ {
-		if b.instr[i].op != Jump &&
-			b.instr[i].op != JumpIfTrue &&
-			b.instr[i].op != JumpIfFalse {
+		if !b.instr[i].op.isJump() {
 			continue
 		}
 
 		label := b.labels[b.instr[i].extra]
 		if label.addr == -1 {
-			panic("unassigned label")
+			return fmt.Errorf("%w: unassigned label %d", ErrInternal, label.index)
 		}
 
 		b.instr[i].extra = label.addr
 	}
 
-	b.exprs = append(b.exprs, Expr(b.instr))
+	expr := Expr(b.instr)
+	if b.optimize {
+		expr = optimizeExpr(expr)
+	}
+	b.exprs = append(b.exprs, expr)
 	b.instr = nil
+	return nil
+}
+
+// AddClosure adds the closure of the next expression. Either all expressions
+// have closures, or none does.
+func (b *Builder) AddClosure(c Closure) {
+	b.closures = append(b.closures, c)
 }
 
 // Build returns the Program.
 func (b *Builder) Build() *Program {
-	return &Program{
+	p := &Program{
 		exprs:   b.exprs,
 		strings: b.strings,
 		consts:  b.consts,
 		inputs:  b.inputs,
+		locals:  b.locals,
+
+		boxTypes: b.boxTypes,
+		closures: b.closures,
 	}
+	p.buildSets()
+	return p
 }
 
 func (b *Builder) newString(str string) int {
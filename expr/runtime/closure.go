@@ -0,0 +1,133 @@
+package runtime
+
+import "context"
+
+// Closure is an expression compiled to Go functions, as an alternative to
+// bytecode. Exactly one of the functions is set, depending on the type of the
+// expression: Number for numbers, Bool for bools and Value for all other
+// types.
+type Closure struct {
+	Number func(env *Env) float64
+	Bool   func(env *Env) bool
+	Value  func(env *Env) RawValue
+}
+
+// Raw returns a function that evaluates the closure to a RawValue, regardless
+// of the type of the expression.
+func (c Closure) Raw() func(env *Env) RawValue {
+	switch {
+	case c.Number != nil:
+		fn := c.Number
+		return func(env *Env) RawValue { return NewRawNumber(fn(env)) }
+	case c.Bool != nil:
+		fn := c.Bool
+		return func(env *Env) RawValue { return NewRawBool(fn(env)) }
+	default:
+		return c.Value
+	}
+}
+
+// Env is the state of a Runtime that is visible to closures. When a closure
+// fails, for example because a function returned an error, the error is
+// recorded in the Env and the execution winds down: the remaining calls are
+// skipped, loops stop, and the closures return placeholder values that are
+// discarded by Runtime.Run, which returns the error.
+type Env struct {
+	ctx       context.Context
+	inputs    []Value
+	locals    []RawValue
+	args      []Value
+	budget    int
+	remaining int
+	err       error
+}
+
+// Input returns the value of the input at inputIndex.
+func (e *Env) Input(inputIndex int) RawValue {
+	return e.inputs[inputIndex].RawValue
+}
+
+// Local returns the value of the local at localIndex.
+func (e *Env) Local(localIndex int) RawValue {
+	return e.locals[localIndex]
+}
+
+// SetLocal sets the value of the local at localIndex.
+func (e *Env) SetLocal(localIndex int, v RawValue) {
+	e.locals[localIndex] = v
+}
+
+// Iterate accounts for a loop iteration. It returns false if the loop must
+// stop, because the execution failed or the budget is exhausted.
+func (e *Env) Iterate() bool {
+	if e.err != nil {
+		return false
+	}
+	if e.budget != 0 {
+		if e.remaining == 0 {
+			e.err = ErrBudgetExceeded
+			return false
+		}
+		e.remaining--
+	}
+	return true
+}
+
+// ArgMark returns the position of the next argument pushed with PushArg. It
+// is passed to Call to consume the arguments pushed since.
+func (e *Env) ArgMark() int {
+	return len(e.args)
+}
+
+// PushArg pushes an argument for the next call. The arguments of a call can be
+// pushed while they are evaluated, since nested calls consume their own
+// arguments.
+func (e *Env) PushArg(v Value) {
+	e.args = append(e.args, v)
+}
+
+// Call calls fn with the arguments pushed since mark, like CallFunc. It
+// returns false if the call failed, or if it was skipped because the execution
+// already failed; the caller must then return a placeholder value.
+func (e *Env) Call(fn *Func, mark int) (RawValue, bool) {
+	if e.err != nil {
+		e.args = e.args[:mark]
+		return RawValue{}, false
+	}
+	res, err := CallFunc(e.ctx, fn, e.args[mark:])
+	e.args = e.args[:mark]
+	if err != nil {
+		e.err = err
+		return RawValue{}, false
+	}
+	return res.RawValue, true
+}
+
+// runClosure runs the closure of the expression at exprIndex.
+func (r *Runtime) runClosure(
+	ctx context.Context,
+	exprIndex int,
+	inputs []Value,
+) (Value, error) {
+	r.env.ctx = ctx
+	r.env.inputs = inputs
+	r.env.args = r.env.args[:0]
+	r.env.budget = r.budget
+	r.env.remaining = r.budget
+	r.env.err = nil
+
+	var raw RawValue
+	c := &r.program.closures[exprIndex]
+	switch {
+	case c.Number != nil:
+		raw = NewRawNumber(c.Number(&r.env))
+	case c.Bool != nil:
+		raw = NewRawBool(c.Bool(&r.env))
+	default:
+		raw = c.Value(&r.env)
+	}
+	if r.env.err != nil {
+		return Value{}, r.env.err
+	}
+	return Value{typ: r.program.ResultType, RawValue: raw}, nil
+}
@@ -0,0 +1,672 @@
+package runtime
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// Disassemble writes a human-readable listing of the program: its result
+// type, inputs, constants and number of locals, followed by the instructions
+// of each expression with their addresses and decoded operands. Jump targets
+// are shown as labels. The listing can be assembled back with Assemble.
+// Programs do not record source positions, so the listing has no source
+// spans.
+//
+// For example, the listing of `a > 1 && s == "x"` is:
+//
+//	result: bool
+//	input 0: number
+//	input 1: string
+//	locals: 0
+//
+//	expr 0:
+//	  0000  LoadInput        0
+//	  0001  PushNumber       1
+//	  0002  CompareGT
+//	  0003  JumpIfFalseOrPop L0
+//	  0004  LoadInput        1
+//	  0005  PushString       "x"
+//	  0006  CompareEqString
+//	L0:
+//	  0007  Return
+func (p *Program) Disassemble(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if p.ResultType != nil {
+		fmt.Fprintf(bw, "result: %v\n", p.ResultType)
+	}
+	for i, input := range p.inputs {
+		fmt.Fprintf(bw, "input %d: %v\n", i, input)
+	}
+	for i, c := range p.consts {
+		fmt.Fprintf(bw, "const %d: %v = %s\n", i, c.Type(), formatValue(c.Type(), c.RawValue))
+	}
+	fmt.Fprintf(bw, "locals: %d\n", p.locals)
+
+	for i, expr := range p.exprs {
+		fmt.Fprintf(bw, "\nexpr %d:\n", i)
+		labels := exprLabels(expr)
+		for addr, instr := range expr {
+			if label, ok := labels[addr]; ok {
+				fmt.Fprintf(bw, "%s:\n", label)
+			}
+			operands, comment := p.operands(instr, labels)
+			line := fmt.Sprintf("  %04d  %-16s %s", addr, instr.op, operands)
+			line = strings.TrimRight(line, " ")
+			if comment != "" {
+				line = fmt.Sprintf("%-40s ; %s", line, comment)
+			}
+			fmt.Fprintln(bw, line)
+		}
+		if label, ok := labels[len(expr)]; ok {
+			fmt.Fprintf(bw, "%s:\n", label)
+		}
+	}
+	return bw.Flush()
+}
+
+// exprLabels returns the labels of the jump targets of expr, by address. The
+// labels are numbered in address order.
+func exprLabels(expr Expr) map[int]string {
+	var targets []int
+	seen := make(map[int]bool)
+	for _, instr := range expr {
+		if instr.op.isJump() && !seen[instr.extra] {
+			seen[instr.extra] = true
+			targets = append(targets, instr.extra)
+		}
+	}
+	sort.Ints(targets)
+	labels := make(map[int]string, len(targets))
+	for i, target := range targets {
+		labels[target] = "L" + strconv.Itoa(i)
+	}
+	return labels
+}
+
+// operands returns the operands of instr as they are written in a listing,
+// and a comment that describes them.
+func (p *Program) operands(instr Instruction, labels map[int]string) (string, string) {
+	switch instr.op {
+	case PushNumber:
+		return formatNumber(instr.vnum), ""
+	case PushString:
+		if instr.extra < 0 || instr.extra >= len(p.strings) {
+			return strconv.Itoa(instr.extra), "invalid string index"
+		}
+		return strconv.Quote(p.strings[instr.extra]), ""
+	case PushBool:
+		return strconv.FormatBool(instr.extra != 0), ""
+	case LoadConst:
+		if instr.extra < 0 || instr.extra >= len(p.consts) {
+			return strconv.Itoa(instr.extra), "invalid constant index"
+		}
+		c := p.consts[instr.extra]
+		return strconv.Itoa(instr.extra), formatValue(c.Type(), c.RawValue)
+	case InSetNumber, InSetString:
+		if instr.extra < 0 || instr.extra >= len(p.consts) {
+			return strconv.Itoa(instr.extra), "invalid constant index"
+		}
+		c := p.consts[instr.extra]
+		if array, ok := c.Object().([]RawValue); ok {
+			return strconv.Itoa(instr.extra), fmt.Sprintf("%d elements", len(array))
+		}
+		return strconv.Itoa(instr.extra), ""
+	case Box:
+		if instr.extra < 0 || instr.extra >= len(p.boxTypes) {
+			return strconv.Itoa(instr.extra), "invalid box type index"
+		}
+		return p.boxTypes[instr.extra].String(), ""
+	case Jump, JumpIfTrue, JumpIfFalse, JumpIfTrueOrPop, JumpIfFalseOrPop:
+		return labels[instr.extra], ""
+	case IterNext:
+		return fmt.Sprintf("%d, %s", instr.arg, labels[instr.extra]), ""
+	case LoadInput, LoadLocal, StoreLocal, IterInit, PushArray, Call:
+		return strconv.Itoa(instr.extra), ""
+	default:
+		return "", ""
+	}
+}
+
+func formatNumber(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// formatValue returns the literal of the value v of type typ in a listing.
+// Functions are written by name.
+func formatValue(typ types.Type, v RawValue) string {
+	switch typ {
+	case types.Number:
+		return formatNumber(v.Number())
+	case types.Bool:
+		return strconv.FormatBool(v.Bool())
+	case types.String:
+		s, _ := v.obj.(string)
+		return strconv.Quote(s)
+	case types.Regexp:
+		if re, ok := v.obj.(*regexp.Regexp); ok && re != nil {
+			return strconv.Quote(re.String())
+		}
+	case types.IP:
+		if addr, ok := v.obj.(netip.Addr); ok && addr.IsValid() {
+			return strconv.Quote(addr.String())
+		}
+		return `""`
+	case types.CIDR:
+		if prefix, ok := v.obj.(netip.Prefix); ok && prefix.IsValid() {
+			return strconv.Quote(prefix.String())
+		}
+		return `""`
+	}
+
+	switch typ := typ.(type) {
+	case *types.Array:
+		array, _ := v.obj.([]RawValue)
+		elems := make([]string, len(array))
+		for i, elem := range array {
+			elems[i] = formatValue(typ.ElementType, elem)
+		}
+		return "[" + strings.Join(elems, ", ") + "]"
+	case *types.Function:
+		if fn, ok := v.obj.(*Func); ok && fn != nil && fn.Name != "" {
+			return fn.Name
+		}
+	}
+	return "?"
+}
+
+// Assemble parses a listing written by Program.Disassemble. Addresses and
+// comments, which start with ';', are ignored. The functions referenced by
+// the constants are not linked: like a deserialized program, the program must
+// be linked with Link.
+//
+// Assemble is meant for tests of the runtime, with programs written by hand.
+// The programs are built with a Builder, which allocates the string table and
+// the box types.
+func Assemble(r io.Reader) (*Program, error) {
+	a := &assembler{
+		b:      NewBuilder(),
+		labels: make(map[string]*Label),
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		a.line++
+		err := a.parseLine(scanner.Text())
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", a.line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	err := a.finishExpr()
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", a.line, err)
+	}
+	prog := a.b.Build()
+	prog.ResultType = a.result
+	return prog, nil
+}
+
+// assembler is the state of Assemble.
+type assembler struct {
+	b      *Builder
+	line   int
+	result types.Type
+
+	// exprs is the number of expressions started, and labels are the labels
+	// of the current expression.
+	exprs  int
+	labels map[string]*Label
+}
+
+var opsByName = func() map[string]Operation {
+	ops := make(map[string]Operation, len(opNames))
+	for op, name := range opNames {
+		ops[name] = op
+	}
+	return ops
+}()
+
+func (a *assembler) parseLine(line string) error {
+	toks, err := tokenize(line)
+	if err != nil {
+		return err
+	}
+	if len(toks) == 0 {
+		return nil
+	}
+	t := &tokens{toks: toks}
+
+	switch {
+	case t.accept("result"):
+		if err := t.expect(":"); err != nil {
+			return err
+		}
+		a.result, err = parseType(t, nil)
+	case t.accept("input"):
+		err = a.parseIndex(t, len(a.b.inputs))
+		if err == nil {
+			var typ types.Type
+			typ, err = parseType(t, nil)
+			a.b.NewInput(typ)
+		}
+	case t.accept("const"):
+		err = a.parseIndex(t, len(a.b.consts))
+		if err == nil {
+			var v Value
+			v, err = parseConst(t)
+			a.b.NewConst(v)
+		}
+	case t.accept("locals"):
+		if err := t.expect(":"); err != nil {
+			return err
+		}
+		var n int
+		n, err = t.int()
+		for i := 0; i < n; i++ {
+			a.b.NewLocal()
+		}
+	case t.accept("expr"):
+		err = a.finishExpr()
+		if err == nil {
+			err = a.parseIndex(t, a.exprs)
+			a.exprs++
+		}
+	case len(toks) == 2 && toks[1] == ":":
+		if a.exprs == 0 {
+			return fmt.Errorf("label outside of an expression")
+		}
+		a.b.AssignLabel(a.label(toks[0]))
+		t.pos = 2
+	default:
+		if a.exprs == 0 {
+			return fmt.Errorf("instruction outside of an expression")
+		}
+		err = a.parseInstr(t)
+	}
+	if err != nil {
+		return err
+	}
+	if !t.done() {
+		return fmt.Errorf("unexpected %q", t.peek())
+	}
+	return nil
+}
+
+// parseIndex parses the index of a declaration, followed by ':', and checks
+// that it is the next index.
+func (a *assembler) parseIndex(t *tokens, next int) error {
+	index, err := t.int()
+	if err != nil {
+		return err
+	}
+	if index != next {
+		return fmt.Errorf("index %d out of order, expected %d", index, next)
+	}
+	return t.expect(":")
+}
+
+func (a *assembler) finishExpr() error {
+	if a.exprs == 0 {
+		return nil
+	}
+	for name, label := range a.labels {
+		if label.addr == -1 {
+			return fmt.Errorf("label %v is not defined", name)
+		}
+	}
+	a.labels = make(map[string]*Label)
+	return a.b.FinishExpr()
+}
+
+func (a *assembler) label(name string) *Label {
+	label, ok := a.labels[name]
+	if !ok {
+		label = a.b.NewLabel()
+		a.labels[name] = label
+	}
+	return label
+}
+
+func (a *assembler) parseInstr(t *tokens) error {
+	// The address is optional.
+	if _, err := strconv.Atoi(t.peek()); err == nil {
+		t.next()
+	}
+	name := t.next()
+	op, ok := opsByName[name]
+	if !ok || op == InvalidOperation {
+		return fmt.Errorf("invalid operation %q", name)
+	}
+
+	switch op {
+	case PushNumber:
+		v, err := strconv.ParseFloat(t.next(), 64)
+		if err != nil {
+			return err
+		}
+		a.b.EmitPushNumber(v)
+	case PushString:
+		s, err := t.string()
+		if err != nil {
+			return err
+		}
+		a.b.EmitPushString(s)
+	case PushBool:
+		v, err := strconv.ParseBool(t.next())
+		if err != nil {
+			return err
+		}
+		a.b.EmitPushBool(v)
+	case Box:
+		typ, err := parseType(t, nil)
+		if err != nil {
+			return err
+		}
+		a.b.EmitBox(typ)
+	case Jump, JumpIfTrue, JumpIfFalse, JumpIfTrueOrPop, JumpIfFalseOrPop:
+		a.b.EmitJump(op, a.label(t.next()))
+	case IterNext:
+		iter, err := t.int()
+		if err != nil {
+			return err
+		}
+		if err := t.expect(","); err != nil {
+			return err
+		}
+		a.b.EmitIterNext(iter, a.label(t.next()))
+	case LoadConst, LoadInput, LoadLocal, StoreLocal, IterInit, PushArray, Call,
+		InSetNumber, InSetString:
+		n, err := t.int()
+		if err != nil {
+			return err
+		}
+		a.b.addInstr(Instruction{op: op, extra: n})
+	default:
+		a.b.EmitOp(op)
+	}
+	return nil
+}
+
+// parseType parses a type as written by its String method. vars are the type
+// variables of the enclosing function type.
+func parseType(t *tokens, vars map[string]*types.TypeVar) (types.Type, error) {
+	name := t.next()
+	switch name {
+	case "void":
+		return types.Void, nil
+	case "number":
+		return types.Number, nil
+	case "string":
+		return types.String, nil
+	case "bool":
+		return types.Bool, nil
+	case "any":
+		return types.Any, nil
+	case "regexp":
+		return types.Regexp, nil
+	case "ip":
+		return types.IP, nil
+	case "cidr":
+		return types.CIDR, nil
+	case "array":
+		if err := t.expect("of"); err != nil {
+			return nil, err
+		}
+		elem, err := parseType(t, vars)
+		if err != nil {
+			return nil, err
+		}
+		return &types.Array{ElementType: elem}, nil
+	case "func":
+		return parseFuncType(t, vars)
+	}
+	if vars != nil && isIdent(name) {
+		v, ok := vars[name]
+		if !ok {
+			v = types.NewTypeVar(name, nil)
+			vars[name] = v
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("invalid type %q", name)
+}
+
+func parseFuncType(t *tokens, vars map[string]*types.TypeVar) (types.Type, error) {
+	if vars == nil {
+		vars = make(map[string]*types.TypeVar)
+	}
+	if err := t.expect("("); err != nil {
+		return nil, err
+	}
+	fn := &types.Function{Params: []types.Type{}}
+	for !t.accept(")") {
+		if len(fn.Params) != 0 {
+			if err := t.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		param, err := parseType(t, vars)
+		if err != nil {
+			return nil, err
+		}
+		fn.Params = append(fn.Params, param)
+		switch {
+		case t.accept("..."):
+			fn.Variadic = true
+		case t.accept("?"):
+			fn.Optional++
+		}
+	}
+	ret, err := parseType(t, vars)
+	if err != nil {
+		return nil, err
+	}
+	fn.Ret = ret
+	return fn, nil
+}
+
+// parseConst parses the type and the value of a constant.
+func parseConst(t *tokens) (Value, error) {
+	typ, err := parseType(t, nil)
+	if err != nil {
+		return Value{}, err
+	}
+	if err := t.expect("="); err != nil {
+		return Value{}, err
+	}
+	v, err := parseValue(t, typ)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{typ: typ, RawValue: v}, nil
+}
+
+func parseValue(t *tokens, typ types.Type) (RawValue, error) {
+	switch typ {
+	case types.Number:
+		v, err := strconv.ParseFloat(t.next(), 64)
+		if err != nil && !math.IsInf(v, 0) {
+			return RawValue{}, err
+		}
+		return NewRawNumber(v), nil
+	case types.Bool:
+		v, err := strconv.ParseBool(t.next())
+		if err != nil {
+			return RawValue{}, err
+		}
+		return NewRawBool(v), nil
+	case types.String:
+		s, err := t.string()
+		if err != nil {
+			return RawValue{}, err
+		}
+		return NewRawObject(s), nil
+	case types.Regexp:
+		s, err := t.string()
+		if err != nil {
+			return RawValue{}, err
+		}
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return RawValue{}, err
+		}
+		return NewRawObject(re), nil
+	case types.IP:
+		s, err := t.string()
+		if err != nil || s == "" {
+			return NewRawObject(netip.Addr{}), err
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return RawValue{}, err
+		}
+		return NewRawObject(addr), nil
+	case types.CIDR:
+		s, err := t.string()
+		if err != nil || s == "" {
+			return NewRawObject(netip.Prefix{}), err
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return RawValue{}, err
+		}
+		return NewRawObject(prefix), nil
+	}
+
+	switch typ := typ.(type) {
+	case *types.Array:
+		if err := t.expect("["); err != nil {
+			return RawValue{}, err
+		}
+		array := []RawValue{}
+		for !t.accept("]") {
+			if len(array) != 0 {
+				if err := t.expect(","); err != nil {
+					return RawValue{}, err
+				}
+			}
+			elem, err := parseValue(t, typ.ElementType)
+			if err != nil {
+				return RawValue{}, err
+			}
+			array = append(array, elem)
+		}
+		return NewRawObject(array), nil
+	case *types.Function:
+		name := t.next()
+		if name == "" || name == "?" {
+			return RawValue{}, fmt.Errorf("function without a name")
+		}
+		return NewRawObject(&Func{Name: name, Type: typ}), nil
+	default:
+		return RawValue{}, fmt.Errorf("invalid constant type %v", typ)
+	}
+}
+
+// tokens is the sequence of tokens of a line of a listing.
+type tokens struct {
+	toks []string
+	pos  int
+}
+
+func (t *tokens) done() bool {
+	return t.pos == len(t.toks)
+}
+
+func (t *tokens) peek() string {
+	if t.done() {
+		return ""
+	}
+	return t.toks[t.pos]
+}
+
+func (t *tokens) next() string {
+	tok := t.peek()
+	if !t.done() {
+		t.pos++
+	}
+	return tok
+}
+
+func (t *tokens) accept(tok string) bool {
+	if t.peek() == tok {
+		t.pos++
+		return true
+	}
+	return false
+}
+
+func (t *tokens) expect(tok string) error {
+	if !t.accept(tok) {
+		return fmt.Errorf("expected %q, found %q", tok, t.peek())
+	}
+	return nil
+}
+
+func (t *tokens) int() (int, error) {
+	return strconv.Atoi(t.next())
+}
+
+func (t *tokens) string() (string, error) {
+	return strconv.Unquote(t.next())
+}
+
+// tokenize splits a line of a listing into tokens: quoted strings,
+// punctuation, "..." and words. Comments are dropped.
+func tokenize(line string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ';':
+			return toks, nil
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			quoted, err := strconv.QuotedPrefix(line[i:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string: %v", err)
+			}
+			toks = append(toks, quoted)
+			i += len(quoted)
+		case strings.HasPrefix(line[i:], "..."):
+			toks = append(toks, "...")
+			i += 3
+		case strings.IndexByte("()[],:=?", c) != -1:
+			toks = append(toks, string(c))
+			i++
+		default:
+			j := i
+			for j < len(line) && strings.IndexByte(" \t;\"()[],:=?", line[j]) == -1 &&
+				!strings.HasPrefix(line[j:], "...") {
+				j++
+			}
+			toks = append(toks, line[i:j])
+			i = j
+		}
+	}
+	return toks, nil
+}
+
+func isIdent(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		if !(c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			i > 0 && c >= '0' && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,305 @@
+package runtime
+
+// SetOptimize enables the peephole optimizer, which rewrites each expression
+// when it is finished by FinishExpr. Expressions finished before the call are
+// not changed. The optimizer:
+//
+//   - fuses `CompareEqNumber; Negate`, emitted for !=, into CompareNeNumber;
+//   - fuses `Duplicate; JumpIfFalse L; ...; And; L:`, emitted for &&, into
+//     `JumpIfFalseOrPop L; ...; L:`, and likewise || into JumpIfTrueOrPop;
+//   - threads jumps to jumps, and short-circuit jumps to short-circuit jumps;
+//   - removes jumps to the next instruction and unreachable code.
+//
+// Jump targets are re-resolved after instructions are removed. The result of
+// the expressions does not change.
+func (b *Builder) SetOptimize(optimize bool) {
+	b.optimize = optimize
+}
+
+func optimizeExpr(expr Expr) Expr {
+	o := &optimizer{expr: append(Expr(nil), expr...)}
+	for {
+		changed := o.threadJumps()
+		for o.fuse() {
+			changed = true
+		}
+		if o.removeDeadCode() {
+			changed = true
+		}
+		if !changed {
+			return o.expr
+		}
+	}
+}
+
+// optimizer is the state of optimizeExpr.
+type optimizer struct {
+	expr Expr
+}
+
+// threadJumps retargets the jumps whose target is another jump that is
+// certainly taken, or certainly not taken.
+func (o *optimizer) threadJumps() bool {
+	changed := false
+	for i := range o.expr {
+		instr := &o.expr[i]
+		if !instr.op.isJump() {
+			continue
+		}
+		op, target := instr.op, instr.extra
+		visited := map[int]bool{target: true}
+		cycle := false
+		for {
+			nextOp, nextTarget, ok := o.thread(op, target)
+			if !ok {
+				break
+			}
+			if visited[nextTarget] {
+				// The jumps loop forever: leave them alone.
+				cycle = true
+				break
+			}
+			visited[nextTarget] = true
+			op, target = nextOp, nextTarget
+		}
+		if !cycle && (op != instr.op || target != instr.extra) {
+			instr.op, instr.extra = op, target
+			changed = true
+		}
+	}
+	return changed
+}
+
+// thread returns the jump equivalent to a jump op to target that skips the
+// instruction at target, if any.
+func (o *optimizer) thread(op Operation, target int) (Operation, int, bool) {
+	if op == IterNext || target >= len(o.expr) {
+		return 0, 0, false
+	}
+	next := o.expr[target]
+	switch {
+	case next.op == Jump:
+		return op, next.extra, true
+
+	case op == JumpIfFalseOrPop && next.op == JumpIfFalseOrPop,
+		op == JumpIfTrueOrPop && next.op == JumpIfTrueOrPop:
+		// The condition is still on the stack, and the next jump is taken
+		// again.
+		return op, next.extra, true
+
+	case op == JumpIfFalseOrPop && next.op == JumpIfFalse:
+		return JumpIfFalse, next.extra, true
+	case op == JumpIfTrueOrPop && next.op == JumpIfTrue:
+		return JumpIfTrue, next.extra, true
+
+	case op == JumpIfFalseOrPop && (next.op == JumpIfTrue || next.op == JumpIfTrueOrPop):
+		// The next jump is not taken, and pops the condition.
+		return JumpIfFalse, target + 1, true
+	case op == JumpIfTrueOrPop && (next.op == JumpIfFalse || next.op == JumpIfFalseOrPop):
+		return JumpIfTrue, target + 1, true
+	}
+	return 0, 0, false
+}
+
+// fuse fuses the first pair of instructions that can be fused, and returns
+// true if it found one.
+func (o *optimizer) fuse() bool {
+	var depths []int
+	for i := 0; i+1 < len(o.expr); i++ {
+		instr, next := o.expr[i], o.expr[i+1]
+		switch {
+		case instr.op == CompareEqNumber && next.op == Negate:
+			if o.isTarget(i + 1) {
+				continue
+			}
+			o.expr[i].op = CompareNeNumber
+			o.remove(i + 1)
+			return true
+
+		case instr.op == Duplicate && (next.op == JumpIfFalse || next.op == JumpIfTrue):
+			if depths == nil {
+				depths = o.depths()
+			}
+			if !o.canFuseShortCircuit(i, depths) {
+				continue
+			}
+			op := JumpIfFalseOrPop
+			if next.op == JumpIfTrue {
+				op = JumpIfTrueOrPop
+			}
+			o.expr[i] = Instruction{op: op, extra: next.extra}
+			o.remove(i+1, next.extra-1)
+			return true
+		}
+	}
+	return false
+}
+
+// canFuseShortCircuit returns true if the Duplicate at i starts the sequence
+// `Duplicate; JumpIfFalse L; X; And; L:` (or its || version), where X pushes
+// one value without touching the duplicated condition. Then the And always
+// produces the value pushed by X, and the sequence is equivalent to
+// `JumpIfFalseOrPop L; X; L:`.
+func (o *optimizer) canFuseShortCircuit(i int, depths []int) bool {
+	jump := i + 1
+	end := o.expr[jump].extra - 1
+	if end <= jump || end >= len(o.expr) || depths[i] < 0 {
+		return false
+	}
+	combine := And
+	if o.expr[jump].op == JumpIfTrue {
+		combine = Or
+	}
+	if o.expr[end].op != combine {
+		return false
+	}
+
+	// X can only be entered from the jump, and only exit through the And.
+	for k, instr := range o.expr {
+		if !instr.op.isJump() || k == jump {
+			continue
+		}
+		inside := k > jump && k < end
+		if inside && (instr.extra <= jump || instr.extra > end) ||
+			!inside && instr.extra >= jump && instr.extra <= end {
+			return false
+		}
+	}
+
+	// X does not pop the condition.
+	depth := depths[i]
+	for k := jump + 1; k < end; k++ {
+		if depths[k] < 0 {
+			continue
+		}
+		pops, _ := stackEffect(o.expr[k])
+		if o.expr[k].op == Return || depths[k]-pops < depth {
+			return false
+		}
+	}
+	return depths[end] == depth+1
+}
+
+// removeDeadCode removes the jumps to the next instruction and the
+// instructions that cannot be reached.
+func (o *optimizer) removeDeadCode() bool {
+	depths := o.depths()
+	var dead []int
+	for i, instr := range o.expr {
+		if depths[i] < 0 || instr.op == Jump && instr.extra == i+1 {
+			dead = append(dead, i)
+		}
+	}
+	if len(dead) == 0 {
+		return false
+	}
+	o.remove(dead...)
+	return true
+}
+
+// isTarget returns true if a jump targets addr.
+func (o *optimizer) isTarget(addr int) bool {
+	for _, instr := range o.expr {
+		if instr.op.isJump() && instr.extra == addr {
+			return true
+		}
+	}
+	return false
+}
+
+// remove removes the instructions at addrs, in increasing order, and
+// re-resolves the jump targets. A jump to a removed instruction jumps to the
+// next instruction that is not removed.
+func (o *optimizer) remove(addrs ...int) {
+	newAddrs := make([]int, len(o.expr)+1)
+	removed := 0
+	for addr := range newAddrs {
+		for removed < len(addrs) && addrs[removed] < addr {
+			removed++
+		}
+		newAddrs[addr] = addr - removed
+	}
+
+	expr := o.expr[:0]
+	next := 0
+	for addr, instr := range o.expr {
+		if next < len(addrs) && addrs[next] == addr {
+			next++
+			continue
+		}
+		if instr.op.isJump() {
+			instr.extra = newAddrs[instr.extra]
+		}
+		expr = append(expr, instr)
+	}
+	o.expr = expr
+}
+
+func (o *optimizer) depths() []int {
+	return exprDepths(o.expr)
+}
+
+// exprDepths returns the stack depth before each instruction of expr, and at
+// the end of expr, or -1 if the instruction cannot be reached.
+func exprDepths(expr Expr) []int {
+	depths := make([]int, len(expr)+1)
+	for i := range depths {
+		depths[i] = -1
+	}
+	depths[0] = 0
+	work := []int{0}
+	flow := func(addr, depth int) {
+		if addr >= 0 && addr < len(depths) && depths[addr] < 0 {
+			depths[addr] = depth
+			work = append(work, addr)
+		}
+	}
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+		if addr == len(expr) {
+			continue
+		}
+		instr := expr[addr]
+		depth := depths[addr]
+		switch instr.op {
+		case Return:
+			continue
+		case Jump:
+			flow(instr.extra, depth)
+			continue
+		case JumpIfTrue, JumpIfFalse:
+			flow(instr.extra, depth-1)
+		case JumpIfTrueOrPop, JumpIfFalseOrPop, IterNext:
+			flow(instr.extra, depth)
+		}
+		pops, pushes := stackEffect(instr)
+		flow(addr+1, depth-pops+pushes)
+	}
+	return depths
+}
+
+// stackEffect returns the number of values that instr pops from the stack,
+// and the number it pushes, when it does not jump.
+func stackEffect(instr Instruction) (pops, pushes int) {
+	switch instr.op {
+	case PushNumber, PushString, PushBool, PushValue, LoadConst, LoadInput, LoadLocal:
+		return 0, 1
+	case PushArray:
+		return instr.extra, 1
+	case Call:
+		return instr.extra + 1, 1
+	case Duplicate:
+		return 1, 2
+	case Box, Negate, InSetNumber, InSetString:
+		return 1, 1
+	case StoreLocal, IterInit, Return, JumpIfTrue, JumpIfFalse, JumpIfTrueOrPop, JumpIfFalseOrPop:
+		return 1, 0
+	case Jump:
+		return 0, 0
+	case IterNext:
+		return 0, 1
+	default:
+		return 2, 1
+	}
+}
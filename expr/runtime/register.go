@@ -0,0 +1,649 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// regInstr is an instruction of register code. It reads its operands from the
+// registers a, b and args, and writes its result to the register dst.
+// Operations that exist in the bytecode have the same meaning, except that
+// they take their operands from registers instead of the stack.
+type regInstr struct {
+	op  Operation
+	dst int
+	a   int
+	b   int
+
+	// args are the arguments of Call, and the elements of PushArray.
+	args []int
+
+	// extra is the jump target of jumps, the box type index of Box, and the
+	// constant index of InSetNumber and InSetString.
+	extra int
+}
+
+type regExpr []regInstr
+
+// regCode is the register code of a program. The registers are laid out as:
+//
+//	locals | stack slots | constants | inputs | immediates
+//
+// The locals are the locals of the bytecode, and the stack slot registers hold
+// the values of the stack at the corresponding depth. The registers of the
+// constants and the immediates are set once by NewRuntime, and the registers
+// of the inputs by each Run. Since instructions read constants, inputs,
+// immediates and locals from their registers directly, the loads of the
+// bytecode, and most of its stack traffic, disappear.
+type regCode struct {
+	exprs []regExpr
+	size  int
+
+	slots  int
+	consts int
+	inputs int
+	imms   int
+
+	immValues []RawValue
+	immNames  []string
+}
+
+// CompileRegisters translates the bytecode of the expressions to register
+// code, which Run executes instead of the bytecode. Programs compiled to
+// closures still run the closures.
+//
+// The register code is an experiment: it is not serialized, and it is not
+// verified by Verify, although it is translated from bytecode that must be
+// valid.
+func (p *Program) CompileRegisters() error {
+	depths := make([][]int, len(p.exprs))
+	maxDepth := 0
+	for i, expr := range p.exprs {
+		depths[i] = exprDepths(expr)
+		for _, depth := range depths[i] {
+			if depth > maxDepth {
+				maxDepth = depth
+			}
+		}
+	}
+
+	code := &regCode{
+		exprs:  make([]regExpr, len(p.exprs)),
+		slots:  p.locals,
+		consts: p.locals + maxDepth,
+	}
+	code.inputs = code.consts + len(p.consts)
+	code.imms = code.inputs + len(p.inputs)
+
+	imms := make(map[immKey]int)
+	for i, expr := range p.exprs {
+		t := &regTranslator{
+			p:       p,
+			code:    code,
+			imms:    imms,
+			expr:    expr,
+			depths:  depths[i],
+			addrs:   make([]int, len(expr)+1),
+			targets: make([]bool, len(expr)+1),
+		}
+		out, err := t.translate()
+		if err != nil {
+			return fmt.Errorf("expression %d: %w", i, err)
+		}
+		code.exprs[i] = out
+	}
+	code.size = code.imms + len(code.immValues)
+	p.regs = code
+	return nil
+}
+
+// RegisterInstrCount returns the number of instructions of the register code
+// of the expression exprIndex, or 0 if the program was not compiled with
+// CompileRegisters.
+func (p *Program) RegisterInstrCount(exprIndex int) int {
+	if p.regs == nil {
+		return 0
+	}
+	return len(p.regs.exprs[exprIndex])
+}
+
+// immKey identifies an immediate value.
+type immKey struct {
+	typ types.Type
+	num uint64
+	str string
+}
+
+// regTranslator translates the bytecode of an expression to register code by
+// simulating the stack: each stack entry is the register that holds its
+// value. Loads and Duplicate push existing registers without emitting
+// instructions, and operations write their results to the stack slot register
+// of their depth.
+//
+// At jumps and jump targets the stack is materialized: each entry is moved to
+// the stack slot register of its depth, so that every path to a jump target
+// leaves the stack in the same registers.
+type regTranslator struct {
+	p      *Program
+	code   *regCode
+	imms   map[immKey]int
+	expr   Expr
+	depths []int
+
+	out   regExpr
+	stack []int
+	live  bool
+
+	// addrs are the addresses in out of the jump targets of expr, and
+	// lastTarget is the address in out of the last jump target.
+	addrs      []int
+	targets    []bool
+	lastTarget int
+}
+
+func (t *regTranslator) translate() (regExpr, error) {
+	for _, instr := range t.expr {
+		if instr.op.isJump() {
+			t.targets[instr.extra] = true
+		}
+	}
+
+	t.live = true
+	for addr := 0; addr <= len(t.expr); addr++ {
+		if t.targets[addr] {
+			if t.live {
+				t.materialize()
+			}
+			t.addrs[addr] = len(t.out)
+			t.lastTarget = len(t.out)
+			t.live = t.depths[addr] >= 0
+			t.stack = t.stack[:0]
+			for i := 0; i < t.depths[addr]; i++ {
+				t.stack = append(t.stack, t.slot(i))
+			}
+		}
+		if !t.live {
+			continue
+		}
+		if addr == len(t.expr) {
+			if len(t.stack) != 1 {
+				return nil, fmt.Errorf("stack depth is %d at the end", len(t.stack))
+			}
+			t.emit(regInstr{op: Return, a: t.pop()})
+			break
+		}
+		err := t.translateInstr(t.expr[addr])
+		if err != nil {
+			return nil, fmt.Errorf("instruction %d (%v): %w", addr, t.expr[addr].op, err)
+		}
+	}
+
+	for i := range t.out {
+		if t.out[i].op.isJump() {
+			t.out[i].extra = t.addrs[t.out[i].extra]
+		}
+	}
+	return t.out, nil
+}
+
+func (t *regTranslator) translateInstr(instr Instruction) error {
+	pops, _ := stackEffect(instr)
+	if pops > len(t.stack) {
+		return fmt.Errorf("stack underflow")
+	}
+
+	switch instr.op {
+	case PushNumber:
+		t.push(t.imm(types.Number, NewRawNumber(instr.vnum)))
+	case PushString:
+		t.push(t.imm(types.String, NewRawObject(t.p.strings[instr.extra])))
+	case PushBool:
+		t.push(t.imm(types.Bool, NewRawBool(instr.extra != 0)))
+	case LoadConst:
+		t.push(t.code.consts + instr.extra)
+	case LoadInput:
+		t.push(t.code.inputs + instr.extra)
+	case LoadLocal:
+		t.push(instr.extra)
+	case Duplicate:
+		t.push(t.stack[len(t.stack)-1])
+
+	case StoreLocal:
+		t.storeLocal(instr.extra)
+	case IterInit:
+		src := t.pop()
+		t.materializeLocal(instr.extra)
+		t.emit(regInstr{op: IterInit, dst: instr.extra, a: src})
+	case IterNext:
+		t.materialize()
+		dst := t.slot(len(t.stack))
+		t.emit(regInstr{op: IterNext, dst: dst, a: instr.arg, extra: instr.extra})
+		t.push(dst)
+
+	case Jump:
+		t.materialize()
+		t.emit(regInstr{op: Jump, extra: instr.extra})
+		t.live = false
+	case JumpIfTrue, JumpIfFalse:
+		cond := t.pop()
+		t.materialize()
+		t.emit(regInstr{op: instr.op, a: cond, extra: instr.extra})
+	case JumpIfTrueOrPop, JumpIfFalseOrPop:
+		// The condition stays in its stack slot when the jump is taken.
+		t.materialize()
+		op := JumpIfTrue
+		if instr.op == JumpIfFalseOrPop {
+			op = JumpIfFalse
+		}
+		t.emit(regInstr{op: op, a: t.pop(), extra: instr.extra})
+	case Return:
+		t.emit(regInstr{op: Return, a: t.pop()})
+		t.live = false
+
+	case Call, PushArray:
+		args := make([]int, instr.extra)
+		for i := len(args) - 1; i >= 0; i-- {
+			args[i] = t.pop()
+		}
+		fn := 0
+		if instr.op == Call {
+			fn = t.pop()
+		}
+		t.emitValue(regInstr{op: instr.op, a: fn, args: args})
+	case Box:
+		t.emitValue(regInstr{op: Box, a: t.pop(), extra: instr.extra})
+	case Negate:
+		t.emitValue(regInstr{op: Negate, a: t.pop()})
+	case InSetNumber, InSetString:
+		// b is only used by the listing.
+		t.emitValue(regInstr{
+			op: instr.op, a: t.pop(), b: t.code.consts + instr.extra, extra: instr.extra})
+	case Add, Subtract, Multiply, Divide, And, Or,
+		CompareEq, CompareEqBool, CompareEqNumber, CompareEqString, CompareNeNumber,
+		CompareLT, CompareLE, CompareGT, CompareGE,
+		ArrayAppend, InArray, InArrayNumber, InArrayString, InPrefix:
+		b, a := t.pop(), t.pop()
+		t.emitValue(regInstr{op: instr.op, a: a, b: b})
+
+	default:
+		return fmt.Errorf("invalid operation")
+	}
+	return nil
+}
+
+// storeLocal translates a StoreLocal to local.
+func (t *regTranslator) storeLocal(local int) {
+	src := t.pop()
+	referenced := false
+	for _, reg := range t.stack {
+		if reg == local {
+			referenced = true
+		}
+	}
+
+	// The instruction that computed the value can write it to the local
+	// directly, if it is not a jump target and the stack does not refer to
+	// the previous value of the local. IterNext only writes the element when
+	// it does not jump.
+	last := len(t.out) - 1
+	if !referenced && src == t.slot(len(t.stack)) && last >= t.lastTarget &&
+		t.out[last].dst == src && (!t.out[last].op.isJump() || t.out[last].op == IterNext) {
+		t.out[last].dst = local
+		return
+	}
+
+	t.materializeLocal(local)
+	if src != local {
+		t.emit(regInstr{op: Move, dst: local, a: src})
+	}
+}
+
+// materialize moves every stack entry to the stack slot register of its depth.
+func (t *regTranslator) materialize() {
+	for i, reg := range t.stack {
+		if reg != t.slot(i) {
+			t.emit(regInstr{op: Move, dst: t.slot(i), a: reg})
+			t.stack[i] = t.slot(i)
+		}
+	}
+}
+
+// materializeLocal moves the stack entries that refer to local to their stack
+// slot registers, before the local is overwritten.
+func (t *regTranslator) materializeLocal(local int) {
+	for i, reg := range t.stack {
+		if reg == local {
+			t.emit(regInstr{op: Move, dst: t.slot(i), a: reg})
+			t.stack[i] = t.slot(i)
+		}
+	}
+}
+
+// emitValue emits an instruction that pushes its result.
+func (t *regTranslator) emitValue(instr regInstr) {
+	instr.dst = t.slot(len(t.stack))
+	t.emit(instr)
+	t.push(instr.dst)
+}
+
+func (t *regTranslator) emit(instr regInstr) {
+	t.out = append(t.out, instr)
+}
+
+func (t *regTranslator) push(reg int) {
+	t.stack = append(t.stack, reg)
+}
+
+func (t *regTranslator) pop() int {
+	reg := t.stack[len(t.stack)-1]
+	t.stack = t.stack[:len(t.stack)-1]
+	return reg
+}
+
+// slot returns the stack slot register of depth.
+func (t *regTranslator) slot(depth int) int {
+	return t.code.slots + depth
+}
+
+// imm returns the register of an immediate value.
+func (t *regTranslator) imm(typ types.Type, v RawValue) int {
+	key := immKey{typ: typ, num: math.Float64bits(v.num)}
+	if s, ok := v.obj.(string); ok {
+		key.str = s
+	}
+	index, ok := t.imms[key]
+	if !ok {
+		index = len(t.code.immValues)
+		t.code.immValues = append(t.code.immValues, v)
+		t.code.immNames = append(t.code.immNames, formatValue(typ, v))
+		t.imms[key] = index
+	}
+	return t.code.imms + index
+}
+
+// newRegisters returns the registers of the program, with its constants and
+// immediates.
+func (c *regCode) newRegisters(consts []Value) []RawValue {
+	regs := make([]RawValue, c.size)
+	for i, v := range consts {
+		regs[c.consts+i] = v.RawValue
+	}
+	copy(regs[c.imms:], c.immValues)
+	return regs
+}
+
+// runRegisters runs the register code of the expression at exprIndex.
+func (r *Runtime) runRegisters(
+	ctx context.Context,
+	exprIndex int,
+	inputs []Value,
+) (Value, error) {
+	regs := r.regs
+	code := r.program.regs
+	for i, input := range inputs {
+		regs[code.inputs+i] = input.RawValue
+	}
+
+	exprInstr := code.exprs[exprIndex]
+	remaining := r.budget
+	for n := 0; n < len(exprInstr); {
+		instr := &exprInstr[n]
+		switch instr.op {
+		case Move:
+			regs[instr.dst] = regs[instr.a]
+		case PushArray:
+			arr := make([]RawValue, len(instr.args))
+			for i, arg := range instr.args {
+				arr[i] = regs[arg]
+			}
+			regs[instr.dst] = NewRawObject(arr)
+		case Box:
+			v := Value{typ: r.program.boxTypes[instr.extra], RawValue: regs[instr.a]}
+			regs[instr.dst] = NewRawObject(v)
+		case Add:
+			regs[instr.dst] = NewRawNumber(regs[instr.a].num + regs[instr.b].num)
+		case Subtract:
+			regs[instr.dst] = NewRawNumber(regs[instr.a].num - regs[instr.b].num)
+		case Multiply:
+			regs[instr.dst] = NewRawNumber(regs[instr.a].num * regs[instr.b].num)
+		case Divide:
+			regs[instr.dst] = NewRawNumber(regs[instr.a].num / regs[instr.b].num)
+		case Negate:
+			regs[instr.dst] = NewRawBool(!regs[instr.a].Bool())
+		case And:
+			regs[instr.dst] = NewRawBool(regs[instr.a].Bool() && regs[instr.b].Bool())
+		case Or:
+			regs[instr.dst] = NewRawBool(regs[instr.a].Bool() || regs[instr.b].Bool())
+		case CompareEq:
+			regs[instr.dst] = NewRawBool(regs[instr.a].Equal(regs[instr.b]))
+		case CompareEqBool:
+			regs[instr.dst] = NewRawBool(regs[instr.a].Bool() == regs[instr.b].Bool())
+		case CompareEqString:
+			regs[instr.dst] = NewRawBool(regs[instr.a].String() == regs[instr.b].String())
+		case CompareEqNumber:
+			regs[instr.dst] = NewRawBool(regs[instr.a].num == regs[instr.b].num)
+		case CompareNeNumber:
+			regs[instr.dst] = NewRawBool(regs[instr.a].num != regs[instr.b].num)
+		case CompareLT:
+			regs[instr.dst] = NewRawBool(regs[instr.a].num < regs[instr.b].num)
+		case CompareLE:
+			regs[instr.dst] = NewRawBool(regs[instr.a].num <= regs[instr.b].num)
+		case CompareGT:
+			regs[instr.dst] = NewRawBool(regs[instr.a].num > regs[instr.b].num)
+		case CompareGE:
+			regs[instr.dst] = NewRawBool(regs[instr.a].num >= regs[instr.b].num)
+		case Jump:
+			n = instr.extra
+			continue
+		case JumpIfTrue:
+			if regs[instr.a].Bool() {
+				n = instr.extra
+				continue
+			}
+		case JumpIfFalse:
+			if !regs[instr.a].Bool() {
+				n = instr.extra
+				continue
+			}
+		case Call:
+			argCount := len(instr.args)
+			if cap(r.callArgs) < argCount {
+				r.callArgs = make([]Value, argCount)
+			} else {
+				r.callArgs = r.callArgs[:argCount]
+			}
+			fn := regs[instr.a].Object().(*Func)
+			fnType := fn.Type.(*types.Function)
+			for i, arg := range instr.args {
+				r.callArgs[i].RawValue = regs[arg]
+				if fnType.ParamType(i) == types.Any {
+					r.callArgs[i] = r.callArgs[i].Object().(Value)
+				}
+			}
+			res, err := CallFunc(ctx, fn, r.callArgs)
+			if err != nil {
+				return Value{}, err
+			}
+			regs[instr.dst] = res.RawValue
+		case Return:
+			return Value{typ: r.program.ResultType, RawValue: regs[instr.a]}, nil
+
+		case IterInit:
+			regs[instr.dst] = NewRawObject(regs[instr.a].Object())
+		case IterNext:
+			// The iterator keeps the array in obj and the index of the next
+			// element in num.
+			iter := &regs[instr.a]
+			arr := iter.obj.([]RawValue)
+			if int(iter.num) >= len(arr) {
+				n = instr.extra
+				continue
+			}
+			if r.budget != 0 {
+				if remaining == 0 {
+					return Value{}, ErrBudgetExceeded
+				}
+				remaining--
+			}
+			regs[instr.dst] = arr[int(iter.num)]
+			iter.num++
+		case ArrayAppend:
+			arr := regs[instr.a].Object().([]RawValue)
+			regs[instr.dst] = NewRawObject(append(arr, regs[instr.b]))
+
+		case InArrayString:
+			left := regs[instr.a].String()
+			res := false
+			for _, elem := range regs[instr.b].Object().([]RawValue) {
+				if left == elem.String() {
+					res = true
+					break
+				}
+			}
+			regs[instr.dst] = NewRawBool(res)
+
+		case InArray:
+			left := regs[instr.a]
+			res := false
+			for _, elem := range regs[instr.b].Object().([]RawValue) {
+				if left.Equal(elem) {
+					res = true
+					break
+				}
+			}
+			regs[instr.dst] = NewRawBool(res)
+
+		case InArrayNumber:
+			left := regs[instr.a].Number()
+			res := false
+			for _, elem := range regs[instr.b].Object().([]RawValue) {
+				if left == elem.Number() {
+					res = true
+					break
+				}
+			}
+			regs[instr.dst] = NewRawBool(res)
+
+		case InPrefix:
+			addr := regs[instr.a].Object().(netip.Addr)
+			prefix := regs[instr.b].Object().(netip.Prefix)
+			regs[instr.dst] = NewRawBool(prefix.Contains(addr))
+
+		case InSetNumber:
+			set := r.program.sets[instr.extra]
+			regs[instr.dst] = NewRawBool(set.ContainsNumber(regs[instr.a].Number()))
+
+		case InSetString:
+			set := r.program.sets[instr.extra]
+			regs[instr.dst] = NewRawBool(set.ContainsString(regs[instr.a].String()))
+
+		default:
+			panic("invalid op")
+		}
+
+		n++
+	}
+
+	return Value{}, fmt.Errorf("invalid program: expression %d does not return", exprIndex)
+}
+
+// DisassembleRegisters writes a listing of the register code of the program,
+// in the format of Disassemble. Registers are written as local[i] for locals,
+// r<i> for stack slots, const[i] for constants and input[i] for inputs, and
+// immediates as their values. For example, `a > 1 && s == "x"` is:
+//
+//	expr 0:
+//	  0000  CompareGT        r0, input[0], 1
+//	  0001  JumpIfFalse      r0, L0
+//	  0002  CompareEqString  r0, input[1], "x"
+//	L0:
+//	  0003  Return           r0
+func (p *Program) DisassembleRegisters(w io.Writer) error {
+	if p.regs == nil {
+		return fmt.Errorf("program was not compiled to registers")
+	}
+	bw := bufio.NewWriter(w)
+	for i, expr := range p.regs.exprs {
+		if i != 0 {
+			fmt.Fprintln(bw)
+		}
+		fmt.Fprintf(bw, "expr %d:\n", i)
+
+		targets := make(map[int]bool)
+		for _, instr := range expr {
+			if instr.op.isJump() {
+				targets[instr.extra] = true
+			}
+		}
+		labels := make(map[int]string)
+		for addr := 0; addr <= len(expr); addr++ {
+			if targets[addr] {
+				labels[addr] = "L" + strconv.Itoa(len(labels))
+			}
+		}
+
+		for addr, instr := range expr {
+			if label, ok := labels[addr]; ok {
+				fmt.Fprintf(bw, "%s:\n", label)
+			}
+			operands := strings.Join(p.regs.operands(instr, labels, p.boxTypes), ", ")
+			fmt.Fprintln(bw, strings.TrimRight(
+				fmt.Sprintf("  %04d  %-16s %s", addr, instr.op, operands), " "))
+		}
+		if label, ok := labels[len(expr)]; ok {
+			fmt.Fprintf(bw, "%s:\n", label)
+		}
+	}
+	return bw.Flush()
+}
+
+func (c *regCode) operands(instr regInstr, labels map[int]string, boxTypes []types.Type) []string {
+	switch instr.op {
+	case Jump:
+		return []string{labels[instr.extra]}
+	case JumpIfTrue, JumpIfFalse:
+		return []string{c.regName(instr.a), labels[instr.extra]}
+	case IterNext:
+		return []string{c.regName(instr.dst), c.regName(instr.a), labels[instr.extra]}
+	case Return:
+		return []string{c.regName(instr.a)}
+	case Move, Negate, IterInit:
+		return []string{c.regName(instr.dst), c.regName(instr.a)}
+	case Box:
+		return []string{c.regName(instr.dst), c.regName(instr.a), boxTypes[instr.extra].String()}
+	case Call, PushArray:
+		operands := []string{c.regName(instr.dst)}
+		if instr.op == Call {
+			operands = append(operands, c.regName(instr.a))
+		}
+		for _, arg := range instr.args {
+			operands = append(operands, c.regName(arg))
+		}
+		return operands
+	default:
+		return []string{c.regName(instr.dst), c.regName(instr.a), c.regName(instr.b)}
+	}
+}
+
+func (c *regCode) regName(reg int) string {
+	switch {
+	case reg < c.slots:
+		return fmt.Sprintf("local[%d]", reg)
+	case reg < c.consts:
+		return fmt.Sprintf("r%d", reg-c.slots)
+	case reg < c.inputs:
+		return fmt.Sprintf("const[%d]", reg-c.consts)
+	case reg < c.imms:
+		return fmt.Sprintf("input[%d]", reg-c.inputs)
+	default:
+		return c.immNames[reg-c.imms]
+	}
+}
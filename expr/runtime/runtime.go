@@ -2,7 +2,9 @@ package runtime
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/netip"
 
 	"github.com/dcaiafa/go-expr/expr/types"
 )
@@ -14,10 +16,10 @@ const (
 
 	Add
 	And
+	ArrayAppend
+	Box
 	Call
-	CompareEqArrayBool
-	CompareEqArrayNumber
-	CompareEqArrayString
+	CompareEq
 	CompareEqBool
 	CompareEqNumber
 	CompareEqString
@@ -25,15 +27,26 @@ const (
 	CompareGT
 	CompareLE
 	CompareLT
+	CompareNeNumber
 	Divide
 	Duplicate
+	InArray
 	InArrayNumber
 	InArrayString
+	InPrefix
+	InSetNumber
+	InSetString
+	IterInit
+	IterNext
 	Jump
 	JumpIfFalse
+	JumpIfFalseOrPop
 	JumpIfTrue
+	JumpIfTrueOrPop
 	LoadConst
 	LoadInput
+	LoadLocal
+	Move
 	Multiply
 	Negate
 	Or
@@ -43,12 +56,81 @@ const (
 	PushString
 	PushValue
 	Return
+	StoreLocal
 	Subtract
 )
 
+// opNames are the names of the operations.
+var opNames = map[Operation]string{
+	InvalidOperation: "InvalidOperation",
+	Add:              "Add",
+	And:              "And",
+	ArrayAppend:      "ArrayAppend",
+	Box:              "Box",
+	Call:             "Call",
+	CompareEq:        "CompareEq",
+	CompareEqBool:    "CompareEqBool",
+	CompareEqNumber:  "CompareEqNumber",
+	CompareEqString:  "CompareEqString",
+	CompareGE:        "CompareGE",
+	CompareGT:        "CompareGT",
+	CompareLE:        "CompareLE",
+	CompareLT:        "CompareLT",
+	CompareNeNumber:  "CompareNeNumber",
+	Divide:           "Divide",
+	Duplicate:        "Duplicate",
+	InArray:          "InArray",
+	InArrayNumber:    "InArrayNumber",
+	InArrayString:    "InArrayString",
+	InPrefix:         "InPrefix",
+	InSetNumber:      "InSetNumber",
+	InSetString:      "InSetString",
+	IterInit:         "IterInit",
+	IterNext:         "IterNext",
+	Jump:             "Jump",
+	JumpIfFalse:      "JumpIfFalse",
+	JumpIfFalseOrPop: "JumpIfFalseOrPop",
+	JumpIfTrue:       "JumpIfTrue",
+	JumpIfTrueOrPop:  "JumpIfTrueOrPop",
+	LoadConst:        "LoadConst",
+	LoadInput:        "LoadInput",
+	LoadLocal:        "LoadLocal",
+	Move:             "Move",
+	Multiply:         "Multiply",
+	Negate:           "Negate",
+	Or:               "Or",
+	PushArray:        "PushArray",
+	PushBool:         "PushBool",
+	PushNumber:       "PushNumber",
+	PushString:       "PushString",
+	PushValue:        "PushValue",
+	Return:           "Return",
+	StoreLocal:       "StoreLocal",
+	Subtract:         "Subtract",
+}
+
+// String returns the name of the operation.
+func (o Operation) String() string {
+	if name, ok := opNames[o]; ok {
+		return name
+	}
+	return fmt.Sprintf("Operation(%d)", int(o))
+}
+
+// isJump returns true if the operation's extra operand is a jump target.
+func (o Operation) isJump() bool {
+	switch o {
+	case Jump, JumpIfTrue, JumpIfFalse, JumpIfTrueOrPop, JumpIfFalseOrPop, IterNext:
+		return true
+	default:
+		return false
+	}
+}
+
 type Instruction struct {
 	op    Operation
 	extra int
+	arg   int
 	vnum  float64
 }
 
@@ -66,42 +148,87 @@ type Program struct {
 	strings []string
 	consts  []Value
 	inputs  []types.Type
+	locals  int
+
+	// boxTypes are the types referenced by Box instructions.
+	boxTypes []types.Type
+
+	// sets are the sets of the constant arrays referenced by InSetNumber and
+	// InSetString instructions, by constant index.
+	sets map[int]*Set
+
+	// closures are set if the expressions were also compiled to closures, in
+	// which case Run executes them instead of the bytecode.
+	closures []Closure
+
+	// regs is set if the expressions were also compiled to register code, in
+	// which case Run executes it instead of the bytecode.
+	regs *regCode
 }
 
 func (p *Program) ExprCount() int {
 	return len(p.exprs)
 }
 
+// InstrCount returns the number of instructions of the expression exprIndex.
+func (p *Program) InstrCount(exprIndex int) int {
+	return len(p.exprs[exprIndex])
+}
+
+// ErrBudgetExceeded is returned by Run when the execution exceeds the budget
+// set with SetBudget.
+var ErrBudgetExceeded = errors.New("execution budget exceeded")
+
+// ErrInternal classifies errors caused by a bug in the compiler rather than
+// by the input. Use errors.Is to test for it.
+var ErrInternal = errors.New("internal compiler error")
+
 type Runtime struct {
 	program  *Program
 	stack    []RawValue
+	locals   []RawValue
 	callArgs []Value
+	regs     []RawValue
+	budget   int
+	env      Env
 }
 
 func NewRuntime(program *Program) *Runtime {
-	return &Runtime{
+	r := &Runtime{
 		program: program,
 		stack:   make([]RawValue, 0, 30),
+		locals:  make([]RawValue, program.locals),
 	}
+	r.env.locals = r.locals
+	if program.regs != nil {
+		r.regs = program.regs.newRegisters(program.consts)
+	}
+	return r
+}
+
+// SetBudget limits the number of loop iterations that a single call to Run can
+// execute. Zero, the default, means no limit.
+func (r *Runtime) SetBudget(budget int) {
+	r.budget = budget
 }
 
 func (r *Runtime) Run(ctx context.Context, exprIndex int, inputs []Value) (Value, error) {
 	r.stack = r.stack[:0]
 
-	if len(inputs) != len(r.program.inputs) {
-		return Value{}, fmt.Errorf(
-			"program expects %d inputs but %d were provided",
-			len(r.program.inputs), len(inputs))
+	err := checkInputs(r.program.inputs, inputs)
+	if err != nil {
+		return Value{}, err
 	}
-	for i, input := range inputs {
-		if !input.Type().Equal(r.program.inputs[i]) {
-			return Value{}, fmt.Errorf(
-				"program expects input index %d type %v but %v was provided",
-				i, r.program.inputs[i], input.Type())
-		}
+
+	if r.program.closures != nil {
+		return r.runClosure(ctx, exprIndex, inputs)
+	}
+	if r.program.regs != nil {
+		return r.runRegisters(ctx, exprIndex, inputs)
 	}
 
 	exprInstr := r.program.exprs[exprIndex]
+	remaining := r.budget
 
 Loop:
 	for n := 0; n < len(exprInstr); {
@@ -124,8 +251,15 @@ Loop:
 			r.push(r.program.consts[instr.extra].RawValue)
 		case LoadInput:
 			r.push(inputs[instr.extra].RawValue)
+		case LoadLocal:
+			r.push(r.locals[instr.extra])
+		case StoreLocal:
+			r.locals[instr.extra] = r.pop()
 		case Duplicate:
 			r.push(r.peek())
+		case Box:
+			v := Value{typ: r.program.boxTypes[instr.extra], RawValue: r.pop()}
+			r.push(NewRawObject(v))
 		case Add:
 			right, left := r.pop(), r.pop()
 			r.push(NewRawNumber(left.num + right.num))
@@ -147,12 +281,9 @@ Loop:
 		case Or:
 			right, left := r.pop(), r.pop()
 			r.push(NewRawBool(left.Bool() || right.Bool()))
-		case CompareEqArrayBool:
-			r.compareEqArrayBool()
-		case CompareEqArrayNumber:
-			r.compareEqArrayNumber()
-		case CompareEqArrayString:
-			r.compareEqArrayString()
+		case CompareEq:
+			right, left := r.pop(), r.pop()
+			r.push(NewRawBool(left.Equal(right)))
 		case CompareEqBool:
 			right, left := r.pop(), r.pop()
 			r.push(NewRawBool(left.Bool() == right.Bool()))
@@ -162,6 +293,9 @@ Loop:
 		case CompareEqNumber:
 			right, left := r.pop(), r.pop()
 			r.push(NewRawBool(left.num == right.num))
+		case CompareNeNumber:
+			right, left := r.pop(), r.pop()
+			r.push(NewRawBool(left.num != right.num))
 		case CompareLT:
 			right, left := r.pop(), r.pop()
 			r.push(NewRawBool(left.num < right.num))
@@ -189,6 +323,19 @@ Loop:
 				n = instr.extra
 				continue
 			}
+		case JumpIfTrueOrPop:
+			// The value stays on the stack if the jump is taken.
+			if r.peek().Bool() {
+				n = instr.extra
+				continue
+			}
+			r.pop()
+		case JumpIfFalseOrPop:
+			if !r.peek().Bool() {
+				n = instr.extra
+				continue
+			}
+			r.pop()
 		case Call:
 			argCount := instr.extra
 			if cap(r.callArgs) < argCount {
@@ -202,17 +349,42 @@ Loop:
 			fn := r.pop().Object().(*Func)
 			fnType := fn.Type.(*types.Function)
 			for i := range r.callArgs {
-				r.callArgs[i].typ = fnType.Params[i]
+				if fnType.ParamType(i) == types.Any {
+					r.callArgs[i] = r.callArgs[i].Object().(Value)
+				}
 			}
-			res := fn.Func(ctx, r.callArgs)
-			if !res.Type().Equal(fnType.Ret) {
-				return Value{}, fmt.Errorf("function returned %v expected %v",
-					res.Type(), fnType.Ret)
+			res, err := CallFunc(ctx, fn, r.callArgs)
+			if err != nil {
+				return Value{}, err
 			}
 			r.push(res.RawValue)
 		case Return:
 			break Loop
 
+		case IterInit:
+			r.locals[instr.extra] = NewRawObject(r.pop().Object())
+		case IterNext:
+			// The iterator keeps the array in obj and the index of the next
+			// element in num.
+			iter := &r.locals[instr.arg]
+			arr := iter.obj.([]RawValue)
+			if int(iter.num) >= len(arr) {
+				n = instr.extra
+				continue
+			}
+			if r.budget != 0 {
+				if remaining == 0 {
+					return Value{}, ErrBudgetExceeded
+				}
+				remaining--
+			}
+			r.push(arr[int(iter.num)])
+			iter.num++
+		case ArrayAppend:
+			elem := r.pop()
+			arr := r.pop().Object().([]RawValue)
+			r.push(NewRawObject(append(arr, elem)))
+
 		case InArrayString:
 			right := r.pop().Object().([]RawValue)
 			left := r.pop().String()
@@ -225,6 +397,18 @@ Loop:
 			}
 			r.push(NewRawBool(res))
 
+		case InArray:
+			right := r.pop().Object().([]RawValue)
+			left := r.pop()
+			res := false
+			for _, elem := range right {
+				if left.Equal(elem) {
+					res = true
+					break
+				}
+			}
+			r.push(NewRawBool(res))
+
 		case InArrayNumber:
 			right := r.pop().Object().([]RawValue)
 			left := r.pop().Number()
@@ -237,6 +421,19 @@ Loop:
 			}
 			r.push(NewRawBool(res))
 
+		case InPrefix:
+			prefix := r.pop().Object().(netip.Prefix)
+			addr := r.pop().Object().(netip.Addr)
+			r.push(NewRawBool(prefix.Contains(addr)))
+
+		case InSetNumber:
+			set := r.program.sets[instr.extra]
+			r.push(NewRawBool(set.ContainsNumber(r.pop().Number())))
+
+		case InSetString:
+			set := r.program.sets[instr.extra]
+			r.push(NewRawBool(set.ContainsString(r.pop().String())))
+
 		default:
 			panic("invalid op")
 		}
@@ -252,6 +449,54 @@ Loop:
 	return Value{typ: r.program.ResultType, RawValue: r.stack[0]}, nil
 }
 
+// CallFunc calls fn with args like the Call instruction. The arguments for
+// parameters of type Any keep their type, and the other arguments take the
+// type of their parameter. It is an error for fn to return a value of a type
+// other than its result type.
+func CallFunc(ctx context.Context, fn *Func, args []Value) (Value, error) {
+	if fn.Func == nil && fn.FuncErr == nil {
+		return Value{}, fmt.Errorf("function %v is not linked", fn.Name)
+	}
+	fnType := fn.Type.(*types.Function)
+	for i := range args {
+		paramType := fnType.ParamType(i)
+		if paramType != types.Any {
+			args[i].typ = paramType
+		}
+	}
+	var res Value
+	if fn.FuncErr != nil {
+		var err error
+		res, err = fn.FuncErr(ctx, args)
+		if err != nil {
+			return Value{}, err
+		}
+	} else {
+		res = fn.Func(ctx, args)
+	}
+	if !res.Type().Equal(fnType.Ret) {
+		return Value{}, fmt.Errorf("function returned %v expected %v",
+			res.Type(), fnType.Ret)
+	}
+	return res, nil
+}
+
+func checkInputs(expected []types.Type, inputs []Value) error {
+	if len(inputs) != len(expected) {
+		return fmt.Errorf(
+			"program expects %d inputs but %d were provided",
+			len(expected), len(inputs))
+	}
+	for i, input := range inputs {
+		if !input.Type().Equal(expected[i]) {
+			return fmt.Errorf(
+				"program expects input index %d type %v but %v was provided",
+				i, expected[i], input.Type())
+		}
+	}
+	return nil
+}
+
 func (r *Runtime) push(v RawValue) {
 	r.stack = append(r.stack, v)
 }
@@ -265,54 +510,3 @@ func (r *Runtime) pop() RawValue {
 func (r *Runtime) peek() RawValue {
 	return r.stack[len(r.stack)-1]
 }
-
-func (r *Runtime) compareEqArrayBool() {
-	right := r.pop().Object().([]RawValue)
-	left := r.pop().Object().([]RawValue)
-	if len(left) != len(right) {
-		r.push(NewRawBool(false))
-		return
-	}
-	for i := range left {
-		if left[i].Bool() != right[i].Bool() {
-			r.push(NewRawBool(false))
-			return
-		}
-	}
-	r.push(NewRawBool(true))
-	return
-}
-
-func (r *Runtime) compareEqArrayNumber() {
-	right := r.pop().Object().([]RawValue)
-	left := r.pop().Object().([]RawValue)
-	if len(left) != len(right) {
-		r.push(NewRawBool(false))
-		return
-	}
-	for i := range left {
-		if left[i].Number() != right[i].Number() {
-			r.push(NewRawBool(false))
-			return
-		}
-	}
-	r.push(NewRawBool(true))
-	return
-}
-
-func (r *Runtime) compareEqArrayString() {
-	right := r.pop().Object().([]RawValue)
-	left := r.pop().Object().([]RawValue)
-	if len(left) != len(right) {
-		r.push(NewRawBool(false))
-		return
-	}
-	for i := range left {
-		if left[i].String() != right[i].String() {
-			r.push(NewRawBool(false))
-			return
-		}
-	}
-	r.push(NewRawBool(true))
-	return
-}
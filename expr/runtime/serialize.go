@@ -0,0 +1,532 @@
+package runtime
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+	"regexp"
+
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// programMagic identifies serialized programs.
+const programMagic = "goexpr"
+
+// programVersion is the version of the serialization format. It must be
+// incremented when the format or the operations change.
+const programVersion = 4
+
+// Type tags of the type encoding. The basic types are encoded as their tag
+// alone.
+const (
+	typeNil byte = iota
+	typeVoid
+	typeNumber
+	typeString
+	typeBool
+	typeAny
+	typeRegexp
+	typeIP
+	typeCIDR
+	typeArray
+	typeFunction
+	typeVar
+)
+
+var basicTypeTags = map[types.Type]byte{
+	types.Void:   typeVoid,
+	types.Number: typeNumber,
+	types.String: typeString,
+	types.Bool:   typeBool,
+	types.Any:    typeAny,
+	types.Regexp: typeRegexp,
+	types.IP:     typeIP,
+	types.CIDR:   typeCIDR,
+}
+
+var basicTypes = map[byte]types.Type{
+	typeVoid:   types.Void,
+	typeNumber: types.Number,
+	typeString: types.String,
+	typeBool:   types.Bool,
+	typeAny:    types.Any,
+	typeRegexp: types.Regexp,
+	typeIP:     types.IP,
+	typeCIDR:   types.CIDR,
+}
+
+// errTruncated is returned when serialized program data ends unexpectedly.
+var errTruncated = errors.New("invalid program data: unexpected end of data")
+
+// FuncResolver resolves the functions referenced by a deserialized program.
+type FuncResolver interface {
+	// ResolveFunc returns the function name of type typ. It fails if there
+	// is no such function, or if its type is not typ.
+	ResolveFunc(name string, typ *types.Function) (*Func, error)
+}
+
+// MarshalBinary serializes the program. Functions are serialized by name, and
+// they must be linked again after UnmarshalBinary. Closures are not
+// serialized: the deserialized program executes the bytecode.
+func (p *Program) MarshalBinary() ([]byte, error) {
+	e := &encoder{}
+	e.buf = append(e.buf, programMagic...)
+	e.uvarint(programVersion)
+
+	e.typ(p.ResultType)
+	e.uvarint(uint64(len(p.strings)))
+	for _, s := range p.strings {
+		e.string(s)
+	}
+	e.uvarint(uint64(len(p.inputs)))
+	for _, input := range p.inputs {
+		e.typ(input)
+	}
+	e.uvarint(uint64(p.locals))
+	e.uvarint(uint64(len(p.boxTypes)))
+	for _, typ := range p.boxTypes {
+		e.typ(typ)
+	}
+	e.uvarint(uint64(len(p.consts)))
+	for i, c := range p.consts {
+		e.typ(c.Type())
+		err := e.value(c.Type(), c.RawValue)
+		if err != nil {
+			return nil, fmt.Errorf("constant %d: %w", i, err)
+		}
+	}
+	e.uvarint(uint64(len(p.exprs)))
+	for _, expr := range p.exprs {
+		e.uvarint(uint64(len(expr)))
+		for _, instr := range expr {
+			e.uvarint(uint64(instr.op))
+			e.varint(int64(instr.extra))
+			e.varint(int64(instr.arg))
+			if instr.op == PushNumber {
+				e.float(instr.vnum)
+			}
+		}
+	}
+	if e.err != nil {
+		return nil, e.err
+	}
+	return e.buf, nil
+}
+
+// UnmarshalBinary deserializes a program serialized by MarshalBinary. The
+// functions referenced by the program are not linked: calling them fails
+// until Link is called.
+func (p *Program) UnmarshalBinary(data []byte) error {
+	d := &decoder{buf: data}
+	if len(data) < len(programMagic) || string(data[:len(programMagic)]) != programMagic {
+		return errors.New("invalid program data: bad header")
+	}
+	d.buf = d.buf[len(programMagic):]
+	version := d.uvarint()
+	if d.err == nil && version != programVersion {
+		return fmt.Errorf("unsupported program version %d, expected %d",
+			version, programVersion)
+	}
+
+	var res Program
+	res.ResultType = d.typ()
+	res.strings = make([]string, d.count())
+	for i := range res.strings {
+		res.strings[i] = d.string()
+	}
+	res.inputs = make([]types.Type, d.count())
+	for i := range res.inputs {
+		res.inputs[i] = d.typ()
+	}
+	res.locals = int(d.uvarint())
+	if d.err == nil && res.locals > len(data) {
+		d.fail(fmt.Errorf("invalid program data: %d locals", res.locals))
+	}
+	res.boxTypes = make([]types.Type, d.count())
+	for i := range res.boxTypes {
+		res.boxTypes[i] = d.typ()
+	}
+	res.consts = make([]Value, d.count())
+	for i := range res.consts {
+		typ := d.typ()
+		if d.err != nil {
+			break
+		}
+		res.consts[i] = Value{typ: typ, RawValue: d.value(typ)}
+	}
+	res.exprs = make([]Expr, d.count())
+	for i := range res.exprs {
+		expr := make(Expr, d.count())
+		for j := range expr {
+			instr := &expr[j]
+			instr.op = Operation(d.uvarint())
+			instr.extra = int(d.varint())
+			instr.arg = int(d.varint())
+			if instr.op == PushNumber {
+				instr.vnum = d.float()
+			}
+		}
+		res.exprs[i] = expr
+	}
+	if d.err != nil {
+		return d.err
+	}
+	if len(d.buf) != 0 {
+		return errors.New("invalid program data: trailing data")
+	}
+	res.buildSets()
+	*p = res
+	return nil
+}
+
+// Link resolves the functions referenced by a deserialized program with
+// funcs.
+func (p *Program) Link(funcs FuncResolver) error {
+	for i, c := range p.consts {
+		linked, err := linkValue(c.Type(), c.RawValue, funcs)
+		if err != nil {
+			return err
+		}
+		p.consts[i].RawValue = linked
+	}
+	return nil
+}
+
+func linkValue(typ types.Type, v RawValue, funcs FuncResolver) (RawValue, error) {
+	switch typ := typ.(type) {
+	case *types.Function:
+		fn := v.Object().(*Func)
+		if fn.Func != nil || fn.FuncErr != nil {
+			return v, nil
+		}
+		linked, err := funcs.ResolveFunc(fn.Name, typ)
+		if err != nil {
+			return RawValue{}, err
+		}
+		return NewRawObject(linked), nil
+
+	case *types.Array:
+		array := v.Object().([]RawValue)
+		for i, elem := range array {
+			linked, err := linkValue(typ.ElementType, elem, funcs)
+			if err != nil {
+				return RawValue{}, err
+			}
+			array[i] = linked
+		}
+		return v, nil
+
+	default:
+		return v, nil
+	}
+}
+
+// encoder encodes programs. The first error is stored in err.
+type encoder struct {
+	buf []byte
+	err error
+}
+
+func (e *encoder) uvarint(v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	e.buf = append(e.buf, tmp[:n]...)
+}
+
+func (e *encoder) varint(v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	e.buf = append(e.buf, tmp[:n]...)
+}
+
+func (e *encoder) float(v float64) {
+	var tmp [8]byte
+	binary.LittleEndian.PutUint64(tmp[:], math.Float64bits(v))
+	e.buf = append(e.buf, tmp[:]...)
+}
+
+func (e *encoder) string(s string) {
+	e.uvarint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// typ encodes typ. The type variables of generic function types are encoded
+// by name.
+func (e *encoder) typ(typ types.Type) {
+	if typ == nil {
+		e.buf = append(e.buf, typeNil)
+		return
+	}
+	if tag, ok := basicTypeTags[typ]; ok {
+		e.buf = append(e.buf, tag)
+		return
+	}
+	switch typ := typ.(type) {
+	case *types.Array:
+		e.buf = append(e.buf, typeArray)
+		e.typ(typ.ElementType)
+	case *types.Function:
+		e.buf = append(e.buf, typeFunction)
+		e.uvarint(uint64(len(typ.Params)))
+		for _, param := range typ.Params {
+			e.typ(param)
+		}
+		e.typ(typ.Ret)
+		variadic := byte(0)
+		if typ.Variadic {
+			variadic = 1
+		}
+		e.buf = append(e.buf, variadic)
+		e.uvarint(uint64(typ.Optional))
+	case *types.TypeVar:
+		e.buf = append(e.buf, typeVar)
+		e.string(typ.Name)
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("type %v cannot be serialized", typ)
+		}
+	}
+}
+
+func (e *encoder) value(typ types.Type, v RawValue) error {
+	switch typ {
+	case types.Number:
+		e.float(v.Number())
+		return nil
+	case types.Bool:
+		if v.Bool() {
+			e.buf = append(e.buf, 1)
+		} else {
+			e.buf = append(e.buf, 0)
+		}
+		return nil
+	case types.String:
+		e.string(v.String())
+		return nil
+	case types.Regexp:
+		e.string(v.Object().(*regexp.Regexp).String())
+		return nil
+	case types.IP:
+		data, _ := v.Object().(netip.Addr).MarshalBinary()
+		e.string(string(data))
+		return nil
+	case types.CIDR:
+		data, _ := v.Object().(netip.Prefix).MarshalBinary()
+		e.string(string(data))
+		return nil
+	}
+
+	switch typ := typ.(type) {
+	case *types.Array:
+		array := v.Object().([]RawValue)
+		e.uvarint(uint64(len(array)))
+		for _, elem := range array {
+			err := e.value(typ.ElementType, elem)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case *types.Function:
+		fn := v.Object().(*Func)
+		if fn.Name == "" {
+			return fmt.Errorf("function of type %v has no name", typ)
+		}
+		e.string(fn.Name)
+		return nil
+	default:
+		return fmt.Errorf("values of type %v cannot be serialized", typ)
+	}
+}
+
+// decoder decodes serialized programs. After the first error, which is
+// stored in err, it returns zero values.
+type decoder struct {
+	buf []byte
+	err error
+
+	// vars are the type variables of the function type being decoded, by
+	// name.
+	vars map[string]*types.TypeVar
+}
+
+func (d *decoder) fail(err error) {
+	if d.err == nil {
+		d.err = err
+	}
+	d.buf = nil
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail(errTruncated)
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail(errTruncated)
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// count decodes the length of a sequence. Every element takes at least one
+// byte, which bounds the allocations for invalid data.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > uint64(len(d.buf)) {
+		d.fail(errTruncated)
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) byte() byte {
+	if len(d.buf) == 0 {
+		d.fail(errTruncated)
+		return 0
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b
+}
+
+func (d *decoder) float() float64 {
+	if len(d.buf) < 8 {
+		d.fail(errTruncated)
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+	d.buf = d.buf[8:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.count()
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return s
+}
+
+func (d *decoder) typ() types.Type {
+	tag := d.byte()
+	if d.err != nil {
+		return nil
+	}
+	if typ, ok := basicTypes[tag]; ok {
+		return typ
+	}
+	switch tag {
+	case typeNil:
+		return nil
+	case typeArray:
+		elem := d.typ()
+		if elem == nil {
+			d.fail(errors.New("invalid program data: array of nil type"))
+			return nil
+		}
+		return &types.Array{ElementType: elem}
+	case typeFunction:
+		outer := d.vars == nil
+		if outer {
+			d.vars = make(map[string]*types.TypeVar)
+			defer func() { d.vars = nil }()
+		}
+		fn := &types.Function{Params: make([]types.Type, d.count())}
+		for i := range fn.Params {
+			fn.Params[i] = d.typ()
+		}
+		fn.Ret = d.typ()
+		fn.Variadic = d.byte() != 0
+		fn.Optional = int(d.uvarint())
+		if d.err != nil {
+			return nil
+		}
+		if fn.Ret == nil || fn.Optional > len(fn.Params) ||
+			(fn.Variadic && len(fn.Params) == 0) {
+			d.fail(errors.New("invalid program data: invalid function type"))
+			return nil
+		}
+		for _, param := range fn.Params {
+			if param == nil {
+				d.fail(errors.New("invalid program data: invalid function type"))
+				return nil
+			}
+		}
+		return fn
+	case typeVar:
+		name := d.string()
+		if d.vars == nil {
+			d.fail(errors.New("invalid program data: type variable outside of a function type"))
+			return nil
+		}
+		v, ok := d.vars[name]
+		if !ok {
+			v = types.NewTypeVar(name, nil)
+			d.vars[name] = v
+		}
+		return v
+	default:
+		d.fail(fmt.Errorf("invalid program data: invalid type tag %d", tag))
+		return nil
+	}
+}
+
+func (d *decoder) value(typ types.Type) RawValue {
+	switch typ {
+	case types.Number:
+		return NewRawNumber(d.float())
+	case types.Bool:
+		return NewRawBool(d.byte() != 0)
+	case types.String:
+		return NewRawObject(d.string())
+	case types.Regexp:
+		pattern := d.string()
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			d.fail(fmt.Errorf("invalid program data: %w", err))
+			return RawValue{}
+		}
+		return NewRawObject(re)
+	case types.IP:
+		var addr netip.Addr
+		err := addr.UnmarshalBinary([]byte(d.string()))
+		if err != nil {
+			d.fail(fmt.Errorf("invalid program data: %w", err))
+			return RawValue{}
+		}
+		return NewRawObject(addr)
+	case types.CIDR:
+		var prefix netip.Prefix
+		err := prefix.UnmarshalBinary([]byte(d.string()))
+		if err != nil {
+			d.fail(fmt.Errorf("invalid program data: %w", err))
+			return RawValue{}
+		}
+		return NewRawObject(prefix)
+	}
+
+	switch typ := typ.(type) {
+	case *types.Array:
+		array := make([]RawValue, d.count())
+		for i := range array {
+			array[i] = d.value(typ.ElementType)
+		}
+		return NewRawObject(array)
+	case *types.Function:
+		// The function is linked by Link.
+		return NewRawObject(&Func{Name: d.string(), Type: typ})
+	default:
+		d.fail(fmt.Errorf("invalid program data: constant of type %v", typ))
+		return RawValue{}
+	}
+}
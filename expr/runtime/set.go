@@ -0,0 +1,87 @@
+package runtime
+
+import (
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// Set is a hash set of numbers or strings. It tests for membership in a
+// constant array without scanning it. Like InArrayNumber, 0 and -0 are the
+// same element, and NaN is not an element of any set.
+type Set struct {
+	numbers map[float64]struct{}
+	strings map[string]struct{}
+}
+
+// NewSet creates the set of the elements of an array of numbers or strings,
+// of type elemType. It returns nil for other element types.
+func NewSet(elemType types.Type, elems []RawValue) *Set {
+	switch elemType {
+	case types.Number:
+		s := &Set{numbers: make(map[float64]struct{}, len(elems))}
+		for _, elem := range elems {
+			s.numbers[elem.Number()] = struct{}{}
+		}
+		return s
+	case types.String:
+		s := &Set{strings: make(map[string]struct{}, len(elems))}
+		for _, elem := range elems {
+			s.strings[elem.String()] = struct{}{}
+		}
+		return s
+	default:
+		return nil
+	}
+}
+
+// ContainsNumber returns true if the set of numbers contains n.
+func (s *Set) ContainsNumber(n float64) bool {
+	_, ok := s.numbers[n]
+	return ok
+}
+
+// ContainsString returns true if the set of strings contains str.
+func (s *Set) ContainsString(str string) bool {
+	_, ok := s.strings[str]
+	return ok
+}
+
+// setElemType returns the element type of the constant array of the InSetNumber
+// and InSetString operations.
+func setElemType(op Operation) types.Type {
+	if op == InSetString {
+		return types.String
+	}
+	return types.Number
+}
+
+// buildSets creates the sets of the constant arrays referenced by InSetNumber
+// and InSetString instructions. Invalid references are left to Verify.
+func (p *Program) buildSets() {
+	p.sets = nil
+	for _, expr := range p.exprs {
+		for _, instr := range expr {
+			if instr.op != InSetNumber && instr.op != InSetString {
+				continue
+			}
+			if instr.extra < 0 || instr.extra >= len(p.consts) {
+				continue
+			}
+			c := p.consts[instr.extra]
+			elemType := setElemType(instr.op)
+			arrayType, ok := c.Type().(*types.Array)
+			if !ok || arrayType.ElementType != elemType {
+				continue
+			}
+			elems, ok := c.Object().([]RawValue)
+			if !ok {
+				continue
+			}
+			if p.sets == nil {
+				p.sets = make(map[int]*Set)
+			}
+			if p.sets[instr.extra] == nil {
+				p.sets[instr.extra] = NewSet(elemType, elems)
+			}
+		}
+	}
+}
@@ -8,9 +8,19 @@ import (
 
 type FuncFn func(ctx context.Context, args []Value) Value
 
+// FuncErrFn is like FuncFn, but the function can fail. The error aborts the
+// execution and is returned by Runtime.Run.
+type FuncErrFn func(ctx context.Context, args []Value) (Value, error)
+
+// Func is a function value. Only one of Func and FuncErr is set, except in
+// deserialized programs that are not linked yet.
 type Func struct {
-	Type types.Type
-	Func FuncFn
+	// Name identifies the function in serialized programs. Functions without
+	// a name cannot be serialized.
+	Name    string
+	Type    types.Type
+	Func    FuncFn
+	FuncErr FuncErrFn
 }
 
 type RawValue struct {
@@ -50,6 +60,29 @@ func (v RawValue) Object() interface{} {
 	return v.obj
 }
 
+// Equal determines whether two values of the same comparable type are equal.
+// Arrays are compared element by element.
+func (v RawValue) Equal(other RawValue) bool {
+	if v.num != other.num {
+		return false
+	}
+	switch obj := v.obj.(type) {
+	case []RawValue:
+		otherObj := other.obj.([]RawValue)
+		if len(obj) != len(otherObj) {
+			return false
+		}
+		for i := range obj {
+			if !obj[i].Equal(otherObj[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return v.obj == other.obj
+	}
+}
+
 type Value struct {
 	RawValue
 	typ types.Type
@@ -59,6 +92,11 @@ func (v Value) Type() types.Type {
 	return v.typ
 }
 
+// NewValue creates a value of type typ from a RawValue.
+func NewValue(typ types.Type, raw RawValue) Value {
+	return Value{typ: typ, RawValue: raw}
+}
+
 func NewNumber(v float64) Value {
 	return Value{typ: types.Number, RawValue: NewRawNumber(v)}
 }
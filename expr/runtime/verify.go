@@ -0,0 +1,625 @@
+package runtime
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"regexp"
+
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// ErrInvalidProgram classifies the errors returned by Program.Verify. Use
+// errors.Is to test for it.
+var ErrInvalidProgram = errors.New("invalid program")
+
+// Verify checks that the program is well formed: that the operands of every
+// instruction are in range, and that every instruction finds values of the
+// right types on the stack and in the locals, on every path. A program that
+// passes verification cannot make Runtime.Run panic, as long as the inputs
+// and the functions it calls return values of their declared types.
+//
+// The programs built by the compiler are always valid. Verify is meant for
+// programs that are deserialized or built by hand.
+func (p *Program) Verify() error {
+	if p.ResultType == nil {
+		return fmt.Errorf("%w: result type is not set", ErrInvalidProgram)
+	}
+	for i, typ := range p.inputs {
+		if typ == nil {
+			return fmt.Errorf("%w: input %d has no type", ErrInvalidProgram, i)
+		}
+	}
+	for i, typ := range p.boxTypes {
+		if typ == nil {
+			return fmt.Errorf("%w: box type %d is not set", ErrInvalidProgram, i)
+		}
+	}
+	for i, c := range p.consts {
+		err := verifyValue(c.Type(), c.RawValue)
+		if err != nil {
+			return fmt.Errorf("%w: constant %d: %v", ErrInvalidProgram, i, err)
+		}
+	}
+	if p.closures != nil && len(p.closures) != len(p.exprs) {
+		return fmt.Errorf("%w: %d closures for %d expressions",
+			ErrInvalidProgram, len(p.closures), len(p.exprs))
+	}
+	for i, expr := range p.exprs {
+		v := &verifier{program: p, expr: expr}
+		err := v.verify()
+		if err != nil {
+			return fmt.Errorf("%w: expression %d: %v", ErrInvalidProgram, i, err)
+		}
+	}
+	return nil
+}
+
+// verifyValue checks that v is a valid value of type typ.
+func verifyValue(typ types.Type, v RawValue) error {
+	var ok bool
+	switch typ {
+	case types.Number, types.Bool:
+		return nil
+	case types.String:
+		_, ok = v.obj.(string)
+	case types.Regexp:
+		var re *regexp.Regexp
+		re, ok = v.obj.(*regexp.Regexp)
+		ok = ok && re != nil
+	case types.IP:
+		_, ok = v.obj.(netip.Addr)
+	case types.CIDR:
+		_, ok = v.obj.(netip.Prefix)
+	default:
+		switch typ := typ.(type) {
+		case *types.Array:
+			var array []RawValue
+			array, ok = v.obj.([]RawValue)
+			if !ok {
+				break
+			}
+			for _, elem := range array {
+				err := verifyValue(typ.ElementType, elem)
+				if err != nil {
+					return err
+				}
+			}
+		case *types.Function:
+			var fn *Func
+			fn, ok = v.obj.(*Func)
+			if !ok || fn == nil {
+				ok = false
+				break
+			}
+			// The functions of generic constants are not called, and
+			// the type variables of deserialized constants are not
+			// the type variables of their functions.
+			fnType, isFunc := fn.Type.(*types.Function)
+			if !isFunc || (!types.IsGeneric(typ) && !fnType.Equal(typ)) {
+				return fmt.Errorf("function of type %v does not match type %v",
+					fn.Type, typ)
+			}
+		default:
+			return fmt.Errorf("invalid type %v", typ)
+		}
+	}
+	if !ok {
+		return fmt.Errorf("invalid value %T for type %v", v.obj, typ)
+	}
+	return nil
+}
+
+// iterType is the type of the locals that hold an iterator over an array.
+type iterType struct {
+	array *types.Array
+}
+
+func (t *iterType) String() string {
+	return "iterator over " + t.array.String()
+}
+
+func (t *iterType) Equal(other types.Type) bool {
+	otherIter, ok := other.(*iterType)
+	return ok && t.array.Equal(otherIter.array)
+}
+
+// boxedType is the type of values boxed by the Box instruction, which can
+// only be passed as arguments for parameters of type Any.
+type boxedType struct {
+	typ types.Type
+}
+
+func (t *boxedType) String() string {
+	return "boxed " + t.typ.String()
+}
+
+func (t *boxedType) Equal(other types.Type) bool {
+	otherBoxed, ok := other.(*boxedType)
+	return ok && t.typ.Equal(otherBoxed.typ)
+}
+
+// unknownType is the element type of empty arrays pushed by PushArray, which
+// can be used as arrays of any type.
+type unknownType struct{}
+
+func (t *unknownType) String() string {
+	return "?"
+}
+
+func (t *unknownType) Equal(other types.Type) bool {
+	return t == other
+}
+
+var unknown = &unknownType{}
+
+// unifyTypes returns the most specific type that is both a and b: if one is
+// an array of unknown elements, it is the other.
+func unifyTypes(a, b types.Type) (types.Type, bool) {
+	if a == unknown {
+		return b, true
+	}
+	if b == unknown {
+		return a, true
+	}
+	aArray, aOK := a.(*types.Array)
+	bArray, bOK := b.(*types.Array)
+	if aOK && bOK {
+		elem, ok := unifyTypes(aArray.ElementType, bArray.ElementType)
+		if !ok {
+			return nil, false
+		}
+		if elem == aArray.ElementType {
+			return a, true
+		}
+		return &types.Array{ElementType: elem}, true
+	}
+	if a.Equal(b) {
+		return a, true
+	}
+	return nil, false
+}
+
+// isValue returns true if values of type t can be stored in locals and
+// compared.
+func isValue(t types.Type) bool {
+	switch t.(type) {
+	case *iterType, *boxedType:
+		return false
+	default:
+		return true
+	}
+}
+
+// verifyState is the state of the abstract execution of an instruction: the
+// types of the values on the stack and in the locals. The type of a local is
+// nil if it is not set on every path to the instruction.
+type verifyState struct {
+	stack  []types.Type
+	locals []types.Type
+}
+
+func (s *verifyState) clone() *verifyState {
+	return &verifyState{
+		stack:  append([]types.Type(nil), s.stack...),
+		locals: append([]types.Type(nil), s.locals...),
+	}
+}
+
+// verifier verifies an expression with an abstract execution that tracks
+// the types of values instead of the values, until the state at each
+// instruction stops changing.
+type verifier struct {
+	program *Program
+	expr    Expr
+
+	// states are the states before each instruction, and before the end of
+	// the expression at len(expr).
+	states []*verifyState
+	work   []int
+}
+
+func (v *verifier) verify() error {
+	v.states = make([]*verifyState, len(v.expr)+1)
+	v.states[0] = &verifyState{locals: make([]types.Type, v.program.locals)}
+	v.work = []int{0}
+	for len(v.work) != 0 {
+		pc := v.work[len(v.work)-1]
+		v.work = v.work[:len(v.work)-1]
+		state := v.states[pc].clone()
+
+		var err error
+		if pc == len(v.expr) {
+			err = v.verifyEnd(state)
+		} else {
+			err = v.step(pc, state)
+		}
+		if err != nil {
+			if pc == len(v.expr) {
+				return fmt.Errorf("end: %v", err)
+			}
+			return fmt.Errorf("instruction %d (%v): %v", pc, v.expr[pc].op, err)
+		}
+	}
+	return nil
+}
+
+// verifyEnd checks that the expression leaves exactly one value, of the
+// result type, on the stack.
+func (v *verifier) verifyEnd(state *verifyState) error {
+	if len(state.stack) != 1 {
+		return fmt.Errorf("stack depth is %d, expected 1", len(state.stack))
+	}
+	if _, ok := unifyTypes(v.program.ResultType, state.stack[0]); !ok {
+		return fmt.Errorf("result has type %v, expected %v",
+			state.stack[0], v.program.ResultType)
+	}
+	return nil
+}
+
+// flow merges state into the state before the instruction at pc, and
+// schedules the instruction if its state changed.
+func (v *verifier) flow(pc int, state *verifyState) error {
+	if pc < 0 || pc > len(v.expr) {
+		return fmt.Errorf("jump target %d out of range", pc)
+	}
+	existing := v.states[pc]
+	if existing == nil {
+		v.states[pc] = state.clone()
+		v.work = append(v.work, pc)
+		return nil
+	}
+	if len(existing.stack) != len(state.stack) {
+		return fmt.Errorf("stack depth %d at %d does not match depth %d of another path",
+			len(state.stack), pc, len(existing.stack))
+	}
+	changed := false
+	for i, typ := range state.stack {
+		merged, ok := unifyTypes(existing.stack[i], typ)
+		if !ok {
+			return fmt.Errorf("stack type %v at %d does not match type %v of another path",
+				typ, pc, existing.stack[i])
+		}
+		if merged != existing.stack[i] {
+			existing.stack[i] = merged
+			changed = true
+		}
+	}
+	for i, typ := range existing.locals {
+		if typ == nil {
+			continue
+		}
+		var merged types.Type
+		if state.locals[i] != nil {
+			merged, _ = unifyTypes(typ, state.locals[i])
+		}
+		if merged != typ {
+			existing.locals[i] = merged
+			changed = true
+		}
+	}
+	if changed {
+		v.work = append(v.work, pc)
+	}
+	return nil
+}
+
+func (v *verifier) step(pc int, s *verifyState) error {
+	p := v.program
+	instr := v.expr[pc]
+
+	switch instr.op {
+	case PushNumber:
+		s.stack = append(s.stack, types.Number)
+
+	case PushString:
+		if err := checkIndex("string", instr.extra, len(p.strings)); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.String)
+
+	case PushBool:
+		s.stack = append(s.stack, types.Bool)
+
+	case PushArray:
+		if instr.extra < 0 || instr.extra > len(s.stack) {
+			return fmt.Errorf("stack underflow")
+		}
+		var elem types.Type = unknown
+		for i := 0; i < instr.extra; i++ {
+			typ, err := v.pop(s, nil)
+			if err != nil {
+				return err
+			}
+			merged, ok := unifyTypes(elem, typ)
+			if !ok {
+				return fmt.Errorf("array elements have types %v and %v", typ, elem)
+			}
+			elem = merged
+		}
+		s.stack = append(s.stack, &types.Array{ElementType: elem})
+
+	case LoadConst:
+		if err := checkIndex("constant", instr.extra, len(p.consts)); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, p.consts[instr.extra].Type())
+
+	case LoadInput:
+		if err := checkIndex("input", instr.extra, len(p.inputs)); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, p.inputs[instr.extra])
+
+	case LoadLocal:
+		if err := checkIndex("local", instr.extra, p.locals); err != nil {
+			return err
+		}
+		typ := s.locals[instr.extra]
+		if typ == nil {
+			return fmt.Errorf("local %d may not be set", instr.extra)
+		}
+		if !isValue(typ) {
+			return fmt.Errorf("local %d holds an %v", instr.extra, typ)
+		}
+		s.stack = append(s.stack, typ)
+
+	case StoreLocal:
+		if err := checkIndex("local", instr.extra, p.locals); err != nil {
+			return err
+		}
+		typ, err := v.pop(s, nil)
+		if err != nil {
+			return err
+		}
+		s.locals[instr.extra] = typ
+
+	case Duplicate:
+		typ, err := v.pop(s, nil)
+		if err != nil {
+			return err
+		}
+		s.stack = append(s.stack, typ, typ)
+
+	case Box:
+		if err := checkIndex("box type", instr.extra, len(p.boxTypes)); err != nil {
+			return err
+		}
+		typ, err := v.pop(s, p.boxTypes[instr.extra])
+		if err != nil {
+			return err
+		}
+		s.stack = append(s.stack, &boxedType{typ: typ})
+
+	case Add, Subtract, Multiply, Divide:
+		if err := v.popN(s, types.Number, types.Number); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.Number)
+
+	case CompareEqNumber, CompareNeNumber, CompareLT, CompareLE, CompareGT, CompareGE:
+		if err := v.popN(s, types.Number, types.Number); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.Bool)
+
+	case CompareEqBool, And, Or:
+		if err := v.popN(s, types.Bool, types.Bool); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.Bool)
+
+	case CompareEqString:
+		if err := v.popN(s, types.String, types.String); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.Bool)
+
+	case CompareEq:
+		right, err := v.pop(s, nil)
+		if err != nil {
+			return err
+		}
+		if _, err := v.pop(s, right); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.Bool)
+
+	case Negate:
+		if err := v.popN(s, types.Bool); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.Bool)
+
+	case Jump:
+		return v.flow(instr.extra, s)
+
+	case JumpIfTrue, JumpIfFalse:
+		if err := v.popN(s, types.Bool); err != nil {
+			return err
+		}
+		if err := v.flow(instr.extra, s); err != nil {
+			return err
+		}
+
+	case JumpIfTrueOrPop, JumpIfFalseOrPop:
+		// The condition stays on the stack if the jump is taken.
+		if err := v.popN(s, types.Bool); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.Bool)
+		if err := v.flow(instr.extra, s); err != nil {
+			return err
+		}
+		s.stack = s.stack[:len(s.stack)-1]
+
+	case Call:
+		if instr.extra < 0 || instr.extra >= len(s.stack) {
+			return fmt.Errorf("stack underflow")
+		}
+		args := s.stack[len(s.stack)-instr.extra:]
+		s.stack = s.stack[:len(s.stack)-instr.extra]
+		fnType, ok := s.stack[len(s.stack)-1].(*types.Function)
+		if !ok {
+			return fmt.Errorf("cannot call %v", s.stack[len(s.stack)-1])
+		}
+		s.stack = s.stack[:len(s.stack)-1]
+		if types.IsGeneric(fnType) {
+			return fmt.Errorf("cannot call generic function %v", fnType)
+		}
+		if !fnType.AcceptsArgs(len(args)) {
+			return fmt.Errorf("function %v does not accept %d arguments",
+				fnType, len(args))
+		}
+		for i, arg := range args {
+			param := fnType.ParamType(i)
+			if param == types.Any {
+				if _, ok := arg.(*boxedType); !ok {
+					return fmt.Errorf("argument %d of type %v is not boxed", i, arg)
+				}
+				continue
+			}
+			if _, ok := unifyTypes(param, arg); !ok || !isValue(arg) {
+				return fmt.Errorf("argument %d has type %v, expected %v", i, arg, param)
+			}
+		}
+		s.stack = append(s.stack, fnType.Ret)
+
+	case Return:
+		return v.verifyEnd(s)
+
+	case IterInit:
+		if err := checkIndex("local", instr.extra, p.locals); err != nil {
+			return err
+		}
+		array, err := v.popArray(s)
+		if err != nil {
+			return err
+		}
+		s.locals[instr.extra] = &iterType{array: array}
+
+	case IterNext:
+		if err := checkIndex("local", instr.arg, p.locals); err != nil {
+			return err
+		}
+		iter, ok := s.locals[instr.arg].(*iterType)
+		if !ok {
+			return fmt.Errorf("local %d does not hold an iterator", instr.arg)
+		}
+		if err := v.flow(instr.extra, s); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, iter.array.ElementType)
+
+	case ArrayAppend:
+		elem, err := v.pop(s, nil)
+		if err != nil {
+			return err
+		}
+		array, err := v.popArray(s)
+		if err != nil {
+			return err
+		}
+		merged, ok := unifyTypes(array.ElementType, elem)
+		if !ok {
+			return fmt.Errorf("cannot append %v to %v", elem, array)
+		}
+		s.stack = append(s.stack, &types.Array{ElementType: merged})
+
+	case InArray:
+		array, err := v.popArray(s)
+		if err != nil {
+			return err
+		}
+		if _, err := v.pop(s, array.ElementType); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.Bool)
+
+	case InArrayNumber, InArrayString:
+		elem := types.Number
+		if instr.op == InArrayString {
+			elem = types.String
+		}
+		if err := v.popN(s, &types.Array{ElementType: elem}, elem); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.Bool)
+
+	case InPrefix:
+		if err := v.popN(s, types.CIDR, types.IP); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.Bool)
+
+	case InSetNumber, InSetString:
+		if err := checkIndex("constant", instr.extra, len(p.consts)); err != nil {
+			return err
+		}
+		elem := setElemType(instr.op)
+		if typ := p.consts[instr.extra].Type(); !typ.Equal(&types.Array{ElementType: elem}) {
+			return fmt.Errorf("constant %d has type %v, expected %v",
+				instr.extra, typ, &types.Array{ElementType: elem})
+		}
+		if _, err := v.pop(s, elem); err != nil {
+			return err
+		}
+		s.stack = append(s.stack, types.Bool)
+
+	default:
+		return fmt.Errorf("invalid operation")
+	}
+
+	return v.flow(pc+1, s)
+}
+
+// pop pops a value from the stack, checking that it has type want, if set.
+// It returns the type of the value.
+func (v *verifier) pop(s *verifyState, want types.Type) (types.Type, error) {
+	if len(s.stack) == 0 {
+		return nil, fmt.Errorf("stack underflow")
+	}
+	typ := s.stack[len(s.stack)-1]
+	s.stack = s.stack[:len(s.stack)-1]
+	if !isValue(typ) {
+		return nil, fmt.Errorf("unexpected %v", typ)
+	}
+	if want == nil {
+		return typ, nil
+	}
+	merged, ok := unifyTypes(want, typ)
+	if !ok {
+		return nil, fmt.Errorf("operand has type %v, expected %v", typ, want)
+	}
+	return merged, nil
+}
+
+// popN pops values of the types in want, from the top of the stack down.
+func (v *verifier) popN(s *verifyState, want ...types.Type) error {
+	for _, typ := range want {
+		if _, err := v.pop(s, typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// popArray pops an array from the stack.
+func (v *verifier) popArray(s *verifyState) (*types.Array, error) {
+	typ, err := v.pop(s, nil)
+	if err != nil {
+		return nil, err
+	}
+	array, ok := typ.(*types.Array)
+	if !ok {
+		return nil, fmt.Errorf("operand has type %v, expected an array", typ)
+	}
+	return array, nil
+}
+
+func checkIndex(kind string, index, count int) error {
+	if index < 0 || index >= count {
+		return fmt.Errorf("%s index %d out of range", kind, index)
+	}
+	return nil
+}
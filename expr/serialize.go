@@ -0,0 +1,88 @@
+package expr
+
+import (
+	"fmt"
+
+	"github.com/dcaiafa/go-expr/expr/internal/ast"
+	"github.com/dcaiafa/go-expr/expr/internal/symbol"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// LoadProgram deserializes a program serialized with Program.MarshalBinary,
+// and links the functions it references with the functions registered with
+// the Compiler. It fails if a function is not registered, or if it is
+// registered with a different signature. The program is verified with
+// Program.Verify.
+//
+// The program is typically compiled by a Compiler configured like c, in
+// another process.
+func (c *Compiler) LoadProgram(data []byte) (*runtime.Program, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	prog := &runtime.Program{}
+	err := prog.UnmarshalBinary(data)
+	if err != nil {
+		return nil, err
+	}
+	err = prog.Link(funcResolver{c})
+	if err != nil {
+		return nil, err
+	}
+	err = prog.Verify()
+	if err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// funcResolver resolves functions by name in the global scope of the
+// Compiler.
+type funcResolver struct {
+	c *Compiler
+}
+
+func (r funcResolver) ResolveFunc(name string, typ *types.Function) (*runtime.Func, error) {
+	if fn, ok := ast.BuiltinFunc(name, typ); ok {
+		return fn, nil
+	}
+
+	var sym symbol.Symbol
+	if r.c.ctx.GlobalScope.Has(name) {
+		sym, _ = r.c.ctx.GlobalScope.Get(name)
+	}
+	fnSym, ok := sym.(*symbol.FuncSymbol)
+	if !ok {
+		return nil, fmt.Errorf("function %v is not registered", name)
+	}
+	for _, overload := range fnSym.Overloads {
+		// The type variables of a deserialized generic type are not the
+		// type variables of the overload, so they are compared by name.
+		if overload.Type.Equal(typ) ||
+			(types.IsGeneric(typ) && overload.Type.String() == typ.String()) {
+			return overload.Func, nil
+		}
+		if isInstance(overload.Type, typ) {
+			return fnSym.OverloadFunc(overload, typ), nil
+		}
+	}
+	return nil, fmt.Errorf("function %v has no overload of type %v", name, typ)
+}
+
+// isInstance returns true if inst is an instance of the generic function type
+// fn.
+func isInstance(fn, inst *types.Function) bool {
+	if !types.IsGeneric(fn) || types.IsGeneric(inst) ||
+		len(fn.Params) != len(inst.Params) ||
+		fn.Variadic != inst.Variadic || fn.Optional != inst.Optional {
+		return false
+	}
+	bindings := make(types.Bindings)
+	for i, param := range fn.Params {
+		if !types.Unify(param, inst.Params[i], bindings) {
+			return false
+		}
+	}
+	return types.Subst(fn.Ret, bindings).Equal(inst.Ret)
+}
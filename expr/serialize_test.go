@@ -0,0 +1,202 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadProgram(t *testing.T) {
+	newCompiler := func() *Compiler {
+		elemT := types.NewTypeVar("T", nil)
+		compiler := NewCompiler()
+		compiler.RegisterInput("a", types.Number)
+		compiler.RegisterInput("s", types.String)
+		compiler.RegisterInput("client", types.IP)
+		compiler.RegisterConst("nets", runtime.NewObject(
+			&types.Array{ElementType: types.CIDR},
+			[]runtime.RawValue{
+				runtime.NewRawObject(netip.MustParsePrefix("10.0.0.0/8")),
+				runtime.NewRawObject(netip.MustParsePrefix("192.168.0.0/16")),
+			}))
+		compiler.RegisterGoFunc("double", func(n float64) float64 { return n * 2 })
+		compiler.RegisterGoFunc("double", func(s string) string { return s + s })
+		compiler.RegisterGoFunc("strLen", func(s string) float64 { return float64(len(s)) })
+		compiler.RegisterFuncDef(&FuncDef{
+			Name: "first",
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				arr := args[0].Object().([]runtime.RawValue)
+				return runtime.NewValue(args[0].Type().(*types.Array).ElementType, arr[0])
+			},
+			Ret:    elemT,
+			Params: []types.Type{&types.Array{ElementType: elemT}},
+		})
+		compiler.RegisterGoFunc("check", func(n float64) (float64, error) {
+			if n < 0 {
+				return 0, errors.New("negative")
+			}
+			return n, nil
+		})
+		compiler.RegisterGoFunc("join", func(parts ...string) string {
+			res := ""
+			for _, part := range parts {
+				res += part
+			}
+			return res
+		})
+		compiler.RegisterFuncDef(&FuncDef{
+			Name: "typeOf",
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewString(args[1].Type().String())
+			},
+			Ret:      types.String,
+			Params:   []types.Type{types.Number, types.Any},
+			Defaults: []runtime.Value{runtime.NewString("x")},
+		})
+		return compiler
+	}
+	inputs := []runtime.Value{
+		runtime.NewNumber(2),
+		runtime.NewString("ab1"),
+		runtime.NewObject(types.IP, netip.MustParseAddr("10.1.2.3")),
+	}
+
+	run := func(name, input string, expected interface{}) {
+		t.Run(name, func(t *testing.T) {
+			prog, err := newCompiler().Compile(input)
+			require.NoError(t, err)
+			data, err := prog.MarshalBinary()
+			require.NoError(t, err)
+
+			loaded, err := newCompiler().LoadProgram(data)
+			require.NoError(t, err)
+			res, err := runtime.NewRuntime(loaded).Run(context.Background(), 0, inputs)
+			if expectedErr, ok := expected.(error); ok {
+				require.EqualError(t, err, expectedErr.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, prog.ResultType, loaded.ResultType)
+			switch expected := expected.(type) {
+			case float64:
+				require.Equal(t, expected, res.Number())
+			case bool:
+				require.Equal(t, expected, res.Bool())
+			default:
+				require.Equal(t, expected, res.Object())
+			}
+		})
+	}
+
+	run("overloads", `double(a) + strLen(double(s))`, 10.0)
+	run("error", `check(a - 3)`, errors.New("negative"))
+	run("variadic", `join(s, "-", s)`, "ab1-ab1")
+	run("any_default", `typeOf(1) == "string" && typeOf(1, [a]) == "array of number"`, true)
+	run("generic", `first([s]) == "ab1" && first([client]) == client`, true)
+	run("regex", `s =~ "^[a-z]+[0-9]$" && regexFind(s, "[0-9]") == "1"`, true)
+	run("regex_dynamic", `regexFind("a1", s)`, "")
+	run("glob", `glob("a*", s)`, true)
+	run("ip", `any n in nets: client in n`, true)
+	run("ip_dynamic", `ip(s) == client`, errors.New(`invalid IP address "ab1"`))
+	run("cidr", `cidr("10.1.0.0/16")`, netip.MustParsePrefix("10.1.0.0/16"))
+	run("loop", `[x * a for x in [1, 2, 3] if x > 1]`,
+		[]runtime.RawValue{runtime.NewRawNumber(4), runtime.NewRawNumber(6)})
+}
+
+func TestLoadProgram_Closures(t *testing.T) {
+	compiler := NewCompiler(WithClosures())
+	compiler.RegisterInput("a", types.Number)
+	prog, err := compiler.Compile(`a * 2`)
+	require.NoError(t, err)
+	data, err := prog.MarshalBinary()
+	require.NoError(t, err)
+
+	// The closures are not serialized, and the loaded program executes the
+	// bytecode.
+	loaded, err := compiler.LoadProgram(data)
+	require.NoError(t, err)
+	res, err := runtime.NewRuntime(loaded).Run(
+		context.Background(), 0, []runtime.Value{runtime.NewNumber(3)})
+	require.NoError(t, err)
+	require.Equal(t, 6.0, res.Number())
+}
+
+func TestLoadProgram_Errors(t *testing.T) {
+	compiler := NewCompiler()
+	compiler.RegisterInput("a", types.Number)
+	compiler.RegisterGoFunc("double", func(n float64) float64 { return n * 2 })
+	prog, err := compiler.Compile(`double(a)`)
+	require.NoError(t, err)
+	data, err := prog.MarshalBinary()
+	require.NoError(t, err)
+
+	t.Run("missing_func", func(t *testing.T) {
+		_, err := NewCompiler().LoadProgram(data)
+		require.EqualError(t, err, "function double is not registered")
+	})
+
+	t.Run("changed_signature", func(t *testing.T) {
+		compiler := NewCompiler()
+		compiler.RegisterGoFunc("double", func(s string) string { return s + s })
+		_, err := compiler.LoadProgram(data)
+		require.EqualError(t, err,
+			"function double has no overload of type func(number) number")
+	})
+
+	t.Run("not_a_func", func(t *testing.T) {
+		compiler := NewCompiler()
+		compiler.RegisterInput("double", types.Number)
+		_, err := compiler.LoadProgram(data)
+		require.EqualError(t, err, "function double is not registered")
+	})
+
+	t.Run("not_linked", func(t *testing.T) {
+		var prog runtime.Program
+		require.NoError(t, prog.UnmarshalBinary(data))
+		_, err := runtime.NewRuntime(&prog).Run(
+			context.Background(), 0, []runtime.Value{runtime.NewNumber(1)})
+		require.EqualError(t, err, "function double is not linked")
+	})
+
+	t.Run("header", func(t *testing.T) {
+		_, err := compiler.LoadProgram([]byte("{}"))
+		require.EqualError(t, err, "invalid program data: bad header")
+	})
+
+	t.Run("version", func(t *testing.T) {
+		data := append([]byte{}, data...)
+		data[len("goexpr")] = 99
+		_, err := compiler.LoadProgram(data)
+		require.EqualError(t, err, "unsupported program version 99, expected 4")
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		for i := 0; i < len(data); i++ {
+			_, err := compiler.LoadProgram(data[:i])
+			require.Error(t, err)
+		}
+		_, err := compiler.LoadProgram(append(data, 0))
+		require.EqualError(t, err, "invalid program data: trailing data")
+	})
+
+	t.Run("unnamed_func", func(t *testing.T) {
+		compiler := NewCompiler()
+		fnType := &types.Function{Params: []types.Type{}, Ret: types.Number}
+		compiler.RegisterConst("f", runtime.NewObject(fnType, &runtime.Func{
+			Type: fnType,
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(1)
+			},
+		}))
+		prog, err := compiler.Compile(`f()`)
+		require.NoError(t, err)
+		_, err = prog.MarshalBinary()
+		require.EqualError(t, err,
+			"constant 0: function of type func() number has no name")
+	})
+}
@@ -0,0 +1,151 @@
+package expr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInSet(t *testing.T) {
+	newCompiler := func(opts ...Option) *Compiler {
+		compiler := NewCompiler(opts...)
+		compiler.RegisterInput("a", types.Number)
+		compiler.RegisterInput("s", types.String)
+		allowed := make([]runtime.RawValue, 100)
+		for i := range allowed {
+			allowed[i] = runtime.NewRawNumber(float64(i * 2))
+		}
+		compiler.RegisterConst("allowed", runtime.NewObject(
+			&types.Array{ElementType: types.Number}, allowed))
+		return compiler
+	}
+
+	run := func(input string, expected string) {
+		t.Run(input, func(t *testing.T) {
+			prog, err := newCompiler().Compile(input)
+			require.NoError(t, err)
+			require.NoError(t, prog.Verify())
+			require.Equal(t, strings.TrimLeft(expected, "\n"), exprListing(t, prog))
+		})
+	}
+
+	// Registered constants are not copied.
+	run(`a in allowed`, `
+  0000  LoadInput        0
+  0001  InSetNumber      0               ; 100 elements
+  0002  Return
+`)
+	run(`s in ["a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p"]`, `
+  0000  LoadInput        1
+  0001  InSetString      1               ; 16 elements
+  0002  Return
+`)
+	// Equal constant arrays share one constant.
+	run(`a in [0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15] ||
+		a + 1 in [0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15]`, `
+  0000  LoadInput        0
+  0001  InSetNumber      1               ; 16 elements
+  0002  JumpIfTrueOrPop  L0
+  0003  LoadInput        0
+  0004  PushNumber       1
+  0005  Add
+  0006  InSetNumber      1               ; 16 elements
+L0:
+  0007  Return
+`)
+	// Small arrays are scanned.
+	run(`a in [1, 2, 3]`, `
+  0000  LoadInput        0
+  0001  LoadConst        1               ; [1, 2, 3]
+  0002  InArrayNumber
+  0003  Return
+`)
+
+	prog, err := newCompiler(WithRegisters()).Compile(`a + 1 in allowed`)
+	require.NoError(t, err)
+	var listing strings.Builder
+	require.NoError(t, prog.DisassembleRegisters(&listing))
+	require.Equal(t, strings.TrimLeft(`
+expr 0:
+  0000  Add              r0, input[0], 1
+  0001  InSetNumber      r0, r0, const[0]
+  0002  Return           r0
+`, "\n"), listing.String())
+
+	// The sets are rebuilt from the constants of deserialized programs.
+	prog, err = newCompiler().Compile(
+		`a in allowed && s in ["a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k", "l", "m", "n", "o", "p"]`)
+	require.NoError(t, err)
+	data, err := prog.MarshalBinary()
+	require.NoError(t, err)
+	var loaded runtime.Program
+	require.NoError(t, loaded.UnmarshalBinary(data))
+	require.NoError(t, loaded.Verify())
+	for _, a := range []float64{0, 1, 198, 200} {
+		inputs := []runtime.Value{runtime.NewNumber(a), runtime.NewString("p")}
+		expected, err := runtime.NewRuntime(prog).Run(context.Background(), 0, inputs)
+		require.NoError(t, err)
+		res, err := runtime.NewRuntime(&loaded).Run(context.Background(), 0, inputs)
+		require.NoError(t, err)
+		require.Equal(t, expected, res)
+	}
+}
+
+// BenchmarkInSet compares the membership test in constant arrays, which uses a
+// set, with the linear scan of an input array with the same elements.
+func BenchmarkInSet(b *testing.B) {
+	run := func(elemType types.Type, size int) {
+		elems := make([]runtime.RawValue, size)
+		for i := range elems {
+			if elemType == types.Number {
+				elems[i] = runtime.NewRawNumber(float64(i))
+			} else {
+				elems[i] = runtime.NewRawObject(fmt.Sprintf("user%d@example.com", i))
+			}
+		}
+		arrayType := &types.Array{ElementType: elemType}
+		// The last element is the worst case of the linear scan.
+		x := runtime.Value{}
+		if elemType == types.Number {
+			x = runtime.NewNumber(float64(size - 1))
+		} else {
+			x = runtime.NewString(fmt.Sprintf("user%d@example.com", size-1))
+		}
+
+		for _, scan := range []struct {
+			name  string
+			input string
+		}{
+			{"set", `x in allowed`},
+			{"linear", `x in list`},
+		} {
+			b.Run(fmt.Sprintf("%v/%d/%s", elemType, size, scan.name), func(b *testing.B) {
+				compiler := NewCompiler()
+				compiler.RegisterInput("x", elemType)
+				compiler.RegisterInput("list", arrayType)
+				compiler.RegisterConst("allowed", runtime.NewObject(arrayType, elems))
+				prog, err := compiler.Compile(scan.input)
+				require.NoError(b, err)
+				inputs := []runtime.Value{x, runtime.NewObject(arrayType, elems)}
+				r := runtime.NewRuntime(prog)
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					_, err := r.Run(context.Background(), 0, inputs)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+
+	for _, size := range []int{4, 8, 16, 32, 1000} {
+		run(types.Number, size)
+		run(types.String, size)
+	}
+}
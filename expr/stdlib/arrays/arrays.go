@@ -0,0 +1,347 @@
+// Package arrays provides aggregation and set functions over arrays for
+// expressions. The functions are registered with a compiler using the Module:
+//
+//	compiler := expr.NewCompiler(expr.WithModule(arrays.Module))
+//
+// Most of the functions are generic over the element type of the array. All
+// the functions are pure, so calls with constant arguments are evaluated at
+// compile time.
+package arrays
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sort"
+
+	"github.com/dcaiafa/go-expr/expr"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+var (
+	numberArray = &types.Array{ElementType: types.Number}
+
+	// anyT, comparableT and orderedT are the element types of the generic
+	// functions.
+	anyT        = types.NewTypeVar("T", nil)
+	comparableT = types.NewTypeVar("T", types.Comparable)
+	orderedT    = types.NewTypeVar("T", types.Ordered)
+)
+
+// Module registers the following functions. T is any type, a comparable type
+// or an ordered type (number or string), depending on the function:
+//
+//	sum(arr array of number) number
+//	avg(arr array of number) number
+//	min(arr array of T) T
+//	max(arr array of T) T
+//	count(arr array of T) number
+//	distinct(arr array of T) array of T
+//	sort(arr array of T) array of T
+//	reverse(arr array of T) array of T
+//	contains(arr array of T, elem T) bool
+//	intersect(a array of T, b array of T) array of T
+//	union(a array of T, b array of T) array of T
+//	difference(a array of T, b array of T) array of T
+//	first(arr array of T) T
+//	last(arr array of T) T
+//
+// avg of an empty array is NaN. min, max, first and last of an empty array
+// fail. As in the math package, min and max return NaN if any element is NaN.
+// sort sorts in ascending order, with NaN first.
+//
+// distinct and the set functions return distinct elements in the order of
+// their first appearance: intersect returns the elements of a that are in b,
+// union returns the elements of a followed by the elements of b that are not
+// in a, and difference returns the elements of a that are not in b.
+//
+// count(arr, predicate) remains available as a builtin.
+var Module expr.Module = module{}
+
+type module struct{}
+
+func (module) Register(c *expr.Compiler) error {
+	for _, def := range funcDefs() {
+		err := c.RegisterFuncDef(def)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func funcDefs() []*expr.FuncDef {
+	anyArray := &types.Array{ElementType: anyT}
+	comparableArray := &types.Array{ElementType: comparableT}
+	orderedArray := &types.Array{ElementType: orderedT}
+
+	defs := []*expr.FuncDef{
+		{
+			Name:   "sum",
+			Func:   sum,
+			Ret:    types.Number,
+			Params: []types.Type{numberArray},
+		},
+		{
+			Name:   "avg",
+			Func:   avg,
+			Ret:    types.Number,
+			Params: []types.Type{numberArray},
+		},
+		{
+			Name:    "min",
+			FuncErr: extreme("min", false),
+			Ret:     orderedT,
+			Params:  []types.Type{orderedArray},
+		},
+		{
+			Name:    "max",
+			FuncErr: extreme("max", true),
+			Ret:     orderedT,
+			Params:  []types.Type{orderedArray},
+		},
+		{
+			Name:   "count",
+			Func:   count,
+			Ret:    types.Number,
+			Params: []types.Type{anyArray},
+		},
+		{
+			Name:   "distinct",
+			Func:   distinct,
+			Ret:    comparableArray,
+			Params: []types.Type{comparableArray},
+		},
+		{
+			Name:   "sort",
+			Func:   sortFunc,
+			Ret:    orderedArray,
+			Params: []types.Type{orderedArray},
+		},
+		{
+			Name:   "reverse",
+			Func:   reverse,
+			Ret:    anyArray,
+			Params: []types.Type{anyArray},
+		},
+		{
+			Name:   "contains",
+			Func:   contains,
+			Ret:    types.Bool,
+			Params: []types.Type{comparableArray, comparableT},
+		},
+		{
+			Name:   "intersect",
+			Func:   setFunc(func(inA, inB bool) bool { return inA && inB }),
+			Ret:    comparableArray,
+			Params: []types.Type{comparableArray, comparableArray},
+		},
+		{
+			Name:   "union",
+			Func:   setFunc(func(inA, inB bool) bool { return inA || inB }),
+			Ret:    comparableArray,
+			Params: []types.Type{comparableArray, comparableArray},
+		},
+		{
+			Name:   "difference",
+			Func:   setFunc(func(inA, inB bool) bool { return inA && !inB }),
+			Ret:    comparableArray,
+			Params: []types.Type{comparableArray, comparableArray},
+		},
+		{
+			Name:    "first",
+			FuncErr: element("first", func(n int) int { return 0 }),
+			Ret:     anyT,
+			Params:  []types.Type{anyArray},
+		},
+		{
+			Name:    "last",
+			FuncErr: element("last", func(n int) int { return n - 1 }),
+			Ret:     anyT,
+			Params:  []types.Type{anyArray},
+		},
+	}
+	for _, def := range defs {
+		def.Pure = true
+	}
+	return defs
+}
+
+func elements(v runtime.Value) []runtime.RawValue {
+	return v.Object().([]runtime.RawValue)
+}
+
+func elementType(v runtime.Value) types.Type {
+	return v.Type().(*types.Array).ElementType
+}
+
+func sum(ctx context.Context, args []runtime.Value) runtime.Value {
+	var res float64
+	for _, elem := range elements(args[0]) {
+		res += elem.Number()
+	}
+	return runtime.NewNumber(res)
+}
+
+func avg(ctx context.Context, args []runtime.Value) runtime.Value {
+	arr := elements(args[0])
+	if len(arr) == 0 {
+		return runtime.NewNumber(math.NaN())
+	}
+	return runtime.NewNumber(sum(ctx, args).Number() / float64(len(arr)))
+}
+
+// less compares two elements of an ordered type. NaN is less than any other
+// number.
+func less(typ types.Type, a, b runtime.RawValue) bool {
+	if typ == types.String {
+		return a.String() < b.String()
+	}
+	x, y := a.Number(), b.Number()
+	return x < y || (math.IsNaN(x) && !math.IsNaN(y))
+}
+
+// extreme creates the implementation of min (or max if greatest is set).
+func extreme(name string, greatest bool) runtime.FuncErrFn {
+	errEmpty := errors.New(name + " of empty array")
+	return func(ctx context.Context, args []runtime.Value) (runtime.Value, error) {
+		arr := elements(args[0])
+		typ := elementType(args[0])
+		if len(arr) == 0 {
+			return runtime.Value{}, errEmpty
+		}
+		res := arr[0]
+		for _, elem := range arr {
+			if typ == types.Number && math.IsNaN(elem.Number()) {
+				return runtime.NewNumber(math.NaN()), nil
+			}
+			if greatest && less(typ, res, elem) || !greatest && less(typ, elem, res) {
+				res = elem
+			}
+		}
+		return runtime.NewValue(typ, res), nil
+	}
+}
+
+func count(ctx context.Context, args []runtime.Value) runtime.Value {
+	return runtime.NewNumber(float64(len(elements(args[0]))))
+}
+
+func distinct(ctx context.Context, args []runtime.Value) runtime.Value {
+	seen := newValueSet(elementType(args[0]))
+	res := []runtime.RawValue{}
+	for _, elem := range elements(args[0]) {
+		if seen.add(elem) {
+			res = append(res, elem)
+		}
+	}
+	return runtime.NewObject(args[0].Type(), res)
+}
+
+func sortFunc(ctx context.Context, args []runtime.Value) runtime.Value {
+	typ := elementType(args[0])
+	res := append([]runtime.RawValue{}, elements(args[0])...)
+	sort.SliceStable(res, func(i, j int) bool {
+		return less(typ, res[i], res[j])
+	})
+	return runtime.NewObject(args[0].Type(), res)
+}
+
+func reverse(ctx context.Context, args []runtime.Value) runtime.Value {
+	arr := elements(args[0])
+	res := make([]runtime.RawValue, len(arr))
+	for i, elem := range arr {
+		res[len(arr)-1-i] = elem
+	}
+	return runtime.NewObject(args[0].Type(), res)
+}
+
+func contains(ctx context.Context, args []runtime.Value) runtime.Value {
+	for _, elem := range elements(args[0]) {
+		if elem.Equal(args[1].RawValue) {
+			return runtime.NewBool(true)
+		}
+	}
+	return runtime.NewBool(false)
+}
+
+// setFunc creates a set function that returns the distinct elements of a and
+// b, in order of appearance, for which keep returns true.
+func setFunc(keep func(inA, inB bool) bool) runtime.FuncFn {
+	return func(ctx context.Context, args []runtime.Value) runtime.Value {
+		typ := elementType(args[0])
+		a := newValueSet(typ)
+		for _, elem := range elements(args[0]) {
+			a.add(elem)
+		}
+		b := newValueSet(typ)
+		for _, elem := range elements(args[1]) {
+			b.add(elem)
+		}
+
+		seen := newValueSet(typ)
+		res := []runtime.RawValue{}
+		for _, arr := range [][]runtime.RawValue{a.elems, b.elems} {
+			for _, elem := range arr {
+				if keep(a.has(elem), b.has(elem)) && seen.add(elem) {
+					res = append(res, elem)
+				}
+			}
+		}
+		return runtime.NewObject(args[0].Type(), res)
+	}
+}
+
+// element creates a function that returns the element at the index returned
+// by index for an array of n elements.
+func element(name string, index func(n int) int) runtime.FuncErrFn {
+	errEmpty := errors.New(name + " of empty array")
+	return func(ctx context.Context, args []runtime.Value) (runtime.Value, error) {
+		arr := elements(args[0])
+		if len(arr) == 0 {
+			return runtime.Value{}, errEmpty
+		}
+		return runtime.NewValue(elementType(args[0]), arr[index(len(arr))]), nil
+	}
+}
+
+// valueSet is a set of values of a comparable type. Values of basic types are
+// hashed; arrays are compared one by one.
+type valueSet struct {
+	hashed map[runtime.RawValue]struct{}
+	elems  []runtime.RawValue
+}
+
+func newValueSet(typ types.Type) *valueSet {
+	s := &valueSet{}
+	if _, isArray := typ.(*types.Array); !isArray {
+		s.hashed = make(map[runtime.RawValue]struct{})
+	}
+	return s
+}
+
+func (s *valueSet) has(v runtime.RawValue) bool {
+	if s.hashed != nil {
+		_, ok := s.hashed[v]
+		return ok
+	}
+	for _, elem := range s.elems {
+		if elem.Equal(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds v to the set. It returns false if v was already in the set.
+func (s *valueSet) add(v runtime.RawValue) bool {
+	if s.has(v) {
+		return false
+	}
+	if s.hashed != nil {
+		s.hashed[v] = struct{}{}
+	}
+	s.elems = append(s.elems, v)
+	return true
+}
@@ -0,0 +1,106 @@
+package arrays
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/stdlib/internal/stdlibtest"
+	stdmath "github.com/dcaiafa/go-expr/expr/stdlib/math"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestArrays(t *testing.T) {
+	run := func(name, input string, expected interface{}) {
+		t.Run(name, func(t *testing.T) {
+			compiler := expr.NewCompiler(
+				expr.WithModule(Module),
+				expr.WithModule(stdmath.Module),
+			)
+			_, err := compiler.RegisterInput("a", &types.Array{ElementType: types.Number})
+			require.NoError(t, err)
+			arr := []runtime.RawValue{
+				runtime.NewRawNumber(3),
+				runtime.NewRawNumber(1),
+				runtime.NewRawNumber(2),
+				runtime.NewRawNumber(3),
+			}
+			args := []runtime.Value{runtime.NewObject(&types.Array{ElementType: types.Number}, arr)}
+			stdlibtest.Run(t, compiler, input, args, expected)
+		})
+	}
+
+	run("sum", `sum(a)`, 9.0)
+	run("sum_empty", `sum([])`, 0.0)
+	run("avg", `avg([1, 2, 6])`, 3.0)
+	run("avg_empty", `isNaN(avg([]))`, true)
+
+	run("min", `min(a)`, 1.0)
+	run("max", `max(a)`, 3.0)
+	run("min_str", `min(["b", "a", "c"])`, "a")
+	run("max_str", `max(["b", "a", "c"])`, "c")
+	run("min_nan", `isNaN(min([1, 0/0, 2]))`, true)
+	run("max_nan", `isNaN(max([1, 2, 0/0]))`, true)
+	run("min_empty", `min([]number)`, stdlibtest.RunError)
+	run("min_bool", `min([true])`, stdlibtest.CompileError)
+	run("min_math", `min(1, 2) + min(a)`, 2.0)
+
+	run("count", `count(a)`, 4.0)
+	run("count_str", `count(["a"])`, 1.0)
+	run("count_pred", `count(a, x => x > 1)`, 3.0)
+
+	run("distinct", `distinct(a)`, []interface{}{3.0, 1.0, 2.0})
+	run("distinct_str", `distinct(["a", "b", "a"])`, []interface{}{"a", "b"})
+	run("distinct_arr", `distinct([[1], [2], [1]])`,
+		[]interface{}{[]interface{}{1.0}, []interface{}{2.0}})
+
+	run("sort", `sort(a)`, []interface{}{1.0, 2.0, 3.0, 3.0})
+	run("sort_str", `sort(["b", "c", "a"])`, []interface{}{"a", "b", "c"})
+	run("sort_empty", `sort([]string)`, []interface{}{})
+	run("sort_arr", `sort([[1]])`, stdlibtest.CompileError)
+
+	run("reverse", `reverse(a)`, []interface{}{3.0, 2.0, 1.0, 3.0})
+	run("reverse_bool", `reverse([true, false])`, []interface{}{false, true})
+
+	run("contains", `contains(a, 2)`, true)
+	run("contains_missing", `contains(a, 5)`, false)
+	run("contains_arr", `contains([[1], [2]], [2])`, true)
+	run("contains_type", `contains(a, "2")`, stdlibtest.CompileError)
+
+	run("intersect", `intersect(a, [2, 3, 4])`, []interface{}{3.0, 2.0})
+	run("union", `union(a, [4, 1, 5])`, []interface{}{3.0, 1.0, 2.0, 4.0, 5.0})
+	run("union_empty", `union([], a)`, []interface{}{3.0, 1.0, 2.0})
+	run("difference", `difference(a, [1])`, []interface{}{3.0, 2.0})
+	run("set_type", `union(a, ["a"])`, stdlibtest.CompileError)
+
+	run("first", `first(a)`, 3.0)
+	run("last", `last(["a", "b"])`, "b")
+	run("first_arr", `first([[1, 2]])`, []interface{}{1.0, 2.0})
+	run("first_empty", `first([]number)`, stdlibtest.RunError)
+	run("last_empty", `last([]string)`, stdlibtest.RunError)
+
+	run("composed", `sum(distinct(a)) + count(sort(reverse(a)))`, 10.0)
+	run("generic_ref", `first`, stdlibtest.CompileError)
+	run("not_array", `first(1)`, stdlibtest.CompileError)
+}
+
+func TestArrays_Fold(t *testing.T) {
+	// Calls with constant arguments are evaluated at compile time, including
+	// the calls to generic functions.
+	require.Equal(t, strings.TrimLeft(`
+  0000  PushNumber       3
+  0001  LoadInput        0
+  0002  CompareEqNumber
+  0003  Return
+`, "\n"), stdlibtest.Listing(t, Module, "x", types.Number, `sum([1, 2]) == x`))
+	for _, input := range []string{
+		`first(["a", "b"]) == "a" && x > max([1, 5, 2])`,
+		`contains([1, 2], 2) || count(["a"]) == x`,
+		`avg([2, 4]) + x == count(["a", "b"]) && min(["b", "c"]) == "b"`,
+	} {
+		listing := stdlibtest.Listing(t, Module, "x", types.Number, input)
+		require.NotContains(t, listing, "Call", input)
+	}
+}
@@ -0,0 +1,97 @@
+// Package stdlibtest is the test harness of the standard library modules.
+package stdlibtest
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	// CompileError is the expected result of an input that must not compile.
+	CompileError = errors.New("compile error expected")
+
+	// RunError is the expected result of an input that must fail at run time.
+	RunError = errors.New("run error expected")
+)
+
+// Run compiles input with compiler, runs it with inputs and checks that the
+// result, converted with ToGo, is expected. expected can also be CompileError
+// or RunError.
+func Run(
+	t *testing.T,
+	compiler *expr.Compiler,
+	input string,
+	inputs []runtime.Value,
+	expected interface{},
+) {
+	t.Helper()
+	prog, err := compiler.Compile(input)
+	if expected == CompileError {
+		require.Error(t, err)
+		return
+	}
+	require.NoError(t, err)
+
+	res, err := runtime.NewRuntime(prog).Run(context.Background(), 0, inputs)
+	if expected == RunError {
+		require.Error(t, err)
+		return
+	}
+	require.NoError(t, err)
+	require.Equal(t, expected, ToGo(res.Type(), res.RawValue))
+}
+
+// Listing compiles input with a new compiler with module and an input named
+// name of type typ, and returns the listing of its instructions, as written by
+// Disassemble. The listing only has the instructions of input, since the
+// compiler is not shared.
+func Listing(
+	t *testing.T,
+	module expr.Module,
+	name string,
+	typ types.Type,
+	input string,
+) string {
+	t.Helper()
+	compiler := expr.NewCompiler(expr.WithModule(module))
+	_, err := compiler.RegisterInput(name, typ)
+	require.NoError(t, err)
+	prog, err := compiler.Compile(input)
+	require.NoError(t, err)
+	var listing strings.Builder
+	require.NoError(t, prog.Disassemble(&listing))
+	_, instrs, ok := strings.Cut(listing.String(), "expr 0:\n")
+	require.True(t, ok)
+	return instrs
+}
+
+// ToGo converts a value of type typ to float64, string, bool or, for arrays,
+// []interface{}. NaN is converted to "NaN", so that it can be compared.
+func ToGo(typ types.Type, v runtime.RawValue) interface{} {
+	switch typ {
+	case types.Number:
+		if math.IsNaN(v.Number()) {
+			return "NaN"
+		}
+		return v.Number()
+	case types.String:
+		return v.String()
+	case types.Bool:
+		return v.Bool()
+	default:
+		elemType := typ.(*types.Array).ElementType
+		res := []interface{}{}
+		for _, elem := range v.Object().([]runtime.RawValue) {
+			res = append(res, ToGo(elemType, elem))
+		}
+		return res
+	}
+}
@@ -0,0 +1,171 @@
+// Package math provides math functions and constants for expressions. They
+// are registered with a compiler using the Module:
+//
+//	compiler := expr.NewCompiler(expr.WithModule(math.Module))
+//
+// All the functions are pure, so calls with constant arguments are evaluated
+// at compile time.
+//
+// The functions follow IEEE 754 semantics, like the Go math package: they
+// do not fail, and invalid operations produce NaN. A NaN argument produces a
+// NaN result, except in isNaN. NaN is never equal to any number, including
+// itself, so isNaN must be used to detect it.
+package math
+
+import (
+	"context"
+	gomath "math"
+
+	"github.com/dcaiafa/go-expr/expr"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// Module registers the constants PI, E and Inf (positive infinity), and the
+// following functions:
+//
+//	abs(x number) number
+//	min(x number, y number, more number...) number
+//	max(x number, y number, more number...) number
+//	floor(x number) number
+//	ceil(x number) number
+//	round(x number, digits number = 0) number
+//	sqrt(x number) number
+//	pow(x number, y number) number
+//	log(x number) number
+//	exp(x number) number
+//	clamp(x number, lo number, hi number) number
+//	isNaN(x number) bool
+//
+// round rounds half away from zero to the given number of decimal digits,
+// which can be negative to round to tens, hundreds, etc. log is the natural
+// logarithm: log(0) is -Inf and the log of a negative number is NaN, as is
+// sqrt of a negative number. clamp returns lo if x < lo and hi if x > hi.
+var Module expr.Module = module{}
+
+type module struct{}
+
+func (module) Register(c *expr.Compiler) error {
+	consts := []struct {
+		name  string
+		value float64
+	}{
+		{"PI", gomath.Pi},
+		{"E", gomath.E},
+		{"Inf", gomath.Inf(1)},
+	}
+	for _, k := range consts {
+		err := c.RegisterConst(k.name, runtime.NewNumber(k.value))
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, def := range funcDefs() {
+		err := c.RegisterFuncDef(def)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func funcDefs() []*expr.FuncDef {
+	defs := []*expr.FuncDef{
+		unary("abs", gomath.Abs),
+		variadic("min", gomath.Min),
+		variadic("max", gomath.Max),
+		unary("floor", gomath.Floor),
+		unary("ceil", gomath.Ceil),
+		{
+			Name:     "round",
+			Func:     round,
+			Ret:      types.Number,
+			Params:   []types.Type{types.Number, types.Number},
+			Defaults: []runtime.Value{runtime.NewNumber(0)},
+		},
+		unary("sqrt", gomath.Sqrt),
+		{
+			Name: "pow",
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewNumber(gomath.Pow(args[0].Number(), args[1].Number()))
+			},
+			Ret:    types.Number,
+			Params: []types.Type{types.Number, types.Number},
+		},
+		unary("log", gomath.Log),
+		unary("exp", gomath.Exp),
+		{
+			Name:   "clamp",
+			Func:   clamp,
+			Ret:    types.Number,
+			Params: []types.Type{types.Number, types.Number, types.Number},
+		},
+		{
+			Name: "isNaN",
+			Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+				return runtime.NewBool(gomath.IsNaN(args[0].Number()))
+			},
+			Ret:    types.Bool,
+			Params: []types.Type{types.Number},
+		},
+	}
+	for _, def := range defs {
+		def.Pure = true
+	}
+	return defs
+}
+
+func unary(name string, fn func(float64) float64) *expr.FuncDef {
+	return &expr.FuncDef{
+		Name: name,
+		Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+			return runtime.NewNumber(fn(args[0].Number()))
+		},
+		Ret:    types.Number,
+		Params: []types.Type{types.Number},
+	}
+}
+
+// variadic creates a function that reduces two or more arguments with fn.
+func variadic(name string, fn func(float64, float64) float64) *expr.FuncDef {
+	return &expr.FuncDef{
+		Name: name,
+		Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+			res := args[0].Number()
+			for _, arg := range args[1:] {
+				res = fn(res, arg.Number())
+			}
+			return runtime.NewNumber(res)
+		},
+		Ret:      types.Number,
+		Params:   []types.Type{types.Number, types.Number, types.Number},
+		Variadic: true,
+	}
+}
+
+func round(ctx context.Context, args []runtime.Value) runtime.Value {
+	x := args[0].Number()
+	digits := gomath.Trunc(args[1].Number())
+	if digits == 0 || gomath.IsNaN(x) || gomath.IsInf(x, 0) {
+		return runtime.NewNumber(gomath.Round(x))
+	}
+	scale := gomath.Pow(10, digits)
+	switch {
+	case gomath.IsNaN(digits):
+		return runtime.NewNumber(gomath.NaN())
+	case scale == 0:
+		// The rounding unit is larger than any float64.
+		return runtime.NewNumber(gomath.Copysign(0, x))
+	case gomath.IsInf(x*scale, 0):
+		// x cannot have that many decimal digits.
+		return runtime.NewNumber(x)
+	default:
+		return runtime.NewNumber(gomath.Round(x*scale) / scale)
+	}
+}
+
+func clamp(ctx context.Context, args []runtime.Value) runtime.Value {
+	x, lo, hi := args[0].Number(), args[1].Number(), args[2].Number()
+	return runtime.NewNumber(gomath.Min(gomath.Max(x, lo), hi))
+}
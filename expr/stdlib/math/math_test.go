@@ -0,0 +1,172 @@
+package math
+
+import (
+	"context"
+	gomath "math"
+	"strings"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/stdlib/internal/stdlibtest"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	nan    = gomath.NaN()
+	posInf = gomath.Inf(1)
+	negInf = gomath.Inf(-1)
+	negZ   = gomath.Copysign(0, -1)
+)
+
+// eval evaluates input with the inputs x and y.
+func eval(t *testing.T, input string, x, y float64) runtime.Value {
+	compiler := expr.NewCompiler(expr.WithModule(Module))
+	_, err := compiler.RegisterInput("x", types.Number)
+	require.NoError(t, err)
+	_, err = compiler.RegisterInput("y", types.Number)
+	require.NoError(t, err)
+
+	prog, err := compiler.Compile(input)
+	require.NoError(t, err)
+
+	args := []runtime.Value{runtime.NewNumber(x), runtime.NewNumber(y)}
+	res, err := runtime.NewRuntime(prog).Run(context.Background(), 0, args)
+	require.NoError(t, err)
+	return res
+}
+
+// requireSame checks that the numbers are identical, including NaN and the
+// sign of zero.
+func requireSame(t *testing.T, expected, actual float64) {
+	if gomath.IsNaN(expected) {
+		require.True(t, gomath.IsNaN(actual), "expected NaN, got %v", actual)
+		return
+	}
+	require.Equal(t, expected, actual)
+	require.Equal(t, gomath.Signbit(expected), gomath.Signbit(actual))
+}
+
+var special = []float64{0, negZ, 1, -1, 0.5, -2.5, 2.5, 1e300, posInf, negInf, nan}
+
+func TestMath_Unary(t *testing.T) {
+	funcs := map[string]func(float64) float64{
+		"abs":   gomath.Abs,
+		"floor": gomath.Floor,
+		"ceil":  gomath.Ceil,
+		"round": gomath.Round,
+		"sqrt":  gomath.Sqrt,
+		"log":   gomath.Log,
+		"exp":   gomath.Exp,
+	}
+	for name, fn := range funcs {
+		t.Run(name, func(t *testing.T) {
+			for _, x := range special {
+				res := eval(t, name+"(x)", x, 0)
+				requireSame(t, fn(x), res.Number())
+			}
+		})
+	}
+}
+
+func TestMath_Binary(t *testing.T) {
+	funcs := map[string]func(float64, float64) float64{
+		"min": gomath.Min,
+		"max": gomath.Max,
+		"pow": gomath.Pow,
+	}
+	for name, fn := range funcs {
+		t.Run(name, func(t *testing.T) {
+			for _, x := range special {
+				for _, y := range special {
+					res := eval(t, name+"(x, y)", x, y)
+					requireSame(t, fn(x, y), res.Number())
+				}
+			}
+		})
+	}
+}
+
+func TestMath(t *testing.T) {
+	run := func(name, input string, expected interface{}) {
+		t.Run(name, func(t *testing.T) {
+			res := eval(t, input, 0, 0)
+			switch expected := expected.(type) {
+			case bool:
+				require.Equal(t, expected, res.Bool())
+			default:
+				requireSame(t, expected.(float64), res.Number())
+			}
+		})
+	}
+
+	run("PI", `PI`, gomath.Pi)
+	run("E", `E`, gomath.E)
+	run("Inf", `Inf`, posInf)
+	run("neg_Inf", `0 - Inf`, negInf)
+
+	run("min3", `min(3, 1, 2)`, 1.0)
+	run("max3", `max(3, 1, 2)`, 3.0)
+	run("min_nan", `min(1, 0/0, 2)`, nan)
+	run("max_inf", `max(1, Inf)`, posInf)
+
+	run("round_half", `round(2.5)`, 3.0)
+	run("round_neg_half", `round(-2.5)`, -3.0)
+	run("round_digits", `round(3.14159, 2)`, 3.14)
+	run("round_digits_trunc", `round(3.14159, 2.9)`, 3.14)
+	run("round_neg_digits", `round(1250, -2)`, 1300.0)
+	run("round_huge_digits", `round(1.5, 400)`, 1.5)
+	run("round_huge_neg_digits", `round(-1.5, -400)`, negZ)
+	run("round_inf", `round(Inf, 2)`, posInf)
+	run("round_nan", `round(0/0, 2)`, nan)
+	run("round_nan_digits", `round(1, 0/0)`, nan)
+
+	run("sqrt_neg", `sqrt(-1)`, nan)
+	run("log_zero", `log(0)`, negInf)
+	run("log_neg", `log(-1)`, nan)
+	run("log_e", `log(E)`, 1.0)
+	run("exp_neg_inf", `exp(0 - Inf)`, 0.0)
+
+	run("clamp_in", `clamp(5, 1, 10)`, 5.0)
+	run("clamp_lo", `clamp(-5, 1, 10)`, 1.0)
+	run("clamp_hi", `clamp(50, 1, 10)`, 10.0)
+	run("clamp_inf", `clamp(Inf, 1, 10)`, 10.0)
+	run("clamp_nan", `clamp(0/0, 1, 10)`, nan)
+
+	run("isNaN", `isNaN(0/0)`, true)
+	run("isNaN_sqrt", `isNaN(sqrt(-1))`, true)
+	run("isNaN_inf", `isNaN(Inf)`, false)
+	run("isNaN_num", `isNaN(1)`, false)
+	run("nan_ne_nan", `0/0 == 0/0`, false)
+}
+
+func TestMath_Errors(t *testing.T) {
+	compiler := expr.NewCompiler(expr.WithModule(Module))
+	for _, input := range []string{
+		`min(1)`,
+		`abs("a")`,
+		`clamp(1, 2)`,
+		`round(1, 2, 3)`,
+	} {
+		_, err := compiler.Compile(input)
+		require.Error(t, err, input)
+	}
+}
+
+func TestMath_Fold(t *testing.T) {
+	// Calls with constant arguments are evaluated at compile time.
+	require.Equal(t, strings.TrimLeft(`
+  0000  PushNumber       2
+  0001  LoadInput        0
+  0002  Add
+  0003  Return
+`, "\n"), stdlibtest.Listing(t, Module, "x", types.Number, `sqrt(4) + x`))
+	require.Equal(t, strings.TrimLeft(`
+  0000  LoadInput        0
+  0001  PushNumber       9
+  0002  CompareLT
+  0003  Return
+`, "\n"), stdlibtest.Listing(t, Module, "x", types.Number,
+		`x < pow(clamp(abs(-5), 0, 3), round(2.4))`))
+}
@@ -0,0 +1,302 @@
+// Package strings provides string functions for expressions. The functions
+// are registered with a compiler using the Module:
+//
+//	compiler := expr.NewCompiler(expr.WithModule(strings.Module))
+//
+// Indices and lengths are measured in runes, not bytes. All the functions are
+// pure, so calls with constant arguments are evaluated at compile time.
+package strings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	gostrings "strings"
+	"unicode/utf8"
+
+	"github.com/dcaiafa/go-expr/expr"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+)
+
+// MaxRepeatLen is the maximum length, in bytes, of the string produced by
+// repeat. Larger results fail with an error.
+const MaxRepeatLen = 1 << 20
+
+var stringArray = &types.Array{ElementType: types.String}
+
+// Module registers the following functions:
+//
+//	lower(s string) string
+//	upper(s string) string
+//	trim(s string) string
+//	split(s string, sep string) []string
+//	join(elems []string, sep string) string
+//	replace(s string, old string, new string) string
+//	substr(s string, start number, length number = -1) string
+//	indexOf(s string, substr string) number
+//	len(s string) number
+//	repeat(s string, count number) string
+//	padLeft(s string, width number, pad string = " ") string
+//	format(format string, args any...) string
+//
+// substr returns the runes of s starting at start, up to length runes or to
+// the end of s if length is negative. Out of range values are clamped.
+// indexOf returns -1 if substr is not found. format replaces each "{}" in
+// format with the next argument, which must be a number, string, bool, IP,
+// CIDR or an array of them.
+var Module expr.Module = module{}
+
+type module struct{}
+
+func (module) Register(c *expr.Compiler) error {
+	for _, def := range funcDefs() {
+		err := c.RegisterFuncDef(def)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func funcDefs() []*expr.FuncDef {
+	defs := []*expr.FuncDef{
+		{
+			Name:   "lower",
+			Func:   stringFunc(gostrings.ToLower),
+			Ret:    types.String,
+			Params: []types.Type{types.String},
+		},
+		{
+			Name:   "upper",
+			Func:   stringFunc(gostrings.ToUpper),
+			Ret:    types.String,
+			Params: []types.Type{types.String},
+		},
+		{
+			Name:   "trim",
+			Func:   stringFunc(gostrings.TrimSpace),
+			Ret:    types.String,
+			Params: []types.Type{types.String},
+		},
+		{
+			Name:   "split",
+			Func:   split,
+			Ret:    stringArray,
+			Params: []types.Type{types.String, types.String},
+		},
+		{
+			Name:   "join",
+			Func:   join,
+			Ret:    types.String,
+			Params: []types.Type{stringArray, types.String},
+		},
+		{
+			Name:   "replace",
+			Func:   replace,
+			Ret:    types.String,
+			Params: []types.Type{types.String, types.String, types.String},
+		},
+		{
+			Name:     "substr",
+			Func:     substr,
+			Ret:      types.String,
+			Params:   []types.Type{types.String, types.Number, types.Number},
+			Defaults: []runtime.Value{runtime.NewNumber(-1)},
+		},
+		{
+			Name:   "indexOf",
+			Func:   indexOf,
+			Ret:    types.Number,
+			Params: []types.Type{types.String, types.String},
+		},
+		{
+			Name:   "len",
+			Func:   length,
+			Ret:    types.Number,
+			Params: []types.Type{types.String},
+		},
+		{
+			Name:    "repeat",
+			FuncErr: repeat,
+			Ret:     types.String,
+			Params:  []types.Type{types.String, types.Number},
+		},
+		{
+			Name:     "padLeft",
+			Func:     padLeft,
+			Ret:      types.String,
+			Params:   []types.Type{types.String, types.Number, types.String},
+			Defaults: []runtime.Value{runtime.NewString(" ")},
+		},
+		{
+			Name:     "format",
+			FuncErr:  format,
+			Ret:      types.String,
+			Params:   []types.Type{types.String, types.Any},
+			Variadic: true,
+		},
+	}
+	for _, def := range defs {
+		def.Pure = true
+	}
+	return defs
+}
+
+func stringFunc(fn func(string) string) runtime.FuncFn {
+	return func(ctx context.Context, args []runtime.Value) runtime.Value {
+		return runtime.NewString(fn(args[0].String()))
+	}
+}
+
+func split(ctx context.Context, args []runtime.Value) runtime.Value {
+	parts := gostrings.Split(args[0].String(), args[1].String())
+	arr := make([]runtime.RawValue, len(parts))
+	for i, part := range parts {
+		arr[i] = runtime.NewRawObject(part)
+	}
+	return runtime.NewObject(stringArray, arr)
+}
+
+func join(ctx context.Context, args []runtime.Value) runtime.Value {
+	arr := args[0].Object().([]runtime.RawValue)
+	elems := make([]string, len(arr))
+	for i, elem := range arr {
+		elems[i] = elem.String()
+	}
+	return runtime.NewString(gostrings.Join(elems, args[1].String()))
+}
+
+func replace(ctx context.Context, args []runtime.Value) runtime.Value {
+	return runtime.NewString(gostrings.ReplaceAll(
+		args[0].String(), args[1].String(), args[2].String()))
+}
+
+func substr(ctx context.Context, args []runtime.Value) runtime.Value {
+	runes := []rune(args[0].String())
+	start := clamp(args[1].Number(), len(runes))
+	end := len(runes)
+	if length := args[2].Number(); length >= 0 {
+		end = start + clamp(length, len(runes)-start)
+	}
+	return runtime.NewString(string(runes[start:end]))
+}
+
+// clamp converts n to an int in the range [0, max].
+func clamp(n float64, max int) int {
+	switch {
+	case n != n || n <= 0:
+		return 0
+	case n >= float64(max):
+		return max
+	default:
+		return int(n)
+	}
+}
+
+func indexOf(ctx context.Context, args []runtime.Value) runtime.Value {
+	s := args[0].String()
+	i := gostrings.Index(s, args[1].String())
+	if i < 0 {
+		return runtime.NewNumber(-1)
+	}
+	return runtime.NewNumber(float64(utf8.RuneCountInString(s[:i])))
+}
+
+func length(ctx context.Context, args []runtime.Value) runtime.Value {
+	return runtime.NewNumber(float64(utf8.RuneCountInString(args[0].String())))
+}
+
+func repeat(ctx context.Context, args []runtime.Value) (runtime.Value, error) {
+	s := args[0].String()
+	count := args[1].Number()
+	if count != count || count < 1 || s == "" {
+		return runtime.NewString(""), nil
+	}
+	if count > float64(MaxRepeatLen/len(s)) {
+		return runtime.Value{}, fmt.Errorf(
+			"repeat: result exceeds the maximum length of %d bytes", MaxRepeatLen)
+	}
+	return runtime.NewString(gostrings.Repeat(s, int(count))), nil
+}
+
+func padLeft(ctx context.Context, args []runtime.Value) runtime.Value {
+	s := args[0].String()
+	pad := []rune(args[2].String())
+	padLen := clamp(args[1].Number(), MaxRepeatLen) - utf8.RuneCountInString(s)
+	if padLen <= 0 || len(pad) == 0 {
+		return runtime.NewString(s)
+	}
+	var sb gostrings.Builder
+	for i := 0; i < padLen; i++ {
+		sb.WriteRune(pad[i%len(pad)])
+	}
+	sb.WriteString(s)
+	return runtime.NewString(sb.String())
+}
+
+var errFormatArgs = errors.New("format: the arguments do not match the {} placeholders")
+
+func format(ctx context.Context, args []runtime.Value) (runtime.Value, error) {
+	f := args[0].String()
+	args = args[1:]
+
+	var sb gostrings.Builder
+	for {
+		i := gostrings.Index(f, "{}")
+		if i < 0 {
+			break
+		}
+		if len(args) == 0 {
+			return runtime.Value{}, errFormatArgs
+		}
+		sb.WriteString(f[:i])
+		err := writeValue(&sb, args[0].Type(), args[0].RawValue)
+		if err != nil {
+			return runtime.Value{}, err
+		}
+		f = f[i+2:]
+		args = args[1:]
+	}
+	if len(args) != 0 {
+		return runtime.Value{}, errFormatArgs
+	}
+	sb.WriteString(f)
+	return runtime.NewString(sb.String()), nil
+}
+
+// writeValue writes v, of type typ, to sb. It returns errFormatArgs for values
+// that cannot be formatted, such as functions.
+func writeValue(sb *gostrings.Builder, typ types.Type, v runtime.RawValue) error {
+	switch typ {
+	case types.Number:
+		sb.WriteString(strconv.FormatFloat(v.Number(), 'g', -1, 64))
+	case types.Bool:
+		sb.WriteString(strconv.FormatBool(v.Bool()))
+	case types.String:
+		sb.WriteString(v.String())
+	case types.IP:
+		sb.WriteString(v.Object().(netip.Addr).String())
+	case types.CIDR:
+		sb.WriteString(v.Object().(netip.Prefix).String())
+	default:
+		arr, ok := typ.(*types.Array)
+		if !ok {
+			return errFormatArgs
+		}
+		sb.WriteByte('[')
+		for i, elem := range v.Object().([]runtime.RawValue) {
+			if i != 0 {
+				sb.WriteString(", ")
+			}
+			err := writeValue(sb, arr.ElementType, elem)
+			if err != nil {
+				return err
+			}
+		}
+		sb.WriteByte(']')
+	}
+	return nil
+}
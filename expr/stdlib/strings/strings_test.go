@@ -0,0 +1,82 @@
+package strings
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr"
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/stdlib/internal/stdlibtest"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStrings(t *testing.T) {
+	run := func(name, input string, expected interface{}) {
+		t.Run(name, func(t *testing.T) {
+			compiler := expr.NewCompiler(expr.WithModule(Module))
+			_, err := compiler.RegisterInput("a", types.String)
+			require.NoError(t, err)
+			args := []runtime.Value{runtime.NewString("héllo")}
+			stdlibtest.Run(t, compiler, input, args, expected)
+		})
+	}
+
+	run("lower", `lower("HeLLo")`, "hello")
+	run("upper", `upper(a)`, "HÉLLO")
+	run("trim", `trim("  a b ")`, "a b")
+	run("split", `split("a,b,,c", ",")`, []interface{}{"a", "b", "", "c"})
+	run("split_empty_sep", `split(a, "")`, []interface{}{"h", "é", "l", "l", "o"})
+	run("join", `join(["a", "b", "c"], "-")`, "a-b-c")
+	run("join_empty", `join([], "-")`, "")
+	run("join_split", `join(split(a, "l"), "L")`, "héLLo")
+	run("replace", `replace("a.b.c", ".", "::")`, "a::b::c")
+	run("substr", `substr(a, 1, 3)`, "éll")
+	run("substr_rest", `substr(a, 1)`, "éllo")
+	run("substr_clamp_start", `substr(a, -2, 2)`, "hé")
+	run("substr_clamp_end", `substr(a, 3, 10)`, "lo")
+	run("substr_out_of_range", `substr(a, 10)`, "")
+	run("indexOf", `indexOf(a, "l")`, 2.0)
+	run("indexOf_missing", `indexOf(a, "x")`, -1.0)
+	run("len", `len(a)`, 5.0)
+	run("len_literal", `len("")`, 0.0)
+	run("repeat", `repeat("ab", 3)`, "ababab")
+	run("repeat_zero", `repeat("ab", 0)`, "")
+	run("repeat_negative", `repeat("ab", -1)`, "")
+	run("repeat_limit", `repeat(a, 1000000)`, stdlibtest.RunError)
+	run("padLeft", `padLeft("7", 3, "0")`, "007")
+	run("padLeft_default", `padLeft(a, 7)`, "  héllo")
+	run("padLeft_multi", `padLeft("x", 6, "ab")`, "ababax")
+	run("padLeft_wide", `padLeft(a, 2)`, "héllo")
+	run("format", `format("{} is {}: {}", a, 1.5, true)`, "héllo is 1.5: true")
+	run("format_array", `format("{}", [1, 2])`, "[1, 2]")
+	run("format_none", `format("{}")`, stdlibtest.RunError)
+	run("format_extra", `format("", 1)`, stdlibtest.RunError)
+	run("format_ip", `format("{} in {}", ip("10.0.0.1"), [cidr("10.1.2.3/8")])`,
+		"10.0.0.1 in [10.0.0.0/8]")
+	run("format_func", `format("{}", lower)`, stdlibtest.RunError)
+	run("format_func_array", `format("{}", [lower])`, stdlibtest.RunError)
+
+	run("type_error", `upper(1)`, stdlibtest.CompileError)
+	run("count_error", `replace("a", "b")`, stdlibtest.CompileError)
+}
+
+func TestStrings_Fold(t *testing.T) {
+	// Calls with constant arguments are evaluated at compile time.
+	require.Equal(t, strings.TrimLeft(`
+  0000  PushString       "ABC"
+  0001  LoadInput        0
+  0002  CompareEqString
+  0003  Return
+`, "\n"), stdlibtest.Listing(t, Module, "a", types.String, `upper("abc") == a`))
+
+	// Only the calls with non-constant arguments remain.
+	for input, calls := range map[string]int{
+		`replace(lower("A.B"), ".", "-") == a`:                       0,
+		`padLeft(substr("héllo", 1), 6) == trim(a)`:                  1,
+		`len(format("{}-{}", 1, true)) + indexOf(a, repeat("l", 2))`: 1,
+	} {
+		listing := stdlibtest.Listing(t, Module, "a", types.String, input)
+		require.Equal(t, calls, strings.Count(listing, "Call "), input)
+	}
+}
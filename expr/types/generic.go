@@ -0,0 +1,121 @@
+package types
+
+// TypeVar is a type parameter of a generic function. It can be used in the
+// parameter and return types of a registered function, and it is bound at
+// each call site to the type of the corresponding argument. For example, a
+// function of type func(array of T) T called with an array of numbers returns
+// a number.
+type TypeVar struct {
+	Name string
+
+	// Constraint restricts the types that can be bound to the variable. If it
+	// is nil, any type can be bound.
+	Constraint func(t Type) bool
+}
+
+var _ Type = (*TypeVar)(nil)
+
+// NewTypeVar creates a type variable. constraint is optional.
+func NewTypeVar(name string, constraint func(t Type) bool) *TypeVar {
+	return &TypeVar{Name: name, Constraint: constraint}
+}
+
+func (v *TypeVar) String() string {
+	return v.Name
+}
+
+// Equal determines whether other is the same type variable. Two type variables
+// with the same name are different variables.
+func (v *TypeVar) Equal(other Type) bool {
+	return v == other
+}
+
+// Ordered returns true if values of type t can be ordered with <.
+func Ordered(t Type) bool {
+	return t == Number || t == String
+}
+
+// TypeVars returns the type variables referenced by t, in order of first
+// appearance.
+func TypeVars(t Type) []*TypeVar {
+	var vars []*TypeVar
+	var visit func(t Type)
+	visit = func(t Type) {
+		switch t := t.(type) {
+		case *TypeVar:
+			for _, v := range vars {
+				if v == t {
+					return
+				}
+			}
+			vars = append(vars, t)
+		case *Array:
+			visit(t.ElementType)
+		case *Function:
+			for _, param := range t.Params {
+				visit(param)
+			}
+			visit(t.Ret)
+		}
+	}
+	visit(t)
+	return vars
+}
+
+// IsGeneric returns true if t references type variables.
+func IsGeneric(t Type) bool {
+	return len(TypeVars(t)) != 0
+}
+
+// Bindings maps type variables to the types bound to them.
+type Bindings map[*TypeVar]Type
+
+// Unify determines whether a value of type from can be used where a value of
+// the generic type to is expected, binding the type variables of to as
+// needed. A variable that is already bound must match exactly.
+func Unify(to, from Type, bindings Bindings) bool {
+	switch to := to.(type) {
+	case *TypeVar:
+		if bound, ok := bindings[to]; ok {
+			return bound.Equal(from)
+		}
+		if IsGeneric(from) || (to.Constraint != nil && !to.Constraint(from)) {
+			return false
+		}
+		bindings[to] = from
+		return true
+
+	case *Array:
+		fromArray, ok := from.(*Array)
+		if !ok {
+			return false
+		}
+		return Unify(to.ElementType, fromArray.ElementType, bindings)
+
+	default:
+		return Assignable(to, from)
+	}
+}
+
+// Subst replaces the type variables in t that have bindings.
+func Subst(t Type, bindings Bindings) Type {
+	switch t := t.(type) {
+	case *TypeVar:
+		if bound, ok := bindings[t]; ok {
+			return bound
+		}
+		return t
+	case *Array:
+		return &Array{ElementType: Subst(t.ElementType, bindings)}
+	case *Function:
+		fn := *t
+		fn.Params = make([]Type, len(t.Params))
+		for i, param := range t.Params {
+			fn.Params[i] = Subst(param, bindings)
+		}
+		fn.Ret = Subst(t.Ret, bindings)
+		return &fn
+	default:
+		return t
+	}
+}
@@ -1,6 +1,9 @@
 package types
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 // Type is the type of values and symbols in an expression.
 type Type interface {
@@ -15,6 +18,10 @@ const (
 	numberKind
 	stringKind
 	boolKind
+	anyKind
+	regexpKind
+	ipKind
+	cidrKind
 )
 
 type basic struct {
@@ -33,6 +40,14 @@ func (b *basic) String() string {
 		return "string"
 	case boolKind:
 		return "bool"
+	case anyKind:
+		return "any"
+	case regexpKind:
+		return "regexp"
+	case ipKind:
+		return "ip"
+	case cidrKind:
+		return "cidr"
 	default:
 		return "invalid"
 	}
@@ -48,12 +63,43 @@ var (
 	Number = &basic{numberKind}
 	String = &basic{stringKind}
 	Bool   = &basic{boolKind}
+
+	// IP is the type of IP addresses, which are stored as netip.Addr objects.
+	IP = &basic{ipKind}
+
+	// CIDR is the type of IP address prefixes, such as 10.0.0.0/8, which are
+	// stored as masked netip.Prefix objects.
+	CIDR = &basic{cidrKind}
+
+	// Any can only be used as the type of function parameters. The argument
+	// is received with the static type of the argument expression.
+	Any = &basic{anyKind}
+
+	// Regexp is the type of regular expressions compiled by the compiler,
+	// which are stored as *regexp.Regexp objects. Expressions cannot produce
+	// values of this type.
+	Regexp = &basic{regexpKind}
 )
 
-// Function is type of function symbols and values.
+// Assignable determines whether a value of type from can be used where a value
+// of type to is expected.
+func Assignable(to, from Type) bool {
+	return to == Any || to.Equal(from)
+}
+
+// Function is type of function symbols and values. It is also the type of
+// lambda expressions passed to higher-order functions.
 type Function struct {
 	Params []Type
 	Ret    Type
+
+	// Variadic indicates that the last parameter accepts zero or more
+	// arguments.
+	Variadic bool
+
+	// Optional is the number of trailing parameters, not including the
+	// variadic parameter, that can be omitted.
+	Optional int
 }
 
 var _ Type = (*Function)(nil)
@@ -67,6 +113,9 @@ func (f *Function) Equal(other Type) bool {
 	if !f.Ret.Equal(otherFn.Ret) {
 		return false
 	}
+	if f.Variadic != otherFn.Variadic || f.Optional != otherFn.Optional {
+		return false
+	}
 	if len(f.Params) != len(otherFn.Params) {
 		return false
 	}
@@ -79,7 +128,49 @@ func (f *Function) Equal(other Type) bool {
 }
 
 func (f *Function) String() string {
-	return "function"
+	var sb strings.Builder
+	sb.WriteString("func(")
+	for i, param := range f.Params {
+		if i != 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(param.String())
+		if f.Variadic && i == len(f.Params)-1 {
+			sb.WriteString("...")
+		} else if i >= f.MinArgs() {
+			sb.WriteString("?")
+		}
+	}
+	sb.WriteString(") ")
+	sb.WriteString(f.Ret.String())
+	return sb.String()
+}
+
+// MinArgs returns the minimum number of arguments required to call the
+// function.
+func (f *Function) MinArgs() int {
+	n := len(f.Params) - f.Optional
+	if f.Variadic {
+		n--
+	}
+	return n
+}
+
+// AcceptsArgs determines whether the function can be called with argCount
+// arguments.
+func (f *Function) AcceptsArgs(argCount int) bool {
+	if argCount < f.MinArgs() {
+		return false
+	}
+	return f.Variadic || argCount <= len(f.Params)
+}
+
+// ParamType returns the type of the parameter that receives argument i.
+func (f *Function) ParamType(i int) Type {
+	if f.Variadic && i >= len(f.Params)-1 {
+		return f.Params[len(f.Params)-1]
+	}
+	return f.Params[i]
 }
 
 type Array struct {
@@ -99,3 +190,30 @@ func (a *Array) Equal(other Type) bool {
 func (a *Array) String() string {
 	return "array of " + a.ElementType.String()
 }
+
+// ValueType returns true if t can be the type of an input or a constant. Void,
+// Any and Regexp, and arrays of them, cannot.
+func ValueType(t Type) bool {
+	switch t := t.(type) {
+	case *basic:
+		return t != Void && t != Any && t != Regexp
+	case *Array:
+		return ValueType(t.ElementType)
+	case *Function:
+		return !IsGeneric(t)
+	default:
+		return false
+	}
+}
+
+// Comparable returns true if values of type t can be compared for equality.
+func Comparable(t Type) bool {
+	switch t := t.(type) {
+	case *basic:
+		return t != Void && t != Regexp
+	case *Array:
+		return Comparable(t.ElementType)
+	default:
+		return false
+	}
+}
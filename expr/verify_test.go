@@ -0,0 +1,250 @@
+package expr
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/dcaiafa/go-expr/expr/runtime"
+	"github.com/dcaiafa/go-expr/expr/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgram_Verify(t *testing.T) {
+	numberArray := &types.Array{ElementType: types.Number}
+	double := &runtime.Func{
+		Name: "double",
+		Type: &types.Function{Params: []types.Type{types.Number}, Ret: types.Number},
+		Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+			return runtime.NewNumber(args[0].Number() * 2)
+		},
+	}
+	typeOf := &runtime.Func{
+		Name: "typeOf",
+		Type: &types.Function{Params: []types.Type{types.Any}, Ret: types.String},
+		Func: func(ctx context.Context, args []runtime.Value) runtime.Value {
+			return runtime.NewString(args[0].Type().String())
+		},
+	}
+
+	run := func(name string, result types.Type, build func(b *runtime.Builder), expected string) {
+		t.Run(name, func(t *testing.T) {
+			b := runtime.NewBuilder()
+			build(b)
+			require.NoError(t, b.FinishExpr())
+			prog := b.Build()
+			prog.ResultType = result
+			err := prog.Verify()
+			if expected == "" {
+				require.NoError(t, err)
+				return
+			}
+			require.True(t, errors.Is(err, runtime.ErrInvalidProgram))
+			require.EqualError(t, err, "invalid program: "+expected)
+		})
+	}
+
+	run("valid", types.Number, func(b *runtime.Builder) {
+		// reduce([], |acc, x| acc + double(x), 0)
+		iter, acc, x := b.NewLocal(), b.NewLocal(), b.NewLocal()
+		loop, done := b.NewLabel(), b.NewLabel()
+		b.EmitPushArray(0)
+		b.EmitIterInit(iter)
+		b.EmitPushNumber(0)
+		b.AssignLabel(loop)
+		b.EmitIterNext(iter, done)
+		b.EmitStoreLocal(x)
+		b.EmitStoreLocal(acc)
+		b.EmitLoadLocal(acc)
+		b.EmitLoadConst(b.NewConst(runtime.NewObject(double.Type, double)))
+		b.EmitLoadLocal(x)
+		b.EmitCall(1)
+		b.EmitOp(runtime.Add)
+		b.EmitJump(runtime.Jump, loop)
+		b.AssignLabel(done)
+		b.EmitOp(runtime.Return)
+	}, "")
+	run("valid_box", types.String, func(b *runtime.Builder) {
+		b.EmitLoadConst(b.NewConst(runtime.NewObject(typeOf.Type, typeOf)))
+		b.EmitPushNumber(1)
+		b.EmitPushArray(1)
+		b.EmitBox(numberArray)
+		b.EmitCall(1)
+	}, "")
+	run("valid_and", types.Bool, func(b *runtime.Builder) {
+		skip := b.NewLabel()
+		b.EmitPushBool(true)
+		b.EmitOp(runtime.Duplicate)
+		b.EmitJump(runtime.JumpIfFalse, skip)
+		b.EmitPushBool(false)
+		b.EmitOp(runtime.And)
+		b.AssignLabel(skip)
+	}, "")
+	run("valid_and_or_pop", types.Bool, func(b *runtime.Builder) {
+		skip := b.NewLabel()
+		b.EmitPushBool(true)
+		b.EmitJump(runtime.JumpIfFalseOrPop, skip)
+		b.EmitPushNumber(1)
+		b.EmitPushNumber(2)
+		b.EmitOp(runtime.CompareNeNumber)
+		b.AssignLabel(skip)
+	}, "")
+
+	run("result_type_not_set", nil, func(b *runtime.Builder) {
+		b.EmitPushNumber(1)
+	}, "result type is not set")
+	run("underflow", types.Number, func(b *runtime.Builder) {
+		b.EmitPushNumber(1)
+		b.EmitOp(runtime.Add)
+	}, "expression 0: instruction 1 (Add): stack underflow")
+	run("operand_type", types.Number, func(b *runtime.Builder) {
+		b.EmitPushString("a")
+		b.EmitPushNumber(1)
+		b.EmitOp(runtime.Add)
+	}, "expression 0: instruction 2 (Add): operand has type string, expected number")
+	run("const_index", types.Number, func(b *runtime.Builder) {
+		b.EmitLoadConst(3)
+	}, "expression 0: instruction 0 (LoadConst): constant index 3 out of range")
+	run("input_index", types.Number, func(b *runtime.Builder) {
+		b.EmitLoadInput(0)
+	}, "expression 0: instruction 0 (LoadInput): input index 0 out of range")
+	run("local_index", types.Number, func(b *runtime.Builder) {
+		b.EmitPushNumber(1)
+		b.EmitStoreLocal(0)
+	}, "expression 0: instruction 1 (StoreLocal): local index 0 out of range")
+	run("local_not_set", types.Number, func(b *runtime.Builder) {
+		b.EmitLoadLocal(b.NewLocal())
+	}, "expression 0: instruction 0 (LoadLocal): local 0 may not be set")
+	run("local_set_on_one_path", types.Number, func(b *runtime.Builder) {
+		local := b.NewLocal()
+		skip := b.NewLabel()
+		b.EmitPushBool(true)
+		b.EmitJump(runtime.JumpIfTrue, skip)
+		b.EmitPushNumber(1)
+		b.EmitStoreLocal(local)
+		b.AssignLabel(skip)
+		b.EmitLoadLocal(local)
+	}, "expression 0: instruction 4 (LoadLocal): local 0 may not be set")
+	run("stack_depth_mismatch", types.Number, func(b *runtime.Builder) {
+		skip := b.NewLabel()
+		b.EmitPushNumber(1)
+		b.EmitPushBool(true)
+		b.EmitJump(runtime.JumpIfTrue, skip)
+		b.EmitPushNumber(2)
+		b.AssignLabel(skip)
+	}, "expression 0: instruction 3 (PushNumber): "+
+		"stack depth 2 at 4 does not match depth 1 of another path")
+	run("stack_type_mismatch", types.Number, func(b *runtime.Builder) {
+		skip, end := b.NewLabel(), b.NewLabel()
+		b.EmitPushBool(true)
+		b.EmitJump(runtime.JumpIfTrue, skip)
+		b.EmitPushNumber(1)
+		b.EmitJump(runtime.Jump, end)
+		b.AssignLabel(skip)
+		b.EmitPushString("a")
+		b.AssignLabel(end)
+	}, "expression 0: instruction 4 (PushString): "+
+		"stack type string at 5 does not match type number of another path")
+	run("result_type", types.Bool, func(b *runtime.Builder) {
+		b.EmitPushNumber(1)
+	}, "expression 0: end: result has type number, expected bool")
+	run("end_depth", types.Number, func(b *runtime.Builder) {
+		b.EmitPushNumber(1)
+		b.EmitPushNumber(2)
+		b.EmitOp(runtime.Return)
+	}, "expression 0: instruction 2 (Return): stack depth is 2, expected 1")
+	run("call_not_func", types.Number, func(b *runtime.Builder) {
+		b.EmitPushNumber(1)
+		b.EmitPushNumber(2)
+		b.EmitCall(1)
+	}, "expression 0: instruction 2 (Call): cannot call number")
+	run("call_arg_type", types.Number, func(b *runtime.Builder) {
+		b.EmitLoadConst(b.NewConst(runtime.NewObject(double.Type, double)))
+		b.EmitPushString("a")
+		b.EmitCall(1)
+	}, "expression 0: instruction 2 (Call): argument 0 has type string, expected number")
+	run("call_arg_count", types.Number, func(b *runtime.Builder) {
+		b.EmitLoadConst(b.NewConst(runtime.NewObject(double.Type, double)))
+		b.EmitCall(0)
+	}, "expression 0: instruction 1 (Call): function func(number) number does not accept 0 arguments")
+	run("call_not_boxed", types.String, func(b *runtime.Builder) {
+		b.EmitLoadConst(b.NewConst(runtime.NewObject(typeOf.Type, typeOf)))
+		b.EmitPushNumber(1)
+		b.EmitCall(1)
+	}, "expression 0: instruction 2 (Call): argument 0 of type number is not boxed")
+	run("boxed_value", types.Number, func(b *runtime.Builder) {
+		b.EmitPushNumber(1)
+		b.EmitBox(types.Number)
+		b.EmitPushNumber(1)
+		b.EmitOp(runtime.Add)
+	}, "expression 0: instruction 3 (Add): unexpected boxed number")
+	run("array_elements", numberArray, func(b *runtime.Builder) {
+		b.EmitPushNumber(1)
+		b.EmitPushString("a")
+		b.EmitPushArray(2)
+	}, "expression 0: instruction 2 (PushArray): array elements have types number and string")
+	run("or_pop_operand", types.Number, func(b *runtime.Builder) {
+		skip := b.NewLabel()
+		b.EmitPushNumber(1)
+		b.EmitJump(runtime.JumpIfTrueOrPop, skip)
+		b.EmitPushNumber(2)
+		b.AssignLabel(skip)
+	}, "expression 0: instruction 1 (JumpIfTrueOrPop): operand has type number, expected bool")
+	run("not_iterator", types.Number, func(b *runtime.Builder) {
+		local := b.NewLocal()
+		end := b.NewLabel()
+		b.EmitPushNumber(1)
+		b.EmitStoreLocal(local)
+		b.EmitIterNext(local, end)
+		b.AssignLabel(end)
+	}, "expression 0: instruction 2 (IterNext): local 0 does not hold an iterator")
+	run("iterator_value", numberArray, func(b *runtime.Builder) {
+		iter := b.NewLocal()
+		b.EmitPushArray(0)
+		b.EmitIterInit(iter)
+		b.EmitLoadLocal(iter)
+	}, "expression 0: instruction 2 (LoadLocal): local 0 holds an iterator over array of ?")
+	run("valid_in_set", types.Bool, func(b *runtime.Builder) {
+		nums := b.NewConst(runtime.NewObject(numberArray, []runtime.RawValue{runtime.NewRawNumber(1)}))
+		b.EmitPushNumber(1)
+		b.EmitInSet(runtime.InSetNumber, nums)
+	}, "")
+	run("in_set_const_type", types.Bool, func(b *runtime.Builder) {
+		nums := b.NewConst(runtime.NewObject(numberArray, []runtime.RawValue{runtime.NewRawNumber(1)}))
+		b.EmitPushString("a")
+		b.EmitInSet(runtime.InSetString, nums)
+	}, "expression 0: instruction 1 (InSetString): constant 0 has type array of number, expected array of string")
+	run("in_set_operand", types.Bool, func(b *runtime.Builder) {
+		nums := b.NewConst(runtime.NewObject(numberArray, []runtime.RawValue{runtime.NewRawNumber(1)}))
+		b.EmitPushString("a")
+		b.EmitInSet(runtime.InSetNumber, nums)
+	}, "expression 0: instruction 1 (InSetNumber): operand has type string, expected number")
+	run("invalid_op", types.Number, func(b *runtime.Builder) {
+		b.EmitOp(runtime.Operation(1000))
+	}, "expression 0: instruction 0 (Operation(1000)): invalid operation")
+	run("invalid_const", types.String, func(b *runtime.Builder) {
+		b.EmitLoadConst(b.NewConst(runtime.NewObject(types.String, 1)))
+	}, "constant 0: invalid value int for type string")
+	run("func_const_type", types.Number, func(b *runtime.Builder) {
+		b.EmitLoadConst(b.NewConst(runtime.NewObject(typeOf.Type, double)))
+	}, "constant 0: function of type func(number) number does not match type func(any) string")
+}
+
+func TestProgram_Verify_Compiled(t *testing.T) {
+	for _, input := range []string{
+		`a > 1 && (a < 10 || a == 5)`,
+		`[x for x in strs if x != "a"]`,
+		`reduce(strs, |acc, x| join(acc, x), "")`,
+		`find(map(strs, |s| [s]), |arr| arr == []) == []`,
+		`any x in strs: (all y in strs: x =~ y)`,
+		`ip("10.0.0.1") in cidr("10.0.0.0/8") && a in [1, 2, a]`,
+	} {
+		compiler := NewCompiler()
+		compiler.RegisterInput("a", types.Number)
+		compiler.RegisterInput("strs", &types.Array{ElementType: types.String})
+		compiler.RegisterGoFunc("join", func(sep string, parts ...string) string { return sep })
+		prog, err := compiler.Compile(input)
+		require.NoError(t, err)
+		require.NoError(t, prog.Verify(), input)
+	}
+}